@@ -0,0 +1,342 @@
+package apu
+
+import (
+	"math"
+
+	"github.com/zbyrne/golangboy/z80"
+)
+
+const (
+	CLOCK = 4194304
+
+	// The frame sequencer runs at 512Hz.
+	sequencerTicks = CLOCK / 512
+)
+
+const (
+	NR10 uint16 = 0xFF10 + iota
+	NR11
+	NR12
+	NR13
+	NR14
+	_
+	NR21
+	NR22
+	NR23
+	NR24
+	NR30
+	NR31
+	NR32
+	NR33
+	NR34
+	_
+	NR41
+	NR42
+	NR43
+	NR44
+	NR50
+	NR51
+	NR52
+
+	WAVE_RAM uint16 = 0xFF30
+)
+
+// Bits that read back as 1 for each register from NR10 to 0xFF2F.
+var readMask = [0x20]byte{
+	0x80, 0x3F, 0x00, 0xFF, 0xBF,
+	0xFF, 0x3F, 0x00, 0xFF, 0xBF,
+	0x7F, 0xFF, 0x9F, 0xFF, 0xBF,
+	0xFF, 0xFF, 0x00, 0x00, 0xBF,
+	0x00, 0x00, 0x70,
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+}
+
+// Sink receives the mixed stereo output and the output of each of the four
+// channels before panning and master volume. wav.Recorder is a Sink.
+type Sink interface {
+	WriteSample(left, right int16, channels ...int16) error
+}
+
+type APU struct {
+	regs    [0x20]byte
+	wave    [0x10]byte
+	enabled bool
+
+	ch1, ch2 square
+	ch3      wave
+	ch4      noise
+
+	seqTicks int
+	seqStep  int
+	dmg      bool
+
+	sampleRate int
+	sampleAcc  int
+	sink       Sink
+	sinkErr    error
+	stems      [4]int16
+	capL, capR float64
+	charge     float64
+}
+
+// New makes an APU producing sampleRate samples per second. With a rate of
+// 0 no samples are generated.
+func New(sampleRate int) *APU {
+	a := &APU{sampleRate: sampleRate}
+	a.ch1.sweeps = true
+	a.ch4.lfsr = 0x7FFF
+	if sampleRate > 0 {
+		a.charge = math.Pow(0.999958, float64(CLOCK)/float64(sampleRate))
+	}
+	return a
+}
+
+// SetDMG makes powering off behave as on the DMG, which leaves the length
+// counters alone and lets them be loaded while off. Later models clear
+// them with everything else.
+func (a *APU) SetDMG(on bool) {
+	a.dmg = on
+}
+
+func (a *APU) SetSink(s Sink) {
+	a.sink = s
+	a.sinkErr = nil
+}
+
+func (a *APU) Sink() Sink {
+	return a.sink
+}
+
+// SinkErr returns the first error from the sink. No more samples are
+// written to it after one.
+func (a *APU) SinkErr() error {
+	return a.sinkErr
+}
+
+func (a *APU) SampleRate() int {
+	return a.sampleRate
+}
+
+func (a *APU) ReadByte(addr uint16) byte {
+	switch {
+	case addr >= WAVE_RAM && addr < WAVE_RAM+0x10:
+		return a.wave[addr-WAVE_RAM]
+	case addr == NR52:
+		val := a.regs[NR52-NR10] & 0x80
+		for i, on := range []bool{a.ch1.enabled, a.ch2.enabled, a.ch3.enabled, a.ch4.enabled} {
+			if on {
+				val |= 1 << uint(i)
+			}
+		}
+		return val | readMask[NR52-NR10]
+	case addr >= NR10 && addr < WAVE_RAM:
+		return a.regs[addr-NR10] | readMask[addr-NR10]
+	}
+	return 0xFF
+}
+
+func (a *APU) WriteByte(addr uint16, val byte) {
+	if addr >= WAVE_RAM && addr < WAVE_RAM+0x10 {
+		a.wave[addr-WAVE_RAM] = val
+		return
+	}
+	if addr < NR10 || addr >= WAVE_RAM {
+		return
+	}
+	if addr == NR52 {
+		a.power(val&0x80 != 0)
+		return
+	}
+	if !a.enabled {
+		if a.dmg {
+			a.loadLength(addr, val)
+		}
+		return
+	}
+	a.regs[addr-NR10] = val
+	switch addr {
+	case NR10:
+		a.ch1.writeSweep(val)
+	case NR11:
+		a.ch1.writeDutyLength(val)
+	case NR12:
+		a.ch1.env.write(val)
+		a.ch1.checkDAC()
+	case NR13:
+		a.ch1.freq = a.ch1.freq&0x700 | uint16(val)
+	case NR14:
+		a.ch1.freq = a.ch1.freq&0xFF | uint16(val&7)<<8
+		a.ch1.len.enabled = val&0x40 != 0
+		if val&0x80 != 0 {
+			a.ch1.trigger()
+		}
+	case NR21:
+		a.ch2.writeDutyLength(val)
+	case NR22:
+		a.ch2.env.write(val)
+		a.ch2.checkDAC()
+	case NR23:
+		a.ch2.freq = a.ch2.freq&0x700 | uint16(val)
+	case NR24:
+		a.ch2.freq = a.ch2.freq&0xFF | uint16(val&7)<<8
+		a.ch2.len.enabled = val&0x40 != 0
+		if val&0x80 != 0 {
+			a.ch2.trigger()
+		}
+	case NR30:
+		a.ch3.dac = val&0x80 != 0
+		if !a.ch3.dac {
+			a.ch3.enabled = false
+		}
+	case NR31:
+		a.ch3.len.load(256, val)
+	case NR32:
+		a.ch3.volume = (val >> 5) & 3
+	case NR33:
+		a.ch3.freq = a.ch3.freq&0x700 | uint16(val)
+	case NR34:
+		a.ch3.freq = a.ch3.freq&0xFF | uint16(val&7)<<8
+		a.ch3.len.enabled = val&0x40 != 0
+		if val&0x80 != 0 {
+			a.ch3.trigger()
+		}
+	case NR41:
+		a.ch4.len.load(64, val&0x3F)
+	case NR42:
+		a.ch4.env.write(val)
+		a.ch4.checkDAC()
+	case NR43:
+		a.ch4.shift = val >> 4
+		a.ch4.narrow = val&0x08 != 0
+		a.ch4.divisor = val & 7
+	case NR44:
+		a.ch4.len.enabled = val&0x40 != 0
+		if val&0x80 != 0 {
+			a.ch4.trigger()
+		}
+	}
+}
+
+func (a *APU) power(on bool) {
+	if on == a.enabled {
+		return
+	}
+	a.enabled = on
+	if !on {
+		lengths := [4]int{a.ch1.len.counter, a.ch2.len.counter, a.ch3.len.counter, a.ch4.len.counter}
+		a.regs = [0x20]byte{}
+		a.ch1 = square{sweeps: true}
+		a.ch2 = square{}
+		a.ch3 = wave{}
+		a.ch4 = noise{lfsr: 0x7FFF}
+		if a.dmg {
+			a.ch1.len.counter, a.ch2.len.counter = lengths[0], lengths[1]
+			a.ch3.len.counter, a.ch4.len.counter = lengths[2], lengths[3]
+		}
+		return
+	}
+	a.regs[NR52-NR10] = 0x80
+	a.seqStep = 0
+	a.seqTicks = 0
+}
+
+// loadLength loads just the length counter from a write to NRx1.
+func (a *APU) loadLength(addr uint16, val byte) {
+	switch addr {
+	case NR11:
+		a.ch1.len.load(64, val&0x3F)
+	case NR21:
+		a.ch2.len.load(64, val&0x3F)
+	case NR31:
+		a.ch3.len.load(256, val)
+	case NR41:
+		a.ch4.len.load(64, val&0x3F)
+	}
+}
+
+func (a *APU) Tick(t z80.ClockTicks) {
+	for ; t > 0; t -= 4 {
+		if a.enabled {
+			a.seqTicks += 4
+			if a.seqTicks >= sequencerTicks {
+				a.seqTicks -= sequencerTicks
+				a.sequence()
+			}
+			a.ch1.tick(4)
+			a.ch2.tick(4)
+			a.ch3.tick(4, &a.wave)
+			a.ch4.tick(4)
+		}
+		if a.sampleRate > 0 {
+			a.sampleAcc += a.sampleRate * 4
+			if a.sampleAcc >= CLOCK {
+				a.sampleAcc -= CLOCK
+				a.mix()
+			}
+		}
+	}
+}
+
+func (a *APU) sequence() {
+	if a.seqStep%2 == 0 {
+		a.ch1.len.clock(&a.ch1.enabled)
+		a.ch2.len.clock(&a.ch2.enabled)
+		a.ch3.len.clock(&a.ch3.enabled)
+		a.ch4.len.clock(&a.ch4.enabled)
+	}
+	if a.seqStep == 2 || a.seqStep == 6 {
+		a.ch1.clockSweep()
+	}
+	if a.seqStep == 7 {
+		a.ch1.env.clock()
+		a.ch2.env.clock()
+		a.ch4.env.clock()
+	}
+	a.seqStep = (a.seqStep + 1) % 8
+}
+
+func (a *APU) highPass(in float64, cap *float64) float64 {
+	out := in - *cap
+	*cap = in - out*a.charge
+	return out
+}
+
+func (a *APU) mix() {
+	levels := [4]byte{a.ch1.output(), a.ch2.output(), a.ch3.output(), a.ch4.output()}
+	dacs := [4]bool{a.ch1.dac(), a.ch2.dac(), a.ch3.dac, a.ch4.dac()}
+	nr50 := a.regs[NR50-NR10]
+	nr51 := a.regs[NR51-NR10]
+	var left, right float64
+	for i, level := range levels {
+		a.stems[i] = 0
+		if !dacs[i] {
+			continue
+		}
+		a.stems[i] = int16(level) * 2184
+		analog := float64(level)/7.5 - 1
+		if nr51&(0x10<<uint(i)) != 0 {
+			left += analog
+		}
+		if nr51&(1<<uint(i)) != 0 {
+			right += analog
+		}
+	}
+	left *= float64((nr50>>4)&7+1) / 8
+	right *= float64(nr50&7+1) / 8
+	left = a.highPass(left, &a.capL)
+	right = a.highPass(right, &a.capR)
+	if a.sink != nil && a.sinkErr == nil {
+		a.sinkErr = a.sink.WriteSample(clamp(left/4), clamp(right/4), a.stems[:]...)
+	}
+}
+
+func clamp(v float64) int16 {
+	v *= 32767
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
@@ -0,0 +1,302 @@
+package apu
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/zbyrne/golangboy/savestate"
+)
+
+type mockSink struct {
+	left, right []int16
+	channels    [][]int16
+	// full fails writes once there are this many samples, if not 0.
+	full int
+}
+
+var errFull = errors.New("sink full")
+
+func (m *mockSink) WriteSample(left, right int16, channels ...int16) error {
+	if m.full != 0 && len(m.left) >= m.full {
+		return errFull
+	}
+	m.left = append(m.left, left)
+	m.right = append(m.right, right)
+	m.channels = append(m.channels, append([]int16(nil), channels...))
+	return nil
+}
+
+func poweredOn(rate int) *APU {
+	a := New(rate)
+	a.WriteByte(NR52, 0x80)
+	a.WriteByte(NR50, 0x77)
+	a.WriteByte(NR51, 0xFF)
+	return a
+}
+
+func TestReadMasks(t *testing.T) {
+	a := New(0)
+	if val := a.ReadByte(NR52); val != 0x70 {
+		t.Errorf("NR52 = 0x%02X powered off, not 0x70", val)
+	}
+	a.WriteByte(NR52, 0x80)
+	a.WriteByte(NR11, 0x80)
+	if val := a.ReadByte(NR11); val != 0xBF {
+		t.Errorf("NR11 = 0x%02X, not 0xBF", val)
+	}
+	if val := a.ReadByte(NR13); val != 0xFF {
+		t.Errorf("NR13 = 0x%02X, not 0xFF", val)
+	}
+	if val := a.ReadByte(0xFF15); val != 0xFF {
+		t.Errorf("0xFF15 = 0x%02X, not 0xFF", val)
+	}
+}
+
+func TestPoweredOffIgnoresWrites(t *testing.T) {
+	a := New(0)
+	a.WriteByte(NR50, 0x77)
+	if val := a.ReadByte(NR50); val != 0 {
+		t.Errorf("NR50 = 0x%02X, written while off", val)
+	}
+	a.WriteByte(WAVE_RAM, 0x12)
+	if val := a.ReadByte(WAVE_RAM); val != 0x12 {
+		t.Errorf("Wave RAM = 0x%02X, not 0x12", val)
+	}
+}
+
+func TestPowerOffClears(t *testing.T) {
+	a := poweredOn(0)
+	a.WriteByte(NR12, 0xF0)
+	a.WriteByte(NR14, 0x80)
+	a.WriteByte(NR52, 0x00)
+	if val := a.ReadByte(NR50); val != 0 {
+		t.Errorf("NR50 = 0x%02X after power off", val)
+	}
+	a.WriteByte(NR52, 0x80)
+	if val := a.ReadByte(NR52); val != 0xF0 {
+		t.Errorf("NR52 = 0x%02X, not 0xF0", val)
+	}
+}
+
+func TestDMGPowerOffKeepsLengths(t *testing.T) {
+	for _, dmg := range []bool{false, true} {
+		a := poweredOn(0)
+		a.SetDMG(dmg)
+		a.WriteByte(NR11, 0x3E)
+		a.WriteByte(NR52, 0x00)
+		a.WriteByte(NR31, 0xF0)
+		a.WriteByte(NR21, 0xC8)
+		want := [3]int{2, 16, 56}
+		if !dmg {
+			want = [3]int{}
+		}
+		if got := [3]int{a.ch1.len.counter, a.ch3.len.counter, a.ch2.len.counter}; got != want {
+			t.Errorf("DMG %t: length counters %v after power off, not %v", dmg, got, want)
+		}
+		if val := a.ReadByte(NR21); val != 0x3F {
+			t.Errorf("DMG %t: NR21 = 0x%02X, written while off", dmg, val)
+		}
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	a := poweredOn(0)
+	a.WriteByte(NR22, 0xF0)
+	a.WriteByte(NR24, 0x80)
+	if val := a.ReadByte(NR52); val != 0xF2 {
+		t.Errorf("NR52 = 0x%02X, not 0xF2", val)
+	}
+}
+
+func TestTriggerWithDACOff(t *testing.T) {
+	a := poweredOn(0)
+	a.WriteByte(NR22, 0x00)
+	a.WriteByte(NR24, 0x80)
+	if val := a.ReadByte(NR52); val != 0xF0 {
+		t.Errorf("NR52 = 0x%02X, channel on with the DAC off", val)
+	}
+}
+
+func TestDACOffDisables(t *testing.T) {
+	a := poweredOn(0)
+	a.WriteByte(NR30, 0x80)
+	a.WriteByte(NR34, 0x80)
+	if val := a.ReadByte(NR52); val != 0xF4 {
+		t.Errorf("NR52 = 0x%02X, not 0xF4", val)
+	}
+	a.WriteByte(NR30, 0x00)
+	if val := a.ReadByte(NR52); val != 0xF0 {
+		t.Errorf("NR52 = 0x%02X after DAC off, not 0xF0", val)
+	}
+}
+
+func TestLength(t *testing.T) {
+	a := poweredOn(0)
+	a.WriteByte(NR42, 0xF0)
+	a.WriteByte(NR41, 62)
+	a.WriteByte(NR44, 0xC0)
+	// Two length clocks are 1/256 of a second apart.
+	a.Tick(sequencerTicks * 2)
+	if val := a.ReadByte(NR52); val&0x08 == 0 {
+		t.Error("Channel 4 stopped early")
+	}
+	a.Tick(sequencerTicks * 2)
+	if val := a.ReadByte(NR52); val&0x08 != 0 {
+		t.Error("Channel 4 still on after its length expired")
+	}
+}
+
+func TestSweepOverflow(t *testing.T) {
+	a := poweredOn(0)
+	a.WriteByte(NR10, 0x11)
+	a.WriteByte(NR12, 0xF0)
+	a.WriteByte(NR13, 0xFF)
+	a.WriteByte(NR14, 0x87)
+	if val := a.ReadByte(NR52); val&0x01 != 0 {
+		t.Error("Channel 1 on after overflowing sweep on trigger")
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	a := poweredOn(0)
+	a.WriteByte(NR12, 0xF1)
+	a.WriteByte(NR14, 0x80)
+	a.Tick(sequencerTicks * 8)
+	if a.ch1.env.volume != 14 {
+		t.Errorf("Volume = %d after one envelope step, not 14", a.ch1.env.volume)
+	}
+}
+
+func TestNoSamplesWithoutRate(t *testing.T) {
+	var s mockSink
+	a := poweredOn(0)
+	a.SetSink(&s)
+	a.Tick(CLOCK / 60)
+	if len(s.left) != 0 {
+		t.Errorf("Wrote %d samples with no rate", len(s.left))
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	var s mockSink
+	a := poweredOn(32768)
+	a.SetSink(&s)
+	a.Tick(CLOCK / 4)
+	if len(s.left) != 8192 {
+		t.Errorf("Wrote %d samples in a quarter second, not 8192", len(s.left))
+	}
+}
+
+func TestSinkError(t *testing.T) {
+	s := mockSink{full: 100}
+	a := poweredOn(32768)
+	a.SetSink(&s)
+	a.Tick(CLOCK / 4)
+	if a.SinkErr() != errFull || len(s.left) != 100 {
+		t.Errorf("Sink error %v after %d samples", a.SinkErr(), len(s.left))
+	}
+	a.SetSink(&mockSink{})
+	if a.SinkErr() != nil {
+		t.Error("New sink kept the old one's error")
+	}
+}
+
+func TestSquareOutput(t *testing.T) {
+	var s mockSink
+	a := poweredOn(65536)
+	a.SetSink(&s)
+	a.WriteByte(NR51, 0x02)
+	a.WriteByte(NR21, 0x80)
+	a.WriteByte(NR22, 0xF0)
+	// 1024Hz
+	a.WriteByte(NR23, 0x00)
+	a.WriteByte(NR24, 0x87)
+	a.Tick(CLOCK / 64)
+	var high, low int
+	for i, ch := range s.channels {
+		if ch[0] != 0 || ch[2] != 0 || ch[3] != 0 {
+			t.Fatalf("Sample %d: silent channels output %v", i, ch)
+		}
+		switch ch[1] {
+		case 15 * 2184:
+			high++
+		case 0:
+			low++
+		default:
+			t.Fatalf("Sample %d: channel 2 output %d", i, ch[1])
+		}
+		if s.left[i] != 0 && i > 16 {
+			t.Fatalf("Sample %d: left output %d with channel 2 panned right", i, s.left[i])
+		}
+	}
+	if high < low*9/10 || high > low*11/10 {
+		t.Errorf("50%% duty gave %d high and %d low samples", high, low)
+	}
+}
+
+func TestNoiseLFSR(t *testing.T) {
+	var n noise
+	n.lfsr = 0x7FFF
+	n.narrow = true
+	n.divisor = 0
+	seen := map[uint16]bool{}
+	for i := 0; i < 127; i++ {
+		n.tick(8)
+		seen[n.lfsr&0x7F] = true
+	}
+	if len(seen) != 127 {
+		t.Errorf("7-bit LFSR visited %d states, not 127", len(seen))
+	}
+}
+
+func TestWaveOutput(t *testing.T) {
+	a := poweredOn(0)
+	for i := uint16(0); i < 16; i++ {
+		a.WriteByte(WAVE_RAM+i, 0xF0)
+	}
+	a.WriteByte(NR30, 0x80)
+	a.WriteByte(NR32, 0x20)
+	a.WriteByte(NR33, 0x00)
+	a.WriteByte(NR34, 0x87)
+	a.Tick(512)
+	if a.ch3.output() != 0 {
+		t.Errorf("Odd sample = %d, not 0", a.ch3.output())
+	}
+	a.Tick(512)
+	if a.ch3.output() != 15 {
+		t.Errorf("Even sample = %d, not 15", a.ch3.output())
+	}
+	a.WriteByte(NR32, 0x60)
+	if a.ch3.output() != 3 {
+		t.Errorf("Quarter volume sample = %d, not 3", a.ch3.output())
+	}
+}
+
+func TestState(t *testing.T) {
+	a := poweredOn(48000)
+	a.WriteByte(NR12, 0xF3)
+	a.WriteByte(NR14, 0x87)
+	a.WriteByte(NR30, 0x80)
+	a.WriteByte(WAVE_RAM, 0x1F)
+	a.WriteByte(NR34, 0x80)
+	a.WriteByte(NR42, 0xA1)
+	a.WriteByte(NR44, 0x80)
+	a.Tick(30000)
+	loaded := New(48000)
+	if err := savestate.Copy(loaded, a, STATE_VERSION); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, a) {
+		t.Errorf("Loaded %+v, saved %+v", loaded, a)
+	}
+	// Both carry on making the same sound.
+	var want, got mockSink
+	a.SetSink(&want)
+	loaded.SetSink(&got)
+	a.Tick(10000)
+	loaded.Tick(10000)
+	if !reflect.DeepEqual(got, want) {
+		t.Error("Loaded APU sounds different")
+	}
+}
@@ -0,0 +1,279 @@
+package apu
+
+var dutyTable = [4][8]byte{
+	{0, 0, 0, 0, 0, 0, 0, 1},
+	{1, 0, 0, 0, 0, 0, 0, 1},
+	{1, 0, 0, 0, 0, 1, 1, 1},
+	{0, 1, 1, 1, 1, 1, 1, 0},
+}
+
+var noiseDivisors = [8]int{8, 16, 32, 48, 64, 80, 96, 112}
+
+type length struct {
+	counter int
+	enabled bool
+}
+
+func (l *length) load(max int, val byte) {
+	l.counter = max - int(val)
+}
+
+func (l *length) clock(enabled *bool) {
+	if l.enabled && l.counter > 0 {
+		l.counter--
+		if l.counter == 0 {
+			*enabled = false
+		}
+	}
+}
+
+func (l *length) trigger(max int) {
+	if l.counter == 0 {
+		l.counter = max
+	}
+}
+
+type envelope struct {
+	initial, period byte
+	up              bool
+	volume, timer   byte
+}
+
+func (e *envelope) write(val byte) {
+	e.initial = val >> 4
+	e.up = val&0x08 != 0
+	e.period = val & 7
+}
+
+func (e *envelope) dac() bool {
+	return e.initial != 0 || e.up
+}
+
+func (e *envelope) trigger() {
+	e.volume = e.initial
+	e.timer = e.period
+}
+
+func (e *envelope) clock() {
+	if e.period == 0 {
+		return
+	}
+	if e.timer > 0 {
+		e.timer--
+	}
+	if e.timer != 0 {
+		return
+	}
+	e.timer = e.period
+	if e.up && e.volume < 15 {
+		e.volume++
+	} else if !e.up && e.volume > 0 {
+		e.volume--
+	}
+}
+
+// square is channels 1 and 2. Only channel 1 sweeps.
+type square struct {
+	enabled bool
+	sweeps  bool
+	len     length
+	env     envelope
+	duty    byte
+	dutyPos int
+	freq    uint16
+	timer   int
+
+	sweepPeriod, sweepShift byte
+	sweepNegate             bool
+	sweepTimer              byte
+	sweepEnabled            bool
+	shadow                  uint16
+}
+
+func (s *square) dac() bool {
+	return s.env.dac()
+}
+
+func (s *square) checkDAC() {
+	if !s.dac() {
+		s.enabled = false
+	}
+}
+
+func (s *square) writeDutyLength(val byte) {
+	s.duty = val >> 6
+	s.len.load(64, val&0x3F)
+}
+
+func (s *square) writeSweep(val byte) {
+	s.sweepPeriod = (val >> 4) & 7
+	s.sweepNegate = val&0x08 != 0
+	s.sweepShift = val & 7
+}
+
+func (s *square) period() int {
+	return (2048 - int(s.freq)) * 4
+}
+
+func (s *square) trigger() {
+	s.enabled = s.dac()
+	s.len.trigger(64)
+	s.timer = s.period()
+	s.env.trigger()
+	if s.sweeps {
+		s.shadow = s.freq
+		s.sweepTimer = s.sweepPeriod
+		if s.sweepTimer == 0 {
+			s.sweepTimer = 8
+		}
+		s.sweepEnabled = s.sweepPeriod != 0 || s.sweepShift != 0
+		if s.sweepShift != 0 {
+			s.sweepTarget()
+		}
+	}
+}
+
+// sweepTarget computes the next sweep frequency, disabling the channel if
+// it overflows.
+func (s *square) sweepTarget() uint16 {
+	delta := s.shadow >> s.sweepShift
+	target := s.shadow + delta
+	if s.sweepNegate {
+		target = s.shadow - delta
+	}
+	if target > 2047 {
+		s.enabled = false
+	}
+	return target
+}
+
+func (s *square) clockSweep() {
+	if s.sweepTimer > 0 {
+		s.sweepTimer--
+	}
+	if s.sweepTimer != 0 {
+		return
+	}
+	s.sweepTimer = s.sweepPeriod
+	if s.sweepTimer == 0 {
+		s.sweepTimer = 8
+	}
+	if !s.sweepEnabled || s.sweepPeriod == 0 {
+		return
+	}
+	target := s.sweepTarget()
+	if target <= 2047 && s.sweepShift != 0 {
+		s.shadow = target
+		s.freq = target
+		s.sweepTarget()
+	}
+}
+
+func (s *square) tick(t int) {
+	s.timer -= t
+	for s.timer <= 0 {
+		s.timer += s.period()
+		s.dutyPos = (s.dutyPos + 1) & 7
+	}
+}
+
+func (s *square) output() byte {
+	if !s.enabled {
+		return 0
+	}
+	return dutyTable[s.duty][s.dutyPos] * s.env.volume
+}
+
+type wave struct {
+	enabled bool
+	dac     bool
+	len     length
+	volume  byte
+	freq    uint16
+	timer   int
+	pos     int
+	sample  byte
+}
+
+func (w *wave) period() int {
+	return (2048 - int(w.freq)) * 2
+}
+
+func (w *wave) trigger() {
+	w.enabled = w.dac
+	w.len.trigger(256)
+	w.timer = w.period()
+	w.pos = 0
+}
+
+func (w *wave) tick(t int, ram *[0x10]byte) {
+	w.timer -= t
+	for w.timer <= 0 {
+		w.timer += w.period()
+		w.pos = (w.pos + 1) & 31
+		w.sample = ram[w.pos/2]
+		if w.pos%2 == 0 {
+			w.sample >>= 4
+		}
+		w.sample &= 0x0F
+	}
+}
+
+func (w *wave) output() byte {
+	if !w.enabled || w.volume == 0 {
+		return 0
+	}
+	return w.sample >> (w.volume - 1)
+}
+
+type noise struct {
+	enabled bool
+	len     length
+	env     envelope
+	shift   byte
+	narrow  bool
+	divisor byte
+	timer   int
+	lfsr    uint16
+}
+
+func (n *noise) dac() bool {
+	return n.env.dac()
+}
+
+func (n *noise) checkDAC() {
+	if !n.dac() {
+		n.enabled = false
+	}
+}
+
+func (n *noise) period() int {
+	return noiseDivisors[n.divisor] << n.shift
+}
+
+func (n *noise) trigger() {
+	n.enabled = n.dac()
+	n.len.trigger(64)
+	n.timer = n.period()
+	n.env.trigger()
+	n.lfsr = 0x7FFF
+}
+
+func (n *noise) tick(t int) {
+	n.timer -= t
+	for n.timer <= 0 {
+		n.timer += n.period()
+		bit := (n.lfsr ^ n.lfsr>>1) & 1
+		n.lfsr = n.lfsr>>1 | bit<<14
+		if n.narrow {
+			n.lfsr = n.lfsr&^0x40 | bit<<6
+		}
+	}
+}
+
+func (n *noise) output() byte {
+	if !n.enabled || n.lfsr&1 != 0 {
+		return 0
+	}
+	return n.env.volume
+}
@@ -0,0 +1,146 @@
+package apu
+
+import "github.com/zbyrne/golangboy/savestate"
+
+// STATE_VERSION is the version of the APU's save state chunk.
+const STATE_VERSION = 1
+
+// SaveState saves the sound hardware and the output filter. The sample
+// rate and sink are the frontend's and aren't saved.
+func (a *APU) SaveState(e *savestate.Encoder) {
+	e.Bytes(a.regs[:])
+	e.Bytes(a.wave[:])
+	e.Bool(a.enabled)
+	a.ch1.saveState(e)
+	a.ch2.saveState(e)
+	a.ch3.saveState(e)
+	a.ch4.saveState(e)
+	e.Int(a.seqTicks)
+	e.Int(a.seqStep)
+	e.Int(a.sampleAcc)
+	for _, s := range a.stems {
+		e.Uint16(uint16(s))
+	}
+	e.Float64(a.capL)
+	e.Float64(a.capR)
+}
+
+func (a *APU) LoadState(d *savestate.Decoder) {
+	d.Bytes(a.regs[:])
+	d.Bytes(a.wave[:])
+	a.enabled = d.Bool()
+	a.ch1.loadState(d)
+	a.ch2.loadState(d)
+	a.ch3.loadState(d)
+	a.ch4.loadState(d)
+	a.seqTicks = d.Int()
+	a.seqStep = d.Int()
+	a.sampleAcc = d.Int()
+	for i := range a.stems {
+		a.stems[i] = int16(d.Uint16())
+	}
+	a.capL = d.Float64()
+	a.capR = d.Float64()
+}
+
+func (l *length) saveState(e *savestate.Encoder) {
+	e.Int(l.counter)
+	e.Bool(l.enabled)
+}
+
+func (l *length) loadState(d *savestate.Decoder) {
+	l.counter = d.Int()
+	l.enabled = d.Bool()
+}
+
+func (v *envelope) saveState(e *savestate.Encoder) {
+	e.Byte(v.initial)
+	e.Byte(v.period)
+	e.Bool(v.up)
+	e.Byte(v.volume)
+	e.Byte(v.timer)
+}
+
+func (v *envelope) loadState(d *savestate.Decoder) {
+	v.initial = d.Byte()
+	v.period = d.Byte()
+	v.up = d.Bool()
+	v.volume = d.Byte()
+	v.timer = d.Byte()
+}
+
+func (s *square) saveState(e *savestate.Encoder) {
+	e.Bool(s.enabled)
+	s.len.saveState(e)
+	s.env.saveState(e)
+	e.Byte(s.duty)
+	e.Int(s.dutyPos)
+	e.Uint16(s.freq)
+	e.Int(s.timer)
+	e.Byte(s.sweepPeriod)
+	e.Byte(s.sweepShift)
+	e.Bool(s.sweepNegate)
+	e.Byte(s.sweepTimer)
+	e.Bool(s.sweepEnabled)
+	e.Uint16(s.shadow)
+}
+
+func (s *square) loadState(d *savestate.Decoder) {
+	s.enabled = d.Bool()
+	s.len.loadState(d)
+	s.env.loadState(d)
+	s.duty = d.Byte()
+	s.dutyPos = d.Int()
+	s.freq = d.Uint16()
+	s.timer = d.Int()
+	s.sweepPeriod = d.Byte()
+	s.sweepShift = d.Byte()
+	s.sweepNegate = d.Bool()
+	s.sweepTimer = d.Byte()
+	s.sweepEnabled = d.Bool()
+	s.shadow = d.Uint16()
+}
+
+func (w *wave) saveState(e *savestate.Encoder) {
+	e.Bool(w.enabled)
+	e.Bool(w.dac)
+	w.len.saveState(e)
+	e.Byte(w.volume)
+	e.Uint16(w.freq)
+	e.Int(w.timer)
+	e.Int(w.pos)
+	e.Byte(w.sample)
+}
+
+func (w *wave) loadState(d *savestate.Decoder) {
+	w.enabled = d.Bool()
+	w.dac = d.Bool()
+	w.len.loadState(d)
+	w.volume = d.Byte()
+	w.freq = d.Uint16()
+	w.timer = d.Int()
+	w.pos = d.Int()
+	w.sample = d.Byte()
+}
+
+func (n *noise) saveState(e *savestate.Encoder) {
+	e.Bool(n.enabled)
+	n.len.saveState(e)
+	n.env.saveState(e)
+	e.Byte(n.shift)
+	e.Bool(n.narrow)
+	e.Byte(n.divisor)
+	e.Int(n.timer)
+	e.Uint16(n.lfsr)
+}
+
+func (n *noise) loadState(d *savestate.Decoder) {
+	n.enabled = d.Bool()
+	n.len.loadState(d)
+	n.env.loadState(d)
+	n.shift = d.Byte()
+	n.narrow = d.Bool()
+	n.divisor = d.Byte()
+	n.timer = d.Int()
+	n.lfsr = d.Uint16()
+}
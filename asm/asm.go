@@ -0,0 +1,464 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+const BANK_SIZE = 0x4000
+
+// headerStart and headerEnd bound the cartridge header from the title on.
+const (
+	headerStart = 0x134
+	headerEnd   = 0x150
+)
+
+// Memory is the part of z80.Memory needed to load a program.
+type Memory interface {
+	WriteByte(uint16, byte)
+}
+
+// Error reports a problem with one line of source.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Section is a block of code or data at a fixed address. Type is the
+// RGBDS memory type such as ROM0, ROMX or WRAM0.
+type Section struct {
+	Name string
+	Type string
+	Bank int
+	Addr uint16
+	Data []byte
+}
+
+type Program struct {
+	Sections []*Section
+	Symbols  map[string]int
+}
+
+var sectionStart = map[string]uint16{
+	"ROM0":  0x0000,
+	"ROMX":  0x4000,
+	"VRAM":  0x8000,
+	"SRAM":  0xA000,
+	"WRAM0": 0xC000,
+	"WRAMX": 0xD000,
+	"OAM":   0xFE00,
+	"HRAM":  0xFF80,
+}
+
+type assembler struct {
+	pass    int
+	line    int
+	pc      uint16
+	scope   string
+	symbols map[string]int
+	section *Section
+	prog    *Program
+	// next tracks where a floating section of each type would go.
+	next map[string]uint16
+	size int
+	// layout is set while evaluating an expression that decides where
+	// later code goes, which can't wait for the second pass to resolve a
+	// forward reference. guessed holds the constants that did.
+	layout  bool
+	forward bool
+	guessed map[string]bool
+}
+
+// Assemble builds RGBDS style source. Code before the first SECTION
+// directive goes in a ROM0 section at address 0.
+func Assemble(src string) (*Program, error) {
+	a := assembler{symbols: map[string]int{}, guessed: map[string]bool{}}
+	lines := strings.Split(src, "\n")
+	for a.pass = 1; a.pass <= 2; a.pass++ {
+		a.prog = &Program{Symbols: a.symbols}
+		a.next = map[string]uint16{}
+		a.scope = ""
+		a.startSection("", "ROM0", 0, 0)
+		for i, line := range lines {
+			a.line = i + 1
+			if err := a.assembleLine(line); err != nil {
+				return nil, &Error{a.line, err.Error()}
+			}
+		}
+	}
+	// Drop the implicit section if nothing went in it.
+	if len(a.prog.Sections[0].Data) == 0 {
+		a.prog.Sections = a.prog.Sections[1:]
+	}
+	return a.prog, nil
+}
+
+// LineSizes returns how many bytes each line emits. The lines don't need
+// to define the symbols they use, so tools can size a fragment of a
+// larger program.
+func LineSizes(lines []string) ([]int, error) {
+	a := assembler{symbols: map[string]int{}, guessed: map[string]bool{}, pass: 1}
+	a.prog = &Program{Symbols: a.symbols}
+	a.next = map[string]uint16{}
+	a.startSection("", "ROM0", 0, 0)
+	sizes := make([]int, len(lines))
+	for i, line := range lines {
+		a.line = i + 1
+		start := a.size
+		if err := a.assembleLine(line); err != nil {
+			return nil, &Error{a.line, err.Error()}
+		}
+		sizes[i] = a.size - start
+	}
+	return sizes, nil
+}
+
+// Load copies every section into memory at its address.
+func (p *Program) Load(mem Memory) {
+	for _, s := range p.Sections {
+		for i, b := range s.Data {
+			mem.WriteByte(s.Addr+uint16(i), b)
+		}
+	}
+}
+
+// Patch writes the ROM sections into a ROM image, using each section's
+// bank to find its offset.
+func (p *Program) Patch(rom []byte) error {
+	for _, s := range p.Sections {
+		var offset int
+		switch s.Type {
+		case "ROM0":
+			offset = int(s.Addr)
+		case "ROMX":
+			offset = s.Bank*BANK_SIZE + int(s.Addr) - BANK_SIZE
+		default:
+			continue
+		}
+		if offset+len(s.Data) > len(rom) {
+			return fmt.Errorf("section %q runs past the end of the ROM", s.Name)
+		}
+		copy(rom[offset:], s.Data)
+	}
+	return nil
+}
+
+// ROM assembles src into a ROM image with header copied over the
+// cartridge header from the title at $0134 on. The image is 32KB, or
+// bigger if a ROMX section needs it.
+func ROM(src string, header ...byte) ([]byte, error) {
+	p, err := Assemble(src)
+	if err != nil {
+		return nil, err
+	}
+	size := 2 * BANK_SIZE
+	for _, s := range p.Sections {
+		for s.Type == "ROMX" && size < (s.Bank+1)*BANK_SIZE {
+			size *= 2
+		}
+	}
+	rom := make([]byte, size)
+	if err := p.Patch(rom); err != nil {
+		return nil, err
+	}
+	if len(header) > headerEnd-headerStart {
+		return nil, fmt.Errorf("header runs past $%04X", headerEnd)
+	}
+	copy(rom[headerStart:], header)
+	return rom, nil
+}
+
+// Bytes returns the contents of the first section, which is all there is
+// for a snippet with no SECTION directives.
+func (p *Program) Bytes() []byte {
+	if len(p.Sections) == 0 {
+		return nil
+	}
+	return p.Sections[0].Data
+}
+
+func (a *assembler) startSection(name, kind string, bank int, addr uint16) {
+	a.section = &Section{Name: name, Type: kind, Bank: bank, Addr: addr}
+	a.prog.Sections = append(a.prog.Sections, a.section)
+	a.pc = addr
+}
+
+func (a *assembler) emit(b ...byte) {
+	a.section.Data = append(a.section.Data, b...)
+	a.size += len(b)
+	a.pc += uint16(len(b))
+	a.next[a.section.Type] = a.pc
+}
+
+func (a *assembler) qualify(name string) string {
+	if strings.HasPrefix(name, ".") {
+		return a.scope + name
+	}
+	return name
+}
+
+func (a *assembler) lookup(name string) (int, error) {
+	val, ok := a.symbols[a.qualify(name)]
+	if a.layout && a.pass == 1 && (!ok || a.guessed[a.qualify(name)]) {
+		return 0, fmt.Errorf("%s must be defined before it's used to size or place code", name)
+	}
+	if ok {
+		a.forward = a.forward || a.guessed[a.qualify(name)]
+		return val, nil
+	}
+	// Forward references are resolved on the second pass.
+	if a.pass == 1 {
+		a.forward = true
+		return 0, nil
+	}
+	return 0, fmt.Errorf("undefined symbol %s", name)
+}
+
+// evalLayout evaluates an expression that sizes or places code. Its value
+// has to be the same on both passes or every later label would move.
+func (a *assembler) evalLayout(expr string) (int, error) {
+	a.layout = true
+	defer func() { a.layout = false }()
+	return a.eval(expr)
+}
+
+func (a *assembler) define(name string, val int) error {
+	if !strings.HasPrefix(name, ".") && !strings.Contains(name, ".") {
+		a.scope = name
+	}
+	name = a.qualify(name)
+	if old, ok := a.symbols[name]; ok && (a.pass == 1 || old != val) {
+		return fmt.Errorf("%s redefined", name)
+	}
+	a.symbols[name] = val
+	return nil
+}
+
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ';':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func (a *assembler) assembleLine(line string) error {
+	line = stripComment(line)
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	fields := strings.Fields(line)
+	// Labels and constants start in the first column.
+	if line[0] != ' ' && line[0] != '\t' {
+		label := fields[0]
+		if strings.HasSuffix(label, ":") {
+			if err := a.define(strings.TrimRight(label, ":"), int(a.pc)); err != nil {
+				return err
+			}
+			line = strings.TrimSpace(line[strings.Index(line, ":"):])
+			line = strings.TrimLeft(line, ":")
+		} else if len(fields) > 2 && strings.ToUpper(fields[1]) == "EQU" {
+			return a.equ(label, line)
+		} else if strings.HasPrefix(label, ".") {
+			if err := a.define(label, int(a.pc)); err != nil {
+				return err
+			}
+			line = line[len(label):]
+		}
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	mnemonic := line
+	args := ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		mnemonic, args = line[:i], strings.TrimSpace(line[i:])
+	}
+	switch strings.ToUpper(mnemonic) {
+	case "DEF":
+		fields := strings.Fields(args)
+		if len(fields) < 3 || strings.ToUpper(fields[1]) != "EQU" {
+			return fmt.Errorf("expected DEF name EQU value")
+		}
+		return a.equ(fields[0], args)
+	case "SECTION":
+		return a.sectionDirective(args)
+	case "DB":
+		return a.data(args, 1)
+	case "DW":
+		return a.data(args, 2)
+	case "DS":
+		return a.space(args)
+	}
+	return a.instruction(strings.ToUpper(mnemonic), args)
+}
+
+func (a *assembler) equ(name, line string) error {
+	i := strings.Index(strings.ToUpper(line), "EQU")
+	a.forward = false
+	val, err := a.eval(line[i+3:])
+	if err != nil {
+		return err
+	}
+	if _, ok := a.symbols[name]; ok && a.pass == 1 {
+		return fmt.Errorf("%s redefined", name)
+	}
+	if a.forward && a.pass == 1 {
+		a.guessed[name] = true
+	}
+	a.symbols[name] = val
+	return nil
+}
+
+// splitArgs splits on commas outside of brackets, parentheses and strings.
+func splitArgs(s string) []string {
+	var args []string
+	var depth int
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '"':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(s) != "" {
+		args = append(args, strings.TrimSpace(s[start:]))
+	}
+	return args
+}
+
+// sectionDirective handles SECTION "name", TYPE[$addr], BANK[n]. Sections
+// without an address follow the last one of the same type.
+func (a *assembler) sectionDirective(args string) error {
+	parts := splitArgs(args)
+	if len(parts) < 2 || len(parts[0]) < 2 || parts[0][0] != '"' {
+		return fmt.Errorf("expected SECTION \"name\", TYPE")
+	}
+	name := strings.Trim(parts[0], "\"")
+	kind, addrExpr := bracketed(parts[1])
+	kind = strings.ToUpper(kind)
+	start, ok := sectionStart[kind]
+	if !ok {
+		return fmt.Errorf("unknown section type %s", kind)
+	}
+	addr, ok := a.next[kind]
+	if !ok {
+		addr = start
+	}
+	if addrExpr != "" {
+		val, err := a.evalLayout(addrExpr)
+		if err != nil {
+			return err
+		}
+		addr = uint16(val)
+	}
+	bank := 0
+	if kind == "ROMX" {
+		bank = 1
+	}
+	if len(parts) > 2 {
+		opt, bankExpr := bracketed(parts[2])
+		if strings.ToUpper(opt) != "BANK" || bankExpr == "" {
+			return fmt.Errorf("expected BANK[n], not %s", parts[2])
+		}
+		val, err := a.evalLayout(bankExpr)
+		if err != nil {
+			return err
+		}
+		bank = val
+	}
+	a.startSection(name, kind, bank, addr)
+	a.next[kind] = addr
+	return nil
+}
+
+// bracketed splits NAME[expr] into its parts.
+func bracketed(s string) (string, string) {
+	i := strings.Index(s, "[")
+	if i < 0 || !strings.HasSuffix(s, "]") {
+		return strings.TrimSpace(s), ""
+	}
+	return strings.TrimSpace(s[:i]), s[i+1 : len(s)-1]
+}
+
+func (a *assembler) data(args string, size int) error {
+	for _, arg := range splitArgs(args) {
+		if size == 1 && len(arg) > 1 && arg[0] == '"' && arg[len(arg)-1] == '"' {
+			a.emit([]byte(arg[1 : len(arg)-1])...)
+			continue
+		}
+		val, err := a.eval(arg)
+		if err != nil {
+			return err
+		}
+		if err := a.checkRange(val, size); err != nil {
+			return err
+		}
+		if size == 1 {
+			a.emit(byte(val))
+		} else {
+			a.emit(byte(val), byte(val>>8))
+		}
+	}
+	return nil
+}
+
+func (a *assembler) space(args string) error {
+	parts := splitArgs(args)
+	if len(parts) == 0 {
+		return fmt.Errorf("DS needs a length")
+	}
+	n, err := a.evalLayout(parts[0])
+	if err != nil {
+		return err
+	}
+	var fill int
+	if len(parts) > 1 {
+		if fill, err = a.eval(parts[1]); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < n; i++ {
+		a.emit(byte(fill))
+	}
+	return nil
+}
+
+func (a *assembler) checkRange(val, size int) error {
+	if a.pass == 1 {
+		return nil
+	}
+	if size == 1 && (val < -128 || val > 0xFF) {
+		return fmt.Errorf("$%X doesn't fit in a byte", val)
+	}
+	if size == 2 && (val < -0x8000 || val > 0xFFFF) {
+		return fmt.Errorf("$%X doesn't fit in a word", val)
+	}
+	return nil
+}
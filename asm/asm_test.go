@@ -0,0 +1,280 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/zbyrne/golangboy/disasm"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+type flatMemory [0x10000]byte
+
+func (m *flatMemory) ReadByte(addr uint16) byte {
+	return m[addr]
+}
+
+func (m *flatMemory) WriteByte(addr uint16, val byte) {
+	m[addr] = val
+}
+
+func (m *flatMemory) ReadWord(addr uint16) uint16 {
+	return uint16(m[addr+1])<<8 | uint16(m[addr])
+}
+
+func (m *flatMemory) WriteWord(addr uint16, val uint16) {
+	m[addr] = byte(val)
+	m[addr+1] = byte(val >> 8)
+}
+
+func assemble(t *testing.T, src string) *Program {
+	p, err := Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// Every opcode the disassembler prints should assemble back to itself.
+func TestRoundTrip(t *testing.T) {
+	for i := 0; i < 0x200; i++ {
+		var m flatMemory
+		code := []byte{byte(i), 0x12, 0x34}
+		if i >= 0x100 {
+			code = []byte{0xCB, byte(i)}
+		} else if i == 0xCB {
+			continue
+		} else if i == 0x10 {
+			// STOP is always followed by 0x00.
+			code[1] = 0
+		}
+		copy(m[0x150:], code)
+		in := disasm.Decode(&m, 0x150)
+		src := fmt.Sprintf("SECTION \"t\", ROM0[$150]\n\t%s\n", in)
+		p, err := Assemble(src)
+		if err != nil {
+			t.Errorf("%s: %v", in, err)
+			continue
+		}
+		if !bytes.Equal(p.Bytes(), in.Bytes) {
+			t.Errorf("%s assembled to %X, not %X", in, p.Bytes(), in.Bytes)
+		}
+	}
+}
+
+func TestSyntaxVariants(t *testing.T) {
+	for _, c := range []struct {
+		src  string
+		code []byte
+	}{
+		{"ld a, [hli]", []byte{0x2A}},
+		{"LD [HLD], A", []byte{0x32}},
+		{"ld [$ff00+c], a", []byte{0xE2}},
+		{"ldh [$40], a", []byte{0xE0, 0x40}},
+		{"cp $10", []byte{0xFE, 0x10}},
+		{"xor a", []byte{0xAF}},
+		{"sub [hl]", []byte{0x96}},
+		{"ld hl, sp - 2", []byte{0xF8, 0xFE}},
+		{"add sp, -1", []byte{0xE8, 0xFF}},
+		{"rst 8", []byte{0xCF}},
+		{"bit 1+2, c", []byte{0xCB, 0x59}},
+		{"ld b, -1", []byte{0x06, 0xFF}},
+		{"ld bc, HIGH($1234) | LOW($5678) << 8", []byte{0x01, 0x12, 0x78}},
+		{"ld a, 'A' + %11 * 2", []byte{0x3E, 0x47}},
+		{"jr @", []byte{0x18, 0xFE}},
+	} {
+		p, err := Assemble("\t" + c.src)
+		if err != nil {
+			t.Errorf("%s: %v", c.src, err)
+			continue
+		}
+		if !bytes.Equal(p.Bytes(), c.code) {
+			t.Errorf("%s assembled to %X, not %X", c.src, p.Bytes(), c.code)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	p := assemble(t, `
+COUNT EQU 3
+DEF STEP EQU 2
+SECTION "main", ROM0[$0150]
+Main:
+	ld b, COUNT
+.loop:
+	dec b
+	jr nz, .loop
+	jp Other.loop ; forward reference
+Other:
+	nop
+.loop
+	jr .loop
+	call Main
+`)
+	want := []byte{
+		0x06, 0x03,
+		0x05,
+		0x20, 0xFD,
+		0xC3, 0x59, 0x01,
+		0x00,
+		0x18, 0xFE,
+		0xCD, 0x50, 0x01,
+	}
+	if !bytes.Equal(p.Bytes(), want) {
+		t.Errorf("Assembled %X, not %X", p.Bytes(), want)
+	}
+	for name, val := range map[string]int{
+		"Main": 0x150, "Main.loop": 0x152, "Other": 0x158, "Other.loop": 0x159, "STEP": 2,
+	} {
+		if p.Symbols[name] != val {
+			t.Errorf("%s = $%X, not $%X", name, p.Symbols[name], val)
+		}
+	}
+}
+
+func TestData(t *testing.T) {
+	p := assemble(t, `
+	db 1, $FF, "Hi;", -1
+	dw $1234, End
+	ds 3, $AA
+End:
+`)
+	want := []byte{0x01, 0xFF, 'H', 'i', ';', 0xFF, 0x34, 0x12, 0x0D, 0x00, 0xAA, 0xAA, 0xAA}
+	if !bytes.Equal(p.Bytes(), want) {
+		t.Errorf("Assembled %X, not %X", p.Bytes(), want)
+	}
+}
+
+func TestSections(t *testing.T) {
+	p := assemble(t, `
+SECTION "entry", ROM0[$100]
+	jp Start
+SECTION "code", ROMX[$4000], BANK[2]
+Start:
+	ld a, [wCount]
+SECTION "more code", ROMX, BANK[2]
+	ret
+SECTION "vars", WRAM0
+wCount: ds 1
+`)
+	if len(p.Sections) != 4 {
+		t.Fatalf("Got %d sections, not 4", len(p.Sections))
+	}
+	if s := p.Sections[2]; s.Addr != 0x4003 || s.Bank != 2 {
+		t.Errorf("Floating section at %d:%04X, not 2:4003", s.Bank, s.Addr)
+	}
+	if p.Symbols["wCount"] != 0xC000 {
+		t.Errorf("wCount = $%X, not $C000", p.Symbols["wCount"])
+	}
+	rom := make([]byte, 0x10000)
+	if err := p.Patch(rom); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rom[0x100:0x103], []byte{0xC3, 0x00, 0x40}) {
+		t.Errorf("Bank 0 patched with %X", rom[0x100:0x103])
+	}
+	if !bytes.Equal(rom[0x8000:0x8004], []byte{0xFA, 0x00, 0xC0, 0xC9}) {
+		t.Errorf("Bank 2 patched with %X", rom[0x8000:0x8004])
+	}
+	if err := p.Patch(make([]byte, 0x8000)); err == nil {
+		t.Error("Patched bank 2 into a 32KB ROM")
+	}
+}
+
+func TestROM(t *testing.T) {
+	rom, err := ROM(`
+SECTION "main", ROM0[$100]
+	nop
+	jp $150
+`, 'T', 'E', 'S', 'T')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rom) != 0x8000 || !bytes.Equal(rom[0x100:0x104], []byte{0x00, 0xC3, 0x50, 0x01}) || string(rom[0x134:0x138]) != "TEST" {
+		t.Errorf("ROM is %d bytes with %X at $100 and %q at $134", len(rom), rom[0x100:0x104], rom[0x134:0x138])
+	}
+	if rom, err = ROM("SECTION \"x\", ROMX[$4000], BANK[5]\n\tdb 1\n"); err != nil || len(rom) != 0x20000 || rom[0x14000] != 1 {
+		t.Errorf("Bank 5 ROM is %d bytes: %v", len(rom), err)
+	}
+	if _, err := ROM("\tnop\n", make([]byte, 0x1D)...); err == nil {
+		t.Error("Header past $150 accepted")
+	}
+	if _, err := ROM("\tbad\n"); err == nil {
+		t.Error("Bad source made a ROM")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	p := assemble(t, `
+	ld a, 5
+	add a, a
+	halt
+`)
+	var m flatMemory
+	p.Load(&m)
+	cpu := z80.New(&m)
+	for i := 0; i < 3; i++ {
+		cpu.Dispatch()
+	}
+	if cpu.A != 10 || !cpu.Halted() {
+		t.Errorf("A = 0x%02X after running the program", cpu.A)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, src := range []string{
+		"\tld a, Missing",
+		"\tfoo a",
+		"\tld [bc], b",
+		"\tld a, 256",
+		"\tjr Far\n\tds 200\nFar:",
+		"\tldh [$C000], a",
+		"Dup:\nDup:",
+		"\tld a, (1",
+		"SECTION \"x\", ROMZ",
+		// Sizes and places that depend on labels further on.
+		"\tds End - @\nEnd:",
+		"Size EQU End - Start\nStart:\n\tds Size\nEnd:",
+		"SECTION \"x\", ROM0[Later]\nLater:",
+		"A EQU B\nB EQU End\n\tds A\nEnd:",
+	} {
+		_, err := Assemble(src)
+		if err == nil {
+			t.Errorf("%q assembled", src)
+		} else if _, ok := err.(*Error); !ok {
+			t.Errorf("%q returned %T", src, err)
+		}
+	}
+	p, err := Assemble("Start:\n\tnop\nEnd:\n\tds End - Start, $FF\nSize EQU End - Start\n\tds Size\nAfter:")
+	if err != nil || p.Symbols["After"] != 3 {
+		t.Errorf("Backward sizes put After at %d: %v", p.Symbols["After"], err)
+	}
+	_, err = Assemble("\tnop\n\n\tld a, b, c")
+	if e, ok := err.(*Error); !ok || e.Line != 3 {
+		t.Errorf("Error %v isn't on line 3", err)
+	}
+}
+
+func TestLineSizes(t *testing.T) {
+	sizes, err := LineSizes([]string{
+		"Main:",
+		"\tld a, [wUndefined]",
+		".loop: dec a ; comment",
+		"\tjr nz, .loop",
+		"",
+		"\tdb \"AB\", 3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{0, 3, 1, 2, 0, 3}
+	for i := range want {
+		if sizes[i] != want[i] {
+			t.Errorf("Line %d is %d bytes, not %d", i+1, sizes[i], want[i])
+		}
+	}
+	if _, err := LineSizes([]string{"\tmy_macro 1, 2"}); err == nil {
+		t.Error("Sized an unknown macro")
+	}
+}
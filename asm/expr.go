@@ -0,0 +1,262 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exprParser is a recursive descent evaluator for RGBDS numeric
+// expressions. Binary operators follow C precedence.
+type exprParser struct {
+	a   *assembler
+	s   string
+	pos int
+}
+
+func (a *assembler) eval(s string) (int, error) {
+	p := exprParser{a: a, s: s}
+	val, err := p.binary(0)
+	if err != nil {
+		return 0, err
+	}
+	p.space()
+	if p.pos != len(p.s) {
+		return 0, fmt.Errorf("unexpected %q in expression", p.s[p.pos:])
+	}
+	return val, nil
+}
+
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) space() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) operator(level int) string {
+	p.space()
+	for _, op := range precedence[level] {
+		if !strings.HasPrefix(p.s[p.pos:], op) {
+			continue
+		}
+		// Don't mistake the start of && or || for & or |.
+		rest := p.s[p.pos+len(op):]
+		if (op == "&" || op == "|") && len(rest) > 0 && rest[0] == op[0] {
+			continue
+		}
+		if (op == "<" || op == ">") && len(rest) > 0 && (rest[0] == op[0] || rest[0] == '=') {
+			continue
+		}
+		p.pos += len(op)
+		return op
+	}
+	return ""
+}
+
+func (p *exprParser) binary(level int) (int, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	lhs, err := p.binary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.operator(level)
+		if op == "" {
+			return lhs, nil
+		}
+		rhs, err := p.binary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "||":
+			lhs = truth(lhs != 0 || rhs != 0)
+		case "&&":
+			lhs = truth(lhs != 0 && rhs != 0)
+		case "|":
+			lhs |= rhs
+		case "^":
+			lhs ^= rhs
+		case "&":
+			lhs &= rhs
+		case "==":
+			lhs = truth(lhs == rhs)
+		case "!=":
+			lhs = truth(lhs != rhs)
+		case "<=":
+			lhs = truth(lhs <= rhs)
+		case ">=":
+			lhs = truth(lhs >= rhs)
+		case "<":
+			lhs = truth(lhs < rhs)
+		case ">":
+			lhs = truth(lhs > rhs)
+		case "<<":
+			lhs <<= uint(rhs)
+		case ">>":
+			lhs >>= uint(rhs)
+		case "+":
+			lhs += rhs
+		case "-":
+			lhs -= rhs
+		case "*":
+			lhs *= rhs
+		case "/", "%":
+			if rhs == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			if op == "/" {
+				lhs /= rhs
+			} else {
+				lhs %= rhs
+			}
+		}
+	}
+}
+
+func truth(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *exprParser) unary() (int, error) {
+	p.space()
+	if p.pos == len(p.s) {
+		return 0, fmt.Errorf("missing operand in %q", p.s)
+	}
+	switch p.s[p.pos] {
+	case '-', '~', '!', '+':
+		op := p.s[p.pos]
+		p.pos++
+		val, err := p.unary()
+		switch op {
+		case '-':
+			val = -val
+		case '~':
+			val = ^val
+		case '!':
+			val = truth(val == 0)
+		}
+		return val, err
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (int, error) {
+	c := p.s[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		val, err := p.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		p.space()
+		if p.pos == len(p.s) || p.s[p.pos] != ')' {
+			return 0, fmt.Errorf("missing ) in %q", p.s)
+		}
+		p.pos++
+		return val, nil
+	case c == '\'':
+		end := strings.IndexByte(p.s[p.pos+1:], '\'')
+		if end != 1 {
+			return 0, fmt.Errorf("bad character constant in %q", p.s)
+		}
+		val := int(p.s[p.pos+1])
+		p.pos += 3
+		return val, nil
+	case c == '@' && !isIdent(p.peek(1)):
+		p.pos++
+		return int(p.a.pc), nil
+	case c == '$' || c == '%' || c == '&' || isDigit(c):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.s) && isIdent(p.s[p.pos]) {
+			p.pos++
+		}
+		return parseNumber(p.s[start:p.pos])
+	case isIdent(c):
+		start := p.pos
+		for p.pos < len(p.s) && isIdent(p.s[p.pos]) {
+			p.pos++
+		}
+		name := p.s[start:p.pos]
+		p.space()
+		if p.peek(0) == '(' {
+			return p.function(name)
+		}
+		return p.a.lookup(name)
+	}
+	return 0, fmt.Errorf("unexpected %q in expression", p.s[p.pos:])
+}
+
+func (p *exprParser) peek(n int) byte {
+	if p.pos+n < len(p.s) {
+		return p.s[p.pos+n]
+	}
+	return 0
+}
+
+func (p *exprParser) function(name string) (int, error) {
+	p.pos++
+	val, err := p.binary(0)
+	if err != nil {
+		return 0, err
+	}
+	p.space()
+	if p.peek(0) != ')' {
+		return 0, fmt.Errorf("missing ) after %s", name)
+	}
+	p.pos++
+	switch strings.ToUpper(name) {
+	case "HIGH":
+		return (val >> 8) & 0xFF, nil
+	case "LOW":
+		return val & 0xFF, nil
+	}
+	return 0, fmt.Errorf("unknown function %s", name)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '.' || c == '#' || c == '@' || isDigit(c) ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func parseNumber(s string) (int, error) {
+	base := 10
+	digits := s
+	switch s[0] {
+	case '$':
+		base, digits = 16, s[1:]
+	case '%':
+		base, digits = 2, s[1:]
+	case '&':
+		base, digits = 8, s[1:]
+	}
+	val, err := strconv.ParseInt(strings.Replace(digits, "_", "", -1), base, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad number %q", s)
+	}
+	return int(val), nil
+}
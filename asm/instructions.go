@@ -0,0 +1,275 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// encoding is one form of an instruction. The operands use the same
+// placeholders as z80.Opcodes: n8, n16, a8, a16 and e8.
+type encoding struct {
+	operands []string
+	opcode   []byte
+}
+
+var instructions = map[string][]encoding{}
+
+func define(text string, opcode ...byte) {
+	fields := strings.SplitN(text, " ", 2)
+	var operands []string
+	if len(fields) > 1 {
+		operands = strings.Split(fields[1], ", ")
+	}
+	instructions[fields[0]] = append(instructions[fields[0]], encoding{operands, opcode})
+}
+
+func init() {
+	r8 := []string{"B", "C", "D", "E", "H", "L", "[HL]", "A"}
+	r16 := []string{"BC", "DE", "HL", "SP"}
+	stack := []string{"BC", "DE", "HL", "AF"}
+	cond := []string{"NZ", "Z", "NC", "C"}
+	alu := []string{"ADD", "ADC", "SUB", "SBC", "AND", "XOR", "OR", "CP"}
+	shifts := []string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SWAP", "SRL"}
+	hexDigits := "0123456789ABCDEF"
+	hex := func(b byte) string {
+		return "$" + string(hexDigits[b>>4]) + string(hexDigits[b&0xF])
+	}
+
+	define("NOP", 0x00)
+	define("STOP", 0x10, 0x00)
+	define("HALT", 0x76)
+	define("DI", 0xF3)
+	define("EI", 0xFB)
+	define("RLCA", 0x07)
+	define("RRCA", 0x0F)
+	define("RLA", 0x17)
+	define("RRA", 0x1F)
+	define("DAA", 0x27)
+	define("CPL", 0x2F)
+	define("SCF", 0x37)
+	define("CCF", 0x3F)
+	for i, r := range r16 {
+		op := byte(i) << 4
+		define("LD "+r+", n16", 0x01|op)
+		define("INC "+r, 0x03|op)
+		define("ADD HL, "+r, 0x09|op)
+		define("DEC "+r, 0x0B|op)
+		define("POP "+stack[i], 0xC1|op)
+		define("PUSH "+stack[i], 0xC5|op)
+	}
+	define("LD [BC], A", 0x02)
+	define("LD [DE], A", 0x12)
+	define("LD [HL+], A", 0x22)
+	define("LD [HL-], A", 0x32)
+	define("LD A, [BC]", 0x0A)
+	define("LD A, [DE]", 0x1A)
+	define("LD A, [HL+]", 0x2A)
+	define("LD A, [HL-]", 0x3A)
+	for i, r := range r8 {
+		op := byte(i) << 3
+		define("INC "+r, 0x04|op)
+		define("DEC "+r, 0x05|op)
+		define("LD "+r+", n8", 0x06|op)
+		define(alu[i]+" A, n8", 0xC6|op)
+		define("RST "+hex(op), 0xC7|op)
+		for j, src := range r8 {
+			// LD [HL], [HL] would be 0x76, which is HALT.
+			if i != 6 || j != 6 {
+				define("LD "+r+", "+src, 0x40|op|byte(j))
+			}
+			define(alu[i]+" A, "+src, 0x80|op|byte(j))
+			define(shifts[i]+" "+src, 0xCB, op|byte(j))
+			define("BIT "+string(hexDigits[i])+", "+src, 0xCB, 0x40|op|byte(j))
+			define("RES "+string(hexDigits[i])+", "+src, 0xCB, 0x80|op|byte(j))
+			define("SET "+string(hexDigits[i])+", "+src, 0xCB, 0xC0|op|byte(j))
+		}
+	}
+	define("LD [a16], SP", 0x08)
+	define("JR e8", 0x18)
+	for i, c := range cond {
+		op := byte(i) << 3
+		define("JR "+c+", e8", 0x20|op)
+		define("RET "+c, 0xC0|op)
+		define("JP "+c+", a16", 0xC2|op)
+		define("CALL "+c+", a16", 0xC4|op)
+	}
+	define("JP a16", 0xC3)
+	define("JP HL", 0xE9)
+	define("CALL a16", 0xCD)
+	define("RET", 0xC9)
+	define("RETI", 0xD9)
+	define("LDH [a8], A", 0xE0)
+	define("LDH A, [a8]", 0xF0)
+	define("LDH [C], A", 0xE2)
+	define("LDH A, [C]", 0xF2)
+	define("LD [C], A", 0xE2)
+	define("LD A, [C]", 0xF2)
+	define("ADD SP, e8", 0xE8)
+	define("LD HL, SP+e8", 0xF8)
+	define("LD SP, HL", 0xF9)
+	define("LD [a16], A", 0xEA)
+	define("LD A, [a16]", 0xFA)
+}
+
+// Operand kinds for matching source against encodings.
+const (
+	literal = iota
+	immediate
+	indirect
+	spOffset
+)
+
+type operand struct {
+	kind int
+	text string
+	expr string
+}
+
+var aliases = map[string]string{
+	"[HLI]":     "[HL+]",
+	"[HLD]":     "[HL-]",
+	"[$FF00+C]": "[C]",
+}
+
+var literals = map[string]bool{
+	"A": true, "B": true, "C": true, "D": true, "E": true, "H": true, "L": true,
+	"AF": true, "BC": true, "DE": true, "HL": true, "SP": true, "NZ": true,
+	"Z": true, "NC": true, "[HL]": true, "[BC]": true, "[DE]": true,
+	"[HL+]": true, "[HL-]": true, "[C]": true,
+}
+
+func parseOperand(s string) operand {
+	norm := strings.ToUpper(strings.Replace(s, " ", "", -1))
+	if alias, ok := aliases[norm]; ok {
+		norm = alias
+	}
+	switch {
+	case literals[norm]:
+		return operand{kind: literal, text: norm}
+	case strings.HasPrefix(norm, "SP+"):
+		return operand{kind: spOffset, expr: s[strings.Index(s, "+")+1:]}
+	case strings.HasPrefix(norm, "SP-"):
+		return operand{kind: spOffset, expr: "-(" + s[strings.Index(s, "-")+1:] + ")"}
+	case strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]"):
+		return operand{kind: indirect, expr: s[1 : len(s)-1]}
+	}
+	return operand{kind: immediate, expr: s}
+}
+
+// aluOps take A as an implied first operand when it's left out.
+var aluOps = map[string]bool{
+	"ADD": true, "ADC": true, "SUB": true, "SBC": true,
+	"AND": true, "XOR": true, "OR": true, "CP": true,
+}
+
+func (a *assembler) instruction(mnemonic, args string) error {
+	forms, ok := instructions[mnemonic]
+	if !ok {
+		return fmt.Errorf("unknown instruction %s", mnemonic)
+	}
+	var ops []operand
+	for _, arg := range splitArgs(args) {
+		ops = append(ops, parseOperand(arg))
+	}
+	if aluOps[mnemonic] && len(ops) == 1 {
+		ops = append([]operand{{kind: literal, text: "A"}}, ops...)
+	}
+	for _, form := range forms {
+		if ok, err := a.matches(form, ops); err != nil {
+			return err
+		} else if ok {
+			return a.encode(form, ops)
+		}
+	}
+	return fmt.Errorf("bad operands for %s: %s", mnemonic, args)
+}
+
+func (a *assembler) matches(form encoding, ops []operand) (bool, error) {
+	if len(form.operands) != len(ops) {
+		return false, nil
+	}
+	for i, want := range form.operands {
+		op := ops[i]
+		switch want {
+		case "n8", "n16", "a16", "e8":
+			if op.kind != immediate {
+				return false, nil
+			}
+		case "[a8]", "[a16]":
+			if op.kind != indirect {
+				return false, nil
+			}
+		case "SP+e8":
+			if op.kind != spOffset {
+				return false, nil
+			}
+		default:
+			if isDigit(want[0]) || want[0] == '$' {
+				// RST vectors and bit numbers are part of the opcode.
+				if op.kind != immediate {
+					return false, nil
+				}
+				val, err := a.eval(op.expr)
+				if err != nil {
+					return false, err
+				}
+				if n, _ := parseNumber(want); n != val {
+					return false, nil
+				}
+			} else if op.kind != literal || op.text != want {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+func (a *assembler) encode(form encoding, ops []operand) error {
+	// Evaluate everything before emitting so @ is the instruction's address.
+	out := append([]byte{}, form.opcode...)
+	for i, want := range form.operands {
+		var size int
+		switch want {
+		case "n8", "e8", "SP+e8", "[a8]":
+			size = 1
+		case "n16", "a16", "[a16]":
+			size = 2
+		default:
+			continue
+		}
+		val, err := a.eval(ops[i].expr)
+		if err != nil {
+			return err
+		}
+		switch {
+		case want == "[a8]":
+			if val >= 0xFF00 && val <= 0xFFFF {
+				val &= 0xFF
+			}
+			if a.pass == 2 && (val < 0 || val > 0xFF) {
+				return fmt.Errorf("LDH address $%X isn't in $FF00-$FFFF", val)
+			}
+		case want == "e8" && form.opcode[0] != 0xE8:
+			// Relative jumps are written with their target address.
+			val -= int(a.pc) + 2
+			if a.pass == 2 && (val < -128 || val > 127) {
+				return fmt.Errorf("jump target is %d bytes away", val)
+			}
+		case want == "e8" || want == "SP+e8":
+			if a.pass == 2 && (val < -128 || val > 127) {
+				return fmt.Errorf("offset %d doesn't fit in a signed byte", val)
+			}
+		default:
+			if err := a.checkRange(val, size); err != nil {
+				return err
+			}
+		}
+		if size == 1 {
+			out = append(out, byte(val))
+		} else {
+			out = append(out, byte(val), byte(val>>8))
+		}
+	}
+	a.emit(out...)
+	return nil
+}
@@ -0,0 +1,133 @@
+package cartridge
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/zbyrne/golangboy/savestate"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+type controller interface {
+	ReadByte(uint16) byte
+	WriteByte(uint16, byte)
+	ROMBank() int
+	RAMBank() int
+	saveState(e *savestate.Encoder)
+	loadState(d *savestate.Decoder)
+}
+
+// Cartridge maps 0x0000-0x7FFF and 0xA000-0xBFFF through the cartridge's
+// memory bank controller.
+type Cartridge struct {
+	Header
+	rom []byte
+	ram []byte
+	rtc *rtc
+	mbc controller
+}
+
+func New(rom []byte) (*Cartridge, error) {
+	h, err := ParseHeader(rom)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cartridge{Header: h, rom: rom}
+	if len(c.rom) < 0x8000 {
+		c.rom = append(c.rom, make([]byte, 0x8000-len(c.rom))...)
+	}
+	c.ram = make([]byte, h.RAMSize)
+	switch h.Type {
+	case 0x00, 0x08, 0x09:
+		c.mbc = &romOnly{c: c}
+	case 0x01, 0x02, 0x03:
+		c.mbc = &mbc1{c: c, bank1: 1}
+	case 0x05, 0x06:
+		c.ram = make([]byte, 0x200)
+		c.mbc = &mbc2{c: c, bank: 1}
+	case 0x0F, 0x10, 0x11, 0x12, 0x13:
+		if h.RTC() {
+			c.rtc = &rtc{}
+		}
+		c.mbc = &mbc3{c: c, romBank: 1}
+	case 0x19, 0x1A, 0x1B, 0x1C, 0x1D, 0x1E:
+		c.mbc = &mbc5{c: c, romBank: 1}
+	default:
+		return nil, fmt.Errorf("cartridge: unsupported cartridge type 0x%02X", h.Type)
+	}
+	return c, nil
+}
+
+func Load(path string) (*Cartridge, error) {
+	rom, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(rom)
+}
+
+func (c *Cartridge) ReadByte(addr uint16) byte {
+	return c.mbc.ReadByte(addr)
+}
+
+func (c *Cartridge) WriteByte(addr uint16, val byte) {
+	c.mbc.WriteByte(addr, val)
+}
+
+// ROM returns the whole ROM image.
+func (c *Cartridge) ROM() []byte {
+	return c.rom
+}
+
+// ROMBank returns the bank currently mapped at 0x4000-0x7FFF.
+func (c *Cartridge) ROMBank() int {
+	return c.mbc.ROMBank()
+}
+
+// RAMBank returns the bank currently mapped at 0xA000-0xBFFF.
+func (c *Cartridge) RAMBank() int {
+	return c.mbc.RAMBank()
+}
+
+// RAM returns the cartridge RAM, for writing battery saves.
+func (c *Cartridge) RAM() []byte {
+	return c.ram
+}
+
+func (c *Cartridge) LoadRAM(ram []byte) {
+	copy(c.ram, ram)
+}
+
+// Tick advances the real time clock, if there is one. The clock runs off
+// emulated cycles rather than the host's clock so runs are repeatable.
+func (c *Cartridge) Tick(t z80.ClockTicks) {
+	if c.rtc != nil {
+		c.rtc.tick(t)
+	}
+}
+
+func (c *Cartridge) romByte(bank int, addr uint16) byte {
+	offset := (bank*0x4000 + int(addr&0x3FFF)) % len(c.rom)
+	return c.rom[offset]
+}
+
+func (c *Cartridge) ramIndex(bank int, addr uint16) int {
+	if len(c.ram) == 0 {
+		return -1
+	}
+	return (bank*0x2000 + int(addr&0x1FFF)) % len(c.ram)
+}
+
+func (c *Cartridge) readRAM(bank int, addr uint16) byte {
+	i := c.ramIndex(bank, addr)
+	if i < 0 {
+		return 0xFF
+	}
+	return c.ram[i]
+}
+
+func (c *Cartridge) writeRAM(bank int, addr uint16, val byte) {
+	if i := c.ramIndex(bank, addr); i >= 0 {
+		c.ram[i] = val
+	}
+}
@@ -0,0 +1,297 @@
+package cartridge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zbyrne/golangboy/savestate"
+)
+
+// makeROM builds a ROM where the first byte of every bank is the bank
+// number.
+func makeROM(kind byte, banks int, ramCode byte) []byte {
+	rom := make([]byte, banks*0x4000)
+	for b := 0; b < banks; b++ {
+		rom[b*0x4000] = byte(b)
+		rom[b*0x4000+1] = byte(b >> 8)
+	}
+	copy(rom[0x134:], "TESTROM")
+	rom[0x147] = kind
+	for size := 0x8000; size < len(rom); size <<= 1 {
+		rom[0x148]++
+	}
+	rom[0x149] = ramCode
+	rom[0x14D] = HeaderChecksum(rom)
+	return rom
+}
+
+func TestParseHeader(t *testing.T) {
+	rom := makeROM(0x03, 8, 0x03)
+	rom[0x143] = 0x80
+	rom[0x146] = 0x03
+	rom[0x14B] = 0x33
+	rom[0x14D] = HeaderChecksum(rom)
+	h, err := ParseHeader(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Title != "TESTROM" {
+		t.Errorf("Title = %q, not \"TESTROM\"", h.Title)
+	}
+	if h.TitleSum() != 0x2E {
+		t.Errorf("Title sum = 0x%02X, not 0x2E", h.TitleSum())
+	}
+	if h.ROMSize != 0x20000 {
+		t.Errorf("ROM size = 0x%X, not 0x20000", h.ROMSize)
+	}
+	if h.RAMSize != 0x8000 {
+		t.Errorf("RAM size = 0x%X, not 0x8000", h.RAMSize)
+	}
+	if !h.CGB() || h.CGBOnly() {
+		t.Error("CGB flag decoded wrongly")
+	}
+	if !h.SGB() {
+		t.Error("SGB flag not decoded")
+	}
+	if !h.Battery() {
+		t.Error("Battery not detected")
+	}
+	if h.Nintendo() {
+		t.Error("Blank new licensee code is Nintendo")
+	}
+	if h.NewLicensee = "01"; !h.Nintendo() {
+		t.Error("New licensee code \"01\" isn't Nintendo")
+	}
+	if h.HeaderChecksum != HeaderChecksum(rom) {
+		t.Errorf("Header checksum = 0x%02X, not 0x%02X", h.HeaderChecksum, HeaderChecksum(rom))
+	}
+}
+
+func TestParseHeaderShort(t *testing.T) {
+	if _, err := ParseHeader(make([]byte, 0x100)); err != ErrShortROM {
+		t.Errorf("Parsing a short ROM returned %v", err)
+	}
+}
+
+func TestUnsupportedType(t *testing.T) {
+	if _, err := New(makeROM(0xFC, 2, 0)); err == nil {
+		t.Error("Created a cartridge with an unsupported MBC")
+	}
+}
+
+func TestROMOnly(t *testing.T) {
+	c, err := New(makeROM(0x00, 2, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.WriteByte(0x2000, 0x05)
+	if val := c.ReadByte(0x4000); val != 1 {
+		t.Errorf("Read bank %d at 0x4000, not 1", val)
+	}
+	if val := c.ReadByte(0xA000); val != 0xFF {
+		t.Errorf("Read 0x%02X with no RAM, not 0xFF", val)
+	}
+}
+
+func TestMBC1Banking(t *testing.T) {
+	c, _ := New(makeROM(0x01, 64, 0))
+	for _, bank := range []byte{1, 2, 0x1F} {
+		c.WriteByte(0x2000, bank)
+		if val := c.ReadByte(0x4000); val != bank {
+			t.Errorf("Read bank %d, not %d", val, bank)
+		}
+		if c.ROMBank() != int(bank) {
+			t.Errorf("ROMBank = %d, not %d", c.ROMBank(), bank)
+		}
+	}
+	c.WriteByte(0x2000, 0)
+	if val := c.ReadByte(0x4000); val != 1 {
+		t.Errorf("Bank 0 selected bank %d, not 1", val)
+	}
+	c.WriteByte(0x2000, 0x02)
+	c.WriteByte(0x4000, 0x01)
+	if val := c.ReadByte(0x4000); val != 0x22 {
+		t.Errorf("Read bank 0x%02X, not 0x22", val)
+	}
+	if val := c.ReadByte(0x0000); val != 0 {
+		t.Errorf("Read bank 0x%02X at 0x0000 in mode 0", val)
+	}
+	c.WriteByte(0x6000, 0x01)
+	if val := c.ReadByte(0x0000); val != 0x20 {
+		t.Errorf("Read bank 0x%02X at 0x0000 in mode 1, not 0x20", val)
+	}
+}
+
+func TestMBC1BankWraps(t *testing.T) {
+	c, _ := New(makeROM(0x01, 4, 0))
+	c.WriteByte(0x2000, 0x05)
+	if val := c.ReadByte(0x4000); val != 1 {
+		t.Errorf("Read bank %d, not 1", val)
+	}
+}
+
+func TestMBC1RAM(t *testing.T) {
+	c, _ := New(makeROM(0x03, 4, 0x03))
+	c.WriteByte(0xA000, 0x12)
+	if val := c.ReadByte(0xA000); val != 0xFF {
+		t.Errorf("Read 0x%02X from disabled RAM, not 0xFF", val)
+	}
+	c.WriteByte(0x0000, 0x0A)
+	c.WriteByte(0xA000, 0x12)
+	c.WriteByte(0x6000, 0x01)
+	c.WriteByte(0x4000, 0x02)
+	c.WriteByte(0xA000, 0x34)
+	if c.RAMBank() != 2 {
+		t.Errorf("RAMBank = %d, not 2", c.RAMBank())
+	}
+	if c.RAM()[0x4000] != 0x34 {
+		t.Errorf("Bank 2 holds 0x%02X, not 0x34", c.RAM()[0x4000])
+	}
+	c.WriteByte(0x6000, 0x00)
+	if val := c.ReadByte(0xA000); val != 0x12 {
+		t.Errorf("Read 0x%02X from bank 0, not 0x12", val)
+	}
+}
+
+func TestMBC2(t *testing.T) {
+	c, _ := New(makeROM(0x06, 16, 0))
+	c.WriteByte(0x2100, 0x03)
+	if val := c.ReadByte(0x4000); val != 3 {
+		t.Errorf("Read bank %d, not 3", val)
+	}
+	c.WriteByte(0x0000, 0x0A)
+	c.WriteByte(0xA001, 0xAB)
+	if val := c.ReadByte(0xA201); val != 0xFB {
+		t.Errorf("Read 0x%02X from mirrored RAM, not 0xFB", val)
+	}
+}
+
+func TestMBC3(t *testing.T) {
+	c, _ := New(makeROM(0x13, 128, 0x03))
+	c.WriteByte(0x2000, 0x7F)
+	if val := c.ReadByte(0x4000); val != 0x7F {
+		t.Errorf("Read bank 0x%02X, not 0x7F", val)
+	}
+	c.WriteByte(0x0000, 0x0A)
+	c.WriteByte(0x4000, 0x03)
+	c.WriteByte(0xBFFF, 0x99)
+	if c.RAM()[0x7FFF] != 0x99 {
+		t.Errorf("Bank 3 holds 0x%02X, not 0x99", c.RAM()[0x7FFF])
+	}
+	c.WriteByte(0x4000, 0x08)
+	if val := c.ReadByte(0xA000); val != 0xFF {
+		t.Errorf("Read 0x%02X from RTC on a cart without one, not 0xFF", val)
+	}
+}
+
+func TestMBC3RTC(t *testing.T) {
+	c, _ := New(makeROM(0x10, 4, 0x03))
+	c.WriteByte(0x0000, 0x0A)
+	c.Tick(ticksPerSecond * 61)
+	c.WriteByte(0x6000, 0x00)
+	c.WriteByte(0x6000, 0x01)
+	c.WriteByte(0x4000, RTC_S)
+	if val := c.ReadByte(0xA000); val != 1 {
+		t.Errorf("Seconds = %d, not 1", val)
+	}
+	c.WriteByte(0x4000, RTC_M)
+	if val := c.ReadByte(0xA000); val != 1 {
+		t.Errorf("Minutes = %d, not 1", val)
+	}
+	// The latched value doesn't move until latched again.
+	c.Tick(ticksPerSecond)
+	c.WriteByte(0x4000, RTC_S)
+	if val := c.ReadByte(0xA000); val != 1 {
+		t.Errorf("Latched seconds = %d, not 1", val)
+	}
+}
+
+func TestMBC3RTCHalt(t *testing.T) {
+	c, _ := New(makeROM(0x10, 4, 0x03))
+	c.WriteByte(0x0000, 0x0A)
+	c.WriteByte(0x4000, RTC_DH)
+	c.WriteByte(0xA000, 0x40)
+	c.Tick(ticksPerSecond * 5)
+	c.WriteByte(0x6000, 0x00)
+	c.WriteByte(0x6000, 0x01)
+	c.WriteByte(0x4000, RTC_S)
+	if val := c.ReadByte(0xA000); val != 0 {
+		t.Errorf("Halted clock counted to %d", val)
+	}
+}
+
+func TestMBC3RTCDayCarry(t *testing.T) {
+	r := &rtc{}
+	r.write(RTC_DL, 0xFF)
+	r.write(RTC_DH, 0x01)
+	r.write(RTC_H, 23)
+	r.write(RTC_M, 59)
+	r.write(RTC_S, 59)
+	r.tick(ticksPerSecond)
+	r.latch()
+	if val := r.read(RTC_DH); val != 0x80 {
+		t.Errorf("DH = 0x%02X, not 0x80", val)
+	}
+	if val := r.read(RTC_DL); val != 0 {
+		t.Errorf("DL = 0x%02X, not 0x00", val)
+	}
+}
+
+func TestMBC5(t *testing.T) {
+	c, _ := New(makeROM(0x1B, 512, 0x04))
+	c.WriteByte(0x2000, 0x00)
+	if val := c.ReadByte(0x4000); val != 0 {
+		t.Errorf("Read bank %d, not 0", val)
+	}
+	c.WriteByte(0x2000, 0x23)
+	c.WriteByte(0x3000, 0x01)
+	if c.ROMBank() != 0x123 {
+		t.Errorf("ROMBank = 0x%X, not 0x123", c.ROMBank())
+	}
+	if lo, hi := c.ReadByte(0x4000), c.ReadByte(0x4001); lo != 0x23 || hi != 0x01 {
+		t.Errorf("Read bank 0x%02X%02X, not 0x0123", hi, lo)
+	}
+	c.WriteByte(0x0000, 0x0A)
+	c.WriteByte(0x4000, 0x0F)
+	c.WriteByte(0xA000, 0x42)
+	if c.RAM()[0xF*0x2000] != 0x42 {
+		t.Error("Write did not land in RAM bank 15")
+	}
+}
+
+func TestLoadRAM(t *testing.T) {
+	c, _ := New(makeROM(0x03, 4, 0x02))
+	c.LoadRAM([]byte{1, 2, 3})
+	c.WriteByte(0x0000, 0x0A)
+	if val := c.ReadByte(0xA002); val != 3 {
+		t.Errorf("Read %d from loaded RAM, not 3", val)
+	}
+}
+
+func TestState(t *testing.T) {
+	for _, kind := range []byte{0x00, 0x03, 0x06, 0x10, 0x1B} {
+		c, _ := New(makeROM(kind, 8, 0x03))
+		c.WriteByte(0x0000, 0x0A)
+		c.WriteByte(0x2000, 0x05)
+		c.WriteByte(0x4000, 0x01)
+		c.WriteByte(0xA010, 0x77)
+		c.Tick(5 * ticksPerSecond)
+		loaded, _ := New(makeROM(kind, 8, 0x03))
+		if err := savestate.Copy(loaded, c, STATE_VERSION); err != nil {
+			t.Fatalf("Type 0x%02X: %v", kind, err)
+		}
+		if !reflect.DeepEqual(loaded, c) {
+			t.Errorf("Type 0x%02X loaded %+v, saved %+v", kind, loaded, c)
+		}
+	}
+}
+
+func TestStateWrongCartridge(t *testing.T) {
+	c, _ := New(makeROM(0x03, 8, 0x03))
+	other := makeROM(0x03, 8, 0x03)
+	copy(other[0x134:], "OTHERROM")
+	loaded, _ := New(other)
+	if err := savestate.Copy(loaded, c, STATE_VERSION); err != ErrWrongCartridge {
+		t.Errorf("Loaded another game's state: %v", err)
+	}
+}
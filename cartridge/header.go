@@ -0,0 +1,112 @@
+package cartridge
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	HEADER_END = 0x150
+)
+
+var ErrShortROM = errors.New("cartridge: ROM is too small to contain a header")
+
+type Header struct {
+	Title          string
+	CGBFlag        byte
+	NewLicensee    string
+	SGBFlag        byte
+	Type           byte
+	ROMSize        int
+	RAMSize        int
+	Destination    byte
+	OldLicensee    byte
+	Version        byte
+	HeaderChecksum byte
+	GlobalChecksum uint16
+}
+
+func ParseHeader(rom []byte) (Header, error) {
+	var h Header
+	if len(rom) < HEADER_END {
+		return h, ErrShortROM
+	}
+	title := rom[0x134:0x144]
+	h.CGBFlag = rom[0x143]
+	if h.CGBFlag&0x80 != 0 {
+		// The last title byte is the CGB flag on colour titles.
+		title = title[:15]
+	}
+	h.Title = strings.TrimRight(string(title), "\x00")
+	h.NewLicensee = string(rom[0x144:0x146])
+	h.SGBFlag = rom[0x146]
+	h.Type = rom[0x147]
+	h.ROMSize = 0x8000 << rom[0x148]
+	switch rom[0x149] {
+	case 0x01:
+		h.RAMSize = 0x800
+	case 0x02:
+		h.RAMSize = 0x2000
+	case 0x03:
+		h.RAMSize = 0x8000
+	case 0x04:
+		h.RAMSize = 0x20000
+	case 0x05:
+		h.RAMSize = 0x10000
+	}
+	h.Destination = rom[0x14A]
+	h.OldLicensee = rom[0x14B]
+	h.Version = rom[0x14C]
+	h.HeaderChecksum = rom[0x14D]
+	h.GlobalChecksum = uint16(rom[0x14E])<<8 | uint16(rom[0x14F])
+	return h, nil
+}
+
+// HeaderChecksum computes the checksum the boot ROM verifies over
+// 0x134-0x14C.
+func HeaderChecksum(rom []byte) byte {
+	var sum byte
+	for _, b := range rom[0x134:0x14D] {
+		sum = sum - b - 1
+	}
+	return sum
+}
+
+func (h Header) CGB() bool {
+	return h.CGBFlag&0x80 != 0
+}
+
+func (h Header) CGBOnly() bool {
+	return h.CGBFlag == 0xC0
+}
+
+func (h Header) SGB() bool {
+	return h.SGBFlag == 0x03 && h.OldLicensee == 0x33
+}
+
+// Nintendo reports whether Nintendo's licensee code is in the header.
+func (h Header) Nintendo() bool {
+	return h.OldLicensee == 0x01 || h.OldLicensee == 0x33 && h.NewLicensee == "01"
+}
+
+// TitleSum adds up the title's bytes. A CGB boot ROM uses it to look up
+// palettes for Nintendo's DMG games.
+func (h Header) TitleSum() byte {
+	var sum byte
+	for _, c := range []byte(h.Title) {
+		sum += c
+	}
+	return sum
+}
+
+func (h Header) Battery() bool {
+	switch h.Type {
+	case 0x03, 0x06, 0x09, 0x0D, 0x0F, 0x10, 0x13, 0x1B, 0x1E, 0x22, 0xFF:
+		return true
+	}
+	return false
+}
+
+func (h Header) RTC() bool {
+	return h.Type == 0x0F || h.Type == 0x10
+}
@@ -0,0 +1,242 @@
+package cartridge
+
+type romOnly struct {
+	c *Cartridge
+}
+
+func (m *romOnly) ReadByte(addr uint16) byte {
+	if addr < 0x8000 {
+		return m.c.romByte(int(addr>>14), addr)
+	}
+	return m.c.readRAM(0, addr)
+}
+
+func (m *romOnly) WriteByte(addr uint16, val byte) {
+	if addr >= 0xA000 {
+		m.c.writeRAM(0, addr, val)
+	}
+}
+
+func (m *romOnly) ROMBank() int {
+	return 1
+}
+
+func (m *romOnly) RAMBank() int {
+	return 0
+}
+
+type mbc1 struct {
+	c          *Cartridge
+	ramEnabled bool
+	bank1      byte
+	bank2      byte
+	mode       byte
+}
+
+func (m *mbc1) ReadByte(addr uint16) byte {
+	switch {
+	case addr < 0x4000:
+		if m.mode == 1 {
+			return m.c.romByte(int(m.bank2)<<5, addr)
+		}
+		return m.c.romByte(0, addr)
+	case addr < 0x8000:
+		return m.c.romByte(m.ROMBank(), addr)
+	}
+	if !m.ramEnabled {
+		return 0xFF
+	}
+	return m.c.readRAM(m.RAMBank(), addr)
+}
+
+func (m *mbc1) WriteByte(addr uint16, val byte) {
+	switch {
+	case addr < 0x2000:
+		m.ramEnabled = val&0x0F == 0x0A
+	case addr < 0x4000:
+		m.bank1 = val & 0x1F
+		if m.bank1 == 0 {
+			m.bank1 = 1
+		}
+	case addr < 0x6000:
+		m.bank2 = val & 0x03
+	case addr < 0x8000:
+		m.mode = val & 1
+	default:
+		if m.ramEnabled {
+			m.c.writeRAM(m.RAMBank(), addr, val)
+		}
+	}
+}
+
+func (m *mbc1) ROMBank() int {
+	return (int(m.bank2)<<5 | int(m.bank1)) % (len(m.c.rom) / 0x4000)
+}
+
+func (m *mbc1) RAMBank() int {
+	if m.mode == 1 {
+		return int(m.bank2)
+	}
+	return 0
+}
+
+type mbc2 struct {
+	c          *Cartridge
+	ramEnabled bool
+	bank       byte
+}
+
+func (m *mbc2) ReadByte(addr uint16) byte {
+	switch {
+	case addr < 0x4000:
+		return m.c.romByte(0, addr)
+	case addr < 0x8000:
+		return m.c.romByte(m.ROMBank(), addr)
+	}
+	if !m.ramEnabled {
+		return 0xFF
+	}
+	// 512 half-bytes, mirrored through the whole RAM area.
+	return m.c.ram[addr&0x1FF] | 0xF0
+}
+
+func (m *mbc2) WriteByte(addr uint16, val byte) {
+	switch {
+	case addr < 0x4000:
+		if addr&0x100 == 0 {
+			m.ramEnabled = val&0x0F == 0x0A
+		} else {
+			m.bank = val & 0x0F
+			if m.bank == 0 {
+				m.bank = 1
+			}
+		}
+	case addr >= 0xA000:
+		if m.ramEnabled {
+			m.c.ram[addr&0x1FF] = val & 0x0F
+		}
+	}
+}
+
+func (m *mbc2) ROMBank() int {
+	return int(m.bank) % (len(m.c.rom) / 0x4000)
+}
+
+func (m *mbc2) RAMBank() int {
+	return 0
+}
+
+type mbc3 struct {
+	c          *Cartridge
+	ramEnabled bool
+	romBank    byte
+	ramBank    byte
+	latch      byte
+}
+
+func (m *mbc3) ReadByte(addr uint16) byte {
+	switch {
+	case addr < 0x4000:
+		return m.c.romByte(0, addr)
+	case addr < 0x8000:
+		return m.c.romByte(m.ROMBank(), addr)
+	}
+	if !m.ramEnabled {
+		return 0xFF
+	}
+	if m.ramBank >= 0x08 {
+		if m.c.rtc == nil {
+			return 0xFF
+		}
+		return m.c.rtc.read(m.ramBank)
+	}
+	return m.c.readRAM(int(m.ramBank), addr)
+}
+
+func (m *mbc3) WriteByte(addr uint16, val byte) {
+	switch {
+	case addr < 0x2000:
+		m.ramEnabled = val&0x0F == 0x0A
+	case addr < 0x4000:
+		m.romBank = val & 0x7F
+		if m.romBank == 0 {
+			m.romBank = 1
+		}
+	case addr < 0x6000:
+		m.ramBank = val & 0x0F
+	case addr < 0x8000:
+		if m.latch == 0 && val == 1 && m.c.rtc != nil {
+			m.c.rtc.latch()
+		}
+		m.latch = val
+	default:
+		if !m.ramEnabled {
+			return
+		}
+		if m.ramBank >= 0x08 {
+			if m.c.rtc != nil {
+				m.c.rtc.write(m.ramBank, val)
+			}
+			return
+		}
+		m.c.writeRAM(int(m.ramBank), addr, val)
+	}
+}
+
+func (m *mbc3) ROMBank() int {
+	return int(m.romBank) % (len(m.c.rom) / 0x4000)
+}
+
+func (m *mbc3) RAMBank() int {
+	return int(m.ramBank)
+}
+
+type mbc5 struct {
+	c          *Cartridge
+	ramEnabled bool
+	romBank    uint16
+	ramBank    byte
+}
+
+func (m *mbc5) ReadByte(addr uint16) byte {
+	switch {
+	case addr < 0x4000:
+		return m.c.romByte(0, addr)
+	case addr < 0x8000:
+		return m.c.romByte(m.ROMBank(), addr)
+	}
+	if !m.ramEnabled {
+		return 0xFF
+	}
+	return m.c.readRAM(int(m.ramBank), addr)
+}
+
+func (m *mbc5) WriteByte(addr uint16, val byte) {
+	switch {
+	case addr < 0x2000:
+		m.ramEnabled = val&0x0F == 0x0A
+	case addr < 0x3000:
+		m.romBank = m.romBank&0x100 | uint16(val)
+	case addr < 0x4000:
+		m.romBank = m.romBank&0xFF | uint16(val&1)<<8
+	case addr < 0x6000:
+		// Bit 3 drives the motor on rumble carts.
+		if m.c.Type >= 0x1C {
+			val &= 0x07
+		}
+		m.ramBank = val & 0x0F
+	case addr < 0x8000:
+	default:
+		if m.ramEnabled {
+			m.c.writeRAM(int(m.ramBank), addr, val)
+		}
+	}
+}
+
+func (m *mbc5) ROMBank() int {
+	return int(m.romBank) % (len(m.c.rom) / 0x4000)
+}
+
+func (m *mbc5) RAMBank() int {
+	return int(m.ramBank)
+}
@@ -0,0 +1,91 @@
+package cartridge
+
+import (
+	"github.com/zbyrne/golangboy/z80"
+)
+
+const (
+	RTC_S byte = 0x08 + iota
+	RTC_M
+	RTC_H
+	RTC_DL
+	RTC_DH
+)
+
+const (
+	rtcHalt  byte = 1 << 6
+	rtcCarry byte = 1 << 7
+
+	ticksPerSecond z80.ClockTicks = 4194304
+)
+
+// rtc is the MBC3 real time clock.
+type rtc struct {
+	regs    [5]byte
+	latched [5]byte
+	ticks   z80.ClockTicks
+}
+
+func (r *rtc) tick(t z80.ClockTicks) {
+	if r.regs[4]&rtcHalt != 0 {
+		return
+	}
+	r.ticks += t
+	for r.ticks >= ticksPerSecond {
+		r.ticks -= ticksPerSecond
+		r.second()
+	}
+}
+
+func (r *rtc) second() {
+	r.regs[0] = (r.regs[0] + 1) & 0x3F
+	if r.regs[0] != 60 {
+		return
+	}
+	r.regs[0] = 0
+	r.regs[1] = (r.regs[1] + 1) & 0x3F
+	if r.regs[1] != 60 {
+		return
+	}
+	r.regs[1] = 0
+	r.regs[2] = (r.regs[2] + 1) & 0x1F
+	if r.regs[2] != 24 {
+		return
+	}
+	r.regs[2] = 0
+	r.regs[3]++
+	if r.regs[3] != 0 {
+		return
+	}
+	if r.regs[4]&1 != 0 {
+		r.regs[4] |= rtcCarry
+	}
+	r.regs[4] ^= 1
+}
+
+func (r *rtc) latch() {
+	r.latched = r.regs
+}
+
+func (r *rtc) read(reg byte) byte {
+	if reg > RTC_DH {
+		return 0xFF
+	}
+	return r.latched[reg-RTC_S]
+}
+
+func (r *rtc) write(reg byte, val byte) {
+	switch reg {
+	case RTC_S:
+		r.ticks = 0
+		r.regs[0] = val & 0x3F
+	case RTC_M:
+		r.regs[1] = val & 0x3F
+	case RTC_H:
+		r.regs[2] = val & 0x1F
+	case RTC_DL:
+		r.regs[3] = val
+	case RTC_DH:
+		r.regs[4] = val & (rtcCarry | rtcHalt | 1)
+	}
+}
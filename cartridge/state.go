@@ -0,0 +1,107 @@
+package cartridge
+
+import (
+	"errors"
+
+	"github.com/zbyrne/golangboy/savestate"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+// STATE_VERSION is the version of the cartridge's save state chunk.
+const STATE_VERSION = 1
+
+var ErrWrongCartridge = errors.New("cartridge: state was saved from another game")
+
+// SaveState saves the RAM, clock and bank registers, with the title and
+// checksum so the state can't be loaded into another game.
+func (c *Cartridge) SaveState(e *savestate.Encoder) {
+	var title [16]byte
+	copy(title[:], c.Title)
+	e.Bytes(title[:])
+	e.Uint16(c.GlobalChecksum)
+	e.Bytes(c.ram)
+	e.Bool(c.rtc != nil)
+	if c.rtc != nil {
+		e.Bytes(c.rtc.regs[:])
+		e.Bytes(c.rtc.latched[:])
+		e.Int(int(c.rtc.ticks))
+	}
+	c.mbc.saveState(e)
+}
+
+func (c *Cartridge) LoadState(d *savestate.Decoder) {
+	var title, want [16]byte
+	copy(want[:], c.Title)
+	d.Bytes(title[:])
+	if d.Uint16() != c.GlobalChecksum || title != want {
+		d.Fail(ErrWrongCartridge)
+		return
+	}
+	d.Bytes(c.ram)
+	if d.Bool() != (c.rtc != nil) {
+		d.Fail(ErrWrongCartridge)
+		return
+	}
+	if c.rtc != nil {
+		d.Bytes(c.rtc.regs[:])
+		d.Bytes(c.rtc.latched[:])
+		c.rtc.ticks = z80.ClockTicks(d.Int())
+	}
+	c.mbc.loadState(d)
+}
+
+func (m *romOnly) saveState(e *savestate.Encoder) {
+}
+
+func (m *romOnly) loadState(d *savestate.Decoder) {
+}
+
+func (m *mbc1) saveState(e *savestate.Encoder) {
+	e.Bool(m.ramEnabled)
+	e.Byte(m.bank1)
+	e.Byte(m.bank2)
+	e.Byte(m.mode)
+}
+
+func (m *mbc1) loadState(d *savestate.Decoder) {
+	m.ramEnabled = d.Bool()
+	m.bank1 = d.Byte()
+	m.bank2 = d.Byte()
+	m.mode = d.Byte()
+}
+
+func (m *mbc2) saveState(e *savestate.Encoder) {
+	e.Bool(m.ramEnabled)
+	e.Byte(m.bank)
+}
+
+func (m *mbc2) loadState(d *savestate.Decoder) {
+	m.ramEnabled = d.Bool()
+	m.bank = d.Byte()
+}
+
+func (m *mbc3) saveState(e *savestate.Encoder) {
+	e.Bool(m.ramEnabled)
+	e.Byte(m.romBank)
+	e.Byte(m.ramBank)
+	e.Byte(m.latch)
+}
+
+func (m *mbc3) loadState(d *savestate.Decoder) {
+	m.ramEnabled = d.Bool()
+	m.romBank = d.Byte()
+	m.ramBank = d.Byte()
+	m.latch = d.Byte()
+}
+
+func (m *mbc5) saveState(e *savestate.Encoder) {
+	e.Bool(m.ramEnabled)
+	e.Uint16(m.romBank)
+	e.Byte(m.ramBank)
+}
+
+func (m *mbc5) loadState(d *savestate.Decoder) {
+	m.ramEnabled = d.Bool()
+	m.romBank = d.Uint16()
+	m.ramBank = d.Byte()
+}
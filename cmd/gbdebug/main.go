@@ -0,0 +1,116 @@
+// gbdebug runs a ROM under an interactive debugger.
+//
+// Usage:
+//
+//	gbdebug [-model dmg] [-boot dmg_boot.bin] [-sym game.sym]
+//	        [-gdb localhost:2345] game.gb
+//	gbdebug -dap stdio|localhost:4711
+//
+// -model picks the Game Boy to run on, and -boot must be that model's boot
+// ROM. Labels are read from the -sym file, an RGBDS or no$gmb .sym or an
+// rgblink .map, or from a .sym next to the ROM if there is one.
+//
+// Type help at the prompt for the commands. Ctrl-C stops a running
+// program and returns to the prompt. With -gdb it serves the GDB remote
+// protocol instead of reading commands. With -dap it serves the Debug
+// Adapter Protocol on stdio or a socket, and the editor's launch request
+// names the ROM.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/dap"
+	"github.com/zbyrne/golangboy/debugger"
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/gdbstub"
+	"github.com/zbyrne/golangboy/symbols"
+)
+
+func main() {
+	model := flag.String("model", "dmg", "model to run on: "+strings.Join(gameboy.ModelNames(), ", "))
+	boot := flag.String("boot", "", "boot ROM to run before the cartridge")
+	sym := flag.String("sym", "", "symbol file, game.sym next to game.gb by default")
+	gdb := flag.String("gdb", "", "serve the GDB remote protocol on this address")
+	dapAddr := flag.String("dap", "", "serve the Debug Adapter Protocol on stdio or this address")
+	flag.Parse()
+	if *dapAddr != "" {
+		var err error
+		if *dapAddr == "stdio" {
+			err = dap.Serve(struct {
+				io.Reader
+				io.Writer
+			}{os.Stdin, os.Stdout})
+		} else {
+			err = dap.ListenAndServe(*dapAddr)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gbdebug [-model name] [-boot file] [-sym file] [-gdb addr] rom | gbdebug -dap stdio|addr")
+		os.Exit(2)
+	}
+	cart, err := cartridge.Load(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	m, err := gameboy.LookupModel(*model)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	opts := gameboy.Options{Model: m}
+	if *boot != "" {
+		if opts.BootROM, err = os.ReadFile(*boot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	gb, err := gameboy.New(cart, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	d := debugger.New(gb)
+	if *sym == "" {
+		guess := strings.TrimSuffix(flag.Arg(0), filepath.Ext(flag.Arg(0))) + ".sym"
+		if _, err := os.Stat(guess); err == nil {
+			*sym = guess
+		}
+	}
+	if *sym != "" {
+		if d.Symbols, err = symbols.Load(*sym); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if *gdb != "" {
+		fmt.Fprintf(os.Stderr, "waiting for GDB on %s\n", *gdb)
+		if err := gdbstub.NewServer(d).ListenAndServe(*gdb); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		for range sig {
+			d.Interrupt()
+		}
+	}()
+	r := newREPL(d, os.Stdout)
+	r.run(os.Stdin)
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/zbyrne/golangboy/debugger"
+	"github.com/zbyrne/golangboy/disasm"
+	"github.com/zbyrne/golangboy/symbols"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+const help = `Addresses and values are hex, with or without a $ or 0x prefix.
+With symbols loaded an address can also be a label, such as Main.loop+3.
+Counts are decimal. An empty line repeats the last command.
+
+  s, step [n]           run n instructions
+  n, next               step over calls
+  finish                run until the current routine returns
+  c, continue           run until a breakpoint or watchpoint
+  b, break [bank:]addr  break at an address, optionally only in one bank;
+                        a label in banked ROM breaks only in its bank
+  watch addr            stop when addr is written
+  rwatch addr           stop when addr is read
+  awatch addr           stop when addr is read or written
+  d, delete id          delete a breakpoint or watchpoint
+  i, info               list breakpoints and watchpoints
+  r, regs               show the registers
+  set reg value         change a register
+  x addr [n]            dump n bytes of memory
+  poke addr byte...     write bytes to memory
+  dis [addr] [n]        disassemble, around PC by default
+  bt                    show the call stack
+  q, quit               exit
+`
+
+type repl struct {
+	d    *debugger.Debugger
+	out  io.Writer
+	last string
+}
+
+func newREPL(d *debugger.Debugger, out io.Writer) *repl {
+	return &repl{d: d, out: out}
+}
+
+func (r *repl) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	r.printf("> ")
+	for scanner.Scan() {
+		if r.exec(scanner.Text()) {
+			return
+		}
+		r.printf("> ")
+	}
+}
+
+func (r *repl) printf(format string, args ...interface{}) {
+	fmt.Fprintf(r.out, format, args...)
+}
+
+func parseHex(s string) (int, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	val, err := strconv.ParseUint(s, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", s)
+	}
+	return int(val), nil
+}
+
+func parseCount(args []string, i, def int) (int, error) {
+	if len(args) <= i {
+		return def, nil
+	}
+	n, err := strconv.Atoi(args[i])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("bad count %q", args[i])
+	}
+	return n, nil
+}
+
+// address reads a label or a hex address. Labels win over hex that
+// could be a label, such as Fade, unless the hex has a prefix.
+func (r *repl) address(s string) (symbols.Symbol, error) {
+	if !strings.HasPrefix(s, "$") && !strings.HasPrefix(strings.ToLower(s), "0x") {
+		if sym, err := r.d.Lookup(s); err == nil {
+			return sym, nil
+		}
+	}
+	addr, err := parseHex(s)
+	if err != nil && r.d.Symbols != nil {
+		return symbols.Symbol{}, fmt.Errorf("bad address or label %q", s)
+	}
+	return symbols.Symbol{Addr: uint16(addr)}, err
+}
+
+// parseBreak reads addr, bank:addr or a label.
+func (r *repl) parseBreak(s string) (int, uint16, error) {
+	if i := strings.Index(s, ":"); i >= 0 {
+		bank, err := parseHex(s[:i])
+		if err != nil {
+			return 0, 0, err
+		}
+		addr, err := parseHex(s[i+1:])
+		return bank, uint16(addr), err
+	}
+	sym, err := r.address(s)
+	if err != nil || sym.Name == "" || sym.Addr < 0x4000 || sym.Addr >= 0x8000 {
+		return debugger.ANY_BANK, sym.Addr, err
+	}
+	return sym.Bank, sym.Addr, nil
+}
+
+// exec runs one command line and reports whether to quit.
+func (r *repl) exec(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		line = r.last
+	}
+	r.last = line
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return false
+	}
+	if err := r.command(args[0], args[1:]); err != nil {
+		if err == io.EOF {
+			return true
+		}
+		r.printf("%v\n", err)
+	}
+	return false
+}
+
+func (r *repl) command(cmd string, args []string) error {
+	d := r.d
+	switch cmd {
+	case "s", "step":
+		n, err := parseCount(args, 0, 1)
+		if err != nil {
+			return err
+		}
+		stop := d.Step()
+		for i := 1; i < n && stop.Reason == debugger.STEP; i++ {
+			stop = d.Step()
+		}
+		r.report(stop)
+	case "n", "next":
+		r.report(d.Next())
+	case "finish":
+		r.report(d.StepOut())
+	case "c", "continue":
+		r.report(d.Continue())
+	case "b", "break":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: break [bank:]addr")
+		}
+		bank, addr, err := r.parseBreak(args[0])
+		if err != nil {
+			return err
+		}
+		bp := d.AddBreakpoint(bank, addr)
+		r.printf("Breakpoint %d at %s\n", bp.ID, formatBreak(bp))
+	case "watch", "rwatch", "awatch":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s addr", cmd)
+		}
+		sym, err := r.address(args[0])
+		if err != nil {
+			return err
+		}
+		kind := map[string]int{
+			"watch":  debugger.WATCH_WRITE,
+			"rwatch": debugger.WATCH_READ,
+			"awatch": debugger.WATCH_READ | debugger.WATCH_WRITE,
+		}[cmd]
+		wp := d.AddWatchpoint(sym.Addr, kind)
+		r.printf("Watchpoint %d at $%04X\n", wp.ID, wp.Addr)
+	case "d", "delete":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: delete id")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("bad id %q", args[0])
+		}
+		return d.Delete(id)
+	case "i", "info":
+		for _, bp := range d.Breakpoints() {
+			r.printf("%-3d break  %s\n", bp.ID, formatBreak(bp))
+		}
+		for _, wp := range d.Watchpoints() {
+			r.printf("%-3d %-6s $%04X\n", wp.ID, watchKind(wp.Kind), wp.Addr)
+		}
+	case "r", "regs":
+		r.printRegisters()
+	case "set":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: set reg value")
+		}
+		val, err := parseHex(args[1])
+		if err != nil {
+			return err
+		}
+		return d.SetRegister(args[0], uint16(val))
+	case "x":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: x addr [n]")
+		}
+		sym, err := r.address(args[0])
+		if err != nil {
+			return err
+		}
+		n, err := parseCount(args, 1, 16)
+		if err != nil {
+			return err
+		}
+		r.dump(sym.Addr, d.ReadMemory(sym.Addr, n))
+	case "poke":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: poke addr byte...")
+		}
+		sym, err := r.address(args[0])
+		if err != nil {
+			return err
+		}
+		var data []byte
+		for _, arg := range args[1:] {
+			b, err := parseHex(arg)
+			if err != nil || b > 0xFF {
+				return fmt.Errorf("bad byte %q", arg)
+			}
+			data = append(data, byte(b))
+		}
+		d.WriteMemory(sym.Addr, data)
+	case "dis":
+		pc := d.GB.CPU.PC
+		var instrs []disasm.Instruction
+		if len(args) == 0 {
+			instrs = d.DisassembleAround(pc, 5, 6)
+		} else {
+			sym, err := r.address(args[0])
+			if err != nil {
+				return err
+			}
+			n, err := parseCount(args, 1, 10)
+			if err != nil {
+				return err
+			}
+			instrs = d.Disassemble(sym.Addr, n)
+		}
+		for _, in := range instrs {
+			r.printInstruction(in, in.Addr == pc)
+		}
+	case "bt":
+		r.backtrace()
+	case "help", "h", "?":
+		r.printf("%s", help)
+	case "q", "quit":
+		return io.EOF
+	default:
+		return fmt.Errorf("unknown command %q, try help", cmd)
+	}
+	return nil
+}
+
+func formatBreak(bp debugger.Breakpoint) string {
+	if bp.Bank == debugger.ANY_BANK {
+		return fmt.Sprintf("$%04X", bp.Addr)
+	}
+	return fmt.Sprintf("%02X:%04X", bp.Bank, bp.Addr)
+}
+
+func watchKind(kind int) string {
+	switch kind {
+	case debugger.WATCH_READ:
+		return "rwatch"
+	case debugger.WATCH_WRITE:
+		return "watch"
+	}
+	return "awatch"
+}
+
+func (r *repl) report(stop debugger.Stop) {
+	switch stop.Reason {
+	case debugger.BREAKPOINT:
+		r.printf("Breakpoint %d\n", stop.ID)
+	case debugger.WATCHPOINT:
+		access := "read"
+		if stop.Write {
+			access = "write"
+		}
+		r.printf("Watchpoint %d: %s $%02X at $%04X by $%04X\n",
+			stop.ID, access, stop.Value, stop.Addr, stop.PC)
+	case debugger.INTERRUPTED:
+		r.printf("Interrupted\n")
+	case debugger.LOCKED:
+		r.printf("CPU locked up by an illegal opcode\n")
+	}
+	r.printInstruction(r.d.Disassemble(r.d.GB.CPU.PC, 1)[0], true)
+}
+
+func (r *repl) printInstruction(in disasm.Instruction, current bool) {
+	marker := " "
+	if current {
+		marker = ">"
+	}
+	if in.Label != "" {
+		r.printf("%s:\n", in.Label)
+	}
+	var raw []string
+	for _, b := range in.Bytes {
+		raw = append(raw, fmt.Sprintf("%02X", b))
+	}
+	r.printf("%s %02X:%04X  %-9s %s\n", marker, r.d.Bank(in.Addr), in.Addr, strings.Join(raw, " "), in)
+}
+
+func (r *repl) printRegisters() {
+	c := r.d.GB.CPU
+	flags := []byte("----")
+	for i, f := range []byte{z80.Z_FLAG, z80.N_FLAG, z80.H_FLAG, z80.C_FLAG} {
+		if c.F&f != 0 {
+			flags[i] = "ZNHC"[i]
+		}
+	}
+	ime := 0
+	if c.IME {
+		ime = 1
+	}
+	r.printf("A:%02X F:%02X [%s] B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X\n",
+		c.A, c.F, flags, c.B, c.C, c.D, c.E, c.H, c.L)
+	r.printf("SP:%04X PC:%04X IME:%d IE:%02X IF:%02X ROM:%02X\n",
+		c.SP, c.PC, ime, c.IE, c.IF, r.d.GB.Cart.ROMBank())
+}
+
+func (r *repl) dump(addr uint16, data []byte) {
+	for i := 0; i < len(data); i += 16 {
+		end := i + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		var hex []string
+		for _, b := range data[i:end] {
+			hex = append(hex, fmt.Sprintf("%02X", b))
+		}
+		r.printf("%04X: %s\n", addr+uint16(i), strings.Join(hex, " "))
+	}
+}
+
+// backtrace prints the innermost frame first. Each line gives where
+// execution is in that frame and which routine it's in.
+func (r *repl) backtrace() {
+	frames := r.d.Backtrace()
+	pc := r.d.GB.CPU.PC
+	for i := 0; i <= len(frames); i++ {
+		routine := "?"
+		if i < len(frames) {
+			routine = fmt.Sprintf("$%04X", frames[i].Target)
+			if label := r.d.Label(frames[i].Target); label != "" {
+				routine = label
+			}
+		}
+		note := ""
+		if i > 0 && frames[i-1].Interrupt {
+			note = " <interrupted>"
+		}
+		r.printf("#%-2d $%04X in %s%s\n", i, pc, routine, note)
+		if i < len(frames) {
+			pc = frames[i].Call
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zbyrne/golangboy/asm"
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/debugger"
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/symbols"
+)
+
+func newTestREPL(t *testing.T) (*repl, *bytes.Buffer) {
+	rom, err := asm.ROM(`
+SECTION "main", ROM0[$100]
+	ld a, $12
+	call Sub
+	halt
+Sub:
+	ld [$C000], a
+	ret
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, _ := cartridge.New(rom)
+	gb, _ := gameboy.New(cart, gameboy.Options{})
+	var out bytes.Buffer
+	return newREPL(debugger.New(gb), &out), &out
+}
+
+func TestCommands(t *testing.T) {
+	r, out := newTestREPL(t)
+	for _, c := range []struct {
+		cmd  string
+		want string
+	}{
+		{"s", "> 00:0102  CD 06 01  CALL $0106"},
+		{"", "> 00:0106  EA 00 C0  LD [$C000], A"},
+		{"bt", "#0  $0106 in $0106\n#1  $0102 in ?"},
+		{"watch c000", "Watchpoint 1 at $C000"},
+		{"c", "Watchpoint 1: write $12 at $C000 by $0106"},
+		{"x c000 2", "C000: 12 00"},
+		{"poke c001 ab cd", ""},
+		{"x $c000 3", "C000: 12 AB CD"},
+		{"set a 99", ""},
+		{"r", "A:99 F:B0 [Z-HC]"},
+		{"b 0:105", "Breakpoint 2 at 00:0105"},
+		{"i", "2   break  00:0105\n1   watch  $C000"},
+		{"c", "Breakpoint 2"},
+		{"dis 100 2", "  00:0100  3E 12     LD A, $12\n  00:0102  CD 06 01  CALL $0106"},
+		{"d 7", "debugger: no such breakpoint or watchpoint"},
+		{"frob", "unknown command"},
+	} {
+		out.Reset()
+		if r.exec(c.cmd) {
+			t.Fatalf("%q quit", c.cmd)
+		}
+		if !strings.Contains(out.String(), c.want) {
+			t.Errorf("%q printed\n%s\nnot\n%s", c.cmd, out.String(), c.want)
+		}
+	}
+	if !r.exec("quit") {
+		t.Error("quit didn't quit")
+	}
+}
+
+func TestReadWatchOnCode(t *testing.T) {
+	r, out := newTestREPL(t)
+	// Running the LD at $0106 fetches $0107 but doesn't read it as data.
+	r.exec("rwatch 107")
+	r.exec("watch c000")
+	out.Reset()
+	r.exec("c")
+	if want := "Watchpoint 2: write $12 at $C000 by $0106"; !strings.Contains(out.String(), want) {
+		t.Errorf("c printed\n%s\nnot\n%s", out.String(), want)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	r, out := newTestREPL(t)
+	r.d.Symbols = symbols.New()
+	r.d.Symbols.Add(symbols.Symbol{Name: "Sub", Addr: 0x106})
+	r.d.Symbols.Add(symbols.Symbol{Name: "wValue", Addr: 0xC000})
+	for _, c := range []struct {
+		cmd  string
+		want string
+	}{
+		{"dis 102 1", "  00:0102  CD 06 01  CALL Sub"},
+		{"b Sub", "Breakpoint 1 at $0106"},
+		{"c", "Breakpoint 1\nSub:\n> 00:0106  EA 00 C0  LD [wValue], A"},
+		{"bt", "#0  $0106 in Sub"},
+		{"x wValue+1 1", "C001: 00"},
+		{"x $c000 1", "C000: 00"},
+		{"watch Nowhere", "bad address or label"},
+	} {
+		out.Reset()
+		r.exec(c.cmd)
+		if !strings.Contains(out.String(), c.want) {
+			t.Errorf("%q printed\n%s\nnot\n%s", c.cmd, out.String(), c.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// gbmovie plays back input movies and imports BizHawk ones.
+//
+// Usage:
+//
+//	gbmovie play [-boot dmg_boot.bin] game.gb movie.gbm
+//	gbmovie import game.bk2 movie.gbm
+//
+// play runs the movie as fast as it can and exits non-zero if it desyncs,
+// naming the first checkpoint that didn't match.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/movie"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gbmovie play [-boot file] rom movie | gbmovie import bk2 movie")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "play":
+		err = play(os.Args[2:])
+	case "import":
+		err = importBK2(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func play(args []string) error {
+	flags := flag.NewFlagSet("play", flag.ExitOnError)
+	boot := flags.String("boot", "", "boot ROM to run before the cartridge")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		usage()
+	}
+	cart, err := cartridge.Load(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var opts gameboy.Options
+	if *boot != "" {
+		if opts.BootROM, err = os.ReadFile(*boot); err != nil {
+			return err
+		}
+	}
+	f, err := os.Open(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	m, err := movie.Read(f)
+	if err != nil {
+		return err
+	}
+	opts.Model = m.Model
+	gb, err := gameboy.New(cart, opts)
+	if err != nil {
+		return err
+	}
+	p, err := movie.NewPlayer(gb, m)
+	if err != nil {
+		return err
+	}
+	if err := p.Play(); err != nil {
+		return err
+	}
+	fmt.Printf("played %d frames\n", p.Frame())
+	return nil
+}
+
+func importBK2(args []string) error {
+	if len(args) != 2 {
+		usage()
+	}
+	in, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	m, err := movie.ImportBK2(in, info.Size())
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(args[1])
+	if err != nil {
+		return err
+	}
+	if err := m.Write(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
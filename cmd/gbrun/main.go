@@ -0,0 +1,353 @@
+// gbrun runs a ROM headless for a number of frames and saves what was on
+// screen.
+//
+// Usage:
+//
+//	gbrun [-frames 600] [-boot dmg_boot.bin] [-movie run.gbm]
+//	      [-png last.png] [-dump dir] [-gif run.gif -from 0 -to 600]
+//	      [-scale 1] [-palette bgb] [-colorize -correct cgb]
+//	      [-hash out.golden -hash-every 1] [-golden want.golden]
+//	      [-model dmg] [-wav out.wav -stems dir] game.gb
+//
+// -png saves the last frame, -dump saves every frame as dir/frameNNNNNN.png
+// and -gif animates the frames from -from up to but not including -to.
+// -hash writes a golden file of frame hashes, and -golden checks the run
+// against one, exiting non-zero at the first frame that differs.
+// With -movie the inputs come from the movie, and -frames 0 runs all of it.
+//
+// -palette takes a preset or four hex colours, lightest first, like
+// e0f8d0,88c070,346856,081820. -colorize colours the game the way a CGB
+// would instead, with -correct picking how its colours are shown. Buttons
+// held on a movie's first frame pick palettes as they do when held during
+// the CGB boot animation. Games running in CGB mode bring their own colours,
+// shown with -correct too.
+//
+// -wav records the sound to a stereo WAV file at SAMPLE_RATE, and -stems
+// records each of the four sound channels to dir/ch1.wav to dir/ch4.wav as
+// well.
+//
+// -model picks the Game Boy to run on, unless a movie says. On an sgb -png
+// saves what the SNES shows, border and all. -sgb is the deprecated old
+// name for -model sgb.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/golden"
+	"github.com/zbyrne/golangboy/movie"
+	"github.com/zbyrne/golangboy/palette"
+	"github.com/zbyrne/golangboy/screenshot"
+	"github.com/zbyrne/golangboy/wav"
+)
+
+// SAMPLE_RATE is the rate sound is recorded at with -wav.
+const SAMPLE_RATE = 48000
+
+var (
+	ErrStems = errors.New("gbrun: -stems needs -wav")
+	ErrSGB   = errors.New("gbrun: -sgb with another -model")
+)
+
+type config struct {
+	rom, boot, movie string
+	frames           int
+	png, dump, gif   string
+	from, to         int
+	opts             screenshot.Options
+	hash, golden     string
+	hashEvery        int
+	wav, stems       string
+	colorize         bool
+	model            gameboy.Model
+	correct          palette.Correction
+}
+
+func main() {
+	var c config
+	flag.IntVar(&c.frames, "frames", 600, "frames to run, or 0 for the whole movie")
+	flag.StringVar(&c.boot, "boot", "", "boot ROM to run before the cartridge")
+	flag.StringVar(&c.movie, "movie", "", "movie to take inputs from")
+	flag.StringVar(&c.png, "png", "", "save the last frame to this PNG")
+	flag.StringVar(&c.dump, "dump", "", "save every frame to numbered PNGs in this directory")
+	flag.StringVar(&c.gif, "gif", "", "save frames -from to -to as this animated GIF")
+	flag.IntVar(&c.from, "from", 0, "first frame of the GIF")
+	flag.IntVar(&c.to, "to", -1, "frame to end the GIF before, or -1 for the last")
+	flag.IntVar(&c.opts.Scale, "scale", 1, "draw each pixel as a scale by scale square")
+	flag.StringVar(&c.hash, "hash", "", "write frame hashes to this golden file")
+	flag.IntVar(&c.hashEvery, "hash-every", 1, "hash every this many frames")
+	flag.StringVar(&c.golden, "golden", "", "check frames against this golden file")
+	flag.StringVar(&c.wav, "wav", "", "record the sound to this WAV file")
+	flag.StringVar(&c.stems, "stems", "", "with -wav, record each sound channel to a WAV file in this directory")
+	model := flag.String("model", "dmg", "model to run on: "+strings.Join(gameboy.ModelNames(), ", "))
+	sgb := flag.Bool("sgb", false, "deprecated: use -model sgb")
+	name := flag.String("palette", "bgb", "palette: four hex colours or one of "+strings.Join(palette.Names(), ", "))
+	flag.BoolVar(&c.colorize, "colorize", false, "colour the game with the CGB boot ROM's palettes")
+	correct := flag.String("correct", "cgb", "colour correction for -colorize and CGB games: "+strings.Join(palette.CorrectionNames(), ", "))
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gbrun [flags] rom")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	c.rom = flag.Arg(0)
+	dmg, err := palette.Parse(*name)
+	if err == nil {
+		c.opts.Palette = dmg.CGB()
+		c.correct, err = palette.LookupCorrection(*correct)
+	}
+	if err == nil {
+		c.model, err = pickModel(*model, *sgb)
+	}
+	if err == nil {
+		err = run(c)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// pickModel looks up -model, which -sgb stands in for.
+func pickModel(name string, sgb bool) (gameboy.Model, error) {
+	if !sgb {
+		return gameboy.LookupModel(name)
+	}
+	fmt.Fprintln(os.Stderr, "gbrun: -sgb is deprecated, use -model sgb")
+	if name != "dmg" && name != "sgb" {
+		return 0, ErrSGB
+	}
+	return gameboy.SGB, nil
+}
+
+func run(c config) error {
+	cart, err := cartridge.Load(c.rom)
+	if err != nil {
+		return err
+	}
+	opts := gameboy.Options{Model: c.model}
+	if c.wav != "" {
+		opts.SampleRate = SAMPLE_RATE
+	} else if c.stems != "" {
+		return ErrStems
+	}
+	if c.boot != "" {
+		if opts.BootROM, err = os.ReadFile(c.boot); err != nil {
+			return err
+		}
+	}
+	var m *movie.Movie
+	if c.movie != "" {
+		f, err := os.Open(c.movie)
+		if err != nil {
+			return err
+		}
+		m, err = movie.Read(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		opts.Model = m.Model
+		if c.frames == 0 {
+			c.frames = len(m.Inputs)
+		}
+	}
+	gb, err := gameboy.New(cart, opts)
+	if err != nil {
+		return err
+	}
+	var rec *recording
+	if c.wav != "" {
+		if rec, err = record(c.wav, c.stems); err != nil {
+			return err
+		}
+		defer func() {
+			if rec != nil {
+				rec.Close()
+			}
+		}()
+		gb.APU.SetSink(rec)
+	}
+	c.opts.Correction = c.correct
+	if c.colorize {
+		c.opts.Palette, _ = palette.Colorize(cart.Header, c.correct)
+		// A movie from power-on holds its first input through the boot
+		// animation, where some buttons pick the palettes instead.
+		if m != nil && len(m.State) == 0 && len(m.Inputs) > 0 {
+			if combo, ok := palette.Combo(m.Inputs[0], c.correct); ok {
+				c.opts.Palette = combo
+			}
+		}
+	}
+	var p *movie.Player
+	if m != nil {
+		if p, err = movie.NewPlayer(gb, m); err != nil {
+			return err
+		}
+	}
+	if c.dump != "" {
+		if err := os.MkdirAll(c.dump, 0755); err != nil {
+			return err
+		}
+	}
+	var check *golden.Checker
+	if c.golden != "" {
+		f, err := os.Open(c.golden)
+		if err != nil {
+			return err
+		}
+		want, err := golden.Read(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		check = golden.NewChecker(want)
+	}
+	var hashes []golden.Entry
+	if c.hashEvery < 1 {
+		c.hashEvery = 1
+	}
+	dumper := &screenshot.Dumper{Dir: c.dump}
+	anim := screenshot.NewGIF()
+	to := c.to
+	if to < 0 {
+		to = c.frames
+	}
+	for n := 0; n < c.frames; n++ {
+		if p != nil && !p.Done() {
+			if err := p.RunFrame(); err != nil {
+				return err
+			}
+		} else {
+			gb.RunFrame()
+		}
+		frame := n + 1
+		if c.hash != "" && (frame%c.hashEvery == 0 || frame == c.frames) {
+			hashes = append(hashes, golden.Entry{Frame: frame, Hash: golden.Hash(gb)})
+		}
+		if check != nil {
+			if err := check.Frame(frame, gb); err != nil {
+				return err
+			}
+		}
+		if c.dump != "" {
+			if err := dumper.Dump(uint64(n), screenshot.Screen(gb, c.opts)); err != nil {
+				return err
+			}
+		}
+		if c.gif != "" && n >= c.from && n < to {
+			anim.Add(screenshot.Screen(gb, c.opts))
+		}
+	}
+	if check != nil && !check.Done() {
+		return golden.ErrEnded
+	}
+	if rec != nil {
+		if err := gb.APU.SinkErr(); err != nil {
+			return err
+		}
+		err := rec.Close()
+		rec = nil
+		if err != nil {
+			return err
+		}
+	}
+	if c.hash != "" {
+		if err := writeHashes(c.hash, hashes); err != nil {
+			return err
+		}
+	}
+	if c.png != "" && gb.SGB != nil {
+		if err := screenshot.SavePNG(c.png, screenshot.Scale(gb.SGB.Image(gb.Frame()), c.opts.Scale)); err != nil {
+			return err
+		}
+	} else if c.png != "" {
+		if err := screenshot.SavePNG(c.png, screenshot.Screen(gb, c.opts)); err != nil {
+			return err
+		}
+	}
+	if c.gif != "" {
+		f, err := os.Create(c.gif)
+		if err != nil {
+			return err
+		}
+		if err := anim.Encode(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	return nil
+}
+
+// recording writes the sound to WAV files.
+type recording struct {
+	*wav.Recorder
+	files []*os.File
+}
+
+func record(path, stems string) (*recording, error) {
+	r := &recording{}
+	create := func(path string) (*os.File, error) {
+		f, err := os.Create(path)
+		if err == nil {
+			r.files = append(r.files, f)
+		}
+		return f, err
+	}
+	mix, err := create(path)
+	if err != nil {
+		return nil, err
+	}
+	var channels []io.WriteSeeker
+	if stems != "" {
+		err = os.MkdirAll(stems, 0755)
+		for i := 1; i <= 4 && err == nil; i++ {
+			var f *os.File
+			f, err = create(filepath.Join(stems, fmt.Sprintf("ch%d.wav", i)))
+			channels = append(channels, f)
+		}
+	}
+	if err == nil {
+		r.Recorder, err = wav.NewRecorder(mix, channels, SAMPLE_RATE)
+	}
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close finishes the WAV files and closes them.
+func (r *recording) Close() error {
+	var err error
+	if r.Recorder != nil {
+		err = r.Recorder.Close()
+	}
+	for _, f := range r.files {
+		if e := f.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func writeHashes(path string, hashes []golden.Entry) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := golden.Write(f, hashes); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
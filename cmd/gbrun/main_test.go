@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zbyrne/golangboy/asm"
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/golden"
+	"github.com/zbyrne/golangboy/joypad"
+	"github.com/zbyrne/golangboy/movie"
+	"github.com/zbyrne/golangboy/palette"
+	"github.com/zbyrne/golangboy/ppu"
+	"github.com/zbyrne/golangboy/screenshot"
+)
+
+// black sets every shade in BGP to black.
+const black = `
+SECTION "main", ROM0[$100]
+	ld a, $FF
+	ldh [$47], a
+.done:
+	jr .done
+`
+
+// pal01 sends the SGB a PAL01 packet that makes colour 0 blue.
+const pal01 = `
+SECTION "entry", ROM0[$100]
+	jp start
+
+SECTION "main", ROM0[$150]
+start:
+	xor a
+	ldh [$00], a
+	ld a, $30
+	ldh [$00], a
+	ld hl, packet
+	ld d, 16
+.byte:
+	ld a, [hl+]
+	ld e, a
+	ld b, 8
+.bit:
+	ld a, $10
+	srl e
+	jr c, .send
+	ld a, $20
+.send:
+	ldh [$00], a
+	ld a, $30
+	ldh [$00], a
+	dec b
+	jr nz, .bit
+	dec d
+	jr nz, .byte
+	ld a, $20
+	ldh [$00], a
+	ld a, $30
+	ldh [$00], a
+.done:
+	jr .done
+packet:
+	DB $01, $00, $7C
+	DS 13
+`
+
+func writeROM(t *testing.T, path string) {
+	writeProgram(t, path, black, false)
+}
+
+func writeProgram(t *testing.T, path, src string, sgb bool) {
+	var header []byte
+	if sgb {
+		header = make([]byte, 0x18)
+		header[0x146-0x134], header[0x14B-0x134] = 0x03, 0x33
+	}
+	rom, err := asm.ROM(src, header...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	c := config{
+		rom:    filepath.Join(dir, "black.gb"),
+		frames: 10,
+		png:    filepath.Join(dir, "last.png"),
+		dump:   filepath.Join(dir, "frames"),
+		gif:    filepath.Join(dir, "run.gif"),
+		from:   2,
+		to:     -1,
+		opts:   screenshot.Options{Palette: palette.Grey.CGB(), Scale: 2},
+	}
+	writeROM(t, c.rom)
+	if err := run(c); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(c.png)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := img.At(100, 100).RGBA(); img.Bounds().Dx() != 320 || r != 0 {
+		t.Errorf("Last frame is %v with red 0x%04X", img.Bounds(), r)
+	}
+	if dumped, _ := filepath.Glob(filepath.Join(c.dump, "*.png")); len(dumped) != 10 {
+		t.Errorf("Dumped %d frames", len(dumped))
+	}
+	f, err = os.Open(c.gif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 8 frames, with every third dropped to keep delays at 2/100s.
+	if len(anim.Image) != 5 {
+		t.Errorf("GIF has %d images", len(anim.Image))
+	}
+}
+
+func TestGolden(t *testing.T) {
+	dir := t.TempDir()
+	c := config{
+		rom:       filepath.Join(dir, "black.gb"),
+		frames:    10,
+		hash:      filepath.Join(dir, "black.golden"),
+		hashEvery: 4,
+	}
+	writeROM(t, c.rom)
+	if err := run(c); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(c.hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := golden.Read(f)
+	f.Close()
+	if err != nil || len(want) != 3 || want[1].Frame != 8 || want[2].Frame != 10 {
+		t.Fatalf("Hashed %v, %v", want, err)
+	}
+
+	c.golden, c.hash = c.hash, ""
+	if err := run(c); err != nil {
+		t.Error(err)
+	}
+	c.frames = 9
+	if err := run(c); err != golden.ErrEnded {
+		t.Errorf("Short run returned %v", err)
+	}
+	want[1].Hash ^= 1
+	writeHashes(c.golden, want)
+	var mismatch *golden.MismatchError
+	if err := run(c); !errors.As(err, &mismatch) || mismatch.Frame != 8 {
+		t.Errorf("Mismatched run returned %v", err)
+	}
+}
+
+func TestColorize(t *testing.T) {
+	dir := t.TempDir()
+	c := config{
+		rom:      filepath.Join(dir, "black.gb"),
+		png:      filepath.Join(dir, "last.png"),
+		movie:    filepath.Join(dir, "held.gbm"),
+		colorize: true,
+	}
+	writeROM(t, c.rom)
+	rom, err := os.ReadFile(c.rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Right and B held from power-on pick the inverted palettes.
+	held := joypad.RIGHT | joypad.B
+	m := &movie.Movie{ROM: sha1.Sum(rom), Inputs: []joypad.Button{held, held, held}}
+	f, err := os.Create(c.movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Write(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run(c); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = os.Open(c.png); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := palette.Combo(held, palette.CORRECT_NONE)
+	if got := color.RGBAModel.Convert(img.At(80, 72)); got != want.Color(3, ppu.LAYER_BG) {
+		t.Errorf("Black is %v, not %v", got, want.Color(3, ppu.LAYER_BG))
+	}
+}
+
+func TestSGB(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		header bool
+		blue   bool
+	}{
+		{true, true},
+		// Games that don't say they support the SGB can't talk to it.
+		{false, false},
+	} {
+		c := config{
+			rom:    filepath.Join(dir, "pal01.gb"),
+			frames: 3,
+			png:    filepath.Join(dir, "sgb.png"),
+			model:  gameboy.SGB,
+			opts:   screenshot.Options{Palette: palette.Grey.CGB()},
+		}
+		writeProgram(t, c.rom, pal01, tc.header)
+		if err := run(c); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(c.png)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 224 {
+			t.Errorf("SGB picture is %v", b)
+		}
+		// Both the border and the screen show colour 0.
+		for _, p := range [][2]int{{0, 0}, {128, 112}} {
+			r, g, b, _ := img.At(p[0], p[1]).RGBA()
+			if blue := r == 0 && g == 0 && b == 0xFFFF; blue != tc.blue {
+				t.Errorf("Header flags %t: (%d, %d) is 0x%04X 0x%04X 0x%04X", tc.header, p[0], p[1], r, g, b)
+			}
+		}
+	}
+}
+
+func TestSGBFlag(t *testing.T) {
+	for _, tc := range []struct {
+		model string
+		sgb   bool
+		want  gameboy.Model
+		err   error
+	}{
+		{"dmg", true, gameboy.SGB, nil},
+		{"sgb", true, gameboy.SGB, nil},
+		{"cgb", true, 0, ErrSGB},
+		{"cgb", false, gameboy.CGB, nil},
+	} {
+		if got, err := pickModel(tc.model, tc.sgb); got != tc.want || err != tc.err {
+			t.Errorf("-model %s -sgb=%t picked %d, %v", tc.model, tc.sgb, got, err)
+		}
+	}
+}
+
+func TestWAV(t *testing.T) {
+	dir := t.TempDir()
+	c := config{
+		rom:    filepath.Join(dir, "black.gb"),
+		frames: 10,
+		wav:    filepath.Join(dir, "run.wav"),
+		stems:  filepath.Join(dir, "stems"),
+	}
+	writeROM(t, c.rom)
+	if err := run(c); err != nil {
+		t.Fatal(err)
+	}
+	mix, err := os.ReadFile(c.wav)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ten frames is about a sixth of a second.
+	frames := int(binary.LittleEndian.Uint32(mix[40:])) / 4
+	if string(mix[:4]) != "RIFF" || frames < SAMPLE_RATE/7 || frames > SAMPLE_RATE/5 {
+		t.Errorf("Recorded %d stereo frames", frames)
+	}
+	for i := 1; i <= 4; i++ {
+		stem, err := os.ReadFile(filepath.Join(c.stems, fmt.Sprintf("ch%d.wav", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := int(binary.LittleEndian.Uint32(stem[40:])) / 2; n != frames {
+			t.Errorf("Stem %d has %d frames, not %d", i, n, frames)
+		}
+	}
+
+	c.wav = ""
+	if err := run(c); err != ErrStems {
+		t.Errorf("Ran with -stems and no -wav: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+// gbserve plays a ROM in a web browser.
+//
+// Usage:
+//
+//	gbserve [-addr localhost:8080] [-model dmg] [-boot dmg_boot.bin]
+//	        [-audio] [-palette bgb] [-colorize -correct cgb] game.gb
+//
+// -model picks the Game Boy to run on, and -boot must be that model's boot
+// ROM. -palette takes a preset or four hex colours, lightest first. -colorize
+// colours the game the way a CGB would instead, with -correct picking how
+// its colours are shown. CGB games run on -model cgb bring their own
+// colours, shown with -correct too.
+//
+// Open the address in a browser to see the screen and play with the
+// keyboard. Everyone connected sees the same game and their buttons are
+// combined. Frames, and sound with -audio, are streamed over a WebSocket
+// at /ws, which browsers may only open from gbserve's own page.
+//
+// /state returns the CPU registers as JSON. Add ?addr=0xC000&len=16 to read
+// memory too.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/palette"
+	"github.com/zbyrne/golangboy/screenshot"
+)
+
+// SAMPLE_RATE is the rate audio is streamed at with -audio.
+const SAMPLE_RATE = 48000
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	model := flag.String("model", "dmg", "model to run on: "+strings.Join(gameboy.ModelNames(), ", "))
+	boot := flag.String("boot", "", "boot ROM to run before the cartridge")
+	audio := flag.Bool("audio", false, "stream sound as well as frames")
+	name := flag.String("palette", "bgb", "palette: four hex colours or one of "+strings.Join(palette.Names(), ", "))
+	colorize := flag.Bool("colorize", false, "colour the game with the CGB boot ROM's palettes")
+	correct := flag.String("correct", "cgb", "colour correction for -colorize and CGB games: "+strings.Join(palette.CorrectionNames(), ", "))
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gbserve [-addr host:port] [-model name] [-boot file] [-audio] [-palette name] [-colorize -correct name] rom")
+		os.Exit(2)
+	}
+	m, err := gameboy.LookupModel(*model)
+	var pal palette.DMG
+	if err == nil {
+		pal, err = palette.Parse(*name)
+	}
+	var corr palette.Correction
+	if err == nil {
+		corr, err = palette.LookupCorrection(*correct)
+	}
+	if err == nil {
+		err = run(flag.Arg(0), m, *boot, *addr, *audio, pal, *colorize, corr)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(rom string, model gameboy.Model, boot, addr string, audio bool, pal palette.DMG, colorize bool, corr palette.Correction) error {
+	cart, err := cartridge.Load(rom)
+	if err != nil {
+		return err
+	}
+	opts := gameboy.Options{Model: model}
+	if boot != "" {
+		if opts.BootROM, err = os.ReadFile(boot); err != nil {
+			return err
+		}
+	}
+	if audio {
+		opts.SampleRate = SAMPLE_RATE
+	}
+	gb, err := gameboy.New(cart, opts)
+	if err != nil {
+		return err
+	}
+	shot := screenshot.Options{Palette: pal.CGB(), Correction: corr}
+	if colorize {
+		shot.Palette, _ = palette.Colorize(cart.Header, corr)
+	}
+	s := newServer(gb, shot)
+	go func() {
+		tick := time.NewTicker(gameboy.FRAME_TIME)
+		for range tick.C {
+			s.step()
+		}
+	}()
+	log.Printf("serving on http://%s/", addr)
+	return http.ListenAndServe(addr, s.handler())
+}
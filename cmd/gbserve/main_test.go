@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zbyrne/golangboy/asm"
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/joypad"
+	"github.com/zbyrne/golangboy/palette"
+	"github.com/zbyrne/golangboy/screenshot"
+)
+
+// buttons copies the held buttons to WRAM and sets BGP so the screen is
+// black.
+const buttons = `
+SECTION "main", ROM0[$100]
+	ld a, $FF
+	ldh [$47], a
+.loop:
+	ld a, $10
+	ldh [$00], a
+	ldh a, [$00]
+	ld [$C000], a
+	jr .loop
+`
+
+func newTestServer(t *testing.T) (*server, *httptest.Server) {
+	rom, err := asm.ROM(buttons)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, err := cartridge.New(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gb, err := gameboy.New(cart, gameboy.Options{SampleRate: SAMPLE_RATE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(gb, screenshot.Options{Palette: palette.Grey.CGB()})
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455.
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Accept key is %s", got)
+	}
+}
+
+// handshake starts opening a WebSocket by hand, the way a browser would,
+// with any extra header lines.
+func handshake(t *testing.T, ts *httptest.Server, extra string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"+extra+"\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, r, resp
+}
+
+func dial(t *testing.T, ts *httptest.Server) (net.Conn, *bufio.Reader) {
+	conn, r, resp := handshake(t, ts, "Origin: http://x\r\n")
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Handshake got %s %v", resp.Status, resp.Header)
+	}
+	return conn, r
+}
+
+// send writes a masked frame.
+func send(conn net.Conn, hdr byte, data []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{hdr, 0x80 | byte(len(data))}, mask...)
+	for i, b := range data {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+}
+
+// receive reads an unfragmented frame from the server.
+func receive(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	n := int(hdr[1])
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		n = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		t.Fatal(err)
+	}
+	return hdr[0], data
+}
+
+func TestStream(t *testing.T) {
+	s, ts := newTestServer(t)
+	conn, r := dial(t, ts)
+
+	// A ping split across a fragmented message is answered in between.
+	send(conn, opBinary, nil)
+	send(conn, 0x80|opPing, []byte("hi"))
+	send(conn, 0x80|opContinuation, []byte{byte(joypad.START | joypad.A)})
+	if hdr, data := receive(t, r); hdr != 0x80|opPong || string(data) != "hi" {
+		t.Fatalf("Ping got 0x%02X %q", hdr, data)
+	}
+	// The server only sees the client once it's read the handshake, so
+	// wait for the buttons to arrive.
+	for held := joypad.Button(0); held == 0; {
+		time.Sleep(time.Millisecond)
+		s.mu.Lock()
+		for c := range s.clients {
+			held = c.held
+		}
+		s.mu.Unlock()
+	}
+
+	s.step()
+	s.step()
+	// The first frame starts where the boot ROM would have left off, so
+	// only the second has a full frame of audio.
+	var frame, audio []byte
+	for i := 0; i < 4; i++ {
+		hdr, data := receive(t, r)
+		if hdr != 0x80|opBinary {
+			t.Fatalf("Got frame 0x%02X", hdr)
+		}
+		switch data[0] {
+		case msgFrame:
+			frame = data
+		case msgAudio:
+			audio = data
+		}
+	}
+	if len(frame) != frameSize || frame[1] != 0xFF || frame[frameSize-1] != 0xFF {
+		t.Errorf("Frame is %d bytes starting 0x%02X", len(frame), frame[1])
+	}
+	if n := len(audio) - 1; n%4 != 0 || n/4 < SAMPLE_RATE/60 {
+		t.Errorf("Audio message has %d bytes", n)
+	}
+
+	resp, err := http.Get(ts.URL + "/state?addr=0xC000&len=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var st state
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	// Start and A are both down on the buttons line.
+	if st.Memory == nil || st.Memory.Addr != 0xC000 || !strings.HasPrefix(st.Memory.Data, "d6") || st.Frames == 0 || st.Registers.SP == 0 || st.Palette[1] != "#aaaaaa" {
+		t.Errorf("State is %+v", st)
+	}
+
+	send(conn, 0x80|opClose, nil)
+	if hdr, _ := receive(t, r); hdr != 0x80|opClose {
+		t.Errorf("Close got 0x%02X", hdr)
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	_, ts := newTestServer(t)
+	for _, origin := range []string{"http://evil.example", "http://x.evil.example", "http://x:8080", "::"} {
+		_, _, resp := handshake(t, ts, "Origin: "+origin+"\r\n")
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Origin %s got %s", origin, resp.Status)
+		}
+	}
+	if _, _, resp := handshake(t, ts, ""); resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("No Origin got %s", resp.Status)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	_, ts := newTestServer(t)
+	for _, path := range []string{"/ws", "/state?addr=0x10000", "/state?addr=0&len=0", "/nothing"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 400 {
+			t.Errorf("GET %s got %s", path, resp.Status)
+		}
+	}
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("Page got %s", resp.Status)
+	}
+}
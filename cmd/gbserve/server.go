@@ -0,0 +1,264 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/joypad"
+	"github.com/zbyrne/golangboy/ppu"
+	"github.com/zbyrne/golangboy/screenshot"
+)
+
+// Every message to the page is binary and starts with its kind.
+const (
+	// msgFrame is followed by the frame's shades, four to a byte with the
+	// leftmost pixel in the top bits.
+	msgFrame = 0
+	// msgAudio is followed by little endian int16 samples, left then right.
+	msgAudio = 1
+	// msgImage is followed by the width and height as little endian
+	// uint16s, then the pixels' red, green and blue row by row. It's sent
+	// in place of msgFrame when the layers aren't all one palette or the
+	// game brings its own colours in CGB mode.
+	msgImage = 2
+)
+
+// frameSize is the length of a msgFrame.
+const frameSize = 1 + ppu.WIDTH*ppu.HEIGHT/4
+
+// The page sends back one byte: the buttons it has held, as a joypad.Button.
+
+// clientQueue is how many messages a client can fall behind by before
+// frames are dropped for it.
+const clientQueue = 8
+
+// maxRead bounds a memory read from the state endpoint.
+const maxRead = 0x1000
+
+//go:embed index.html
+var page []byte
+
+type client struct {
+	out  chan []byte
+	held joypad.Button
+}
+
+// server runs the GameBoy and shares it with every connected page.
+type server struct {
+	// mu guards the GameBoy and the clients.
+	mu      sync.Mutex
+	gb      *gameboy.GameBoy
+	clients map[*client]bool
+	audio   []byte
+	shot    screenshot.Options
+	// shades sends frames as msgFrame, for the page to colour with
+	// palette.
+	shades  bool
+	palette [4]string
+}
+
+func newServer(gb *gameboy.GameBoy, shot screenshot.Options) *server {
+	s := &server{gb: gb, clients: make(map[*client]bool), shot: shot}
+	p := shot.Palette
+	s.shades = p[ppu.LAYER_BG] == p[ppu.LAYER_OBJ0] && p[ppu.LAYER_BG] == p[ppu.LAYER_OBJ1]
+	for i, c := range p[ppu.LAYER_BG] {
+		s.palette[i] = fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	if gb.APU.SampleRate() != 0 {
+		gb.APU.SetSink(s)
+	}
+	return s
+}
+
+// WriteSample collects audio for the next msgAudio.
+func (s *server) WriteSample(left, right int16, channels ...int16) error {
+	s.audio = binary.LittleEndian.AppendUint16(s.audio, uint16(left))
+	s.audio = binary.LittleEndian.AppendUint16(s.audio, uint16(right))
+	return nil
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	})
+	mux.HandleFunc("/ws", s.serveWS)
+	mux.HandleFunc("/state", s.serveState)
+	return mux
+}
+
+// step runs a frame with everyone's buttons held and sends it out.
+func (s *server) step() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var held joypad.Button
+	for c := range s.clients {
+		held |= c.held
+	}
+	s.gb.Joypad.SetButtons(held)
+	s.gb.RunFrame()
+	if s.shades && s.gb.Colors() == nil {
+		s.broadcast(packFrame(s.gb.Frame()))
+	} else {
+		s.broadcast(packImage(screenshot.Screen(s.gb, s.shot)))
+	}
+	if len(s.audio) > 0 {
+		s.broadcast(append([]byte{msgAudio}, s.audio...))
+		s.audio = s.audio[:0]
+	}
+}
+
+func (s *server) broadcast(msg []byte) {
+	for c := range s.clients {
+		select {
+		case c.out <- msg:
+		default:
+		}
+	}
+}
+
+func packFrame(frame []byte) []byte {
+	msg := make([]byte, frameSize)
+	msg[0] = msgFrame
+	for i, shade := range frame {
+		msg[1+i/4] |= (shade & 3) << uint(6-2*(i%4))
+	}
+	return msg
+}
+
+func packImage(img image.Image) []byte {
+	b := img.Bounds()
+	msg := make([]byte, 5, 5+3*b.Dx()*b.Dy())
+	msg[0] = msgImage
+	binary.LittleEndian.PutUint16(msg[1:], uint16(b.Dx()))
+	binary.LittleEndian.PutUint16(msg[3:], uint16(b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			msg = append(msg, c.R, c.G, c.B)
+		}
+	}
+	return msg
+}
+
+func (s *server) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	c := &client{out: make(chan []byte, clientQueue)}
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range c.out {
+			if conn.WriteMessage(opBinary, msg) != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+	for {
+		op, msg, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		if op == opBinary && len(msg) == 1 {
+			s.mu.Lock()
+			c.held = joypad.Button(msg[0])
+			s.mu.Unlock()
+		}
+	}
+	s.mu.Lock()
+	delete(s.clients, c)
+	close(c.out)
+	s.mu.Unlock()
+	<-done
+}
+
+type registers struct {
+	A, F, B, C, D, E, H, L byte
+	SP, PC                 uint16
+	IME                    bool
+	IE, IF                 byte
+}
+
+type memory struct {
+	Addr uint16
+	Data string
+}
+
+type state struct {
+	Registers  registers
+	Cycles     uint64
+	Frames     uint64
+	SampleRate int
+	Palette    [4]string
+	Memory     *memory `json:",omitempty"`
+}
+
+// serveState describes the CPU and, given addr and optionally len, reads
+// memory through the MMU. Numbers in the query can be decimal or 0x hex.
+func (s *server) serveState(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var read *memory
+	n := 1
+	if v := q.Get("len"); v != "" {
+		l, err := strconv.ParseUint(v, 0, 16)
+		if err != nil || l == 0 || l > maxRead {
+			http.Error(w, "bad len", http.StatusBadRequest)
+			return
+		}
+		n = int(l)
+	}
+	if v := q.Get("addr"); v != "" {
+		addr, err := strconv.ParseUint(v, 0, 16)
+		if err != nil {
+			http.Error(w, "bad addr", http.StatusBadRequest)
+			return
+		}
+		read = &memory{Addr: uint16(addr)}
+	}
+
+	s.mu.Lock()
+	c := s.gb.CPU
+	st := state{
+		Registers:  registers{c.A, c.F, c.B, c.C, c.D, c.E, c.H, c.L, c.SP, c.PC, c.IME, c.IE, c.IF},
+		Cycles:     s.gb.Cycles(),
+		Frames:     s.gb.PPU.Frames(),
+		SampleRate: s.gb.APU.SampleRate(),
+		Palette:    s.palette,
+		Memory:     read,
+	}
+	if read != nil {
+		buf := make([]byte, n)
+		for i := range buf {
+			buf[i] = s.gb.MMU.ReadByte(read.Addr + uint16(i))
+		}
+		read.Data = hex.EncodeToString(buf)
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(st)
+}
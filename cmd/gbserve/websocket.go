@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Just enough of RFC 6455 to talk to a browser: no extensions and no
+// subprotocols.
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxMessage bounds what a client can make us buffer.
+const maxMessage = 1 << 16
+
+var (
+	errHandshake = errors.New("websocket: bad handshake")
+	errOrigin    = errors.New("websocket: cross-origin handshake")
+	errProtocol  = errors.New("websocket: protocol error")
+	errTooBig    = errors.New("websocket: message too big")
+)
+
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	// wmu keeps a pong or close from the reader landing in the middle of
+	// a message being written.
+	wmu sync.Mutex
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h[name] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgrade takes over an HTTP request and finishes the opening handshake.
+func upgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-Websocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!headerHas(r.Header, "Connection", "upgrade") ||
+		!headerHas(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-Websocket-Version") != "13" {
+		http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+		return nil, errHandshake
+	}
+	// Browsers let any page open a WebSocket anywhere, so only take
+	// them from our own page. Clients that aren't browsers don't send an
+	// Origin.
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || !strings.EqualFold(u.Host, r.Host) {
+			http.Error(w, "cross-origin websocket", http.StatusForbidden)
+			return nil, errOrigin
+		}
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't hijack the connection", http.StatusInternalServerError)
+		return nil, errHandshake
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+func (c *wsConn) writeFrame(op byte, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | op
+	switch n := len(data); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	if _, err := c.conn.Write(hdr); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// WriteMessage sends a whole message in one frame.
+func (c *wsConn) WriteMessage(op byte, data []byte) error {
+	return c.writeFrame(op, data)
+}
+
+// ReadMessage returns the next text or binary message, answering pings on
+// the way. A close from the client is answered and returned as io.EOF.
+func (c *wsConn) ReadMessage() (byte, []byte, error) {
+	var op byte
+	var msg []byte
+	for {
+		fin, frameOp, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOp {
+		case opPing:
+			if err := c.writeFrame(opPong, data); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, nil)
+			return 0, nil, io.EOF
+		case opText, opBinary:
+			if msg != nil {
+				return 0, nil, errProtocol
+			}
+			op = frameOp
+			msg = data
+		case opContinuation:
+			if msg == nil {
+				return 0, nil, errProtocol
+			}
+			if len(msg)+len(data) > maxMessage {
+				return 0, nil, errTooBig
+			}
+			msg = append(msg, data...)
+		default:
+			return 0, nil, errProtocol
+		}
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, data []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.r, hdr[:]); err != nil {
+		return
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0F
+	// Clients must mask everything they send, and nothing is negotiated
+	// that would set the reserved bits.
+	if hdr[0]&0x70 != 0 || hdr[1]&0x80 == 0 {
+		return false, 0, nil, errProtocol
+	}
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxMessage {
+		return false, 0, nil, errTooBig
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+		return
+	}
+	data = make([]byte, n)
+	if _, err = io.ReadFull(c.r, data); err != nil {
+		return
+	}
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+	return
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/zbyrne/golangboy/joypad"
+)
+
+// Terminals only say when a key is typed, not when it's let go, so a
+// button stays down for holdFrames after its key last arrived. That's long
+// enough to bridge the pause before a held key starts repeating.
+const holdFrames = 16
+
+const ctrlC = 3
+
+var keyButtons = map[string]joypad.Button{
+	"\x1b[A": joypad.UP,
+	"\x1b[B": joypad.DOWN,
+	"\x1b[C": joypad.RIGHT,
+	"\x1b[D": joypad.LEFT,
+	"w":      joypad.UP,
+	"s":      joypad.DOWN,
+	"d":      joypad.RIGHT,
+	"a":      joypad.LEFT,
+	"x":      joypad.A,
+	"z":      joypad.B,
+	"\r":     joypad.START,
+	"\n":     joypad.START,
+	"\x7f":   joypad.SELECT,
+	"\b":     joypad.SELECT,
+}
+
+// keyboard turns what's typed into held buttons.
+type keyboard struct {
+	held [8]int
+	quit bool
+}
+
+// feed takes bytes read from the terminal.
+func (k *keyboard) feed(in []byte) {
+	for len(in) > 0 {
+		n := 1
+		if in[0] == 0x1b && len(in) >= 3 && (in[1] == '[' || in[1] == 'O') {
+			n = 3
+		}
+		key := string(in[:n])
+		if key[0] == 0x1b && n == 3 {
+			key = "\x1b[" + key[2:]
+		}
+		in = in[n:]
+		switch key {
+		case "q", string(rune(ctrlC)):
+			k.quit = true
+		}
+		if b, ok := keyButtons[key]; ok {
+			for i := range k.held {
+				if b&(1<<uint(i)) != 0 {
+					k.held[i] = holdFrames
+				}
+			}
+		}
+	}
+}
+
+// frame returns the buttons down for the next frame and counts the frame
+// off the time they're held for.
+func (k *keyboard) frame() joypad.Button {
+	var b joypad.Button
+	for i := range k.held {
+		if k.held[i] > 0 {
+			b |= 1 << uint(i)
+			k.held[i]--
+		}
+	}
+	return b
+}
@@ -0,0 +1,151 @@
+// gbterm plays a ROM in a terminal.
+//
+// Usage:
+//
+//	gbterm [-model dmg] [-boot dmg_boot.bin] [-256] [-palette bgb]
+//	       [-colorize -correct cgb] game.gb
+//
+// The screen is drawn with half block characters and needs a terminal of
+// at least 160 columns by 72 rows. Colours are 24-bit unless COLORTERM
+// says otherwise or -256 is given. -model picks the Game Boy to run on,
+// and -boot must be that model's boot ROM. -palette takes a preset or four hex
+// colours, lightest first. -colorize colours the game the way a CGB would
+// instead, with -correct picking how its colours are shown. CGB games run
+// on -model cgb bring their own colours, shown with -correct too.
+//
+// Keys: arrows or WASD for the D-pad, X for A, Z for B, Enter for Start,
+// Backspace for Select, Q or Ctrl-C to quit. Keys are only read on Linux.
+// Elsewhere the game plays without input until Ctrl-C.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/palette"
+	"github.com/zbyrne/golangboy/screenshot"
+)
+
+type config struct {
+	rom, boot       string
+	model           gameboy.Model
+	xterm, colorize bool
+	palette         palette.DMG
+	correct         palette.Correction
+}
+
+func main() {
+	var c config
+	model := flag.String("model", "dmg", "model to run on: "+strings.Join(gameboy.ModelNames(), ", "))
+	flag.StringVar(&c.boot, "boot", "", "boot ROM to run before the cartridge")
+	flag.BoolVar(&c.xterm, "256", false, "use the 256 colour palette instead of 24-bit colour")
+	name := flag.String("palette", "bgb", "palette: four hex colours or one of "+strings.Join(palette.Names(), ", "))
+	flag.BoolVar(&c.colorize, "colorize", false, "colour the game with the CGB boot ROM's palettes")
+	correct := flag.String("correct", "cgb", "colour correction for -colorize and CGB games: "+strings.Join(palette.CorrectionNames(), ", "))
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gbterm [-model name] [-boot file] [-256] [-palette name] [-colorize -correct name] rom")
+		os.Exit(2)
+	}
+	c.rom = flag.Arg(0)
+	var err error
+	c.model, err = gameboy.LookupModel(*model)
+	if err == nil {
+		c.palette, err = palette.Parse(*name)
+	}
+	if err == nil {
+		c.correct, err = palette.LookupCorrection(*correct)
+	}
+	if err == nil {
+		err = run(c)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(c config) error {
+	cart, err := cartridge.Load(c.rom)
+	if err != nil {
+		return err
+	}
+	opts := gameboy.Options{Model: c.model}
+	if c.boot != "" {
+		if opts.BootROM, err = os.ReadFile(c.boot); err != nil {
+			return err
+		}
+	}
+	gb, err := gameboy.New(cart, opts)
+	if err != nil {
+		return err
+	}
+
+	// Without raw mode there's no input, and Ctrl-C arrives as a signal.
+	var input chan []byte
+	if restore, err := makeRaw(int(os.Stdin.Fd())); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: playing without input\n", err)
+	} else {
+		defer restore()
+		input = make(chan []byte, 16)
+		go func() {
+			for {
+				buf := make([]byte, 64)
+				n, err := os.Stdin.Read(buf)
+				if err != nil {
+					close(input)
+					return
+				}
+				input <- buf[:n]
+			}
+		}()
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	os.Stdout.WriteString(hideCursor + clearScreen)
+	defer os.Stdout.WriteString(reset + showCursor + clearScreen + home)
+
+	colorTerm := os.Getenv("COLORTERM")
+	r := &renderer{trueColor: !c.xterm && (colorTerm == "truecolor" || colorTerm == "24bit" || colorTerm == "")}
+	shot := screenshot.Options{Palette: c.palette.CGB(), Correction: c.correct}
+	if c.colorize {
+		shot.Palette, _ = palette.Colorize(cart.Header, c.correct)
+	}
+	var keys keyboard
+	tick := time.NewTicker(gameboy.FRAME_TIME)
+	defer tick.Stop()
+	for {
+		select {
+		case <-interrupt:
+			return nil
+		case <-tick.C:
+		}
+	drain:
+		for {
+			select {
+			case in, ok := <-input:
+				if !ok {
+					return nil
+				}
+				keys.feed(in)
+			default:
+				break drain
+			}
+		}
+		if keys.quit {
+			return nil
+		}
+		gb.Joypad.SetButtons(keys.frame())
+		gb.RunFrame()
+		if _, err := os.Stdout.Write(r.render(screenshot.Screen(gb, shot))); err != nil {
+			return err
+		}
+	}
+}
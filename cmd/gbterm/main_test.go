@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zbyrne/golangboy/joypad"
+	"github.com/zbyrne/golangboy/palette"
+	"github.com/zbyrne/golangboy/ppu"
+	"github.com/zbyrne/golangboy/screenshot"
+)
+
+func TestRender(t *testing.T) {
+	frame := make([]byte, ppu.WIDTH*ppu.HEIGHT)
+	// Black on the second row of the first cell row, from x = 80.
+	for x := 80; x < ppu.WIDTH; x++ {
+		frame[ppu.WIDTH+x] = 3
+	}
+	img := screenshot.Image(frame, nil, screenshot.Options{Palette: palette.BGB.CGB()})
+	r := &renderer{trueColor: true}
+	out := string(r.render(img))
+	if !strings.HasPrefix(out, home) || strings.Count(out, "\r\n") != ppu.HEIGHT/2 || strings.Count(out, upperHalf) != ppu.WIDTH*ppu.HEIGHT/2 {
+		t.Fatalf("Rendered %d lines of %d blocks", strings.Count(out, "\r\n"), strings.Count(out, upperHalf))
+	}
+	first := strings.SplitN(out[len(home):], "\r\n", 2)[0]
+	want := "\x1b[38;2;224;248;208m\x1b[48;2;224;248;208m" + strings.Repeat(upperHalf, 80) +
+		"\x1b[48;2;8;24;32m" + strings.Repeat(upperHalf, 80) + reset
+	if first != want {
+		t.Errorf("First row is %q, not %q", first, want)
+	}
+
+	r.trueColor = false
+	out = string(r.render(img))
+	if !strings.Contains(out, "\x1b[38;5;") || strings.Contains(out, ";2;") {
+		t.Error("256 colour rendering used 24-bit escapes")
+	}
+	if again := r.render(img); !bytes.Equal(again, []byte(out)) {
+		t.Error("Rendering the same frame twice differs")
+	}
+}
+
+func TestXterm256(t *testing.T) {
+	for c, want := range map[rgb]int{
+		{0, 0, 0}:          16,
+		{255, 255, 255}:    231,
+		{255, 0, 0}:        196,
+		{128, 128, 128}:    244,
+		{0x08, 0x18, 0x20}: 233,
+	} {
+		if got := xterm256(c); got != want {
+			t.Errorf("%v is colour %d, not %d", c, got, want)
+		}
+	}
+}
+
+func TestKeyboard(t *testing.T) {
+	var k keyboard
+	k.feed([]byte("\x1b[Ax\x1bOC"))
+	if b := k.frame(); b != joypad.UP|joypad.A|joypad.RIGHT {
+		t.Errorf("Held 0x%02X", b)
+	}
+	for i := 1; i < holdFrames; i++ {
+		k.frame()
+	}
+	if b := k.frame(); b != 0 {
+		t.Errorf("Still holding 0x%02X", b)
+	}
+	k.feed([]byte("\r"))
+	k.frame()
+	k.feed([]byte("\r"))
+	for i := 0; i < holdFrames; i++ {
+		if b := k.frame(); b != joypad.START {
+			t.Errorf("Repeated key let go after %d frames", i)
+			break
+		}
+	}
+	if k.quit {
+		t.Error("Quit early")
+	}
+	k.feed([]byte{ctrlC})
+	if !k.quit {
+		t.Error("Ctrl-C didn't quit")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strconv"
+)
+
+type rgb [3]byte
+
+func toRGB(c color.Color) rgb {
+	v := color.RGBAModel.Convert(c).(color.RGBA)
+	return rgb{v.R, v.G, v.B}
+}
+
+// renderer draws frames with one upper half block per two pixels, the top
+// pixel in the foreground colour and the bottom one in the background, so
+// the screen takes 160 columns and 72 rows.
+type renderer struct {
+	// trueColor uses 24-bit colour escapes, otherwise the xterm 256 colour
+	// palette.
+	trueColor bool
+	buf       bytes.Buffer
+}
+
+const (
+	upperHalf   = "▀"
+	clearScreen = "\x1b[2J"
+	home        = "\x1b[H"
+	hideCursor  = "\x1b[?25l"
+	showCursor  = "\x1b[?25h"
+	reset       = "\x1b[0m"
+)
+
+// render returns the escapes that draw a frame over the last.
+func (r *renderer) render(img image.Image) []byte {
+	r.buf.Reset()
+	r.buf.WriteString(home)
+	b := img.Bounds()
+	for y := b.Min.Y; y+1 < b.Max.Y; y += 2 {
+		var fg, bg rgb
+		for x := b.Min.X; x < b.Max.X; x++ {
+			top, bottom := toRGB(img.At(x, y)), toRGB(img.At(x, y+1))
+			if x == b.Min.X || top != fg {
+				fg = top
+				r.colour(38, fg)
+			}
+			if x == b.Min.X || bottom != bg {
+				bg = bottom
+				r.colour(48, bg)
+			}
+			r.buf.WriteString(upperHalf)
+		}
+		r.buf.WriteString(reset + "\r\n")
+	}
+	return r.buf.Bytes()
+}
+
+// colour sets the foreground (38) or background (48) colour.
+func (r *renderer) colour(layer int, c rgb) {
+	r.buf.WriteString("\x1b[")
+	r.buf.WriteString(strconv.Itoa(layer))
+	if r.trueColor {
+		r.buf.WriteString(";2")
+		for _, v := range c {
+			r.buf.WriteByte(';')
+			r.buf.WriteString(strconv.Itoa(int(v)))
+		}
+	} else {
+		r.buf.WriteString(";5;")
+		r.buf.WriteString(strconv.Itoa(xterm256(c)))
+	}
+	r.buf.WriteByte('m')
+}
+
+// cubeLevels are the steps of the xterm 6x6x6 colour cube.
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// xterm256 picks the closest colour from the cube or the grey ramp.
+func xterm256(c rgb) int {
+	var cube [3]int
+	for i, v := range c {
+		best := 0
+		for j, level := range cubeLevels {
+			if abs(int(v)-level) < abs(int(v)-cubeLevels[best]) {
+				best = j
+			}
+		}
+		cube[i] = best
+	}
+	index := 16 + 36*cube[0] + 6*cube[1] + cube[2]
+	dist := distance(c, rgb{byte(cubeLevels[cube[0]]), byte(cubeLevels[cube[1]]), byte(cubeLevels[cube[2]])})
+
+	// The greys run from 8 to 238 in steps of 10.
+	avg := (int(c[0]) + int(c[1]) + int(c[2])) / 3
+	step := (avg - 3) / 10
+	if step < 0 {
+		step = 0
+	} else if step > 23 {
+		step = 23
+	}
+	grey := byte(8 + 10*step)
+	if distance(c, rgb{grey, grey, grey}) < dist {
+		return 232 + step
+	}
+	return index
+}
+
+func distance(a, b rgb) int {
+	var d int
+	for i := range a {
+		d += (int(a[i]) - int(b[i])) * (int(a[i]) - int(b[i]))
+	}
+	return d
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
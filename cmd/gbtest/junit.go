@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit reports the results as one test suite per directory.
+func writeJUnit(w io.Writer, results []result) error {
+	var doc junitSuites
+	index := map[string]int{}
+	var times []float64
+	for _, r := range results {
+		dir := path.Dir(r.Name)
+		i, ok := index[dir]
+		if !ok {
+			i = len(doc.Suites)
+			index[dir] = i
+			doc.Suites = append(doc.Suites, junitSuite{Name: dir})
+			times = append(times, 0)
+		}
+		s := &doc.Suites[i]
+		c := junitCase{
+			Name:      strings.TrimSuffix(path.Base(r.Name), path.Ext(r.Name)),
+			ClassName: strings.ReplaceAll(dir, "/", "."),
+			Time:      seconds(r.Time.Seconds()),
+		}
+		if !r.Pass {
+			c.Failure = &junitFailure{Message: r.Detail, Text: fmt.Sprintf("%s after %d frames: %s", r.Suite, r.Frames, r.Detail)}
+			s.Failures++
+		}
+		s.Tests++
+		s.Cases = append(s.Cases, c)
+		times[i] += r.Time.Seconds()
+		s.Time = seconds(times[i])
+	}
+	io.WriteString(w, xml.Header)
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
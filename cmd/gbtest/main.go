@@ -0,0 +1,171 @@
+// gbtest runs directories of test ROMs headlessly and reports which pass.
+//
+// Usage:
+//
+//	gbtest [-frames 7200] [-j 4] [-junit report.xml] [-boot dmg_boot.bin]
+//	       [-model auto] dir...
+//
+// Every .gb and .gbc file under the directories is run until it reports a
+// result or -frames of emulated time run out. With -model auto, the
+// default, .gbc files and cartridges whose header says they support the
+// CGB run on a CGB and the rest on a DMG. Results are read the way each suite
+// reports them:
+//
+//   - Blargg tests print "Passed" or "Failed" to the serial port, or leave
+//     a status in cartridge RAM after the signature DE B0 61 at 0xA001.
+//   - Mooneye tests run LD B,B and leave 3, 5, 8, 13, 21, 34 in B, C, D,
+//     E, H and L if they passed.
+//   - Acid tests have a reference screenshot next to the ROM, game.png for
+//     game.gb, compared with the screen once they run LD B,B. Tests running
+//     in CGB mode are compared in colour, the rest in shades.
+//
+// A summary table goes to stdout and gbtest exits non-zero if anything
+// failed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/zbyrne/golangboy/gameboy"
+)
+
+func main() {
+	frames := flag.Uint64("frames", 7200, "frames to run each ROM for before giving up")
+	jobs := flag.Int("j", runtime.NumCPU(), "ROMs to run at once")
+	junit := flag.String("junit", "", "write a JUnit XML report to this file")
+	boot := flag.String("boot", "", "boot ROM to run before each cartridge")
+	model := flag.String("model", AUTO, "model to run on: "+AUTO+", "+strings.Join(gameboy.ModelNames(), ", "))
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: gbtest [-frames n] [-j n] [-junit file] [-boot file] [-model name] dir...")
+		os.Exit(2)
+	}
+	var opts gameboy.Options
+	auto := *model == AUTO
+	if !auto {
+		var err error
+		if opts.Model, err = gameboy.LookupModel(*model); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if *boot != "" {
+		var err error
+		if opts.BootROM, err = os.ReadFile(*boot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	roms, err := findROMs(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	results := runAll(roms, opts, auto, *frames, *jobs)
+	failed := summarize(os.Stdout, results)
+	if *junit != "" {
+		f, err := os.Create(*junit)
+		if err == nil {
+			err = writeJUnit(f, results)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// findROMs lists the .gb and .gbc files under each directory, with Name
+// set to the path from that directory.
+func findROMs(dirs []string) ([]result, error) {
+	var roms []result
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !isROM(path) {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			roms = append(roms, result{Name: filepath.ToSlash(rel), Path: path})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(roms, func(i, j int) bool {
+		return roms[i].Name < roms[j].Name
+	})
+	return roms, nil
+}
+
+func isROM(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".gb" || ext == ".gbc"
+}
+
+func runAll(roms []result, opts gameboy.Options, auto bool, frames uint64, jobs int) []result {
+	if jobs < 1 {
+		jobs = 1
+	}
+	results := make([]result, len(roms))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				r := runROM(roms[i].Path, opts, auto, frames)
+				r.Name = roms[i].Name
+				results[i] = r
+			}
+		}()
+	}
+	for i := range roms {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// summarize prints a table of the results and returns how many failed.
+func summarize(w io.Writer, results []result) int {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROM\tSUITE\tRESULT\tFRAMES\tDETAIL")
+	var failed int
+	for _, r := range results {
+		status := "pass"
+		if !r.Pass {
+			status = "FAIL"
+			failed++
+		}
+		suite := r.Suite
+		if suite == "" {
+			suite = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", r.Name, suite, status, r.Frames, r.Detail)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d passed, %d failed\n", len(results)-failed, failed)
+	return failed
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zbyrne/golangboy/asm"
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/ppu"
+)
+
+// printer prints Message to the serial port the way Blargg's tests do.
+const printer = `
+SECTION "main", ROM0[$100]
+	ld hl, Message
+.next:
+	ld a, [hl+]
+	or a
+	jr z, .done
+	ldh [$01], a
+	ld a, $81
+	ldh [$02], a
+.wait:
+	ldh a, [$02]
+	bit 7, a
+	jr nz, .wait
+	jr .next
+.done:
+	jr .done
+Message:
+`
+
+// blarggRAM leaves a result in cartridge RAM instead.
+const blarggRAM = `
+SECTION "main", ROM0[$100]
+	ld a, $0A
+	ld [$0000], a
+	ld hl, $A000
+	ld a, $80
+	ld [hl+], a
+	ld a, $DE
+	ld [hl+], a
+	ld a, $B0
+	ld [hl+], a
+	ld a, $61
+	ld [hl+], a
+	ld de, Message
+.copy:
+	ld a, [de]
+	inc de
+	ld [hl+], a
+	or a
+	jr nz, .copy
+	ld a, STATUS
+	ld [$A000], a
+.done:
+	jr .done
+Message:
+	db "crc wrong", 10, 0
+`
+
+func mooneye(b byte) string {
+	return strings.Replace(`
+SECTION "main", ROM0[$100]
+	ld b, FIRST
+	ld c, 5
+	ld d, 8
+	ld e, 13
+	ld h, 21
+	ld l, 34
+	ld b, b
+.done:
+	jr .done
+`, "FIRST", string('0'+b), 1)
+}
+
+// cgb passes as a mooneye test only on a CGB, which boots with $11 in A.
+const cgb = `
+SECTION "main", ROM0[$100]
+	sub $0E
+	ld b, a
+	ld c, 5
+	ld d, 8
+	ld e, 13
+	ld h, 21
+	ld l, 34
+	ld b, b
+.done:
+	jr .done
+`
+
+// acid fills the screen with the shade of colour 0 in BGP.
+const acid = `
+SECTION "main", ROM0[$100]
+	ld a, $03
+	ldh [$47], a
+	ld b, b
+.done:
+	jr .done
+`
+
+// cgbAcid fills the screen with red, colour 0 of background palette 0, in
+// CGB mode.
+const cgbAcid = `
+SECTION "flag", ROM0[$143]
+	db $80
+SECTION "main", ROM0[$100]
+	ld a, $80
+	ldh [$68], a
+	ld a, $1F
+	ldh [$69], a
+	xor a
+	ldh [$69], a
+	ld b, b
+.done:
+	jr .done
+`
+
+const spin = `
+SECTION "main", ROM0[$100]
+.done:
+	jr .done
+`
+
+func writeROM(t *testing.T, path, source string, header ...byte) {
+	// header starts at the cartridge type.
+	if len(header) > 0 {
+		header = append(make([]byte, 0x147-0x134), header...)
+	}
+	rom, err := asm.ROM(source, header...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writePNG(t *testing.T, path string, c color.Color) {
+	img := image.NewRGBA(image.Rect(0, 0, ppu.WIDTH, ppu.HEIGHT))
+	for y := 0; y < ppu.HEIGHT; y++ {
+		for x := 0; x < ppu.WIDTH; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	writeROM(t, filepath.Join(dir, "blargg", "pass.gb"), printer+`db "01-test", 10, 10, "Passed", 10, 0`)
+	writeROM(t, filepath.Join(dir, "blargg", "fail.gb"), printer+`db "02-test", 10, 10, "C3", 10, "Failed #3", 10, 0`)
+	writeROM(t, filepath.Join(dir, "blargg", "ram-pass.gb"), strings.Replace(blarggRAM, "STATUS", "0", 1), 0x03, 0, 0x02)
+	writeROM(t, filepath.Join(dir, "blargg", "ram-fail.gb"), strings.Replace(blarggRAM, "STATUS", "1", 1), 0x03, 0, 0x02)
+	writeROM(t, filepath.Join(dir, "mooneye", "pass.gb"), mooneye(3))
+	writeROM(t, filepath.Join(dir, "mooneye", "fail.gb"), mooneye(4))
+	writeROM(t, filepath.Join(dir, "acid", "pass.gb"), acid)
+	writePNG(t, filepath.Join(dir, "acid", "pass.png"), color.Gray{0})
+	writeROM(t, filepath.Join(dir, "acid", "fail.gb"), acid)
+	writePNG(t, filepath.Join(dir, "acid", "fail.png"), color.Gray{0xAA})
+	// A CGB test is checked in colour, so nearly red isn't enough.
+	writeROM(t, filepath.Join(dir, "acid", "cgb-pass.gbc"), cgbAcid)
+	writePNG(t, filepath.Join(dir, "acid", "cgb-pass.png"), color.RGBA{0xFF, 0, 0, 0xFF})
+	writeROM(t, filepath.Join(dir, "acid", "cgb-fail.gbc"), cgbAcid)
+	writePNG(t, filepath.Join(dir, "acid", "cgb-fail.png"), color.RGBA{0xFF, 0x10, 0, 0xFF})
+	writeROM(t, filepath.Join(dir, "spin.gb"), spin)
+	writeROM(t, filepath.Join(dir, "cgb", "dmg.gb"), cgb)
+	writeROM(t, filepath.Join(dir, "cgb", "ext.gbc"), cgb)
+	writeROM(t, filepath.Join(dir, "cgb", "flag.gb"), cgb+"SECTION \"flag\", ROM0[$143]\n\tdb $80\n")
+
+	roms, err := findROMs([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := runAll(roms, gameboy.Options{}, true, 30, 4)
+	want := map[string]struct {
+		suite  string
+		pass   bool
+		detail string
+	}{
+		"acid/cgb-fail.gbc":  {ACID, false, "23040 pixels differ, first at (0, 0)"},
+		"acid/cgb-pass.gbc":  {ACID, true, ""},
+		"acid/fail.gb":       {ACID, false, "23040 pixels differ, first at (0, 0)"},
+		"acid/pass.gb":       {ACID, true, ""},
+		"blargg/fail.gb":     {BLARGG, false, "Failed #3"},
+		"blargg/pass.gb":     {BLARGG, true, ""},
+		"blargg/ram-fail.gb": {BLARGG, false, "status 1: crc wrong"},
+		"blargg/ram-pass.gb": {BLARGG, true, ""},
+		"cgb/dmg.gb":         {MOONEYE, false, "B=F3 C=05 D=08 E=0D H=15 L=22"},
+		"cgb/ext.gbc":        {MOONEYE, true, ""},
+		"cgb/flag.gb":        {MOONEYE, true, ""},
+		"mooneye/fail.gb":    {MOONEYE, false, "B=04 C=05 D=08 E=0D H=15 L=22"},
+		"mooneye/pass.gb":    {MOONEYE, true, ""},
+		"spin.gb":            {"", false, "no result after 30 frames"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("Ran %d ROMs, not %d", len(results), len(want))
+	}
+	for _, r := range results {
+		w := want[r.Name]
+		if r.Suite != w.suite || r.Pass != w.pass || r.Detail != w.detail {
+			t.Errorf("%s: %s pass=%v %q, not %s pass=%v %q", r.Name, r.Suite, r.Pass, r.Detail, w.suite, w.pass, w.detail)
+		}
+	}
+
+	var table bytes.Buffer
+	if failed := summarize(&table, results); failed != 7 {
+		t.Errorf("Counted %d failures", failed)
+	}
+	if !strings.HasSuffix(table.String(), "7 passed, 7 failed\n") {
+		t.Errorf("Summary is\n%s", table.String())
+	}
+
+	var report bytes.Buffer
+	if err := writeJUnit(&report, results); err != nil {
+		t.Fatal(err)
+	}
+	var doc junitSuites
+	if err := xml.Unmarshal(report.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range doc.Suites {
+		names = append(names, s.Name)
+		if s.Name == "blargg" && (s.Tests != 4 || s.Failures != 2 || s.Cases[0].Name != "fail" || s.Cases[0].Failure == nil) {
+			t.Errorf("Blargg suite is %+v", s)
+		}
+	}
+	if strings.Join(names, " ") != "acid blargg cgb mooneye ." {
+		t.Errorf("Suites are %v", names)
+	}
+}
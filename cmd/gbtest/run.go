@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/ppu"
+)
+
+const (
+	BLARGG  = "blargg"
+	MOONEYE = "mooneye"
+	ACID    = "acid"
+
+	// AUTO picks the model from each cartridge.
+	AUTO = "auto"
+
+	// ldBB is LD B,B, which mooneye and acid tests run when they finish.
+	ldBB = 0x40
+	// blarggTail is how many frames to keep running after a Blargg test
+	// says it failed, to catch the reason.
+	blarggTail = 60
+)
+
+// fibonacci is what a passing mooneye test leaves in B, C, D, E, H and L.
+var fibonacci = [6]byte{3, 5, 8, 13, 21, 34}
+
+// blarggSignature is at 0xA001 once a Blargg test has put its result in
+// cartridge RAM, for tests that don't print to the serial port.
+var blarggSignature = []byte{0xDE, 0xB0, 0x61}
+
+type result struct {
+	// Name is the ROM's path from the directory given, with slashes.
+	Name   string
+	Path   string
+	Suite  string
+	Pass   bool
+	Frames uint64
+	Detail string
+	Time   time.Duration
+}
+
+// serialLog collects what a test prints to the serial port.
+type serialLog struct {
+	bytes.Buffer
+	changed bool
+}
+
+func (s *serialLog) Exchange(out byte) byte {
+	s.WriteByte(out)
+	s.changed = true
+	return 0xFF
+}
+
+// runROM runs a test ROM until it reports a result or the budget of
+// frames runs out. A PNG next to the ROM makes it an acid test, checked
+// against the screen once it finishes. With auto set the ROM runs on a
+// CGB if it is a .gbc file or its header says it supports one.
+func runROM(path string, opts gameboy.Options, auto bool, budget uint64) result {
+	start := time.Now()
+	r := run(path, opts, auto, budget)
+	r.Path = path
+	r.Time = time.Since(start)
+	return r
+}
+
+func run(path string, opts gameboy.Options, auto bool, budget uint64) result {
+	cart, err := cartridge.Load(path)
+	if err != nil {
+		return result{Detail: err.Error()}
+	}
+	if auto {
+		opts.Model = gameboy.DMG
+		if cart.Header.CGB() || filepath.Ext(path) == ".gbc" {
+			opts.Model = gameboy.CGB
+		}
+	}
+	var want image.Image
+	reference := strings.TrimSuffix(path, filepath.Ext(path)) + ".png"
+	if _, err := os.Stat(reference); err == nil {
+		if want, err = loadScreenshot(reference); err != nil {
+			return result{Suite: ACID, Detail: err.Error()}
+		}
+	}
+	g, err := gameboy.New(cart, opts)
+	if err != nil {
+		return result{Detail: err.Error()}
+	}
+	var log serialLog
+	g.Serial.Connect(&log)
+
+	limit := budget * ppu.FRAME_TICKS
+	frames := func() uint64 {
+		return g.Cycles() / ppu.FRAME_TICKS
+	}
+	var frame uint64
+	for g.Cycles() < limit {
+		// Blargg tests print their name first, and some run LD B,B as
+		// part of the test.
+		if log.Len() == 0 && g.MMU.ReadByte(g.CPU.PC) == ldBB {
+			if want != nil {
+				// Let the picture finish before looking at it.
+				g.RunFrame()
+				g.RunFrame()
+				return compareScreen(g, want, frames())
+			}
+			return checkMooneye(g, frames())
+		}
+		g.StepInstruction()
+		if log.changed {
+			log.changed = false
+			out := log.String()
+			if strings.Contains(out, "Passed") {
+				return result{Suite: BLARGG, Pass: true, Frames: frames()}
+			}
+			if strings.Contains(out, "Failed") {
+				g.RunCycles(blarggTail * ppu.FRAME_TICKS)
+				return result{Suite: BLARGG, Frames: frames(), Detail: lastLine(log.String())}
+			}
+		}
+		if frames() != frame {
+			frame = frames()
+			if r, ok := checkBlarggRAM(g); ok {
+				r.Frames = frame
+				return r
+			}
+		}
+	}
+	r := result{Frames: frames(), Detail: fmt.Sprintf("no result after %d frames", budget)}
+	if want != nil {
+		r.Suite = ACID
+	}
+	if out := strings.TrimSpace(log.String()); out != "" {
+		r.Suite = BLARGG
+		r.Detail += ": " + lastLine(out)
+	}
+	return r
+}
+
+func checkMooneye(g *gameboy.GameBoy, frames uint64) result {
+	c := g.CPU
+	regs := [6]byte{c.B, c.C, c.D, c.E, c.H, c.L}
+	r := result{Suite: MOONEYE, Frames: frames, Pass: regs == fibonacci}
+	if !r.Pass {
+		r.Detail = fmt.Sprintf("B=%02X C=%02X D=%02X E=%02X H=%02X L=%02X", c.B, c.C, c.D, c.E, c.H, c.L)
+	}
+	return r
+}
+
+// checkBlarggRAM looks for a result left in cartridge RAM. 0x80 at 0xA000
+// means the test is still running.
+func checkBlarggRAM(g *gameboy.GameBoy) (result, bool) {
+	for i, b := range blarggSignature {
+		if g.MMU.ReadByte(0xA001+uint16(i)) != b {
+			return result{}, false
+		}
+	}
+	status := g.MMU.ReadByte(0xA000)
+	if status == 0x80 {
+		return result{}, false
+	}
+	r := result{Suite: BLARGG, Pass: status == 0}
+	if !r.Pass {
+		var text []byte
+		for addr := uint16(0xA004); addr < 0xC000; addr++ {
+			b := g.MMU.ReadByte(addr)
+			if b == 0 {
+				break
+			}
+			text = append(text, b)
+		}
+		r.Detail = fmt.Sprintf("status %d: %s", status, lastLine(string(text)))
+	}
+	return r, true
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// loadScreenshot reads a reference PNG.
+func loadScreenshot(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if img.Bounds().Size() != image.Pt(ppu.WIDTH, ppu.HEIGHT) {
+		return nil, fmt.Errorf("%s: not %dx%d", path, ppu.WIDTH, ppu.HEIGHT)
+	}
+	return img, nil
+}
+
+// shade turns a colour into the nearest shade, 0 white to 3 black.
+func shade(c color.Color) uint16 {
+	r, g, b, _ := c.RGBA()
+	grey := (r + g + b) / 3
+	return uint16(3 - (grey*3+0x7FFF)/0xFFFF)
+}
+
+// cgbColor turns a colour into the CGB's 15 bit format. Reference
+// screenshots of CGB tests show its colours without correction.
+func cgbColor(c color.Color) uint16 {
+	r, g, b, _ := c.RGBA()
+	return uint16(r>>11) | uint16(g>>11)<<5 | uint16(b>>11)<<10
+}
+
+// compareScreen checks the screen against a reference: in colour if the
+// test runs in CGB mode, otherwise in shades.
+func compareScreen(g *gameboy.GameBoy, want image.Image, frames uint64) result {
+	r := result{Suite: ACID, Frames: frames}
+	colors, frame := g.Colors(), g.Frame()
+	min := want.Bounds().Min
+	var wrong int
+	first := -1
+	for i := 0; i < ppu.WIDTH*ppu.HEIGHT; i++ {
+		c := want.At(min.X+i%ppu.WIDTH, min.Y+i/ppu.WIDTH)
+		var ok bool
+		if colors != nil {
+			ok = colors[i]&0x7FFF == cgbColor(c)
+		} else {
+			ok = uint16(frame[i]) == shade(c)
+		}
+		if !ok {
+			if first < 0 {
+				first = i
+			}
+			wrong++
+		}
+	}
+	r.Pass = wrong == 0
+	if !r.Pass {
+		r.Detail = fmt.Sprintf("%d pixels differ, first at (%d, %d)", wrong, first%ppu.WIDTH, first/ppu.WIDTH)
+	}
+	return r
+}
@@ -0,0 +1,463 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zbyrne/golangboy/asm"
+	"github.com/zbyrne/golangboy/symbols"
+)
+
+const testSource = `SECTION "vars", WRAM0[$C000]
+Counter:
+	ds 1
+
+SECTION "main", ROM0[$100]
+Main:
+	ld hl, Counter
+	; bump the counter
+.loop:
+	inc [hl]
+	call Bump
+	jr .loop
+
+Bump:
+	nop
+	ret
+
+Waiter:
+	call Wait
+	jr Waiter
+
+Wait:
+	ld hl, Flag
+	bit 0, [hl]
+	jr z, Wait
+	ret
+
+SECTION "flag", WRAM0[$C001]
+Flag:
+	ds 1
+`
+
+// writeProject assembles testSource into dir as game.gb with a game.sym
+// alongside, the way RGBDS would leave them.
+func writeProject(t *testing.T, dir string) string {
+	p, err := asm.Assemble(testSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom := make([]byte, 0x8000)
+	if err := p.Patch(rom); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range p.Symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sym strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sym, "00:%04X %s\n", p.Symbols[name], name)
+	}
+	files := map[string]string{"main.asm": testSource, "game.gb": string(rom), "game.sym": sym.String()}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "game.gb")
+}
+
+type message struct {
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+type client struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	seq    int
+	events []message
+}
+
+func newClient(t *testing.T) (*client, chan error) {
+	server, conn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(server)
+	}()
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}, done
+}
+
+func (c *client) read() message {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var n int
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	fmt.Sscanf(line, "Content-Length: %d", &n)
+	c.r.ReadString('\n')
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.t.Fatal(err)
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// send sends a request and returns its response, holding on to any events
+// that arrive first.
+func (c *client) send(command string, args interface{}) message {
+	c.seq++
+	data, _ := json.Marshal(map[string]interface{}{
+		"seq": c.seq, "type": "request", "command": command, "arguments": args,
+	})
+	fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	for {
+		msg := c.read()
+		if msg.Type == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		if msg.RequestSeq != c.seq {
+			c.t.Fatalf("%s got a response to request %d", command, msg.RequestSeq)
+		}
+		return msg
+	}
+}
+
+// call sends a request and returns the body of a successful response.
+func (c *client) call(command string, args interface{}, body interface{}) {
+	msg := c.send(command, args)
+	if !msg.Success {
+		c.t.Fatalf("%s failed: %+v", command, msg)
+	}
+	if body != nil {
+		json.Unmarshal(msg.Body, body)
+	}
+}
+
+// event waits for the named event and returns its body.
+func (c *client) event(name string, body interface{}) {
+	for {
+		var msg message
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.read()
+		}
+		if msg.Type == "event" && msg.Event == name {
+			if body != nil {
+				json.Unmarshal(msg.Body, body)
+			}
+			return
+		}
+	}
+}
+
+type stoppedBody struct {
+	Reason           string `json:"reason"`
+	HitBreakpointIDs []int  `json:"hitBreakpointIds"`
+}
+
+func (c *client) stopped(reason string) {
+	var body stoppedBody
+	c.event("stopped", &body)
+	if body.Reason != reason {
+		c.t.Errorf("Stopped for %q, not %q", body.Reason, reason)
+	}
+}
+
+// stack returns name@line for each frame.
+func (c *client) stack() []string {
+	var body struct {
+		StackFrames []stackFrame `json:"stackFrames"`
+	}
+	c.call("stackTrace", map[string]int{"threadId": threadID}, &body)
+	var frames []string
+	for _, f := range body.StackFrames {
+		frames = append(frames, fmt.Sprintf("%s@%d", f.Name, f.Line))
+	}
+	return frames
+}
+
+func (c *client) variable(ref int, name string) string {
+	var body struct {
+		Variables []variable `json:"variables"`
+	}
+	c.call("variables", map[string]int{"variablesReference": ref}, &body)
+	for _, v := range body.Variables {
+		if v.Name == name {
+			return v.Value
+		}
+	}
+	c.t.Errorf("No variable %s", name)
+	return ""
+}
+
+func TestSession(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	program := writeProject(t, dir)
+	c, done := newClient(t)
+
+	var caps capabilities
+	c.call("initialize", map[string]string{"adapterID": "golangboy"}, &caps)
+	if !caps.SupportsConfigurationDoneRequest {
+		t.Errorf("Capabilities %+v", caps)
+	}
+	c.call("launch", map[string]interface{}{"program": program, "stopOnEntry": true}, nil)
+	c.event("initialized", nil)
+
+	// The comment on line 8 moves down to the code on line 10.
+	var bps struct {
+		Breakpoints []breakpoint `json:"breakpoints"`
+	}
+	c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": filepath.Join(dir, "main.asm")},
+		"breakpoints": []map[string]int{{"line": 8}, {"line": 15}, {"line": 40}},
+	}, &bps)
+	if len(bps.Breakpoints) != 3 {
+		t.Fatalf("Breakpoints %+v", bps.Breakpoints)
+	}
+	for i, want := range []int{10, 15} {
+		if bp := bps.Breakpoints[i]; !bp.Verified || bp.Line != want {
+			t.Errorf("Breakpoint %d is %+v, not at line %d", i, bp, want)
+		}
+	}
+	if bps.Breakpoints[2].Verified {
+		t.Errorf("Breakpoint past the end verified: %+v", bps.Breakpoints[2])
+	}
+
+	c.call("configurationDone", nil, nil)
+	c.stopped("entry")
+	if got := strings.Join(c.stack(), " "); got != "Main@7" {
+		t.Errorf("Stack at entry is %s", got)
+	}
+
+	c.call("continue", map[string]int{"threadId": threadID}, nil)
+	c.stopped("breakpoint")
+	if got := strings.Join(c.stack(), " "); got != "Main.loop@10" {
+		t.Errorf("Stack at the loop is %s", got)
+	}
+
+	c.call("continue", map[string]int{"threadId": threadID}, nil)
+	c.stopped("breakpoint")
+	if got := strings.Join(c.stack(), " "); got != "Bump@15 Main.loop+1@11" {
+		t.Errorf("Stack in Bump is %s", got)
+	}
+	if got := c.variable(labelsRef, "Counter"); got != "$01 (1)" {
+		t.Errorf("Counter is %s", got)
+	}
+	if got := c.variable(registersRef, "PC"); got != "$0109" {
+		t.Errorf("PC is %s", got)
+	}
+
+	var set struct {
+		Value string `json:"value"`
+	}
+	c.call("setVariable", map[string]interface{}{"variablesReference": registersRef, "name": "A", "value": "$42"}, &set)
+	if set.Value != "$42" {
+		t.Errorf("Set A to %s", set.Value)
+	}
+	c.call("setVariable", map[string]interface{}{"variablesReference": labelsRef, "name": "Counter", "value": "7"}, &set)
+	var eval struct {
+		Result string `json:"result"`
+	}
+	c.call("evaluate", map[string]string{"expression": "Counter"}, &eval)
+	if eval.Result != "$07 (7)" {
+		t.Errorf("Counter evaluated to %s", eval.Result)
+	}
+
+	c.call("stepOut", map[string]int{"threadId": threadID}, nil)
+	c.stopped("step")
+	if got := strings.Join(c.stack(), " "); got != "Main.loop+4@12" {
+		t.Errorf("Stack after stepping out is %s", got)
+	}
+
+	// A query in the middle of a step picks the step up again afterwards,
+	// so it still stops once Wait returns.
+	c.call("setVariable", map[string]interface{}{"variablesReference": registersRef, "name": "PC", "value": "$010B"}, &set)
+	c.call("next", map[string]int{"threadId": threadID}, nil)
+	c.call("setVariable", map[string]interface{}{"variablesReference": labelsRef, "name": "Flag", "value": "1"}, &set)
+	c.stopped("step")
+	if got := strings.Join(c.stack(), " "); got != "Waiter+3@20" {
+		t.Errorf("Stack after stepping over Wait is %s", got)
+	}
+	c.call("setVariable", map[string]interface{}{"variablesReference": registersRef, "name": "PC", "value": "$0100"}, &set)
+
+	// Requests made while running stop the program only for a moment.
+	c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": filepath.Join(dir, "main.asm")},
+		"breakpoints": []map[string]int{},
+	}, nil)
+	c.call("continue", map[string]int{"threadId": threadID}, nil)
+	c.call("evaluate", map[string]string{"expression": "A"}, &eval)
+	if eval.Result != "$42" {
+		t.Errorf("A evaluated to %s", eval.Result)
+	}
+	c.call("pause", map[string]int{"threadId": threadID}, nil)
+	c.stopped("pause")
+
+	c.call("disconnect", nil, nil)
+	c.event("terminated", nil)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResumeAtBreakpoint(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	program := writeProject(t, dir)
+	c, done := newClient(t)
+	c.call("initialize", map[string]string{"adapterID": "golangboy"}, nil)
+	c.call("launch", map[string]interface{}{"program": program, "stopOnEntry": true}, nil)
+	c.event("initialized", nil)
+	c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": filepath.Join(dir, "main.asm")},
+		"breakpoints": []map[string]int{{"line": 15}},
+	}, nil)
+	c.call("configurationDone", nil, nil)
+	c.stopped("entry")
+
+	// Wait for a Flag that never gets set, then move PC onto the
+	// breakpoint in Bump while it runs. Picking the run up again must stop
+	// there rather than run the NOP and return into the wait.
+	var set struct {
+		Value string `json:"value"`
+	}
+	c.call("setVariable", map[string]interface{}{"variablesReference": registersRef, "name": "PC", "value": "$010B"}, &set)
+	c.call("continue", map[string]int{"threadId": threadID}, nil)
+	c.call("setVariable", map[string]interface{}{"variablesReference": registersRef, "name": "PC", "value": "$0109"}, &set)
+	c.stopped("breakpoint")
+	if got := c.variable(registersRef, "PC"); got != "$0109" {
+		t.Errorf("Stopped with PC %s", got)
+	}
+
+	c.call("disconnect", nil, nil)
+	c.event("terminated", nil)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLaunchModel(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	program := writeProject(t, dir)
+	c, done := newClient(t)
+	c.call("initialize", map[string]string{"adapterID": "golangboy"}, nil)
+	c.call("launch", map[string]interface{}{"program": program, "model": "cgb", "stopOnEntry": true}, nil)
+	c.event("initialized", nil)
+	c.call("configurationDone", nil, nil)
+	c.stopped("entry")
+	// A CGB leaves its boot ROM with $11 in A.
+	if got := c.variable(registersRef, "A"); got != "$11" {
+		t.Errorf("A is %s at entry on a CGB", got)
+	}
+
+	c.call("disconnect", nil, nil)
+	c.event("terminated", nil)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBankedLabels(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	program := writeProject(t, dir)
+	// A DMG only has work RAM bank 1 at $D000, so Far is never mapped.
+	sym, err := os.OpenFile(filepath.Join(dir, "game.sym"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(sym, "01:D000 Near\n02:D000 Far\n")
+	sym.Close()
+	c, done := newClient(t)
+	c.call("initialize", map[string]string{"adapterID": "golangboy"}, nil)
+	c.call("launch", map[string]interface{}{"program": program, "stopOnEntry": true}, nil)
+	c.event("initialized", nil)
+	c.call("configurationDone", nil, nil)
+	c.stopped("entry")
+
+	c.call("setVariable", map[string]interface{}{"variablesReference": labelsRef, "name": "Near", "value": "$42"}, nil)
+	if got := c.variable(labelsRef, "Far"); got != "bank 02 not mapped" {
+		t.Errorf("Far shows as %s", got)
+	}
+	if msg := c.send("setVariable", map[string]interface{}{"variablesReference": labelsRef, "name": "Far", "value": "$99"}); msg.Success {
+		t.Error("Set a label in a bank that isn't mapped")
+	}
+	if msg := c.send("evaluate", map[string]string{"expression": "Far"}); msg.Success {
+		t.Errorf("Evaluated a label in a bank that isn't mapped: %s", msg.Body)
+	}
+	if got := c.variable(labelsRef, "Near"); got != "$42 (66)" {
+		t.Errorf("Near is %s", got)
+	}
+
+	c.call("disconnect", nil, nil)
+	c.event("terminated", nil)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeProject(t, dir)
+	syms, err := symbols.Load(filepath.Join(dir, "game.sym"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newSourceMap()
+	if err := m.load([]string{dir}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "main.asm")
+	for line, want := range map[int]uint16{7: 0x100, 10: 0x103, 11: 0x104, 12: 0x107, 16: 0x10A} {
+		sym, got, err := m.address(path, line, syms)
+		if err != nil || got != line || sym.Addr != want {
+			t.Errorf("Line %d is at 0x%04X on line %d: %v", line, sym.Addr, got, err)
+		}
+		p, l, ok := m.location(0, want, syms)
+		if !ok || p != path || l != line {
+			t.Errorf("0x%04X is at %s:%d", want, p, l)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// request is the envelope of every message from the client.
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// conn frames messages with Content-Length headers.
+type conn struct {
+	r   *bufio.Reader
+	w   io.Writer
+	mu  sync.Mutex
+	seq int
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	return &conn{r: bufio.NewReader(rw), w: rw}
+}
+
+func (c *conn) read() (*request, error) {
+	headers, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(headers.Get("Content-Length")))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("dap: bad Content-Length %q", headers.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, err
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (c *conn) write(msg interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	switch m := msg.(type) {
+	case *response:
+		m.Seq = c.seq
+	case *event:
+		m.Seq = c.seq
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (c *conn) respond(req *request, body interface{}) error {
+	return c.write(&response{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (c *conn) fail(req *request, err error) error {
+	return c.write(&response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: err.Error()})
+}
+
+func (c *conn) event(name string, body interface{}) error {
+	return c.write(&event{Type: "event", Event: name, Body: body})
+}
+
+// The parts of the protocol's types that the server fills in.
+
+type capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsFunctionBreakpoints      bool `json:"supportsFunctionBreakpoints"`
+	SupportsSetVariable              bool `json:"supportsSetVariable"`
+	SupportsEvaluateForHovers        bool `json:"supportsEvaluateForHovers"`
+	SupportsTerminateRequest         bool `json:"supportsTerminateRequest"`
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type breakpoint struct {
+	ID       int     `json:"id,omitempty"`
+	Verified bool    `json:"verified"`
+	Message  string  `json:"message,omitempty"`
+	Source   *source `json:"source,omitempty"`
+	Line     int     `json:"line,omitempty"`
+}
+
+type stackFrame struct {
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Source *source `json:"source,omitempty"`
+	Line   int     `json:"line"`
+	Column int     `json:"column"`
+	// InstructionPointerReference is the bank and address as bb:aaaa.
+	InstructionPointerReference string `json:"instructionPointerReference"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+type thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
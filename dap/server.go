@@ -0,0 +1,593 @@
+// Package dap serves the Debug Adapter Protocol so editors can debug
+// RGBDS projects at the source level.
+//
+// A launch request names the ROM, its .sym or .map file and the source
+// files or directories to match labels against:
+//
+//	{"program": "game.gb", "symbols": "game.sym", "sources": ["src"]}
+//
+// symbols defaults to the ROM's name with a .sym extension and sources to
+// the ROM's directory. model names the Game Boy to run on, "dmg" by
+// default, and bootROM a boot ROM for it to run first. Set stopOnEntry to
+// stop before the first instruction.
+package dap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/debugger"
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/symbols"
+)
+
+const (
+	threadID     = 1
+	registersRef = 1
+	labelsRef    = 2
+)
+
+var (
+	errNotLaunched = errors.New("no program has been launched")
+	errUnsupported = errors.New("not supported")
+)
+
+type launchArgs struct {
+	Program     string   `json:"program"`
+	Model       string   `json:"model"`
+	BootROM     string   `json:"bootROM"`
+	Symbols     string   `json:"symbols"`
+	Sources     []string `json:"sources"`
+	StopOnEntry bool     `json:"stopOnEntry"`
+}
+
+type server struct {
+	c    *conn
+	d    *debugger.Debugger
+	syms *symbols.Table
+	src  *sourceMap
+	// lineBreaks holds the breakpoint IDs set in each source file and
+	// funcBreaks those set by label name.
+	lineBreaks  map[string][]int
+	funcBreaks  []int
+	stopOnEntry bool
+	running     bool
+	run         func() debugger.Stop
+	done        chan debugger.Stop
+}
+
+// ListenAndServe accepts editor connections on addr, one after another.
+func ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		err = Serve(c)
+		c.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Serve runs one debug session over rw, which is usually stdio or a
+// socket. It returns nil once the client disconnects.
+func Serve(rw io.ReadWriter) error {
+	s := &server{
+		c:          newConn(rw),
+		syms:       symbols.New(),
+		src:        newSourceMap(),
+		lineBreaks: map[string][]int{},
+		done:       make(chan debugger.Stop, 1),
+	}
+	requests := make(chan *request)
+	errs := make(chan error, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		for {
+			req, err := s.c.read()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case req := <-requests:
+			if s.handle(req) {
+				return nil
+			}
+		case stop := <-s.done:
+			s.running = false
+			s.stopped(stop)
+		case err := <-errs:
+			s.halt()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// resume runs the debugger in the background. Its stop arrives on done.
+// The run is kept so a query that halts it can pick it up again.
+func (s *server) resume(run func() debugger.Stop) {
+	s.running = true
+	s.run = run
+	go func() {
+		s.done <- run()
+	}()
+}
+
+// halt stops a running program so a request can look at it. It returns
+// the stop and whether the program was running. Run clears a pending
+// interrupt when it starts, so keep asking until it stops.
+func (s *server) halt() (debugger.Stop, bool) {
+	if !s.running {
+		return debugger.Stop{}, false
+	}
+	for {
+		s.d.Interrupt()
+		select {
+		case stop := <-s.done:
+			s.running = false
+			return stop, true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (s *server) stopped(stop debugger.Stop) {
+	body := map[string]interface{}{"threadId": threadID, "allThreadsStopped": true}
+	switch stop.Reason {
+	case debugger.BREAKPOINT:
+		body["reason"] = "breakpoint"
+		body["hitBreakpointIds"] = []int{stop.ID}
+	case debugger.WATCHPOINT:
+		body["reason"] = "data breakpoint"
+	case debugger.INTERRUPTED:
+		body["reason"] = "pause"
+	case debugger.LOCKED:
+		body["reason"] = "exception"
+		body["description"] = "CPU locked up by an illegal opcode"
+	default:
+		body["reason"] = "step"
+	}
+	s.c.event("stopped", body)
+}
+
+// handle answers one request and reports whether the session is over.
+func (s *server) handle(req *request) bool {
+	switch req.Command {
+	case "initialize":
+		s.c.respond(req, capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsFunctionBreakpoints:      true,
+			SupportsSetVariable:              true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		})
+		return false
+	case "launch":
+		if err := s.launch(req.Arguments); err != nil {
+			s.c.fail(req, err)
+			return false
+		}
+		s.c.respond(req, nil)
+		s.c.event("initialized", nil)
+		return false
+	case "disconnect", "terminate":
+		s.halt()
+		s.c.respond(req, nil)
+		s.c.event("terminated", nil)
+		return true
+	case "threads":
+		s.c.respond(req, map[string]interface{}{"threads": []thread{{threadID, "SM83"}}})
+		return false
+	}
+	if s.d == nil {
+		s.c.fail(req, errNotLaunched)
+		return false
+	}
+	switch req.Command {
+	case "configurationDone":
+		s.c.respond(req, nil)
+		if s.stopOnEntry {
+			s.c.event("stopped", map[string]interface{}{"reason": "entry", "threadId": threadID, "allThreadsStopped": true})
+		} else {
+			s.resume(s.d.Continue)
+		}
+		return false
+	case "continue":
+		s.halt()
+		s.c.respond(req, map[string]interface{}{"allThreadsContinued": true})
+		s.resume(s.d.Continue)
+		return false
+	case "next", "stepIn", "stepOut":
+		s.halt()
+		s.c.respond(req, nil)
+		s.resume(s.stepper(req.Command))
+		return false
+	case "pause":
+		stop, wasRunning := s.halt()
+		s.c.respond(req, nil)
+		if wasRunning {
+			s.stopped(stop)
+		}
+		return false
+	}
+	// Everything else needs the program stopped for a moment.
+	stop, wasRunning := s.halt()
+	body, err := s.query(req)
+	if err != nil {
+		s.c.fail(req, err)
+	} else {
+		s.c.respond(req, body)
+	}
+	switch {
+	case !wasRunning:
+	case stop.Reason == debugger.INTERRUPTED:
+		// The halt may have come just as the program reached a
+		// breakpoint, or the query moved PC onto one, and picking the
+		// run up again would step past it.
+		if bp, ok := s.d.AtBreakpoint(); ok {
+			s.stopped(bp)
+		} else {
+			s.resume(s.run)
+		}
+	default:
+		// It stopped by itself before the halt.
+		s.stopped(stop)
+	}
+	return false
+}
+
+// stepper turns a step request into a run that can be resumed after a
+// query halts it. Next and stepOut fix their target depth up front so a
+// resumed step still stops where the first one would have.
+func (s *server) stepper(command string) func() debugger.Stop {
+	depth := s.d.Depth()
+	switch {
+	case command == "next" && s.d.AtCall():
+		return func() debugger.Stop { return s.d.Finish(depth) }
+	case command == "stepOut" && depth > 0:
+		return func() debugger.Stop { return s.d.Finish(depth - 1) }
+	}
+	return s.d.Step
+}
+
+func (s *server) query(req *request) (interface{}, error) {
+	switch req.Command {
+	case "setBreakpoints":
+		return s.setBreakpoints(req.Arguments)
+	case "setFunctionBreakpoints":
+		return s.setFunctionBreakpoints(req.Arguments)
+	case "setExceptionBreakpoints":
+		return map[string]interface{}{"breakpoints": []breakpoint{}}, nil
+	case "stackTrace":
+		return s.stackTrace(), nil
+	case "scopes":
+		return map[string]interface{}{"scopes": []scope{
+			{Name: "Registers", VariablesReference: registersRef},
+			{Name: "Labels", VariablesReference: labelsRef},
+		}}, nil
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		return map[string]interface{}{"variables": s.variables(args.VariablesReference)}, nil
+	case "setVariable":
+		return s.setVariable(req.Arguments)
+	case "evaluate":
+		return s.evaluate(req.Arguments)
+	}
+	return nil, errUnsupported
+}
+
+func (s *server) launch(raw json.RawMessage) error {
+	var args launchArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return err
+	}
+	cart, err := cartridge.Load(args.Program)
+	if err != nil {
+		return err
+	}
+	var opts gameboy.Options
+	if args.Model != "" {
+		if opts.Model, err = gameboy.LookupModel(args.Model); err != nil {
+			return err
+		}
+	}
+	if args.BootROM != "" {
+		if opts.BootROM, err = os.ReadFile(args.BootROM); err != nil {
+			return err
+		}
+	}
+	gb, err := gameboy.New(cart, opts)
+	if err != nil {
+		return err
+	}
+	if args.Symbols == "" {
+		args.Symbols = strings.TrimSuffix(args.Program, filepath.Ext(args.Program)) + ".sym"
+	}
+	if s.syms, err = symbols.Load(args.Symbols); err != nil {
+		return err
+	}
+	if len(args.Sources) == 0 {
+		args.Sources = []string{filepath.Dir(args.Program)}
+	}
+	if err := s.src.load(args.Sources); err != nil {
+		return err
+	}
+	s.d = debugger.New(gb)
+	s.d.Symbols = s.syms
+	s.stopOnEntry = args.StopOnEntry
+	return nil
+}
+
+// bankFor gives a breakpoint on a label the label's bank if it's in
+// switchable ROM, and any bank otherwise.
+func bankFor(sym symbols.Symbol) int {
+	if sym.Addr >= 0x4000 && sym.Addr < 0x8000 {
+		return sym.Bank
+	}
+	return debugger.ANY_BANK
+}
+
+func (s *server) setBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source      source `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	path, _ := filepath.Abs(args.Source.Path)
+	for _, id := range s.lineBreaks[path] {
+		s.d.Delete(id)
+	}
+	s.lineBreaks[path] = nil
+	result := []breakpoint{}
+	for _, b := range args.Breakpoints {
+		sym, line, err := s.src.address(path, b.Line, s.syms)
+		if err != nil {
+			result = append(result, breakpoint{Verified: false, Message: err.Error(), Line: b.Line})
+			continue
+		}
+		bp := s.d.AddBreakpoint(bankFor(sym), sym.Addr)
+		s.lineBreaks[path] = append(s.lineBreaks[path], bp.ID)
+		result = append(result, breakpoint{ID: bp.ID, Verified: true, Line: line, Source: &source{Name: filepath.Base(path), Path: path}})
+	}
+	return map[string]interface{}{"breakpoints": result}, nil
+}
+
+func (s *server) setFunctionBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Breakpoints []struct {
+			Name string `json:"name"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	for _, id := range s.funcBreaks {
+		s.d.Delete(id)
+	}
+	s.funcBreaks = nil
+	result := []breakpoint{}
+	for _, b := range args.Breakpoints {
+		sym, ok := s.syms.Lookup(b.Name)
+		if !ok {
+			result = append(result, breakpoint{Message: "unknown label " + b.Name})
+			continue
+		}
+		bp := s.d.AddBreakpoint(bankFor(sym), sym.Addr)
+		s.funcBreaks = append(s.funcBreaks, bp.ID)
+		result = append(result, breakpoint{ID: bp.ID, Verified: true})
+	}
+	return map[string]interface{}{"breakpoints": result}, nil
+}
+
+// name labels an address as label+offset, falling back to bb:aaaa.
+func (s *server) name(addr uint16) string {
+	if label := s.d.Label(addr); label != "" {
+		return label
+	}
+	return fmt.Sprintf("%02X:%04X", s.d.Bank(addr), addr)
+}
+
+func (s *server) stackTrace() interface{} {
+	pcs := []uint16{s.d.GB.CPU.PC}
+	for _, f := range s.d.Backtrace() {
+		pcs = append(pcs, f.Call)
+	}
+	frames := []stackFrame{}
+	for i, pc := range pcs {
+		bank := s.d.Bank(pc)
+		frame := stackFrame{
+			ID:                          i,
+			Name:                        s.name(pc),
+			InstructionPointerReference: fmt.Sprintf("%02X:%04X", bank, pc),
+		}
+		if path, line, ok := s.src.location(bank, pc, s.syms); ok {
+			frame.Source = &source{Name: filepath.Base(path), Path: path}
+			frame.Line = line
+			frame.Column = 1
+		}
+		frames = append(frames, frame)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+var registerNames = []string{"A", "F", "B", "C", "D", "E", "H", "L", "SP", "PC", "IME", "IE", "IF"}
+
+func (s *server) variables(ref int) []variable {
+	vars := []variable{}
+	switch ref {
+	case registersRef:
+		for _, name := range registerNames {
+			val, _ := s.d.Register(name)
+			vars = append(vars, variable{Name: name, Value: formatRegister(name, val)})
+		}
+		flags := []byte("----")
+		f, _ := s.d.Register("F")
+		for i := uint(0); i < 4; i++ {
+			if f&(0x80>>i) != 0 {
+				flags[i] = "ZNHC"[i]
+			}
+		}
+		vars = append(vars, variable{Name: "flags", Value: string(flags)})
+	case labelsRef:
+		for _, sym := range s.syms.Symbols() {
+			if !isRAMLabel(sym) {
+				continue
+			}
+			if !s.mapped(sym) {
+				vars = append(vars, variable{Name: sym.Name, Value: fmt.Sprintf("bank %02X not mapped", sym.Bank)})
+				continue
+			}
+			val := s.d.ReadMemory(sym.Addr, 1)[0]
+			vars = append(vars, variable{
+				Name:            sym.Name,
+				Value:           fmt.Sprintf("$%02X (%d)", val, val),
+				MemoryReference: fmt.Sprintf("0x%04X", sym.Addr),
+			})
+		}
+	}
+	return vars
+}
+
+// isRAMLabel picks out labels worth watching: those in RAM rather than
+// ROM or the IO registers.
+func isRAMLabel(sym symbols.Symbol) bool {
+	return sym.Addr >= 0x8000 && (sym.Addr < 0xFEA0 || sym.Addr >= 0xFF80)
+}
+
+// mapped says whether a RAM label's bank is the one mapped at its address,
+// so reading the address reads the label. A work RAM label in bank 0, as a
+// symbol file without banks gives, goes with whichever bank is mapped.
+func (s *server) mapped(sym symbols.Symbol) bool {
+	if sym.Addr >= 0xD000 && sym.Addr < 0xE000 && sym.Bank == 0 {
+		return true
+	}
+	return sym.Bank == s.d.Bank(sym.Addr)
+}
+
+func errNotMapped(sym symbols.Symbol) error {
+	return fmt.Errorf("%s is in bank %02X, which isn't mapped", sym.Name, sym.Bank)
+}
+
+func formatRegister(name string, val uint16) string {
+	switch name {
+	case "SP", "PC":
+		return fmt.Sprintf("$%04X", val)
+	case "IME":
+		return strconv.Itoa(int(val))
+	}
+	return fmt.Sprintf("$%02X", val)
+}
+
+// parseValue reads $hex, 0xhex, %binary or decimal.
+func parseValue(s string) (uint16, error) {
+	s = strings.TrimSpace(s)
+	base := 10
+	switch {
+	case strings.HasPrefix(s, "$"):
+		base, s = 16, s[1:]
+	case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0X"):
+		base, s = 16, s[2:]
+	case strings.HasPrefix(s, "%"):
+		base, s = 2, s[1:]
+	}
+	val, err := strconv.ParseUint(s, base, 16)
+	return uint16(val), err
+}
+
+func (s *server) setVariable(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int    `json:"variablesReference"`
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	val, err := parseValue(args.Value)
+	if err != nil {
+		return nil, err
+	}
+	switch args.VariablesReference {
+	case registersRef:
+		if err := s.d.SetRegister(args.Name, val); err != nil {
+			return nil, err
+		}
+		val, _ = s.d.Register(args.Name)
+		return map[string]interface{}{"value": formatRegister(args.Name, val)}, nil
+	case labelsRef:
+		sym, ok := s.syms.Lookup(args.Name)
+		if !ok || !isRAMLabel(sym) {
+			return nil, fmt.Errorf("unknown label %s", args.Name)
+		}
+		if !s.mapped(sym) {
+			return nil, errNotMapped(sym)
+		}
+		s.d.WriteMemory(sym.Addr, []byte{byte(val)})
+		return map[string]interface{}{"value": fmt.Sprintf("$%02X (%d)", byte(val), byte(val))}, nil
+	}
+	return nil, errUnsupported
+}
+
+// evaluate understands register names and labels. A RAM label gives the
+// byte stored there, if its bank is mapped, and any other label its
+// address.
+func (s *server) evaluate(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	expr := strings.TrimSpace(args.Expression)
+	if val, err := s.d.Register(expr); err == nil {
+		return map[string]interface{}{"result": formatRegister(strings.ToUpper(expr), val), "variablesReference": 0}, nil
+	}
+	sym, ok := s.syms.Lookup(expr)
+	if !ok {
+		return nil, fmt.Errorf("unknown register or label %s", expr)
+	}
+	if isRAMLabel(sym) {
+		if !s.mapped(sym) {
+			return nil, errNotMapped(sym)
+		}
+		val := s.d.ReadMemory(sym.Addr, 1)[0]
+		return map[string]interface{}{"result": fmt.Sprintf("$%02X (%d)", val, val), "variablesReference": 0}, nil
+	}
+	return map[string]interface{}{"result": sym.String(), "variablesReference": 0}, nil
+}
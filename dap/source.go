@@ -0,0 +1,188 @@
+package dap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zbyrne/golangboy/asm"
+	"github.com/zbyrne/golangboy/symbols"
+)
+
+// maxLookahead is how far a breakpoint on a line without code moves down
+// looking for some.
+const maxLookahead = 20
+
+var sourceExts = map[string]bool{".asm": true, ".s": true, ".inc": true, ".z80": true, ".sm83": true}
+
+// labelDef is where a label is defined, with lines numbered from 1.
+type labelDef struct {
+	name string
+	line int
+}
+
+// sourceMap ties labels in .sym files back to the lines that define them.
+// RGBDS doesn't output line numbers, so the address of a line is found by
+// assembling the lines between it and the label above.
+type sourceMap struct {
+	files  map[string][]string
+	defs   map[string][]labelDef
+	labels map[string]string
+}
+
+func newSourceMap() *sourceMap {
+	return &sourceMap{files: map[string][]string{}, defs: map[string][]labelDef{}, labels: map[string]string{}}
+}
+
+// load reads source files, searching directories recursively.
+func (m *sourceMap) load(paths []string) error {
+	for _, p := range paths {
+		err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !sourceExts[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			return m.addFile(path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *sourceMap) addFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	m.files[abs] = lines
+	scope := ""
+	for i, line := range lines {
+		name, ok := labelOn(line)
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(name, ".") {
+			name = scope + name
+		} else if !strings.Contains(name, ".") {
+			scope = name
+		}
+		m.defs[abs] = append(m.defs[abs], labelDef{name, i + 1})
+		m.labels[name] = abs
+	}
+	return nil
+}
+
+// labelOn returns the label defined at the start of a line, if any.
+func labelOn(line string) (string, bool) {
+	if line == "" || line[0] == ' ' || line[0] == '\t' || line[0] == ';' {
+		return "", false
+	}
+	field := strings.Fields(line)[0]
+	if i := strings.Index(field, ":"); i > 0 {
+		return field[:i], true
+	}
+	if strings.HasPrefix(field, ".") && len(field) > 1 {
+		return field, true
+	}
+	return "", false
+}
+
+// sizes assembles lines from start, numbered from 1, up to n lines on.
+// Addresses can't be carried across a section change.
+func sizes(lines []string, start, n int) ([]int, error) {
+	end := start - 1 + n
+	if end > len(lines) {
+		end = len(lines)
+	}
+	fragment := lines[start-1 : end]
+	for i, line := range fragment {
+		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(line)), "SECTION") {
+			fragment = fragment[:i]
+			break
+		}
+	}
+	return asm.LineSizes(fragment)
+}
+
+// address finds the address of the code on a line. If the line has no
+// code it moves down to the next one that does and returns that line.
+func (m *sourceMap) address(path string, line int, syms *symbols.Table) (symbols.Symbol, int, error) {
+	abs, _ := filepath.Abs(path)
+	lines, ok := m.files[abs]
+	if !ok {
+		return symbols.Symbol{}, 0, fmt.Errorf("%s isn't one of the loaded sources", filepath.Base(path))
+	}
+	defs := m.defs[abs]
+	i := sort.Search(len(defs), func(i int) bool {
+		return defs[i].line > line
+	})
+	for i--; i >= 0; i-- {
+		label, ok := syms.Lookup(defs[i].name)
+		if !ok {
+			continue
+		}
+		start := defs[i].line
+		sz, err := sizes(lines, start, line-start+maxLookahead+1)
+		if err != nil {
+			return symbols.Symbol{}, 0, err
+		}
+		addr := label.Addr
+		for l := start; l-start < len(sz); l++ {
+			if l >= line && sz[l-start] > 0 {
+				return symbols.Symbol{Name: label.Name, Bank: label.Bank, Addr: addr}, l, nil
+			}
+			addr += uint16(sz[l-start])
+		}
+		return symbols.Symbol{}, 0, fmt.Errorf("no code near line %d", line)
+	}
+	return symbols.Symbol{}, 0, fmt.Errorf("no label above line %d", line)
+}
+
+// location finds the line holding the instruction at bank:addr.
+func (m *sourceMap) location(bank int, addr uint16, syms *symbols.Table) (string, int, bool) {
+	label, offset, ok := syms.Nearest(bank, addr)
+	if !ok {
+		return "", 0, false
+	}
+	path, ok := m.labels[label.Name]
+	if !ok {
+		return "", 0, false
+	}
+	// The instruction lies between this label and the next one.
+	lines := m.files[path]
+	start, end := 0, len(lines)+1
+	for _, def := range m.defs[path] {
+		if def.name == label.Name {
+			start = def.line
+		} else if start != 0 && def.line > start {
+			end = def.line
+			break
+		}
+	}
+	sz, err := sizes(lines, start, end-start)
+	if err != nil {
+		return "", 0, false
+	}
+	var pos uint16
+	for i, n := range sz {
+		if pos == offset && n > 0 {
+			return path, start + i, true
+		}
+		pos += uint16(n)
+		if pos > offset {
+			break
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,549 @@
+package debugger
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"github.com/zbyrne/golangboy/disasm"
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/symbols"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+const (
+	WATCH_READ = 1 << iota
+	WATCH_WRITE
+)
+
+// ANY_BANK makes a breakpoint match whichever bank is mapped.
+const ANY_BANK = -1
+
+// stepBudget bounds how long Step waits for a halted CPU to wake, about
+// a second of emulated time.
+const stepBudget = 1 << 22
+
+var (
+	ErrRegister  = errors.New("debugger: unknown register")
+	ErrNotFound  = errors.New("debugger: no such breakpoint or watchpoint")
+	ErrNoSymbols = errors.New("debugger: no symbols loaded")
+)
+
+type StopReason int
+
+const (
+	STEP StopReason = iota
+	BREAKPOINT
+	WATCHPOINT
+	INTERRUPTED
+	LOCKED
+)
+
+type Breakpoint struct {
+	ID   int
+	Bank int
+	Addr uint16
+}
+
+// Watchpoint watches Len bytes from Addr.
+type Watchpoint struct {
+	ID   int
+	Addr uint16
+	Len  int
+	Kind int
+}
+
+// Stop describes why execution stopped. For breakpoints and watchpoints
+// ID says which one. For watchpoints Addr, Value and Write describe the
+// access, and PC is the instruction that made it.
+type Stop struct {
+	Reason StopReason
+	ID     int
+	PC     uint16
+	Addr   uint16
+	Value  byte
+	Write  bool
+}
+
+// Frame is an entry on the shadow call stack. Call is the address of the
+// CALL or RST, or of the instruction an interrupt preempted. SP is where
+// the return address was pushed.
+type Frame struct {
+	Call      uint16
+	Target    uint16
+	Return    uint16
+	SP        uint16
+	Interrupt bool
+}
+
+// Debugger drives a GameBoy one instruction at a time. While attached it
+// sits between the CPU and the MMU to catch watched accesses, so the
+// emulator itself carries no debugging code.
+type Debugger struct {
+	GB *gameboy.GameBoy
+	// Symbols names addresses in disassembly, and can be nil.
+	Symbols *symbols.Table
+
+	breakpoints []Breakpoint
+	watchpoints []Watchpoint
+	nextID      int
+	frames      []Frame
+	pc          uint16
+	fetch       int
+	hit         *Stop
+	interrupted int32
+}
+
+func New(gb *gameboy.GameBoy) *Debugger {
+	d := &Debugger{GB: gb, nextID: 1}
+	gb.CPU.SetMemory(watchMemory{d})
+	return d
+}
+
+// Detach hands the CPU back its memory.
+func (d *Debugger) Detach() {
+	d.GB.CPU.SetMemory(d.GB.MMU)
+}
+
+// Interrupt stops Continue, Next or StepOut. It's safe to call from
+// another goroutine.
+func (d *Debugger) Interrupt() {
+	atomic.StoreInt32(&d.interrupted, 1)
+}
+
+// Bank returns the bank mapped at addr, as the GameBoy's Bank does.
+func (d *Debugger) Bank(addr uint16) int {
+	return d.GB.Bank(addr)
+}
+
+// Label names addr as label or label+offset, using the banks mapped now.
+// It returns "" if there's no label for addr.
+func (d *Debugger) Label(addr uint16) string {
+	if d.Symbols == nil {
+		return ""
+	}
+	return d.Symbols.Label(d.Bank(addr), addr)
+}
+
+// Lookup finds the bank and address of a label, which may have a decimal
+// offset as in Main.loop+3.
+func (d *Debugger) Lookup(expr string) (symbols.Symbol, error) {
+	if d.Symbols == nil {
+		return symbols.Symbol{}, ErrNoSymbols
+	}
+	return d.Symbols.Resolve(expr)
+}
+
+func (d *Debugger) AddBreakpoint(bank int, addr uint16) Breakpoint {
+	bp := Breakpoint{ID: d.nextID, Bank: bank, Addr: addr}
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, bp)
+	return bp
+}
+
+func (d *Debugger) AddWatchpoint(addr uint16, kind int) Watchpoint {
+	return d.AddWatchRange(addr, 1, kind)
+}
+
+// AddWatchRange watches n bytes from addr with one watchpoint, wrapping
+// around the top of memory.
+func (d *Debugger) AddWatchRange(addr uint16, n int, kind int) Watchpoint {
+	wp := Watchpoint{ID: d.nextID, Addr: addr, Len: n, Kind: kind}
+	d.nextID++
+	d.watchpoints = append(d.watchpoints, wp)
+	return wp
+}
+
+// Delete removes the breakpoint or watchpoint with the given ID.
+func (d *Debugger) Delete(id int) error {
+	for i, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return nil
+		}
+	}
+	for i, wp := range d.watchpoints {
+		if wp.ID == id {
+			d.watchpoints = append(d.watchpoints[:i], d.watchpoints[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (d *Debugger) Breakpoints() []Breakpoint {
+	return append([]Breakpoint(nil), d.breakpoints...)
+}
+
+func (d *Debugger) Watchpoints() []Watchpoint {
+	return append([]Watchpoint(nil), d.watchpoints...)
+}
+
+// Backtrace returns the shadow call stack, innermost frame first.
+func (d *Debugger) Backtrace() []Frame {
+	frames := make([]Frame, len(d.frames))
+	for i, f := range d.frames {
+		frames[len(frames)-1-i] = f
+	}
+	return frames
+}
+
+// AtBreakpoint returns the stop for a breakpoint on the instruction about
+// to run. A run steps off the current instruction first, so a client
+// picking a run up again after interrupting it checks here before it
+// does.
+func (d *Debugger) AtBreakpoint() (Stop, bool) {
+	cpu := d.GB.CPU
+	if cpu.Halted() {
+		return Stop{}, false
+	}
+	bp, ok := d.breakpointAt(cpu.PC)
+	if !ok {
+		return Stop{}, false
+	}
+	return Stop{Reason: BREAKPOINT, ID: bp.ID, PC: cpu.PC}, true
+}
+
+func (d *Debugger) breakpointAt(pc uint16) (Breakpoint, bool) {
+	for _, bp := range d.breakpoints {
+		if bp.Addr == pc && (bp.Bank == ANY_BANK || bp.Bank == d.Bank(pc)) {
+			return bp, true
+		}
+	}
+	return Breakpoint{}, false
+}
+
+func (d *Debugger) access(addr uint16, val byte, write bool) {
+	if d.hit != nil {
+		return
+	}
+	// Fetching the instruction being run isn't reading it as data.
+	if !write && int(addr-d.pc) < d.fetch {
+		return
+	}
+	kind := WATCH_READ
+	if write {
+		kind = WATCH_WRITE
+	}
+	for _, wp := range d.watchpoints {
+		if int(addr-wp.Addr) < wp.Len && wp.Kind&kind != 0 {
+			d.hit = &Stop{Reason: WATCHPOINT, ID: wp.ID, PC: d.pc, Addr: addr, Value: val, Write: write}
+			return
+		}
+	}
+}
+
+// step runs one instruction and keeps the shadow call stack up to date.
+func (d *Debugger) step() {
+	cpu := d.GB.CPU
+	pc, sp := cpu.PC, cpu.SP
+	d.pc = pc
+	op := d.GB.MMU.ReadByte(pc)
+	d.fetch = z80.Opcodes[op].Length
+	if op == 0xCB {
+		d.fetch = z80.CBOpcodes[d.GB.MMU.ReadByte(pc+1)].Length
+	}
+	frame := d.GB.PPU.Frames()
+	d.GB.StepInstruction()
+	if d.GB.PPU.Frames() != frame {
+		d.GB.Joypad.Latch()
+	}
+	// Anything that moves SP above a frame's return address unwinds it,
+	// whether that's a RET or the code dropping it off the stack.
+	for len(d.frames) > 0 && d.frames[len(d.frames)-1].SP < cpu.SP {
+		d.frames = d.frames[:len(d.frames)-1]
+	}
+	if cpu.SP != sp-2 {
+		return
+	}
+	ret := d.GB.MMU.ReadWord(cpu.SP)
+	switch {
+	case ret == pc && cpu.PC >= 0x40 && cpu.PC <= 0x60 && cpu.PC&7 == 0:
+		d.frames = append(d.frames, Frame{Call: pc, Target: cpu.PC, Return: ret, SP: cpu.SP, Interrupt: true})
+	case isCall(op) && ret == pc+uint16(z80.Opcodes[op].Length):
+		d.frames = append(d.frames, Frame{Call: pc, Target: cpu.PC, Return: ret, SP: cpu.SP})
+	}
+}
+
+func isCall(op byte) bool {
+	return strings.HasPrefix(z80.Opcodes[op].Mnemonic, "CALL") ||
+		strings.HasPrefix(z80.Opcodes[op].Mnemonic, "RST")
+}
+
+func (d *Debugger) stopped(reason StopReason) Stop {
+	if d.hit != nil {
+		return *d.hit
+	}
+	return Stop{Reason: reason, PC: d.GB.CPU.PC}
+}
+
+// Step runs one instruction. A halted CPU is run until it wakes up.
+func (d *Debugger) Step() Stop {
+	d.hit = nil
+	d.step()
+	start := d.GB.Cycles()
+	for d.GB.CPU.Halted() && d.hit == nil && d.GB.Cycles()-start < stepBudget {
+		d.step()
+	}
+	return d.stopped(STEP)
+}
+
+// Continue runs until a breakpoint or watchpoint is hit, the CPU locks up
+// or Interrupt is called.
+func (d *Debugger) Continue() Stop {
+	return d.run(func() bool {
+		return false
+	})
+}
+
+// Next steps over calls, stopping early if the callee hits a breakpoint.
+func (d *Debugger) Next() Stop {
+	if !d.AtCall() {
+		return d.Step()
+	}
+	return d.Finish(d.Depth())
+}
+
+// StepOut runs until the current routine returns.
+func (d *Debugger) StepOut() Stop {
+	depth := d.Depth()
+	if depth == 0 {
+		return d.Step()
+	}
+	return d.Finish(depth - 1)
+}
+
+// Depth is the number of calls on the stack.
+func (d *Debugger) Depth() int {
+	return len(d.frames)
+}
+
+// AtCall reports whether the next instruction is a call or rst.
+func (d *Debugger) AtCall() bool {
+	return isCall(d.GB.MMU.ReadByte(d.GB.CPU.PC))
+}
+
+// Finish runs until the call stack is at most depth deep. Next and
+// StepOut use it, and a client can use it to pick up a step that was
+// interrupted part way.
+func (d *Debugger) Finish(depth int) Stop {
+	return d.run(func() bool {
+		return len(d.frames) <= depth
+	})
+}
+
+// run steps off the current instruction and carries on until done says
+// so or something stops it.
+func (d *Debugger) run(done func() bool) Stop {
+	atomic.StoreInt32(&d.interrupted, 0)
+	d.hit = nil
+	d.step()
+	cpu := d.GB.CPU
+	for {
+		switch {
+		case d.hit != nil:
+			return *d.hit
+		case done():
+			return d.stopped(STEP)
+		case cpu.Locked():
+			return d.stopped(LOCKED)
+		case atomic.LoadInt32(&d.interrupted) != 0:
+			return d.stopped(INTERRUPTED)
+		}
+		if stop, ok := d.AtBreakpoint(); ok {
+			return stop
+		}
+		d.step()
+	}
+}
+
+// Register reads a CPU register by name: A-L, AF, BC, DE, HL, SP, PC,
+// IE, IF or IME.
+func (d *Debugger) Register(name string) (uint16, error) {
+	c := d.GB.CPU
+	pair := func(hi, lo byte) uint16 {
+		return uint16(hi)<<8 | uint16(lo)
+	}
+	switch strings.ToUpper(name) {
+	case "A":
+		return uint16(c.A), nil
+	case "F":
+		return uint16(c.F), nil
+	case "B":
+		return uint16(c.B), nil
+	case "C":
+		return uint16(c.C), nil
+	case "D":
+		return uint16(c.D), nil
+	case "E":
+		return uint16(c.E), nil
+	case "H":
+		return uint16(c.H), nil
+	case "L":
+		return uint16(c.L), nil
+	case "AF":
+		return pair(c.A, c.F), nil
+	case "BC":
+		return pair(c.B, c.C), nil
+	case "DE":
+		return pair(c.D, c.E), nil
+	case "HL":
+		return pair(c.H, c.L), nil
+	case "SP":
+		return c.SP, nil
+	case "PC":
+		return c.PC, nil
+	case "IE":
+		return uint16(c.IE), nil
+	case "IF":
+		return uint16(c.IF), nil
+	case "IME":
+		if c.IME {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, ErrRegister
+}
+
+func (d *Debugger) SetRegister(name string, val uint16) error {
+	c := d.GB.CPU
+	hi, lo := byte(val>>8), byte(val)
+	switch strings.ToUpper(name) {
+	case "A":
+		c.A = lo
+	case "F":
+		c.F = lo & 0xF0
+	case "B":
+		c.B = lo
+	case "C":
+		c.C = lo
+	case "D":
+		c.D = lo
+	case "E":
+		c.E = lo
+	case "H":
+		c.H = lo
+	case "L":
+		c.L = lo
+	case "AF":
+		c.A, c.F = hi, lo&0xF0
+	case "BC":
+		c.B, c.C = hi, lo
+	case "DE":
+		c.D, c.E = hi, lo
+	case "HL":
+		c.H, c.L = hi, lo
+	case "SP":
+		c.SP = val
+	case "PC":
+		c.PC = val
+	case "IE":
+		c.IE = lo
+	case "IF":
+		c.IF = lo & 0x1F
+	case "IME":
+		c.IME = val != 0
+	default:
+		return ErrRegister
+	}
+	return nil
+}
+
+// ReadMemory reads n bytes without tripping watchpoints.
+func (d *Debugger) ReadMemory(addr uint16, n int) []byte {
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = d.GB.MMU.ReadByte(addr + uint16(i))
+	}
+	return buf
+}
+
+// WriteMemory writes through the MMU, so writes to ROM go to the MBC
+// rather than patching the image.
+func (d *Debugger) WriteMemory(addr uint16, data []byte) {
+	for i, b := range data {
+		d.GB.MMU.WriteByte(addr+uint16(i), b)
+	}
+}
+
+func (d *Debugger) Disassemble(addr uint16, n int) []disasm.Instruction {
+	var out []disasm.Instruction
+	for i := 0; i < n; i++ {
+		in := disasm.Decode(d.GB.MMU, addr)
+		out = append(out, in)
+		addr += uint16(in.Len())
+	}
+	return d.annotate(out)
+}
+
+// annotate labels instructions if there are symbols.
+func (d *Debugger) annotate(instrs []disasm.Instruction) []disasm.Instruction {
+	if d.Symbols == nil {
+		return instrs
+	}
+	labels := disasm.Labels(d.Symbols, d.Bank)
+	for i := range instrs {
+		instrs[i].Annotate(labels)
+	}
+	return instrs
+}
+
+// DisassembleAround lists instructions either side of addr. Code can't be
+// decoded backwards, so it looks for a starting point a little earlier
+// that decodes into a run of instructions ending exactly at addr.
+func (d *Debugger) DisassembleAround(addr uint16, before, after int) []disasm.Instruction {
+	var best []disasm.Instruction
+	for back := 3 * before; back > 0; back-- {
+		if back > int(addr) {
+			continue
+		}
+		instrs := disasm.Range(d.GB.MMU, addr-uint16(back), addr)
+		last := instrs[len(instrs)-1]
+		if last.Addr+uint16(last.Len()) != addr {
+			continue
+		}
+		if len(instrs) >= before {
+			best = instrs[len(instrs)-before:]
+			break
+		}
+		if len(instrs) > len(best) {
+			best = instrs
+		}
+	}
+	return append(d.annotate(best), d.Disassemble(addr, after)...)
+}
+
+// watchMemory is what the CPU sees while the debugger is attached.
+type watchMemory struct {
+	d *Debugger
+}
+
+func (m watchMemory) ReadByte(addr uint16) byte {
+	val := m.d.GB.MMU.ReadByte(addr)
+	if len(m.d.watchpoints) != 0 {
+		m.d.access(addr, val, false)
+	}
+	return val
+}
+
+// PeekByte reads without tripping watchpoints, for the CPU's tracer.
+func (m watchMemory) PeekByte(addr uint16) byte {
+	return m.d.GB.MMU.ReadByte(addr)
+}
+
+func (m watchMemory) WriteByte(addr uint16, val byte) {
+	if len(m.d.watchpoints) != 0 {
+		m.d.access(addr, val, true)
+	}
+	m.d.GB.MMU.WriteByte(addr, val)
+}
+
+func (m watchMemory) ReadWord(addr uint16) uint16 {
+	return uint16(m.ReadByte(addr)) | uint16(m.ReadByte(addr+1))<<8
+}
+
+func (m watchMemory) WriteWord(addr uint16, val uint16) {
+	m.WriteByte(addr, byte(val))
+	m.WriteByte(addr+1, byte(val>>8))
+}
@@ -0,0 +1,252 @@
+package debugger
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/zbyrne/golangboy/asm"
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/gameboy"
+)
+
+// newDebugger assembles src into a 64KB MBC1 ROM and attaches to it.
+func newDebugger(t *testing.T, src string) *Debugger {
+	rom, err := asm.ROM(src + `
+SECTION "header", ROM0[$147]
+	db $01, $01
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, err := cartridge.New(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gb, err := gameboy.New(cart, gameboy.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(gb)
+}
+
+const program = `
+SECTION "vblank", ROM0[$40]
+	reti
+SECTION "main", ROM0[$100]
+	ld a, 2
+	ld [$2000], a
+	call Sub
+	ld hl, $C000
+	ld [hl], $55
+	ld a, [hl]
+Spin:
+	jr Spin
+Sub:
+	call $4000
+	ret
+SECTION "banked", ROMX[$4000], BANK[2]
+	nop
+	ret
+`
+
+func TestStep(t *testing.T) {
+	d := newDebugger(t, program)
+	stop := d.Step()
+	if stop.Reason != STEP || stop.PC != 0x102 || d.GB.CPU.A != 2 {
+		t.Errorf("Stopped %v with A = 0x%02X", stop, d.GB.CPU.A)
+	}
+}
+
+func TestBreakpoint(t *testing.T) {
+	d := newDebugger(t, program)
+	wrongBank := d.AddBreakpoint(3, 0x4000)
+	bp := d.AddBreakpoint(2, 0x4000)
+	stop := d.Continue()
+	if stop.Reason != BREAKPOINT || stop.ID != bp.ID || stop.PC != 0x4000 {
+		t.Fatalf("Stopped %+v, not at breakpoint %d", stop, bp.ID)
+	}
+	frames := d.Backtrace()
+	if len(frames) != 2 || frames[0].Target != 0x4000 || frames[1].Call != 0x105 || frames[1].Return != 0x108 {
+		t.Errorf("Backtrace %+v", frames)
+	}
+	// Continue should step off the breakpoint rather than stop at it again.
+	if err := d.Delete(bp.ID); err != nil {
+		t.Fatal(err)
+	}
+	d.AddBreakpoint(ANY_BANK, 0x4000)
+	d.AddBreakpoint(ANY_BANK, 0x4001)
+	if stop := d.Continue(); stop.PC != 0x4001 {
+		t.Errorf("Stopped at 0x%04X, not 0x4001", stop.PC)
+	}
+	if err := d.Delete(wrongBank.ID); err != nil {
+		t.Error(err)
+	}
+	if err := d.Delete(wrongBank.ID); err != ErrNotFound {
+		t.Errorf("Deleting twice returned %v", err)
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	d := newDebugger(t, program)
+	write := d.AddWatchpoint(0xC000, WATCH_WRITE)
+	read := d.AddWatchpoint(0xC000, WATCH_READ)
+	stop := d.Continue()
+	if stop.Reason != WATCHPOINT || stop.ID != write.ID || !stop.Write || stop.Value != 0x55 || stop.PC != 0x10B {
+		t.Errorf("Stopped %+v, not at the write", stop)
+	}
+	if d.GB.MMU.ReadByte(0xC000) != 0x55 {
+		t.Error("The write didn't complete")
+	}
+	stop = d.Continue()
+	if stop.Reason != WATCHPOINT || stop.ID != read.ID || stop.Write || stop.PC != 0x10D {
+		t.Errorf("Stopped %+v, not at the read", stop)
+	}
+	// The debugger's own reads don't count.
+	d.ReadMemory(0xC000, 1)
+	if stop := d.Step(); stop.Reason != STEP {
+		t.Errorf("Stepping stopped for %+v", stop)
+	}
+}
+
+func TestWatchSkipsFetches(t *testing.T) {
+	d := newDebugger(t, program)
+	// The opcode and operands of ld [$2000], a, then the read of $C000.
+	d.AddWatchRange(0x102, 3, WATCH_READ)
+	read := d.AddWatchpoint(0xC000, WATCH_READ)
+	if stop := d.Continue(); stop.Reason != WATCHPOINT || stop.ID != read.ID {
+		t.Errorf("Stopped %+v, not at the read of $C000", stop)
+	}
+}
+
+func TestWatchRange(t *testing.T) {
+	d := newDebugger(t, program)
+	short := d.AddWatchRange(0xBFF0, 0x10, WATCH_WRITE)
+	long := d.AddWatchRange(0xBFF0, 0x11, WATCH_WRITE)
+	stop := d.Continue()
+	if stop.Reason != WATCHPOINT || stop.ID != long.ID || stop.Addr != 0xC000 {
+		t.Errorf("Stopped %+v, not at the write to the end of the range", stop)
+	}
+	if wps := d.Watchpoints(); len(wps) != 2 || wps[0] != short {
+		t.Errorf("Watchpoints are %+v", wps)
+	}
+}
+
+func TestTraceSkipsWatchpoints(t *testing.T) {
+	d := newDebugger(t, program)
+	// Traced with the first instruction, but not read by it.
+	d.AddWatchpoint(0x103, WATCH_READ)
+	var trace bytes.Buffer
+	d.GB.Trace(&trace, nil)
+	if stop := d.Step(); stop.Reason != STEP || trace.Len() == 0 {
+		t.Errorf("Tracing stopped for %+v", stop)
+	}
+}
+
+func TestNextAndStepOut(t *testing.T) {
+	d := newDebugger(t, program)
+	d.Step()
+	d.Step()
+	if stop := d.Next(); stop.Reason != STEP || stop.PC != 0x108 {
+		t.Errorf("Next stopped %+v, not after the call", stop)
+	}
+	if len(d.Backtrace()) != 0 {
+		t.Errorf("Backtrace %+v after returning", d.Backtrace())
+	}
+	d = newDebugger(t, program)
+	d.AddBreakpoint(ANY_BANK, 0x4000)
+	d.Continue()
+	if stop := d.StepOut(); stop.PC != 0x113 || len(d.Backtrace()) != 1 {
+		t.Errorf("Stepped out to 0x%04X with %d frames", stop.PC, len(d.Backtrace()))
+	}
+}
+
+func TestInterruptFrame(t *testing.T) {
+	d := newDebugger(t, `
+SECTION "vblank", ROM0[$40]
+	nop
+	reti
+SECTION "main", ROM0[$100]
+	ld a, 1
+	ldh [$FF], a
+	ei
+Spin:
+	jr Spin
+`)
+	d.AddBreakpoint(ANY_BANK, 0x41)
+	d.Continue()
+	frames := d.Backtrace()
+	if len(frames) != 1 || !frames[0].Interrupt || frames[0].Target != 0x40 {
+		t.Errorf("Backtrace %+v", frames)
+	}
+	d.Step()
+	if len(d.Backtrace()) != 0 {
+		t.Errorf("Backtrace %+v after RETI", d.Backtrace())
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	d := newDebugger(t, program)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		d.Interrupt()
+	}()
+	if stop := d.Continue(); stop.Reason != INTERRUPTED {
+		t.Errorf("Stopped %+v", stop)
+	}
+}
+
+func TestLocked(t *testing.T) {
+	d := newDebugger(t, `
+SECTION "main", ROM0[$100]
+	nop
+	db $D3
+`)
+	if stop := d.Continue(); stop.Reason != LOCKED || stop.PC != 0x101 {
+		t.Errorf("Stopped %+v", stop)
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	d := newDebugger(t, program)
+	if err := d.SetRegister("hl", 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetRegister("AF", 0xABCD); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]uint16{"H": 0x12, "L": 0x34, "A": 0xAB, "F": 0xC0, "pc": 0x100} {
+		if val, err := d.Register(name); err != nil || val != want {
+			t.Errorf("%s = 0x%04X, %v, not 0x%04X", name, val, err, want)
+		}
+	}
+	if _, err := d.Register("Q"); err != ErrRegister {
+		t.Errorf("Reading Q returned %v", err)
+	}
+	d.WriteMemory(0xC010, []byte{1, 2, 3})
+	if mem := d.ReadMemory(0xC010, 3); mem[2] != 3 {
+		t.Errorf("Read back %X", mem)
+	}
+}
+
+func TestDisassembleAround(t *testing.T) {
+	d := newDebugger(t, program)
+	instrs := d.DisassembleAround(0x108, 2, 2)
+	var addrs []uint16
+	for _, in := range instrs {
+		addrs = append(addrs, in.Addr)
+	}
+	if len(addrs) != 4 || addrs[0] != 0x102 || addrs[1] != 0x105 || addrs[2] != 0x108 || addrs[3] != 0x10B {
+		t.Errorf("Disassembled %04X", addrs)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	d := newDebugger(t, program)
+	d.AddWatchpoint(0xC000, WATCH_WRITE)
+	d.Detach()
+	d.GB.RunCycles(200)
+	if d.GB.MMU.ReadByte(0xC000) != 0x55 {
+		t.Error("Program didn't run after detaching")
+	}
+}
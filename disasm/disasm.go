@@ -0,0 +1,232 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zbyrne/golangboy/symbols"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+const BANK_SIZE = 0x4000
+
+const hexDigits = "0123456789ABCDEF"
+
+// Instruction is one decoded instruction. Operands are rendered in RGBDS
+// syntax, with relative jumps resolved to their target address.
+type Instruction struct {
+	Addr     uint16
+	Bytes    []byte
+	Mnemonic string
+	Operands []string
+	Cycles   z80.ClockTicks
+	// Branch is the cost of a taken conditional jump, call or return.
+	Branch z80.ClockTicks
+	// Label is the label at Addr, if Annotate found one.
+	Label string
+}
+
+// Labeler finds the closest label at or before addr and how far past it
+// addr is.
+type Labeler func(addr uint16) (name string, offset uint16, ok bool)
+
+// Labels looks addresses up in syms, using bank to tell which bank is
+// mapped at each one.
+func Labels(syms *symbols.Table, bank func(addr uint16) int) Labeler {
+	return func(addr uint16) (string, uint16, bool) {
+		s, offset, ok := syms.Nearest(bank(addr), addr)
+		return s.Name, offset, ok
+	}
+}
+
+// Annotate fills in Label and replaces addresses in the operands with
+// labels. Jump targets and memory operands may be named label+offset, but
+// 16 bit immediates could be plain numbers and are only named when a label
+// is at that exact address.
+func (i *Instruction) Annotate(l Labeler) {
+	if name, offset, ok := l(i.Addr); ok && offset == 0 {
+		i.Label = name
+	}
+	info := z80.Opcodes[i.Bytes[0]]
+	if info.Mnemonic == "" || i.Bytes[0] == 0xCB {
+		return
+	}
+	fields := strings.SplitN(info.Mnemonic, " ", 2)
+	if len(fields) == 1 {
+		return
+	}
+	for n, operand := range strings.Split(fields[1], ", ") {
+		var addr uint16
+		exact := false
+		switch {
+		case strings.Contains(operand, "a16"):
+			addr = uint16(i.Bytes[2])<<8 | uint16(i.Bytes[1])
+		case strings.Contains(operand, "n16"):
+			addr = uint16(i.Bytes[2])<<8 | uint16(i.Bytes[1])
+			exact = true
+		case strings.Contains(operand, "a8"):
+			addr = 0xFF00 | uint16(i.Bytes[1])
+		case operand == "e8" && i.Mnemonic == "JR":
+			addr = i.Addr + uint16(len(i.Bytes)) + uint16(int8(i.Bytes[1]))
+		default:
+			continue
+		}
+		name, offset, ok := l(addr)
+		if !ok || (exact && offset != 0) {
+			continue
+		}
+		if offset != 0 {
+			name = fmt.Sprintf("%s+%d", name, offset)
+		}
+		old := i.Operands[n]
+		if start := strings.Index(old, "$"); start >= 0 {
+			end := start + 1
+			for end < len(old) && strings.IndexByte(hexDigits, old[end]) >= 0 {
+				end++
+			}
+			i.Operands[n] = old[:start] + name + old[end:]
+		}
+	}
+}
+
+func (i Instruction) Len() int {
+	return len(i.Bytes)
+}
+
+func (i Instruction) String() string {
+	if len(i.Operands) == 0 {
+		return i.Mnemonic
+	}
+	return i.Mnemonic + " " + strings.Join(i.Operands, ", ")
+}
+
+// Decode disassembles the instruction at addr.
+func Decode(mem z80.Memory, addr uint16) Instruction {
+	op := mem.ReadByte(addr)
+	info := z80.Opcodes[op]
+	if op == 0xCB {
+		info = z80.CBOpcodes[mem.ReadByte(addr+1)]
+	}
+	in := Instruction{Addr: addr, Cycles: info.Cycles, Branch: info.Branch}
+	for i := 0; i < info.Length; i++ {
+		in.Bytes = append(in.Bytes, mem.ReadByte(addr+uint16(i)))
+	}
+	if info.Mnemonic == "" {
+		in.Mnemonic = "DB"
+		in.Operands = []string{fmt.Sprintf("$%02X", op)}
+		return in
+	}
+	fields := strings.SplitN(info.Mnemonic, " ", 2)
+	in.Mnemonic = fields[0]
+	if len(fields) == 1 {
+		return in
+	}
+	for _, operand := range strings.Split(fields[1], ", ") {
+		in.Operands = append(in.Operands, in.operand(operand))
+	}
+	return in
+}
+
+// operand fills in an immediate placeholder from the instruction bytes.
+func (i Instruction) operand(s string) string {
+	var n8 byte
+	var n16 uint16
+	if len(i.Bytes) > 1 {
+		n8 = i.Bytes[1]
+	}
+	if len(i.Bytes) > 2 {
+		n16 = uint16(i.Bytes[2])<<8 | uint16(i.Bytes[1])
+	}
+	switch {
+	case strings.Contains(s, "n16"):
+		return strings.Replace(s, "n16", fmt.Sprintf("$%04X", n16), 1)
+	case strings.Contains(s, "a16"):
+		return strings.Replace(s, "a16", fmt.Sprintf("$%04X", n16), 1)
+	case strings.Contains(s, "n8"):
+		return strings.Replace(s, "n8", fmt.Sprintf("$%02X", n8), 1)
+	case strings.Contains(s, "a8"):
+		return strings.Replace(s, "a8", fmt.Sprintf("$FF%02X", n8), 1)
+	case s == "e8" && i.Mnemonic == "JR":
+		target := i.Addr + uint16(len(i.Bytes)) + uint16(int8(n8))
+		return fmt.Sprintf("$%04X", target)
+	case s == "SP+e8":
+		if int8(n8) < 0 {
+			return fmt.Sprintf("SP%d", int8(n8))
+		}
+		return fmt.Sprintf("SP+%d", int8(n8))
+	case s == "e8":
+		return fmt.Sprintf("%d", int8(n8))
+	}
+	return s
+}
+
+// Range disassembles from start up to, but not including, end.
+func Range(mem z80.Memory, start, end uint16) []Instruction {
+	var out []Instruction
+	for addr := int(start); addr < int(end); {
+		in := Decode(mem, uint16(addr))
+		out = append(out, in)
+		addr += in.Len()
+	}
+	return out
+}
+
+// Bank disassembles one 16KB bank of a ROM image at the address it's
+// mapped to: 0x0000 for bank 0 and 0x4000 for the rest.
+func Bank(rom []byte, bank int) []Instruction {
+	mem := bankMemory{rom: rom, bank: bank}
+	if bank == 0 {
+		return Range(mem, 0, BANK_SIZE)
+	}
+	return Range(mem, BANK_SIZE, 2*BANK_SIZE)
+}
+
+// Fprint writes a listing with an address and the raw bytes on each line.
+// Labels found by Annotate get a line of their own.
+func Fprint(w io.Writer, instrs []Instruction) error {
+	for _, in := range instrs {
+		if in.Label != "" {
+			if _, err := fmt.Fprintf(w, "%s:\n", in.Label); err != nil {
+				return err
+			}
+		}
+		var raw []string
+		for _, b := range in.Bytes {
+			raw = append(raw, fmt.Sprintf("%02X", b))
+		}
+		_, err := fmt.Fprintf(w, "%04X: %-9s %s\n", in.Addr, strings.Join(raw, " "), in)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bankMemory reads a ROM bank as if it were mapped. Reads outside the
+// image return 0xFF like an open bus, and writes are dropped.
+type bankMemory struct {
+	rom  []byte
+	bank int
+}
+
+func (m bankMemory) ReadByte(addr uint16) byte {
+	offset := int(addr)
+	if addr >= BANK_SIZE && m.bank > 1 {
+		offset += (m.bank - 1) * BANK_SIZE
+	}
+	if offset >= len(m.rom) {
+		return 0xFF
+	}
+	return m.rom[offset]
+}
+
+func (m bankMemory) WriteByte(addr uint16, val byte) {
+}
+
+func (m bankMemory) ReadWord(addr uint16) uint16 {
+	return uint16(m.ReadByte(addr+1))<<8 | uint16(m.ReadByte(addr))
+}
+
+func (m bankMemory) WriteWord(addr uint16, val uint16) {
+}
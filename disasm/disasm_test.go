@@ -0,0 +1,179 @@
+package disasm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zbyrne/golangboy/symbols"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+type flatMemory [0x10000]byte
+
+func (m *flatMemory) ReadByte(addr uint16) byte {
+	return m[addr]
+}
+
+func (m *flatMemory) WriteByte(addr uint16, val byte) {
+	m[addr] = val
+}
+
+func (m *flatMemory) ReadWord(addr uint16) uint16 {
+	return uint16(m[addr+1])<<8 | uint16(m[addr])
+}
+
+func (m *flatMemory) WriteWord(addr uint16, val uint16) {
+	m[addr] = byte(val)
+	m[addr+1] = byte(val >> 8)
+}
+
+func TestDecode(t *testing.T) {
+	for _, c := range []struct {
+		code   []byte
+		text   string
+		cycles z80.ClockTicks
+		branch z80.ClockTicks
+	}{
+		{[]byte{0x00}, "NOP", 4, 0},
+		{[]byte{0x01, 0x34, 0x12}, "LD BC, $1234", 12, 0},
+		{[]byte{0x08, 0x00, 0xC0}, "LD [$C000], SP", 20, 0},
+		{[]byte{0x22}, "LD [HL+], A", 8, 0},
+		{[]byte{0x36, 0x7F}, "LD [HL], $7F", 12, 0},
+		{[]byte{0x18, 0xFE}, "JR $0100", 12, 0},
+		{[]byte{0x20, 0x05}, "JR NZ, $0107", 8, 12},
+		{[]byte{0x7E}, "LD A, [HL]", 8, 0},
+		{[]byte{0x76}, "HALT", 4, 0},
+		{[]byte{0xAF}, "XOR A, A", 4, 0},
+		{[]byte{0xC4, 0x50, 0x01}, "CALL NZ, $0150", 12, 24},
+		{[]byte{0xD8}, "RET C", 8, 20},
+		{[]byte{0xE0, 0x40}, "LDH [$FF40], A", 12, 0},
+		{[]byte{0xF2}, "LDH A, [C]", 8, 0},
+		{[]byte{0xE8, 0xFD}, "ADD SP, -3", 16, 0},
+		{[]byte{0xF8, 0x05}, "LD HL, SP+5", 12, 0},
+		{[]byte{0xF8, 0x80}, "LD HL, SP-128", 12, 0},
+		{[]byte{0xFF}, "RST $38", 16, 0},
+		{[]byte{0xCB, 0x37}, "SWAP A", 8, 0},
+		{[]byte{0xCB, 0x46}, "BIT 0, [HL]", 12, 0},
+		{[]byte{0xCB, 0xFE}, "SET 7, [HL]", 16, 0},
+		{[]byte{0xD3}, "DB $D3", 4, 0},
+	} {
+		var m flatMemory
+		copy(m[0x100:], c.code)
+		in := Decode(&m, 0x100)
+		if in.String() != c.text {
+			t.Errorf("Decoded %X as %q, not %q", c.code, in, c.text)
+		}
+		if in.Len() != len(c.code) {
+			t.Errorf("%s is %d bytes, not %d", c.text, in.Len(), len(c.code))
+		}
+		if in.Cycles != c.cycles || in.Branch != c.branch {
+			t.Errorf("%s takes %d/%d ticks, not %d/%d", c.text, in.Cycles, in.Branch, c.cycles, c.branch)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	var m flatMemory
+	copy(m[0x150:], []byte{0x3E, 0x01, 0xCB, 0x27, 0xC3, 0x50, 0x01})
+	instrs := Range(&m, 0x150, 0x157)
+	if len(instrs) != 3 {
+		t.Fatalf("Decoded %d instructions, not 3", len(instrs))
+	}
+	if instrs[1].Addr != 0x152 || instrs[2].String() != "JP $0150" {
+		t.Errorf("Decoded %v", instrs)
+	}
+	var buf bytes.Buffer
+	Fprint(&buf, instrs[:1])
+	if buf.String() != "0150: 3E 01     LD A, $01\n" {
+		t.Errorf("Listing %q", buf.String())
+	}
+}
+
+func TestRangeToTop(t *testing.T) {
+	var m flatMemory
+	instrs := Range(&m, 0xFFFE, 0xFFFF)
+	if len(instrs) != 1 {
+		t.Errorf("Decoded %d instructions, not 1", len(instrs))
+	}
+}
+
+func TestBank(t *testing.T) {
+	rom := make([]byte, 4*BANK_SIZE)
+	rom[0] = 0xC9
+	rom[2*BANK_SIZE] = 0x3E
+	rom[2*BANK_SIZE+1] = 0x42
+	bank0 := Bank(rom, 0)
+	if len(bank0) != BANK_SIZE || bank0[0].String() != "RET" {
+		t.Errorf("Bank 0 decoded to %d instructions starting %s", len(bank0), bank0[0])
+	}
+	bank2 := Bank(rom, 2)
+	if bank2[0].Addr != 0x4000 || bank2[0].String() != "LD A, $42" {
+		t.Errorf("Bank 2 starts with %04X %s", bank2[0].Addr, bank2[0])
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	syms, err := symbols.ParseSym(strings.NewReader(`
+00:0150 Main
+00:0156 Main.loop
+02:4000 Banked
+00:C000 wBuffer
+00:FF80 hFlag
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m flatMemory
+	copy(m[0x150:], []byte{
+		0x21, 0x00, 0xC0, // ld hl, wBuffer
+		0x11, 0x01, 0xC0, // ld de, $C001
+		0xFA, 0x02, 0xC0, // ld a, [wBuffer+2]
+		0xE0, 0x80, // ldh [hFlag], a
+		0xCD, 0x00, 0x40, // call Banked
+		0x18, 0xF6, // jr Main.loop
+		0x06, 0x50, // ld b, $50
+	})
+	instrs := Range(&m, 0x150, 0x162)
+	bank := func(addr uint16) int {
+		if addr >= 0x4000 && addr < 0x8000 {
+			return 2
+		}
+		return 0
+	}
+	for i := range instrs {
+		instrs[i].Annotate(Labels(syms, bank))
+	}
+	var buf bytes.Buffer
+	Fprint(&buf, instrs)
+	want := `Main:
+0150: 21 00 C0  LD HL, wBuffer
+0153: 11 01 C0  LD DE, $C001
+Main.loop:
+0156: FA 02 C0  LD A, [wBuffer+2]
+0159: E0 80     LDH [hFlag], A
+015B: CD 00 40  CALL Banked
+015E: 18 F6     JR Main.loop
+0160: 06 50     LD B, $50
+`
+	if buf.String() != want {
+		t.Errorf("Listing\n%s", buf.String())
+	}
+}
+
+// The disassembler and CPU share their tables, so every instruction
+// should step PC by its decoded length.
+func TestDecodeMatchesDispatch(t *testing.T) {
+	var m flatMemory
+	copy(m[0x200:], []byte{0x01, 0x34, 0x12, 0xCB, 0x11, 0xE0, 0x80, 0xFA, 0x00, 0xC0, 0x10, 0x00})
+	cpu := z80.New(&m)
+	cpu.PC = 0x200
+	for _, in := range Range(&m, 0x200, 0x20A) {
+		if cpu.PC != in.Addr {
+			t.Fatalf("CPU at %04X, disassembly at %04X", cpu.PC, in.Addr)
+		}
+		if ticks := cpu.Dispatch(); ticks != in.Cycles {
+			t.Errorf("%s took %d ticks, disassembly says %d", in, ticks, in.Cycles)
+		}
+	}
+}
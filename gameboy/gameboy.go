@@ -0,0 +1,418 @@
+package gameboy
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/zbyrne/golangboy/apu"
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/joypad"
+	"github.com/zbyrne/golangboy/ppu"
+	"github.com/zbyrne/golangboy/serial"
+	"github.com/zbyrne/golangboy/sgb"
+	"github.com/zbyrne/golangboy/symbols"
+	"github.com/zbyrne/golangboy/timer"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+// Model picks which Game Boy is emulated. The values are saved in movies
+// and save states, so new models go on the end.
+type Model int
+
+const (
+	DMG Model = iota
+	// SGB is a DMG in a Super Game Boy. Games that say they support it in
+	// their header can send it commands through the joypad register.
+	SGB
+	// MGB is the Game Boy Pocket.
+	MGB
+	// CGB is the Game Boy Color. Games that say they support it in their
+	// header run in CGB mode, with banked VRAM and work RAM, colour
+	// palettes and double speed.
+	CGB
+	// AGB is the Game Boy Advance, which runs Game Boy games as a CGB.
+	AGB
+)
+
+var modelNames = map[string]Model{
+	"dmg": DMG,
+	"sgb": SGB,
+	"mgb": MGB,
+	"cgb": CGB,
+	"agb": AGB,
+}
+
+// LookupModel finds a model by its lower case name, such as "cgb".
+func LookupModel(name string) (Model, error) {
+	m, ok := modelNames[name]
+	if !ok {
+		return 0, ErrModel
+	}
+	return m, nil
+}
+
+// ModelNames lists the models' names.
+func ModelNames() []string {
+	var names []string
+	for name := range modelNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cgb reports whether the model has a CGB inside, without the DMG's
+// hardware bugs.
+func (m Model) cgb() bool {
+	return m == CGB || m == AGB
+}
+
+func (m Model) bootROMSize() int {
+	if m.cgb() {
+		return 0x900
+	}
+	return 0x100
+}
+
+// FRAME_TIME is how long a frame takes on the hardware, a little under
+// 1/60th of a second.
+const FRAME_TIME = time.Second * ppu.FRAME_TICKS / apu.CLOCK
+
+var (
+	ErrBootROMSize = errors.New("gameboy: boot ROM is the wrong size for the model")
+	ErrModel       = errors.New("gameboy: unknown model")
+)
+
+// speedSwitchTicks is how long the CPU waits for the clock to settle when
+// a CGB changes speed.
+const speedSwitchTicks = 2050 * 4
+
+type Options struct {
+	Model Model
+	// BootROM is run from 0x0000 if given. Otherwise the machine starts
+	// at 0x0100 in the state the boot ROM leaves it in. It's 256 bytes, or
+	// 2304 for the CGB and AGB, whose boot ROMs skip the cartridge header
+	// at 0x0100-0x01FF.
+	BootROM []byte
+	// SampleRate is the audio output rate. 0 disables audio output.
+	SampleRate int
+	// Doctor makes LY always read 0x90, which Gameboy Doctor expects when
+	// comparing CPU traces.
+	Doctor bool
+}
+
+// GameBoy ties the CPU and the rest of the hardware together. The
+// components are exported so frontends and tools can get at them, but the
+// machine should only be advanced through StepInstruction, RunCycles and
+// RunFrame.
+type GameBoy struct {
+	CPU    *z80.Z80
+	MMU    *MMU
+	Cart   *cartridge.Cartridge
+	PPU    *ppu.PPU
+	APU    *apu.APU
+	Timer  *timer.Timer
+	Joypad *joypad.Joypad
+	Serial *serial.Port
+	// SGB is the Super Game Boy, when the model is SGB.
+	SGB *sgb.SGB
+
+	model  Model
+	cycles uint64
+	// fast holds CPU ticks in double speed that don't yet make a whole
+	// machine cycle at normal speed.
+	fast z80.ClockTicks
+}
+
+func New(cart *cartridge.Cartridge, opts Options) (*GameBoy, error) {
+	if opts.BootROM != nil && len(opts.BootROM) != opts.Model.bootROMSize() {
+		return nil, ErrBootROMSize
+	}
+	g := &GameBoy{Cart: cart, model: opts.Model}
+	g.MMU = &MMU{cart: cart, boot: opts.BootROM, doctor: opts.Doctor}
+	cpu := z80.New(g.MMU)
+	g.CPU = &cpu
+	g.PPU = ppu.New(g.CPU)
+	g.APU = apu.New(opts.SampleRate)
+	g.Timer = timer.New(g.CPU)
+	g.Joypad = joypad.New(g.CPU)
+	g.Serial = serial.New(g.CPU)
+	g.MMU.cpu = g.CPU
+	g.MMU.ppu = g.PPU
+	g.MMU.apu = g.APU
+	g.MMU.timer = g.Timer
+	g.MMU.joypad = g.Joypad
+	g.MMU.serial = g.Serial
+	g.MMU.cgb = opts.Model.cgb() && cart.Header.CGB()
+	g.PPU.SetDMG(!opts.Model.cgb())
+	g.PPU.SetCGB(g.MMU.cgb)
+	g.APU.SetDMG(!opts.Model.cgb())
+	if opts.Model == SGB {
+		g.SGB = sgb.New()
+		// The SGB only listens to games that ask for it.
+		if cart.Header.SGB() {
+			g.MMU.sgb = g.SGB
+		}
+	}
+	if opts.BootROM != nil {
+		g.MMU.bootEnabled = true
+	} else {
+		g.skipBoot()
+	}
+	return g, nil
+}
+
+// bootState is how a model's boot ROM leaves the machine. Games tell the
+// models apart by it.
+type bootState struct {
+	// regs are A, F, B, C, D, E, H and L.
+	regs [8]byte
+	// div is the timer's counter, which depends on how long the boot ROM
+	// runs.
+	div uint16
+	// chime is whether the boot ROM plays its sound, leaving channel 1
+	// on.
+	chime bool
+	dma   byte
+}
+
+var bootStates = map[Model]bootState{
+	DMG: {[8]byte{0x01, 0xB0, 0x00, 0x13, 0x00, 0xD8, 0x01, 0x4D}, 0xABCC, true, 0xFF},
+	SGB: {[8]byte{0x01, 0x00, 0x00, 0x14, 0x00, 0x00, 0xC0, 0x60}, 0xD850, false, 0xFF},
+	MGB: {[8]byte{0xFF, 0xB0, 0x00, 0x13, 0x00, 0xD8, 0x01, 0x4D}, 0xABCC, true, 0xFF},
+	CGB: {[8]byte{0x11, 0x80, 0x00, 0x00, 0xFF, 0x56, 0x00, 0x0D}, 0x1EA0, true, 0x00},
+	// The AGB's boot ROM ends with an extra INC B.
+	AGB: {[8]byte{0x11, 0x00, 0x01, 0x00, 0xFF, 0x56, 0x00, 0x0D}, 0x1EA0, true, 0x00},
+}
+
+// dmgModeDIV is the timer's counter when a CGB boot ROM hands over to a
+// game in DMG mode, after looking up its palettes.
+const dmgModeDIV = 0x2670
+
+// skipBoot sets up the registers as the boot ROM leaves them.
+func (g *GameBoy) skipBoot() {
+	c := g.CPU
+	s := bootStates[g.model]
+	r := s.regs
+	if g.model.cgb() && !g.MMU.cgb {
+		// In DMG mode the boot ROM leaves the sum it looked the game's
+		// palettes up by in B, and DE and HL differently.
+		sum := g.titleSum()
+		r[2] += sum
+		r[4], r[5], r[6], r[7] = 0x00, 0x08, 0x00, 0x7C
+		// Two sums take a different path through the logo code.
+		if sum == 0x43 || sum == 0x58 {
+			r[6], r[7] = 0x99, 0x1A
+		}
+		if g.model == AGB {
+			// The flags are from the INC B.
+			r[1] = 0
+			if r[2] == 0 {
+				r[1] |= 0x80
+			}
+			if r[2]&0x0F == 0 {
+				r[1] |= 0x20
+			}
+		}
+		s.div = dmgModeDIV
+	}
+	c.A, c.F, c.B, c.C = r[0], r[1], r[2], r[3]
+	c.D, c.E, c.H, c.L = r[4], r[5], r[6], r[7]
+	c.SP = 0xFFFE
+	c.PC = 0x0100
+	c.IF = z80.VBLANK_INT
+	g.Timer.SetCounter(s.div)
+	// Writing P1 through the MMU would look like a packet's reset pulse to
+	// an SGB.
+	g.Joypad.WriteByte(P1, 0x00)
+	for _, r := range []struct {
+		addr uint16
+		val  byte
+	}{
+		{timer.TAC, 0xF8},
+		{apu.NR52, 0x80},
+		{apu.NR11, 0x80},
+		{apu.NR50, 0x77},
+		{apu.NR51, 0xF3},
+		{ppu.LCDC, 0x91},
+		{ppu.BGP, 0xFC},
+	} {
+		g.MMU.WriteByte(r.addr, r.val)
+	}
+	if s.chime {
+		// The game starts as the chime's last note fades out, so channel
+		// 1 is left on but silent.
+		g.MMU.WriteByte(apu.NR12, 0x08)
+		g.MMU.WriteByte(apu.NR13, 0xC1)
+		g.MMU.WriteByte(apu.NR14, 0x87)
+	}
+	g.MMU.WriteByte(apu.NR12, 0xF3)
+	if g.MMU.cgb {
+		// The boot ROM makes every background colour white for CGB
+		// games, and leaves the object colours as they powered up.
+		g.MMU.WriteByte(ppu.BCPS, 0x80)
+		for i := 0; i < 32; i++ {
+			g.MMU.WriteByte(ppu.BCPD, 0xFF)
+			g.MMU.WriteByte(ppu.BCPD, 0x7F)
+		}
+	}
+	// The boot ROM never starts a DMA, so OAM isn't copied into.
+	g.MMU.dma = s.dma
+}
+
+// titleSum is what a CGB boot ROM looks a DMG game's palettes up by: the
+// sum of the title's bytes if Nintendo published it, or 0.
+func (g *GameBoy) titleSum() byte {
+	if h := g.Cart.Header; h.Nintendo() {
+		return h.TitleSum()
+	}
+	return 0
+}
+
+func (g *GameBoy) Model() Model {
+	return g.model
+}
+
+// Bank returns the bank mapped at addr, numbered as in symbol files: the
+// ROM bank in 0x4000-0x7FFF, the VRAM bank in 0x8000-0x9FFF, the RAM bank
+// in 0xA000-0xBFFF, the work RAM bank in 0xD000-0xDFFF and 0 elsewhere.
+func (g *GameBoy) Bank(addr uint16) int {
+	switch {
+	case addr >= 0x4000 && addr < 0x8000:
+		return g.Cart.ROMBank()
+	case addr >= 0x8000 && addr < 0xA000:
+		return int(g.PPU.ReadByte(ppu.VBK) & 1)
+	case addr >= 0xA000 && addr < 0xC000:
+		return g.Cart.RAMBank()
+	case addr >= 0xD000 && addr < 0xE000:
+		return g.MMU.WRAMBank()
+	}
+	return 0
+}
+
+// Trace logs each instruction to w as the CPU's tracer does, naming the
+// code being run from syms if it isn't nil. Passing a nil w turns tracing
+// off.
+func (g *GameBoy) Trace(w io.Writer, syms *symbols.Table) {
+	g.CPU.SetTracer(w)
+	if syms == nil {
+		g.CPU.SetTraceLabels(nil)
+		return
+	}
+	g.CPU.SetTraceLabels(func(pc uint16) string {
+		return syms.Label(g.Bank(pc), pc)
+	})
+}
+
+// Cycles returns the number of clock ticks run since power on. They're
+// ticks of the normal speed clock, so in double speed the CPU gets two for
+// each.
+func (g *GameBoy) Cycles() uint64 {
+	return g.cycles
+}
+
+// DoubleSpeed reports whether a CGB is running at double speed.
+func (g *GameBoy) DoubleSpeed() bool {
+	return g.MMU.doubleSpeed
+}
+
+// tick hands the time taken by an instruction to the rest of the
+// hardware. The timer goes first so that an overflow during the
+// instruction is pending before the next one is fetched. The timer and
+// serial port run off the CPU's clock, so keep up with it in double
+// speed.
+func (g *GameBoy) tick(t z80.ClockTicks) {
+	g.Timer.Tick(t)
+	g.Serial.Tick(t)
+	if g.MMU.doubleSpeed {
+		g.fast += t
+		t = g.fast / 8 * 4
+		g.fast %= 8
+	}
+	g.tickNormal(t)
+}
+
+// tickNormal runs the hardware that stays at normal speed.
+func (g *GameBoy) tickNormal(t z80.ClockTicks) {
+	frame := g.PPU.Frames()
+	g.PPU.Tick(t)
+	if g.SGB != nil && g.PPU.Frames() != frame {
+		g.SGB.Frame(g.PPU.Frame())
+	}
+	g.APU.Tick(t)
+	g.Cart.Tick(t)
+	g.cycles += uint64(t)
+}
+
+// StepInstruction runs one instruction, or services one interrupt, and
+// returns the ticks it took on the CPU's clock.
+func (g *GameBoy) StepInstruction() z80.ClockTicks {
+	stopped := g.CPU.Stopped()
+	t := g.CPU.Dispatch()
+	g.tick(t)
+	if !stopped && g.CPU.Stopped() {
+		g.stop()
+	}
+	return t
+}
+
+// stop follows the CPU running STOP, which resets DIV. With a speed switch
+// armed a CGB changes speed instead of sleeping, the timer held while the
+// clock settles.
+func (g *GameBoy) stop() {
+	g.Timer.WriteByte(timer.DIV, 0)
+	if !g.MMU.speedArmed {
+		return
+	}
+	g.MMU.speedArmed = false
+	g.MMU.doubleSpeed = !g.MMU.doubleSpeed
+	g.fast = 0
+	g.CPU.Resume()
+	g.tickNormal(speedSwitchTicks)
+}
+
+// RunCycles runs whole instructions until at least n ticks have passed and
+// returns how many actually did.
+func (g *GameBoy) RunCycles(n uint64) uint64 {
+	start := g.cycles
+	for g.cycles-start < n {
+		g.StepInstruction()
+	}
+	return g.cycles - start
+}
+
+// RunFrame latches the joypad and runs until the PPU finishes a frame. With
+// the LCD off it runs for a frame's worth of ticks instead.
+func (g *GameBoy) RunFrame() {
+	g.Joypad.Latch()
+	frame := g.PPU.Frames()
+	start := g.cycles
+	for g.PPU.Frames() == frame {
+		if !g.PPU.Enabled() && g.cycles-start >= ppu.FRAME_TICKS {
+			return
+		}
+		g.StepInstruction()
+	}
+}
+
+// Frame returns the last completed frame. In CGB mode it holds colour
+// numbers rather than shades, and Colors has the colours.
+func (g *GameBoy) Frame() []byte {
+	return g.PPU.Frame()
+}
+
+// Colors returns the last frame's colours in the CGB's 15 bit format when
+// a game runs in CGB mode, and nil otherwise.
+func (g *GameBoy) Colors() []uint16 {
+	if !g.MMU.cgb {
+		return nil
+	}
+	return g.PPU.Colors()
+}
+
+// Layers says which palette each pixel of the last frame came from.
+func (g *GameBoy) Layers() []byte {
+	return g.PPU.Layers()
+}
@@ -0,0 +1,715 @@
+package gameboy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zbyrne/golangboy/apu"
+	"github.com/zbyrne/golangboy/asm"
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/joypad"
+	"github.com/zbyrne/golangboy/ppu"
+	"github.com/zbyrne/golangboy/symbols"
+	"github.com/zbyrne/golangboy/timer"
+	"github.com/zbyrne/golangboy/wav"
+)
+
+var _ apu.Sink = (*wav.Recorder)(nil)
+
+// testCart makes a ROM only cartridge with code at 0x0100 and handlers
+// at the interrupt vectors.
+func testCart(t *testing.T, code []byte, vectors map[uint16][]byte) *cartridge.Cartridge {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], code)
+	for addr, handler := range vectors {
+		copy(rom[addr:], handler)
+	}
+	c, err := cartridge.New(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newTestGameBoy(t *testing.T, code []byte, vectors map[uint16][]byte) *GameBoy {
+	return newGameBoy(t, testCart(t, code, vectors), Options{})
+}
+
+func newGameBoy(t *testing.T, cart *cartridge.Cartridge, opts Options) *GameBoy {
+	t.Helper()
+	g, err := New(cart, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// Loop forever.
+var spin = []byte{0x18, 0xFE}
+
+type recorder struct {
+	sent []byte
+}
+
+func (r *recorder) Exchange(out byte) byte {
+	r.sent = append(r.sent, out)
+	return 0xFF
+}
+
+func TestNewSkipsBoot(t *testing.T) {
+	g := newTestGameBoy(t, spin, nil)
+	if g.CPU.PC != 0x0100 {
+		t.Errorf("PC = 0x%04X, not 0x0100", g.CPU.PC)
+	}
+	if g.CPU.A != 0x01 || g.CPU.F != 0xB0 || g.CPU.SP != 0xFFFE {
+		t.Errorf("A = 0x%02X F = 0x%02X SP = 0x%04X", g.CPU.A, g.CPU.F, g.CPU.SP)
+	}
+	for _, r := range []struct {
+		addr uint16
+		val  byte
+	}{
+		{P1, 0xCF},
+		{0xFF04, 0xAB},
+		{0xFF07, 0xF8},
+		{IF, 0xE1},
+		{ppu.LCDC, 0x91},
+		{ppu.BGP, 0xFC},
+		{apu.NR50, 0x77},
+		{IE, 0x00},
+	} {
+		if val := g.MMU.ReadByte(r.addr); val != r.val {
+			t.Errorf("0x%04X = 0x%02X, not 0x%02X", r.addr, val, r.val)
+		}
+	}
+}
+
+func TestSGB(t *testing.T) {
+	g, err := New(testCart(t, spin, nil), Options{Model: SGB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.SGB == nil || g.MMU.sgb != nil {
+		t.Error("SGB listens to a game without its header flags")
+	}
+	cart := testCart(t, spin, nil)
+	cart.SGBFlag, cart.OldLicensee = 0x03, 0x33
+	if g = newGameBoy(t, cart, Options{Model: SGB}); g.MMU.sgb == nil {
+		t.Fatal("SGB ignores a game with its header flags")
+	}
+	// Reading the buttons isn't a packet without a reset pulse first.
+	frame := make([]byte, ppu.WIDTH*ppu.HEIGHT)
+	before := g.SGB.Image(frame).At(128, 112)
+	for i := 0; i < 200; i++ {
+		for _, sel := range []byte{0x20, 0x10, 0x30} {
+			g.MMU.WriteByte(P1, sel)
+		}
+	}
+	if after := g.SGB.Image(frame).At(128, 112); after != before {
+		t.Errorf("Polling the joypad changed the screen from %v to %v", before, after)
+	}
+}
+
+func TestModels(t *testing.T) {
+	for _, m := range []struct {
+		model          Model
+		cgb            bool
+		title          string
+		regs           [8]byte
+		key1, div, dma byte
+		nr52           byte
+	}{
+		{DMG, false, "", [8]byte{0x01, 0xB0, 0x00, 0x13, 0x00, 0xD8, 0x01, 0x4D}, 0xFF, 0xAB, 0xFF, 0xF1},
+		{MGB, false, "", [8]byte{0xFF, 0xB0, 0x00, 0x13, 0x00, 0xD8, 0x01, 0x4D}, 0xFF, 0xAB, 0xFF, 0xF1},
+		{SGB, false, "", [8]byte{0x01, 0x00, 0x00, 0x14, 0x00, 0x00, 0xC0, 0x60}, 0xFF, 0xD8, 0xFF, 0xF0},
+		{CGB, true, "", [8]byte{0x11, 0x80, 0x00, 0x00, 0xFF, 0x56, 0x00, 0x0D}, 0x7E, 0x1E, 0x00, 0xF1},
+		{CGB, false, "", [8]byte{0x11, 0x80, 0x00, 0x00, 0x00, 0x08, 0x00, 0x7C}, 0xFF, 0x26, 0x00, 0xF1},
+		// In DMG mode B is the sum of a Nintendo title.
+		{CGB, false, "TETRIS", [8]byte{0x11, 0x80, 0xDB, 0x00, 0x00, 0x08, 0x00, 0x7C}, 0xFF, 0x26, 0x00, 0xF1},
+		{CGB, false, "X", [8]byte{0x11, 0x80, 0x58, 0x00, 0x00, 0x08, 0x99, 0x1A}, 0xFF, 0x26, 0x00, 0xF1},
+		{AGB, true, "", [8]byte{0x11, 0x00, 0x01, 0x00, 0xFF, 0x56, 0x00, 0x0D}, 0x7E, 0x1E, 0x00, 0xF1},
+		{AGB, false, "TETRIS", [8]byte{0x11, 0x00, 0xDC, 0x00, 0x00, 0x08, 0x00, 0x7C}, 0xFF, 0x26, 0x00, 0xF1},
+		// 0xFF plus the extra INC B.
+		{AGB, false, "BALLOON KID", [8]byte{0x11, 0xA0, 0x00, 0x00, 0x00, 0x08, 0x00, 0x7C}, 0xFF, 0x26, 0x00, 0xF1},
+	} {
+		cart := testCart(t, spin, nil)
+		if m.cgb {
+			cart.CGBFlag = 0x80
+		}
+		if m.title != "" {
+			cart.Title, cart.OldLicensee = m.title, 0x01
+		}
+		g, err := New(cart, Options{Model: m.model})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := g.CPU
+		if regs := [8]byte{c.A, c.F, c.B, c.C, c.D, c.E, c.H, c.L}; regs != m.regs {
+			t.Errorf("Model %d with CGB flag %t and title %q: registers % 02X", m.model, m.cgb, m.title, regs)
+		}
+		for _, r := range []struct {
+			name string
+			addr uint16
+			want byte
+		}{
+			{"KEY1", KEY1, m.key1},
+			{"DIV", timer.DIV, m.div},
+			{"DMA", DMA, m.dma},
+			{"NR52", apu.NR52, m.nr52},
+		} {
+			if val := g.MMU.ReadByte(r.addr); val != r.want {
+				t.Errorf("Model %d with CGB flag %t: %s = 0x%02X, not 0x%02X", m.model, m.cgb, r.name, val, r.want)
+			}
+		}
+	}
+	for _, name := range ModelNames() {
+		if m, err := LookupModel(name); err != nil || bootStates[m].regs == ([8]byte{}) {
+			t.Errorf("%s is model %d: %v", name, m, err)
+		}
+	}
+	if _, err := LookupModel("gba"); err != ErrModel {
+		t.Errorf("Looked up gba: %v", err)
+	}
+}
+
+// switchSpeed arms a speed switch and runs STOP.
+var switchSpeed = []byte{
+	0x3E, 0x01, // LD A, 1
+	0xE0, 0x4D, // LDH [KEY1], A
+	0x10, 0x00, // STOP
+	0x18, 0xFE, // JR -2
+}
+
+func TestDoubleSpeed(t *testing.T) {
+	cart := testCart(t, switchSpeed, nil)
+	cart.CGBFlag = 0x80
+	g, err := New(cart, Options{Model: CGB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.StepInstruction()
+	g.StepInstruction()
+	if val := g.MMU.ReadByte(KEY1); val != 0x7F {
+		t.Errorf("KEY1 = 0x%02X armed, not 0x7F", val)
+	}
+	g.StepInstruction()
+	if val := g.MMU.ReadByte(KEY1); !g.DoubleSpeed() || val != 0xFE || g.CPU.Stopped() {
+		t.Errorf("KEY1 = 0x%02X after STOP, stopped %t", val, g.CPU.Stopped())
+	}
+	if div := g.Timer.Counter(); div != 0 {
+		t.Errorf("DIV counter 0x%04X after STOP", div)
+	}
+
+	// Frames take as long as ever, and the timer counts twice as fast.
+	g.RunFrame()
+	start, div := g.Cycles(), g.Timer.Counter()
+	g.RunFrame()
+	if d := g.Cycles() - start; d < ppu.FRAME_TICKS || d > ppu.FRAME_TICKS+8 {
+		t.Errorf("Frame took %d ticks", d)
+	}
+	if got, want := g.Timer.Counter()-div, uint16(2*(g.Cycles()-start)); got != want {
+		t.Errorf("DIV counter moved 0x%04X, not 0x%04X", got, want)
+	}
+
+	var state bytes.Buffer
+	if err := g.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newGameBoy(t, cart, Options{Model: CGB})
+	if err := loaded.LoadState(&state); err != nil || !loaded.DoubleSpeed() {
+		t.Errorf("Loaded state at double speed %t: %v", loaded.DoubleSpeed(), err)
+	}
+
+	// Without the CGB flag there's no KEY1 and STOP sleeps.
+	g = newGameBoy(t, testCart(t, switchSpeed, nil), Options{Model: CGB})
+	for i := 0; i < 3; i++ {
+		g.StepInstruction()
+	}
+	if !g.CPU.Stopped() || g.DoubleSpeed() {
+		t.Error("Switched speed in DMG mode")
+	}
+}
+
+func TestCGBRegisters(t *testing.T) {
+	cart := testCart(t, spin, nil)
+	cart.CGBFlag = 0x80
+	g, err := New(cart, Options{Model: CGB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := g.PPU.CGBColor(false, 7, 3); c != 0x7FFF {
+		t.Errorf("Background palette 7 colour 3 starts 0x%04X, not white", c)
+	}
+	m := g.MMU
+	m.WriteByte(SVBK, 3)
+	m.WriteByte(0xD000, 0x33)
+	m.WriteByte(SVBK, 0)
+	if val := m.ReadByte(0xD000); val != 0 || g.Bank(0xD000) != 1 || m.ReadByte(SVBK) != 0xF8 {
+		t.Errorf("Bank 0 reads 0x%02X as bank %d", val, g.Bank(0xD000))
+	}
+	m.WriteByte(SVBK, 0xFB)
+	if val := m.ReadByte(0xF000); val != 0x33 || g.Bank(0xD000) != 3 || m.ReadByte(SVBK) != 0xFB {
+		t.Errorf("Bank 3 echo reads 0x%02X", val)
+	}
+
+	m.WriteByte(ppu.VBK, 1)
+	m.WriteByte(0x8000, 0x42)
+	if g.PPU.VRAM1[0] != 0x42 || g.PPU.VRAM[0] != 0 || g.Bank(0x8000) != 1 {
+		t.Errorf("VRAM bank 1 write went to bank %d", g.Bank(0x8000))
+	}
+	m.WriteByte(ppu.VBK, 0)
+	if val := m.ReadByte(0x8000); val != 0 || m.ReadByte(ppu.VBK) != 0xFE {
+		t.Errorf("VRAM bank 0 reads 0x%02X", val)
+	}
+
+	m.WriteByte(ppu.BCPS, 0x80)
+	m.WriteByte(ppu.BCPD, 0xFF)
+	m.WriteByte(ppu.BCPD, 0x7F)
+	m.WriteByte(ppu.OCPS, 0x3E)
+	m.WriteByte(ppu.OCPD, 0x1F)
+	if c := g.PPU.CGBColor(false, 0, 0); c != 0x7FFF || m.ReadByte(ppu.BCPS) != 0xC2 {
+		t.Errorf("Background colour 0 is 0x%04X, BCPS 0x%02X", c, m.ReadByte(ppu.BCPS))
+	}
+	if c := g.PPU.CGBColor(true, 7, 3); c != 0x1F || m.ReadByte(ppu.OCPD) != 0x1F || m.ReadByte(ppu.OCPS) != 0x7E {
+		t.Errorf("Object palette 7 colour 3 is 0x%04X", c)
+	}
+
+	var state bytes.Buffer
+	g.SaveState(&state)
+	loaded := newGameBoy(t, cart, Options{Model: CGB})
+	if err := loaded.LoadState(&state); err != nil {
+		t.Fatal(err)
+	}
+	if val := loaded.MMU.ReadByte(0xD000); val != 0x33 || loaded.PPU.CGBColor(false, 0, 0) != 0x7FFF {
+		t.Errorf("Loaded bank 3 reads 0x%02X", val)
+	}
+	if g.Colors() == nil {
+		t.Error("No colours in CGB mode")
+	}
+
+	// Outside CGB mode none of it is there.
+	g = newGameBoy(t, testCart(t, spin, nil), Options{Model: CGB})
+	for _, r := range []uint16{SVBK, ppu.VBK, ppu.BCPS, ppu.OCPD} {
+		g.MMU.WriteByte(r, 1)
+		if val := g.MMU.ReadByte(r); val != 0xFF {
+			t.Errorf("0x%04X = 0x%02X in DMG mode", r, val)
+		}
+	}
+	g.MMU.WriteByte(0xD000, 0x11)
+	if g.MMU.wram[0x1000] != 0x11 || g.Bank(0xD000) != 1 {
+		t.Error("Work RAM at 0xD000 isn't bank 1 in DMG mode")
+	}
+	if g.Colors() != nil {
+		t.Error("Colours in DMG mode")
+	}
+}
+
+func TestBootROM(t *testing.T) {
+	boot := make([]byte, 0x100)
+	// LD A 1; LDH (0x50) A
+	copy(boot, []byte{0x3E, 0x01, 0xE0, 0x50})
+	rom := testCart(t, spin, nil)
+	rom.ROM()[0] = 0xAA
+	g, err := New(rom, Options{BootROM: boot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.CPU.PC != 0 {
+		t.Errorf("PC = 0x%04X, not 0x0000", g.CPU.PC)
+	}
+	if val := g.MMU.ReadByte(0); val != 0x3E {
+		t.Errorf("Read 0x%02X from the boot ROM, not 0x3E", val)
+	}
+	g.StepInstruction()
+	g.StepInstruction()
+	if val := g.MMU.ReadByte(0); val != 0xAA {
+		t.Errorf("Read 0x%02X after unmapping the boot ROM, not 0xAA", val)
+	}
+}
+
+func TestCGBBootROM(t *testing.T) {
+	boot := make([]byte, 0x900)
+	boot[0], boot[0x100], boot[0x200] = 0x31, 0xEE, 0x3E
+	rom := testCart(t, spin, nil)
+	g, err := New(rom, Options{Model: CGB, BootROM: boot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []struct {
+		addr uint16
+		val  byte
+	}{
+		{0x0000, 0x31},
+		{0x0100, rom.ROM()[0x100]},
+		{0x0200, 0x3E},
+	} {
+		if val := g.MMU.ReadByte(r.addr); val != r.val {
+			t.Errorf("0x%04X = 0x%02X, not 0x%02X", r.addr, val, r.val)
+		}
+	}
+}
+
+func TestBootROMSize(t *testing.T) {
+	for _, tc := range []struct {
+		model Model
+		size  int
+	}{
+		{DMG, 10},
+		{DMG, 0x900},
+		{CGB, 0x100},
+		{AGB, 0x100},
+	} {
+		_, err := New(testCart(t, spin, nil), Options{Model: tc.model, BootROM: make([]byte, tc.size)})
+		if err != ErrBootROMSize {
+			t.Errorf("New with a 0x%X byte boot ROM on model %d returned %v, not ErrBootROMSize", tc.size, tc.model, err)
+		}
+	}
+}
+
+func TestStepInstruction(t *testing.T) {
+	g := newTestGameBoy(t, []byte{0x00, 0xC3, 0x00, 0x01}, nil)
+	if ticks := g.StepInstruction(); ticks != 4 {
+		t.Errorf("NOP took %d ticks, not 4", ticks)
+	}
+	if ticks := g.StepInstruction(); ticks != 16 {
+		t.Errorf("JP took %d ticks, not 16", ticks)
+	}
+	if g.Cycles() != 20 {
+		t.Errorf("Cycles = %d, not 20", g.Cycles())
+	}
+	if val := g.PPU.ReadByte(ppu.STAT) & 3; val != ppu.MODE_OAM {
+		t.Errorf("PPU in mode %d after 20 ticks, not 2", val)
+	}
+}
+
+func TestRunCycles(t *testing.T) {
+	g := newTestGameBoy(t, spin, nil)
+	// JR takes 12 ticks.
+	if n := g.RunCycles(100); n != 108 {
+		t.Errorf("Ran %d ticks, not 108", n)
+	}
+}
+
+func TestRunFrame(t *testing.T) {
+	g := newTestGameBoy(t, spin, nil)
+	g.RunFrame()
+	if g.PPU.Frames() != 1 {
+		t.Fatalf("Frames = %d, not 1", g.PPU.Frames())
+	}
+	start := g.Cycles()
+	g.RunFrame()
+	if g.PPU.Frames() != 2 {
+		t.Fatalf("Frames = %d, not 2", g.PPU.Frames())
+	}
+	if n := g.Cycles() - start; n < ppu.FRAME_TICKS-12 || n > ppu.FRAME_TICKS+12 {
+		t.Errorf("Frame took %d ticks", n)
+	}
+}
+
+func TestRunFrameLCDOff(t *testing.T) {
+	// XOR A; LDH (0x40) A; JR -2
+	g := newTestGameBoy(t, []byte{0xAF, 0xE0, 0x40, 0x18, 0xFE}, nil)
+	g.RunFrame()
+	if g.PPU.Frames() != 0 {
+		t.Errorf("Frames = %d with the LCD off", g.PPU.Frames())
+	}
+	if g.Cycles() < ppu.FRAME_TICKS {
+		t.Errorf("Ran %d ticks, less than a frame", g.Cycles())
+	}
+}
+
+func TestVBlankInterrupt(t *testing.T) {
+	code := []byte{
+		0xAF,       // XOR A
+		0xE0, 0x0F, // LDH (IF) A
+		0x3C,       // INC A
+		0xE0, 0xFF, // LDH (IE) A
+		0xFB,       // EI
+		0x76,       // HALT
+		0x18, 0xFD, // JR -3
+	}
+	vectors := map[uint16][]byte{
+		0x40: {0x04, 0xD9}, // INC B; RETI
+	}
+	g := newTestGameBoy(t, code, vectors)
+	g.CPU.B = 0
+	for i := 0; i < 4; i++ {
+		g.RunFrame()
+	}
+	if g.CPU.B < 3 || g.CPU.B > 4 {
+		t.Errorf("VBlank handler ran %d times in 4 frames", g.CPU.B)
+	}
+}
+
+func TestTimerInterrupt(t *testing.T) {
+	code := []byte{
+		0xAF,       // XOR A
+		0xE0, 0x0F, // LDH (IF) A
+		0x3E, 0x04, // LD A 4
+		0xE0, 0xFF, // LDH (IE) A
+		0x3E, 0x05, // LD A 5
+		0xE0, 0x07, // LDH (TAC) A
+		0xFB,       // EI
+		0x76,       // HALT
+		0x18, 0xFD, // JR -3
+	}
+	vectors := map[uint16][]byte{
+		0x50: {0x0C, 0xD9}, // INC C; RETI
+	}
+	g := newTestGameBoy(t, code, vectors)
+	g.CPU.C = 0
+	// TIMA overflows every 256 * 16 ticks.
+	g.RunCycles(4096*3 + 200)
+	if g.CPU.C != 3 {
+		t.Errorf("Timer handler ran %d times, not 3", g.CPU.C)
+	}
+}
+
+func TestSerialTransfer(t *testing.T) {
+	code := []byte{
+		0x3E, 'O', // LD A 'O'
+		0xE0, 0x01, // LDH (SB) A
+		0x3E, 0x81, // LD A 0x81
+		0xE0, 0x02, // LDH (SC) A
+		0x18, 0xFE, // JR -2
+	}
+	g := newTestGameBoy(t, code, nil)
+	var r recorder
+	g.Serial.Connect(&r)
+	g.RunCycles(5000)
+	if string(r.sent) != "O" {
+		t.Errorf("Sent %q, not \"O\"", r.sent)
+	}
+	if g.CPU.IF&0x08 == 0 {
+		t.Error("Serial interrupt not requested")
+	}
+}
+
+func TestJoypadLatchedPerFrame(t *testing.T) {
+	g := newTestGameBoy(t, spin, nil)
+	g.MMU.WriteByte(P1, 0x20)
+	g.Joypad.Press(joypad.DOWN)
+	if val := g.MMU.ReadByte(P1); val != 0xEF {
+		t.Errorf("P1 = 0x%02X before the frame, not 0xEF", val)
+	}
+	g.RunFrame()
+	if val := g.MMU.ReadByte(P1); val != 0xE7 {
+		t.Errorf("P1 = 0x%02X after the frame, not 0xE7", val)
+	}
+}
+
+func TestOAMDMA(t *testing.T) {
+	g := newTestGameBoy(t, spin, nil)
+	for i := uint16(0); i < 0xA0; i++ {
+		g.MMU.WriteByte(0xC100+i, byte(i))
+	}
+	g.MMU.WriteByte(DMA, 0xC1)
+	if g.PPU.OAM[0x9F] != 0x9F || g.PPU.OAM[1] != 1 {
+		t.Error("OAM not copied")
+	}
+	if val := g.MMU.ReadByte(DMA); val != 0xC1 {
+		t.Errorf("DMA = 0x%02X, not 0xC1", val)
+	}
+}
+
+func TestEchoRAM(t *testing.T) {
+	g := newTestGameBoy(t, spin, nil)
+	g.MMU.WriteByte(0xC123, 0x45)
+	if val := g.MMU.ReadByte(0xE123); val != 0x45 {
+		t.Errorf("Echo read 0x%02X, not 0x45", val)
+	}
+	g.MMU.WriteWord(0xFDFE, 0x1234)
+	if val := g.MMU.ReadWord(0xDDFE); val != 0x1234 {
+		t.Errorf("Echo write landed as 0x%04X, not 0x1234", val)
+	}
+}
+
+func TestHRAMAndIE(t *testing.T) {
+	g := newTestGameBoy(t, spin, nil)
+	g.MMU.WriteByte(0xFF80, 0x12)
+	g.MMU.WriteByte(0xFFFE, 0x34)
+	g.MMU.WriteByte(IE, 0x1F)
+	if g.MMU.ReadByte(0xFF80) != 0x12 || g.MMU.ReadByte(0xFFFE) != 0x34 {
+		t.Error("HRAM not stored")
+	}
+	if g.CPU.IE != 0x1F {
+		t.Errorf("IE = 0x%02X, not 0x1F", g.CPU.IE)
+	}
+}
+
+func TestAudioCapture(t *testing.T) {
+	g := newGameBoy(t, testCart(t, spin, nil), Options{SampleRate: 48000})
+	f, err := os.CreateTemp(t.TempDir(), "*.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rec, err := wav.NewRecorder(f, []io.WriteSeeker{}, 48000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.APU.SetSink(rec)
+	for i := 0; i < 60; i++ {
+		g.RunFrame()
+	}
+	rec.Close()
+	st, _ := f.Stat()
+	// Sixty frames is just over a second.
+	if st.Size() < 44+48000*4 || st.Size() > 44+48500*4 {
+		t.Errorf("Captured %d bytes", st.Size())
+	}
+}
+
+func TestDoctorTrace(t *testing.T) {
+	g := newGameBoy(t, testCart(t, []byte{0x00, 0xC3, 0x00, 0x01}, nil), Options{Doctor: true})
+	var buf bytes.Buffer
+	g.CPU.SetTracer(&buf)
+	g.StepInstruction()
+	g.StepInstruction()
+	want := "A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,00,01\n" +
+		"A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0101 PCMEM:C3,00,01,00\n"
+	if buf.String() != want {
+		t.Errorf("Traced\n%s\nnot\n%s", buf.String(), want)
+	}
+	if val := g.MMU.ReadByte(ppu.LY); val != 0x90 {
+		t.Errorf("LY = 0x%02X, not 0x90", val)
+	}
+}
+
+func TestTraceLabels(t *testing.T) {
+	g := newTestGameBoy(t, []byte{0xC3, 0x00, 0x40}, nil)
+	syms, _ := symbols.ParseSym(strings.NewReader("00:0100 Start\n01:4000 Banked\n"))
+	var buf bytes.Buffer
+	g.Trace(&buf, syms)
+	g.StepInstruction()
+	g.StepInstruction()
+	g.Trace(nil, nil)
+	g.StepInstruction()
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], " ; Start") || !strings.HasSuffix(lines[1], " ; Banked") {
+		t.Errorf("Traced\n%s", buf.String())
+	}
+}
+
+// stateProgram changes the palette from a timer interrupt while a tone
+// plays, so the frames, the sound and the CPU all move on.
+const stateProgram = `
+SECTION "timer", ROM0[$50]
+	inc b
+	reti
+SECTION "main", ROM0[$100]
+	ld a, $05
+	ldh [$07], a
+	ld a, $04
+	ldh [$FF], a
+	ld a, $80
+	ldh [$26], a
+	ld a, $77
+	ldh [$24], a
+	ld a, $FF
+	ldh [$25], a
+	ld a, $F0
+	ldh [$12], a
+	ld a, $87
+	ldh [$14], a
+	ei
+.loop:
+	ld a, b
+	ldh [$47], a
+	ld [$C000], a
+	jr .loop
+`
+
+type samples []int16
+
+func (s *samples) WriteSample(left, right int16, channels ...int16) error {
+	*s = append(*s, left, right)
+	return nil
+}
+
+func newStateGameBoy(t *testing.T, title string) *GameBoy {
+	rom, err := asm.ROM(stateProgram, []byte(title)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, err := cartridge.New(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newGameBoy(t, cart, Options{SampleRate: 48000})
+}
+
+// record runs a few frames and returns what came out.
+func record(g *GameBoy) ([]byte, samples) {
+	var frames []byte
+	var sound samples
+	g.APU.SetSink(&sound)
+	for i := 0; i < 5; i++ {
+		g.RunFrame()
+		frames = append(frames, g.Frame()...)
+	}
+	return frames, sound
+}
+
+func TestSaveState(t *testing.T) {
+	g := newStateGameBoy(t, "STATE")
+	g.RunCycles(100000)
+	var state bytes.Buffer
+	if err := g.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+	wantFrames, wantSound := record(g)
+	if len(wantSound) == 0 || bytes.Equal(wantFrames[:ppu.WIDTH*ppu.HEIGHT], wantFrames[len(wantFrames)-ppu.WIDTH*ppu.HEIGHT:]) {
+		t.Fatal("Nothing changes while recording")
+	}
+
+	loaded := newStateGameBoy(t, "STATE")
+	if err := loaded.LoadState(bytes.NewReader(state.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	frames, sound := record(loaded)
+	if !bytes.Equal(frames, wantFrames) {
+		t.Error("Frames differ after loading")
+	}
+	if !reflect.DeepEqual(sound, wantSound) {
+		t.Errorf("%d samples differ after loading, %d wanted", len(sound), len(wantSound))
+	}
+	var want, got bytes.Buffer
+	g.SaveState(&want)
+	loaded.SaveState(&got)
+	if !bytes.Equal(got.Bytes(), want.Bytes()) || loaded.CPU.PC != g.CPU.PC {
+		t.Errorf("Machines differ after loading: PC 0x%04X, not 0x%04X", loaded.CPU.PC, g.CPU.PC)
+	}
+}
+
+func TestLoadStateFails(t *testing.T) {
+	g := newStateGameBoy(t, "STATE")
+	g.RunCycles(100000)
+	var state bytes.Buffer
+	g.SaveState(&state)
+
+	other := newStateGameBoy(t, "OTHER")
+	other.RunCycles(5000)
+	var before, after bytes.Buffer
+	other.SaveState(&before)
+	if err := other.LoadState(bytes.NewReader(state.Bytes())); !errors.Is(err, cartridge.ErrWrongCartridge) {
+		t.Errorf("Loaded another game's state: %v", err)
+	}
+	other.SaveState(&after)
+	if !bytes.Equal(before.Bytes(), after.Bytes()) {
+		t.Error("Failed load changed the machine")
+	}
+
+	boot := make([]byte, 0x100)
+	booting := newGameBoy(t, testCart(t, spin, nil), Options{BootROM: boot})
+	state.Reset()
+	booting.SaveState(&state)
+	plain := newTestGameBoy(t, spin, nil)
+	if err := plain.LoadState(&state); !errors.Is(err, ErrStateNoBoot) {
+		t.Errorf("Loaded a boot ROM state without one: %v", err)
+	}
+}
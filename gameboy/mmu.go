@@ -0,0 +1,208 @@
+package gameboy
+
+import (
+	"github.com/zbyrne/golangboy/apu"
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/joypad"
+	"github.com/zbyrne/golangboy/ppu"
+	"github.com/zbyrne/golangboy/serial"
+	"github.com/zbyrne/golangboy/sgb"
+	"github.com/zbyrne/golangboy/timer"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+const (
+	P1   uint16 = 0xFF00
+	IF   uint16 = 0xFF0F
+	DMA  uint16 = 0xFF46
+	KEY1 uint16 = 0xFF4D
+	BOOT uint16 = 0xFF50
+	SVBK uint16 = 0xFF70
+	IE   uint16 = 0xFFFF
+)
+
+// MMU is the CPU's view of the address space. It decodes addresses and
+// hands them to the component that owns them.
+type MMU struct {
+	boot        []byte
+	bootEnabled bool
+	// wram is 8 banks of 4K. Bank 0 is at 0xC000 and SVBK picks the one
+	// at 0xD000, which is always 1 outside CGB mode.
+	wram   [0x8000]byte
+	svbk   byte
+	hram   [0x7F]byte
+	dma    byte
+	doctor bool
+
+	// cgb is set when a CGB runs a game in CGB mode, which gives it KEY1.
+	cgb         bool
+	speedArmed  bool
+	doubleSpeed bool
+
+	cart   *cartridge.Cartridge
+	cpu    *z80.Z80
+	ppu    *ppu.PPU
+	apu    *apu.APU
+	timer  *timer.Timer
+	joypad *joypad.Joypad
+	serial *serial.Port
+	// sgb watches P1 when the cartridge talks to a Super Game Boy.
+	sgb *sgb.SGB
+}
+
+func (m *MMU) ReadByte(addr uint16) byte {
+	switch {
+	case addr < 0x8000:
+		// A CGB's boot ROM leaves the cartridge header showing through.
+		if m.bootEnabled && (addr < 0x100 || addr >= 0x200) && int(addr) < len(m.boot) {
+			return m.boot[addr]
+		}
+		return m.cart.ReadByte(addr)
+	case addr < 0xA000:
+		return m.ppu.ReadByte(addr)
+	case addr < 0xC000:
+		return m.cart.ReadByte(addr)
+	case addr < 0xE000:
+		return m.wram[m.wramIndex(addr)]
+	case addr < 0xFE00:
+		// Echo of work RAM.
+		return m.wram[m.wramIndex(addr-0x2000)]
+	case addr < 0xFEA0:
+		return m.ppu.ReadByte(addr)
+	case addr < 0xFF00:
+		return 0xFF
+	case addr >= 0xFF80 && addr < 0xFFFF:
+		return m.hram[addr-0xFF80]
+	}
+	switch {
+	case addr == P1:
+		if m.sgb != nil {
+			return m.sgb.ReadP1(m.joypad.ReadByte(addr))
+		}
+		return m.joypad.ReadByte(addr)
+	case addr == serial.SB || addr == serial.SC:
+		return m.serial.ReadByte(addr)
+	case addr >= timer.DIV && addr <= timer.TAC:
+		return m.timer.ReadByte(addr)
+	case addr == IF:
+		return m.cpu.IF | 0xE0
+	case addr >= apu.NR10 && addr < apu.WAVE_RAM+0x10:
+		return m.apu.ReadByte(addr)
+	case addr == DMA:
+		return m.dma
+	case m.cgb && (addr == ppu.VBK || addr >= ppu.BCPS && addr <= ppu.OCPD):
+		return m.ppu.ReadByte(addr)
+	case addr == SVBK && m.cgb:
+		return 0xF8 | m.svbk
+	case addr == KEY1 && m.cgb:
+		val := byte(0x7E)
+		if m.doubleSpeed {
+			val |= 0x80
+		}
+		if m.speedArmed {
+			val |= 0x01
+		}
+		return val
+	case addr == ppu.LY && m.doctor:
+		return 0x90
+	case addr >= ppu.LCDC && addr <= ppu.WX:
+		return m.ppu.ReadByte(addr)
+	case addr == IE:
+		return m.cpu.IE
+	}
+	return 0xFF
+}
+
+func (m *MMU) WriteByte(addr uint16, val byte) {
+	switch {
+	case addr < 0x8000:
+		m.cart.WriteByte(addr, val)
+		return
+	case addr < 0xA000:
+		m.ppu.WriteByte(addr, val)
+		return
+	case addr < 0xC000:
+		m.cart.WriteByte(addr, val)
+		return
+	case addr < 0xE000:
+		m.wram[m.wramIndex(addr)] = val
+		return
+	case addr < 0xFE00:
+		m.wram[m.wramIndex(addr-0x2000)] = val
+		return
+	case addr < 0xFEA0:
+		m.ppu.WriteByte(addr, val)
+		return
+	case addr < 0xFF00:
+		return
+	case addr >= 0xFF80 && addr < 0xFFFF:
+		m.hram[addr-0xFF80] = val
+		return
+	}
+	switch {
+	case addr == P1:
+		m.joypad.WriteByte(addr, val)
+		if m.sgb != nil {
+			m.sgb.WriteP1(val)
+		}
+	case addr == serial.SB || addr == serial.SC:
+		m.serial.WriteByte(addr, val)
+	case addr >= timer.DIV && addr <= timer.TAC:
+		m.timer.WriteByte(addr, val)
+	case addr == IF:
+		m.cpu.IF = val & 0x1F
+	case addr >= apu.NR10 && addr < apu.WAVE_RAM+0x10:
+		m.apu.WriteByte(addr, val)
+	case addr == DMA:
+		m.oamDMA(val)
+	case addr == KEY1 && m.cgb:
+		m.speedArmed = val&1 != 0
+	case m.cgb && (addr == ppu.VBK || addr >= ppu.BCPS && addr <= ppu.OCPD):
+		m.ppu.WriteByte(addr, val)
+	case addr == SVBK && m.cgb:
+		m.svbk = val & 7
+	case addr >= ppu.LCDC && addr <= ppu.WX:
+		m.ppu.WriteByte(addr, val)
+	case addr == BOOT:
+		if val != 0 {
+			m.bootEnabled = false
+		}
+	case addr == IE:
+		m.cpu.IE = val
+	}
+}
+
+// WRAMBank is the work RAM bank at 0xD000.
+func (m *MMU) WRAMBank() int {
+	if m.svbk == 0 {
+		return 1
+	}
+	return int(m.svbk)
+}
+
+// wramIndex finds an address from 0xC000 to 0xDFFF in wram.
+func (m *MMU) wramIndex(addr uint16) int {
+	if addr < 0xD000 {
+		return int(addr - 0xC000)
+	}
+	return m.WRAMBank()*0x1000 + int(addr-0xD000)
+}
+
+// oamDMA copies a page into OAM. The copy happens all at once rather than
+// over 160 machine cycles.
+func (m *MMU) oamDMA(val byte) {
+	m.dma = val
+	src := uint16(val) << 8
+	for i := uint16(0); i < 0xA0; i++ {
+		m.ppu.OAM[i] = m.ReadByte(src + i)
+	}
+}
+
+func (m *MMU) ReadWord(addr uint16) uint16 {
+	return uint16(m.ReadByte(addr)) | uint16(m.ReadByte(addr+1))<<8
+}
+
+func (m *MMU) WriteWord(addr uint16, val uint16) {
+	m.WriteByte(addr, byte(val))
+	m.WriteByte(addr+1, byte(val>>8))
+}
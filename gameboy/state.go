@@ -0,0 +1,135 @@
+package gameboy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/zbyrne/golangboy/apu"
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/joypad"
+	"github.com/zbyrne/golangboy/ppu"
+	"github.com/zbyrne/golangboy/savestate"
+	"github.com/zbyrne/golangboy/serial"
+	"github.com/zbyrne/golangboy/sgb"
+	"github.com/zbyrne/golangboy/timer"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+// STATE_VERSION is the version of the machine and MMU save state chunks.
+const STATE_VERSION = 1
+
+var (
+	ErrStateModel  = errors.New("gameboy: state was saved from another model")
+	ErrStateNoBoot = errors.New("gameboy: state was saved running a boot ROM")
+)
+
+type chunk struct {
+	tag     string
+	version int
+	c       savestate.Component
+}
+
+func (g *GameBoy) chunks() []chunk {
+	chunks := []chunk{
+		{"GB  ", STATE_VERSION, machine{g}},
+		{"CPU ", z80.STATE_VERSION, g.CPU},
+		{"MMU ", STATE_VERSION, g.MMU},
+		{"CART", cartridge.STATE_VERSION, g.Cart},
+		{"PPU ", ppu.STATE_VERSION, g.PPU},
+		{"APU ", apu.STATE_VERSION, g.APU},
+		{"TIMR", timer.STATE_VERSION, g.Timer},
+		{"SERL", serial.STATE_VERSION, g.Serial},
+		{"JOYP", joypad.STATE_VERSION, g.Joypad},
+	}
+	if g.SGB != nil {
+		chunks = append(chunks, chunk{"SGB ", sgb.STATE_VERSION, g.SGB})
+	}
+	return chunks
+}
+
+// SaveState snapshots the whole machine. Running on from a loaded state
+// gives exactly the same output as running on from when it was saved.
+// What's plugged into the serial port, the boot ROM and the audio sink
+// aren't part of the state.
+func (g *GameBoy) SaveState(w io.Writer) error {
+	sw := savestate.NewWriter(w)
+	for _, c := range g.chunks() {
+		sw.Chunk(c.tag, c.version, c.c)
+	}
+	return sw.Err()
+}
+
+// LoadState restores a snapshot made by SaveState on a GameBoy with the
+// same cartridge and model. If it fails the machine is left as it was.
+func (g *GameBoy) LoadState(r io.Reader) error {
+	sr, err := savestate.NewReader(r)
+	if err != nil {
+		return err
+	}
+	var backup bytes.Buffer
+	if err := g.SaveState(&backup); err != nil {
+		return err
+	}
+	for _, c := range g.chunks() {
+		if err := sr.Chunk(c.tag, c.c); err != nil {
+			g.restore(&backup)
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *GameBoy) restore(backup io.Reader) {
+	sr, _ := savestate.NewReader(backup)
+	for _, c := range g.chunks() {
+		sr.Chunk(c.tag, c.c)
+	}
+}
+
+// machine saves what belongs to the GameBoy itself.
+type machine struct {
+	g *GameBoy
+}
+
+func (m machine) SaveState(e *savestate.Encoder) {
+	e.Int(int(m.g.model))
+	e.Uint64(m.g.cycles)
+	e.Int(int(m.g.fast))
+}
+
+func (m machine) LoadState(d *savestate.Decoder) {
+	if Model(d.Int()) != m.g.model {
+		d.Fail(ErrStateModel)
+		return
+	}
+	m.g.cycles = d.Uint64()
+	m.g.fast = z80.ClockTicks(d.Int())
+}
+
+func (m *MMU) SaveState(e *savestate.Encoder) {
+	e.Bool(m.bootEnabled)
+	e.Bytes(m.wram[:0x2000])
+	e.Bytes(m.hram[:])
+	e.Byte(m.dma)
+	e.Bool(m.speedArmed)
+	e.Bool(m.doubleSpeed)
+	e.Bytes(m.wram[0x2000:])
+	e.Byte(m.svbk)
+}
+
+func (m *MMU) LoadState(d *savestate.Decoder) {
+	bootEnabled := d.Bool()
+	if bootEnabled && m.boot == nil {
+		d.Fail(ErrStateNoBoot)
+		return
+	}
+	m.bootEnabled = bootEnabled
+	d.Bytes(m.wram[:0x2000])
+	d.Bytes(m.hram[:])
+	m.dma = d.Byte()
+	m.speedArmed = d.Bool()
+	m.doubleSpeed = d.Bool()
+	d.Bytes(m.wram[0x2000:])
+	m.svbk = d.Byte()
+}
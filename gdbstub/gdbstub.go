@@ -0,0 +1,451 @@
+// Package gdbstub serves the GDB remote serial protocol so GDB compatible
+// front ends can drive the debugger.
+//
+// The register file is A, F, B, C, D, E, H and L as one byte each, then SP
+// and PC as little endian words. Breakpoint addresses above 0xFFFF carry
+// a ROM bank in the upper bits, so 0x24000 is 02:4000.
+package gdbstub
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/zbyrne/golangboy/debugger"
+)
+
+const targetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.golangboy.sm83">
+    <reg name="a" bitsize="8" regnum="0"/>
+    <reg name="f" bitsize="8"/>
+    <reg name="b" bitsize="8"/>
+    <reg name="c" bitsize="8"/>
+    <reg name="d" bitsize="8"/>
+    <reg name="e" bitsize="8"/>
+    <reg name="h" bitsize="8"/>
+    <reg name="l" bitsize="8"/>
+    <reg name="sp" bitsize="16" type="data_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+  </feature>
+</target>
+`
+
+// PACKET_SIZE is the longest packet the stub takes or sends. Memory
+// reads and writes are at most half that, since each byte is two hex
+// digits.
+const (
+	PACKET_SIZE = 0x4000
+	maxMemory   = PACKET_SIZE / 2
+)
+
+var registers = []string{"A", "F", "B", "C", "D", "E", "H", "L", "SP", "PC"}
+
+// Server handles one connection at a time.
+type Server struct {
+	d *debugger.Debugger
+	// points maps a Z packet's type and address to what it created.
+	points map[string][]int
+	last   debugger.Stop
+	noAck  int32
+	hangup error
+}
+
+func NewServer(d *debugger.Debugger) *Server {
+	return &Server{d: d, points: map[string][]int{}, last: debugger.Stop{Reason: debugger.INTERRUPTED}}
+}
+
+// ListenAndServe accepts debugger connections on addr, one after another.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		err = s.Serve(conn)
+		conn.Close()
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+}
+
+// event is a packet or a break request from the client.
+type event struct {
+	packet string
+	brk    bool
+	err    error
+}
+
+// readEvents parses the client's stream until it fails or quit closes.
+func (s *Server) readEvents(r *bufio.Reader, w io.Writer, events chan<- event, quit <-chan struct{}) {
+	send := func(ev event) bool {
+		select {
+		case events <- ev:
+			return true
+		case <-quit:
+			return false
+		}
+	}
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			send(event{err: err})
+			return
+		}
+		switch c {
+		case 0x03:
+			if !send(event{brk: true}) {
+				return
+			}
+		case '$':
+			data, long, err := readPacket(r)
+			if err != nil {
+				send(event{err: err})
+				return
+			}
+			var sum [2]byte
+			if _, err := io.ReadFull(r, sum[:]); err != nil {
+				send(event{err: err})
+				return
+			}
+			if long {
+				w.Write([]byte("-"))
+				continue
+			}
+			if atomic.LoadInt32(&s.noAck) == 0 {
+				want, _ := strconv.ParseUint(string(sum[:]), 16, 8)
+				if byte(want) != checksum(data) {
+					w.Write([]byte("-"))
+					continue
+				}
+				w.Write([]byte("+"))
+			}
+			if !send(event{packet: data}) {
+				return
+			}
+		}
+	}
+}
+
+// readPacket reads a packet's data up to the #, keeping no more than
+// PACKET_SIZE bytes of it and reporting whether there was more.
+func readPacket(r *bufio.Reader) (string, bool, error) {
+	var data []byte
+	long := false
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", false, err
+		}
+		if c == '#' {
+			return string(data), long, nil
+		}
+		if len(data) == PACKET_SIZE {
+			long = true
+		} else {
+			data = append(data, c)
+		}
+	}
+}
+
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// Serve talks to one client until it detaches or the connection drops.
+func (s *Server) Serve(conn io.ReadWriter) error {
+	events := make(chan event)
+	quit := make(chan struct{})
+	defer close(quit)
+	atomic.StoreInt32(&s.noAck, 0)
+	s.hangup = nil
+	go s.readEvents(bufio.NewReader(conn), conn, events, quit)
+	for {
+		ev := <-events
+		if ev.err != nil {
+			return ev.err
+		}
+		if ev.brk {
+			continue
+		}
+		reply, done := s.handle(ev.packet, events)
+		if s.hangup != nil {
+			return s.hangup
+		}
+		if _, err := fmt.Fprintf(conn, "$%s#%02x", reply, checksum(reply)); err != nil {
+			return err
+		}
+		if ev.packet == "QStartNoAckMode" {
+			// Only stop acking once the reply has gone out.
+			atomic.StoreInt32(&s.noAck, 1)
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+func (s *Server) handle(p string, events <-chan event) (string, bool) {
+	switch {
+	case p == "?":
+		return s.stopReply(s.last), false
+	case strings.HasPrefix(p, "qSupported"):
+		return fmt.Sprintf("PacketSize=%x;qXfer:features:read+;swbreak+;hwbreak+;QStartNoAckMode+;vContSupported+", PACKET_SIZE), false
+	case p == "QStartNoAckMode":
+		return "OK", false
+	case strings.HasPrefix(p, "qXfer:features:read:target.xml:"):
+		return xfer(targetXML, p[len("qXfer:features:read:target.xml:"):]), false
+	case p == "qAttached":
+		return "1", false
+	case p == "qC":
+		return "QC1", false
+	case p == "qfThreadInfo":
+		return "m1", false
+	case p == "qsThreadInfo":
+		return "l", false
+	case strings.HasPrefix(p, "H"), strings.HasPrefix(p, "T"):
+		return "OK", false
+	case p == "g":
+		var buf []byte
+		for _, name := range registers {
+			buf = append(buf, s.register(name)...)
+		}
+		return hex.EncodeToString(buf), false
+	case strings.HasPrefix(p, "G"):
+		return s.writeRegisters(p[1:]), false
+	case strings.HasPrefix(p, "p"):
+		n, err := strconv.ParseUint(p[1:], 16, 8)
+		if err != nil || int(n) >= len(registers) {
+			return "E01", false
+		}
+		return hex.EncodeToString(s.register(registers[n])), false
+	case strings.HasPrefix(p, "P"):
+		return s.writeRegister(p[1:]), false
+	case strings.HasPrefix(p, "m"):
+		addr, n, err := addrLen(p[1:])
+		if err != nil || n > maxMemory {
+			return "E01", false
+		}
+		return hex.EncodeToString(s.d.ReadMemory(uint16(addr), n)), false
+	case strings.HasPrefix(p, "M"):
+		parts := strings.SplitN(p[1:], ":", 2)
+		addr, n, err := addrLen(parts[0])
+		if err != nil || len(parts) != 2 || n > maxMemory {
+			return "E01", false
+		}
+		data, err := hex.DecodeString(parts[1])
+		if err != nil || len(data) != n {
+			return "E01", false
+		}
+		s.d.WriteMemory(uint16(addr), data)
+		return "OK", false
+	case strings.HasPrefix(p, "Z"), strings.HasPrefix(p, "z"):
+		return s.point(p), false
+	case p == "vCont?":
+		return "vCont;c;C;s;S", false
+	case strings.HasPrefix(p, "vCont;"):
+		action := p[len("vCont;"):]
+		if strings.HasPrefix(action, "s") || strings.HasPrefix(action, "S") {
+			return s.resume(s.d.Step, events), false
+		}
+		return s.resume(s.d.Continue, events), false
+	case strings.HasPrefix(p, "c"), strings.HasPrefix(p, "s"):
+		if len(p) > 1 {
+			addr, err := strconv.ParseUint(p[1:], 16, 16)
+			if err != nil {
+				return "E01", false
+			}
+			s.d.SetRegister("PC", uint16(addr))
+		}
+		if p[0] == 's' {
+			return s.resume(s.d.Step, events), false
+		}
+		return s.resume(s.d.Continue, events), false
+	case p == "D", strings.HasPrefix(p, "D;"):
+		return "OK", true
+	case p == "k":
+		return "", true
+	}
+	return "", false
+}
+
+// xfer serves one chunk of a qXfer object given an "offset,length" request.
+func xfer(doc, req string) string {
+	off, n, err := addrLen(req)
+	if err != nil {
+		return "E01"
+	}
+	if off >= len(doc) {
+		return "l"
+	}
+	if off+n >= len(doc) {
+		return "l" + doc[off:]
+	}
+	return "m" + doc[off:off+n]
+}
+
+func addrLen(s string) (int, int, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected addr,length")
+	}
+	addr, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := strconv.ParseUint(parts[1], 16, 32)
+	return int(addr), int(n), err
+}
+
+func (s *Server) register(name string) []byte {
+	val, _ := s.d.Register(name)
+	if name == "SP" || name == "PC" {
+		return []byte{byte(val), byte(val >> 8)}
+	}
+	return []byte{byte(val)}
+}
+
+func (s *Server) writeRegisters(data string) string {
+	buf, err := hex.DecodeString(data)
+	if err != nil || len(buf) != 12 {
+		return "E01"
+	}
+	for i, name := range registers[:8] {
+		s.d.SetRegister(name, uint16(buf[i]))
+	}
+	s.d.SetRegister("SP", uint16(buf[8])|uint16(buf[9])<<8)
+	s.d.SetRegister("PC", uint16(buf[10])|uint16(buf[11])<<8)
+	return "OK"
+}
+
+func (s *Server) writeRegister(req string) string {
+	parts := strings.SplitN(req, "=", 2)
+	n, err := strconv.ParseUint(parts[0], 16, 8)
+	if err != nil || len(parts) != 2 || int(n) >= len(registers) {
+		return "E01"
+	}
+	buf, err := hex.DecodeString(parts[1])
+	if err != nil || len(buf) == 0 {
+		return "E01"
+	}
+	val := uint16(buf[0])
+	if len(buf) > 1 {
+		val |= uint16(buf[1]) << 8
+	}
+	s.d.SetRegister(registers[n], val)
+	return "OK"
+}
+
+// point handles Z and z packets. Software and hardware breakpoints are
+// the same thing here. Types 2, 3 and 4 are write, read and access
+// watchpoints, which cover every byte of the given length.
+func (s *Server) point(p string) string {
+	parts := strings.Split(p[1:], ",")
+	if len(parts) < 3 {
+		return "E01"
+	}
+	addr, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return "E01"
+	}
+	n, err := strconv.ParseUint(parts[2], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	key := parts[0] + "," + parts[1]
+	if p[0] == 'z' {
+		for _, id := range s.points[key] {
+			s.d.Delete(id)
+		}
+		delete(s.points, key)
+		return "OK"
+	}
+	if _, ok := s.points[key]; ok {
+		return "OK"
+	}
+	var ids []int
+	switch parts[0] {
+	case "0", "1":
+		bank := debugger.ANY_BANK
+		if addr > 0xFFFF {
+			bank = int(addr >> 16)
+		}
+		ids = append(ids, s.d.AddBreakpoint(bank, uint16(addr)).ID)
+	case "2", "3", "4":
+		kind := map[string]int{
+			"2": debugger.WATCH_WRITE,
+			"3": debugger.WATCH_READ,
+			"4": debugger.WATCH_READ | debugger.WATCH_WRITE,
+		}[parts[0]]
+		if n == 0 {
+			n = 1
+		}
+		ids = append(ids, s.d.AddWatchRange(uint16(addr), int(n), kind).ID)
+	default:
+		return ""
+	}
+	s.points[key] = ids
+	return "OK"
+}
+
+// resume runs the debugger while watching for a break from the client.
+func (s *Server) resume(run func() debugger.Stop, events <-chan event) string {
+	done := make(chan debugger.Stop)
+	go func() {
+		done <- run()
+	}()
+	for {
+		select {
+		case stop := <-done:
+			s.last = stop
+			return s.stopReply(stop)
+		case ev := <-events:
+			// The client can only break or hang up while we're running.
+			if ev.err != nil {
+				s.hangup = ev.err
+			}
+			if ev.brk || ev.err != nil {
+				s.d.Interrupt()
+			}
+		}
+	}
+}
+
+func (s *Server) stopReply(stop debugger.Stop) string {
+	switch stop.Reason {
+	case debugger.BREAKPOINT:
+		return "T05swbreak:;"
+	case debugger.WATCHPOINT:
+		kind := "rwatch"
+		for _, wp := range s.d.Watchpoints() {
+			if wp.ID == stop.ID && wp.Kind == debugger.WATCH_READ|debugger.WATCH_WRITE {
+				kind = "awatch"
+			}
+		}
+		if stop.Write && kind != "awatch" {
+			kind = "watch"
+		}
+		return fmt.Sprintf("T05%s:%x;", kind, stop.Addr)
+	case debugger.INTERRUPTED:
+		return "T02"
+	case debugger.LOCKED:
+		return "T04"
+	}
+	return "T05"
+}
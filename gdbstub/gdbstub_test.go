@@ -0,0 +1,165 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zbyrne/golangboy/asm"
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/debugger"
+	"github.com/zbyrne/golangboy/gameboy"
+)
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	ack  bool
+}
+
+func (c *client) send(p string) {
+	fmt.Fprintf(c.conn, "$%s#%02x", p, checksum(p))
+	if c.ack {
+		if b, _ := c.r.ReadByte(); b != '+' {
+			c.t.Fatalf("%s acked with %q", p, b)
+		}
+	}
+}
+
+func (c *client) reply() string {
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.r.Discard(2)
+	if c.ack {
+		c.conn.Write([]byte("+"))
+	}
+	return data[:len(data)-1]
+}
+
+func (c *client) call(p, want string) {
+	c.send(p)
+	if got := c.reply(); got != want {
+		c.t.Errorf("%s returned %q, not %q", p, got, want)
+	}
+}
+
+func newClient(t *testing.T, src string) (*client, *debugger.Debugger, chan error) {
+	rom, err := asm.ROM(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, _ := cartridge.New(rom)
+	gb, _ := gameboy.New(cart, gameboy.Options{})
+	d := debugger.New(gb)
+	server, conn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(d).Serve(server)
+	}()
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn), ack: true}, d, done
+}
+
+const program = `
+SECTION "main", ROM0[$100]
+	ld a, $12
+	ld [$C000], a
+	ld a, [$C001]
+Spin:
+	jr Spin
+`
+
+func TestRegistersAndMemory(t *testing.T) {
+	c, d, _ := newClient(t, program)
+	c.call("qSupported:swbreak+", "PacketSize=4000;qXfer:features:read+;swbreak+;hwbreak+;QStartNoAckMode+;vContSupported+")
+	c.call("?", "T02")
+	c.call("g", "01b0001300d8014dfeff0001")
+	c.call("p9", "0001")
+	c.call("P0=42", "OK")
+	c.call("p0", "42")
+	c.call("G0102030405060708feff5001", "OK")
+	if d.GB.CPU.PC != 0x150 || d.GB.CPU.H != 0x07 || d.GB.CPU.F != 0x00 {
+		t.Errorf("G left PC = 0x%04X H = 0x%02X F = 0x%02X", d.GB.CPU.PC, d.GB.CPU.H, d.GB.CPU.F)
+	}
+	c.call("Mc000,2:abcd", "OK")
+	c.call("mc000,3", "abcd00")
+	// Lengths that wouldn't fit in a packet.
+	c.call("m0,ffffffff", "E01")
+	c.call("m0,2001", "E01")
+	c.call("M0,2001:00", "E01")
+	c.call("p1a", "E01")
+	c.call("qXfer:features:read:target.xml:0,a", "m<?xml vers")
+	c.call("vMustReplyEmpty", "")
+}
+
+func TestStepAndBreakpoints(t *testing.T) {
+	c, _, done := newClient(t, program)
+	c.call("QStartNoAckMode", "OK")
+	c.ack = false
+	c.call("s", "T05")
+	c.call("p9", "0201")
+	c.call("Z0,108,1", "OK")
+	c.call("c", "T05swbreak:;")
+	c.call("p9", "0801")
+	c.call("z0,108,1", "OK")
+	c.call("s100", "T05")
+	c.call("Z2,c000,1", "OK")
+	c.call("Z3,c001,1", "OK")
+	c.call("vCont;c", "T05watch:c000;")
+	c.call("vCont;c", "T05rwatch:c001;")
+	c.call("D", "OK")
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWatchRange(t *testing.T) {
+	c, d, done := newClient(t, program)
+	c.call("QStartNoAckMode", "OK")
+	c.ack = false
+	c.call("Z2,b000,ffff", "OK")
+	if n := len(d.Watchpoints()); n != 1 {
+		t.Errorf("Watching a range made %d watchpoints", n)
+	}
+	c.call("c", "T05watch:c000;")
+	c.call("z2,b000,ffff", "OK")
+	c.call("D", "OK")
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBreakWhileRunning(t *testing.T) {
+	c, _, _ := newClient(t, program)
+	c.send("c")
+	time.Sleep(10 * time.Millisecond)
+	c.conn.Write([]byte{0x03})
+	if got := c.reply(); got != "T02" {
+		t.Errorf("Break returned %q", got)
+	}
+	c.send("?")
+	if got := c.reply(); !strings.HasPrefix(got, "T02") {
+		t.Errorf("? returned %q", got)
+	}
+}
+
+func TestBadChecksum(t *testing.T) {
+	c, _, _ := newClient(t, program)
+	c.conn.Write([]byte("$g#00"))
+	if b, _ := c.r.ReadByte(); b != '-' {
+		t.Errorf("Bad packet answered with %q", b)
+	}
+	// Too long to take, whatever the checksum.
+	c.conn.Write([]byte("$" + strings.Repeat("0", PACKET_SIZE+1) + "#00"))
+	if b, _ := c.r.ReadByte(); b != '-' {
+		t.Errorf("Long packet answered with %q", b)
+	}
+}
@@ -0,0 +1,135 @@
+// Package golden records hashes of the frames a ROM draws and checks later
+// runs against them, so a CPU or PPU change that alters what's on screen
+// is caught at the first frame it shows up in.
+//
+// A golden file is text with a frame number, counting from 1, and the
+// frame's hash in hex on each line, frames in order. Blank lines and lines
+// starting with # are ignored.
+package golden
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+
+	"github.com/zbyrne/golangboy/gameboy"
+)
+
+var (
+	ErrFormat = errors.New("golden: malformed file")
+	ErrEnded  = errors.New("golden: run ended before the last golden frame")
+)
+
+// Entry is the hash of a finished frame.
+type Entry struct {
+	Frame int
+	Hash  uint64
+}
+
+// MismatchError is the first frame that didn't match its golden hash.
+type MismatchError struct {
+	Frame     int
+	Want, Got uint64
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("golden: frame %d hashes to %016x, not %016x", e.Frame, e.Got, e.Want)
+}
+
+// Hash hashes the GameBoy's last frame, along with its colours in CGB
+// mode, where the frame holds colour numbers rather than shades.
+func Hash(g *gameboy.GameBoy) uint64 {
+	h := fnv.New64a()
+	h.Write(g.Frame())
+	if colors := g.Colors(); colors != nil {
+		binary.Write(h, binary.LittleEndian, colors)
+	}
+	return h.Sum64()
+}
+
+// Run runs frames frames and hashes every every'th one and the last. An
+// every below 1 hashes only the last.
+func Run(g *gameboy.GameBoy, frames, every int) []Entry {
+	var entries []Entry
+	for n := 1; n <= frames; n++ {
+		g.RunFrame()
+		if (every > 0 && n%every == 0) || n == frames {
+			entries = append(entries, Entry{n, Hash(g)})
+		}
+	}
+	return entries
+}
+
+// Checker compares frames with golden ones as a run goes.
+type Checker struct {
+	want []Entry
+	next int
+}
+
+func NewChecker(want []Entry) *Checker {
+	return &Checker{want: want}
+}
+
+// Frame checks the GameBoy's last frame as frame n, counting from 1, if
+// it has a golden hash.
+func (c *Checker) Frame(n int, g *gameboy.GameBoy) error {
+	if c.Done() || c.want[c.next].Frame != n {
+		return nil
+	}
+	e := c.want[c.next]
+	c.next++
+	if got := Hash(g); got != e.Hash {
+		return &MismatchError{n, e.Hash, got}
+	}
+	return nil
+}
+
+// Done says whether every golden frame has been checked.
+func (c *Checker) Done() bool {
+	return c.next == len(c.want)
+}
+
+// Compare runs the GameBoy up to the last golden frame and returns a
+// *MismatchError for the first one that differs.
+func Compare(g *gameboy.GameBoy, want []Entry) error {
+	c := NewChecker(want)
+	for n := 1; !c.Done(); n++ {
+		g.RunFrame()
+		if err := c.Frame(n, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Write(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		fmt.Fprintf(bw, "%d %016x\n", e.Frame, e.Hash)
+	}
+	return bw.Flush()
+}
+
+func Read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		var e Entry
+		if _, err := fmt.Sscanf(text, "%d %x", &e.Frame, &e.Hash); err != nil || e.Frame < 1 {
+			return nil, fmt.Errorf("%w: line %d", ErrFormat, line)
+		}
+		if n := len(entries); n > 0 && e.Frame <= entries[n-1].Frame {
+			return nil, fmt.Errorf("%w: line %d is out of order", ErrFormat, line)
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
@@ -0,0 +1,212 @@
+package golden
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/internal/testrom"
+)
+
+// counter draws a checkerboard of tiles with a block sprite on top, then
+// scrolls the background and moves the sprite one pixel every VBlank, so
+// each frame's picture depends on the frame number.
+const counter = `
+SECTION "entry", ROM0[$100]
+	jp start
+
+SECTION "main", ROM0[$150]
+start:
+	; Turn the screen off in VBlank to fill VRAM.
+.off:
+	ldh a, [$44]
+	cp 144
+	jr nz, .off
+	xor a
+	ldh [$40], a
+	; Tile 1 is a checkerboard, tile 2 a solid block.
+	ld hl, $8010
+	ld de, Tiles
+	ld c, 32
+.tiles:
+	ld a, [de]
+	ld [hli], a
+	inc de
+	dec c
+	jr nz, .tiles
+	; Alternate tiles 0 and 1 across the top eight rows of the map.
+	ld hl, $9800
+	ld c, 0
+.map:
+	ld a, l
+	and 1
+	ld [hli], a
+	dec c
+	jr nz, .map
+	ld hl, $FE00
+	ld a, 56
+	ld [hli], a
+	ld a, 8
+	ld [hli], a
+	ld a, 2
+	ld [hli], a
+	xor a
+	ld [hli], a
+	ld a, $E4
+	ldh [$47], a
+	ldh [$48], a
+	; LCD on, tile data at $8000, sprites and background on.
+	ld a, $93
+	ldh [$40], a
+	ld b, 0
+.loop:
+	ldh a, [$44]
+	cp 144
+	jr nz, .loop
+	inc b
+	ld a, b
+	ldh [$43], a
+	ld [$FE01], a
+.next:
+	ldh a, [$44]
+	cp 144
+	jr z, .next
+	jr .loop
+Tiles:
+	DB $AA, $55, $55, $AA, $AA, $55, $55, $AA
+	DB $AA, $55, $55, $AA, $AA, $55, $55, $AA
+	DB $FF, $FF, $FF, $FF, $FF, $FF, $FF, $FF
+	DB $FF, $FF, $FF, $FF, $FF, $FF, $FF, $FF
+`
+
+// palettes fades background colour 0 up a step every VBlank in CGB mode,
+// so its frames differ only in their colours.
+const palettes = `
+SECTION "entry", ROM0[$100]
+	jp start
+
+SECTION "flag", ROM0[$143]
+	DB $80
+
+SECTION "main", ROM0[$150]
+start:
+	ld b, 0
+.loop:
+	ldh a, [$44]
+	cp 144
+	jr nz, .loop
+	inc b
+	ld a, $80
+	ldh [$68], a
+	ld a, b
+	ldh [$69], a
+	xor a
+	ldh [$69], a
+.next:
+	ldh a, [$44]
+	cp 144
+	jr z, .next
+	jr .loop
+`
+
+// updateEnv names the environment variable that makes check rewrite its
+// golden file instead of comparing against it.
+const updateEnv = "GOLDEN_UPDATE"
+
+// check compares a run against the golden file at path, failing the test
+// at the first frame that differs. With $GOLDEN_UPDATE set it runs frames
+// frames and writes the file instead, hashing every every'th frame.
+func check(t *testing.T, g *gameboy.GameBoy, path string, frames, every int) {
+	t.Helper()
+	if os.Getenv(updateEnv) != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Write(f, Run(g, frames, every))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%v (set %s=1 to record it)", err, updateEnv)
+	}
+	want, err := Read(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Compare(g, want); err != nil {
+		t.Error(err)
+	}
+}
+
+func newGameBoy(t *testing.T) *gameboy.GameBoy {
+	return testrom.New(t, counter, gameboy.Options{})
+}
+
+func TestGolden(t *testing.T) {
+	check(t, newGameBoy(t), "testdata/counter.golden", 120, 7)
+}
+
+func TestGoldenCGB(t *testing.T) {
+	check(t, testrom.New(t, palettes, gameboy.Options{Model: gameboy.CGB}), "testdata/palettes.golden", 60, 5)
+}
+
+func TestHashColors(t *testing.T) {
+	g := testrom.New(t, palettes, gameboy.Options{Model: gameboy.CGB})
+	g.RunFrame()
+	frame := append([]byte(nil), g.Frame()...)
+	hash := Hash(g)
+	g.RunFrame()
+	if !bytes.Equal(g.Frame(), frame) {
+		t.Fatal("Colour numbers changed between frames")
+	}
+	if Hash(g) == hash {
+		t.Error("Frames with different colours hash the same")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	entries := Run(newGameBoy(t), 25, 10)
+	if len(entries) != 3 || entries[2].Frame != 25 || entries[0].Hash == entries[1].Hash {
+		t.Fatalf("Recorded %v", entries)
+	}
+	if last := Run(newGameBoy(t), 5, 0); len(last) != 1 || last[0].Frame != 5 {
+		t.Errorf("Recorded %v with every 0", last)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	read, err := Read(strings.NewReader("# counter\n\n" + buf.String()))
+	if err != nil || len(read) != 3 || read[1] != entries[1] {
+		t.Fatalf("Read back %v, %v", read, err)
+	}
+	if err := Compare(newGameBoy(t), read); err != nil {
+		t.Error(err)
+	}
+
+	read[1].Hash ^= 1
+	read[2].Hash ^= 1
+	var mismatch *MismatchError
+	if err := Compare(newGameBoy(t), read); !errors.As(err, &mismatch) || mismatch.Frame != 20 || mismatch.Want != read[1].Hash {
+		t.Errorf("Compare returned %v", err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, in := range []string{"1", "x 0123", "0 0123", "2 0123\n1 0123"} {
+		if _, err := Read(strings.NewReader(in)); !errors.Is(err, ErrFormat) {
+			t.Errorf("Read %q: %v", in, err)
+		}
+	}
+}
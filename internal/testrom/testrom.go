@@ -0,0 +1,49 @@
+// Package testrom builds Game Boys running small assembled programs, for
+// the tests of packages that drive a whole machine.
+package testrom
+
+import (
+	"testing"
+
+	"github.com/zbyrne/golangboy/asm"
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/joypad"
+)
+
+// ROM assembles src with header copied over the cartridge header, as
+// asm.ROM does.
+func ROM(t testing.TB, src string, header ...byte) []byte {
+	t.Helper()
+	rom, err := asm.ROM(src, header...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rom
+}
+
+// GameBoy starts a Game Boy with rom in its cartridge slot.
+func GameBoy(t testing.TB, rom []byte, opts gameboy.Options) *gameboy.GameBoy {
+	t.Helper()
+	cart, err := cartridge.New(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gameboy.New(cart, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// New assembles src and starts a Game Boy running it.
+func New(t testing.TB, src string, opts gameboy.Options) *gameboy.GameBoy {
+	t.Helper()
+	return GameBoy(t, ROM(t, src), opts)
+}
+
+// Press picks the directions held on a frame, a pattern that changes from
+// one frame to the next with step.
+func Press(frame, step int) joypad.Button {
+	return joypad.Button(frame*step) & (joypad.RIGHT | joypad.LEFT | joypad.UP | joypad.DOWN)
+}
@@ -0,0 +1,110 @@
+package joypad
+
+import (
+	"sync"
+
+	"github.com/zbyrne/golangboy/z80"
+)
+
+type Button byte
+
+const (
+	RIGHT Button = 1 << iota
+	LEFT
+	UP
+	DOWN
+	A
+	B
+	SELECT
+	START
+)
+
+const (
+	SELECT_DIRECTIONS byte = 1 << 4
+	SELECT_BUTTONS    byte = 1 << 5
+)
+
+// Joypad is the P1 register at 0xFF00. Frontends may call Press, Release
+// and SetButtons from any goroutine, but the new state is only seen by the
+// emulated hardware when the owner calls Latch. Latching at a fixed point
+// (e.g. the start of every frame) keeps replays deterministic.
+type Joypad struct {
+	mu      sync.Mutex
+	pending Button
+
+	pressed Button
+	sel     byte
+	irq     z80.Interrupter
+}
+
+func New(irq z80.Interrupter) *Joypad {
+	return &Joypad{sel: SELECT_DIRECTIONS | SELECT_BUTTONS, irq: irq}
+}
+
+func (j *Joypad) SetButtons(b Button) {
+	j.mu.Lock()
+	j.pending = b
+	j.mu.Unlock()
+}
+
+func (j *Joypad) Press(b Button) {
+	j.mu.Lock()
+	j.pending |= b
+	j.mu.Unlock()
+}
+
+func (j *Joypad) Release(b Button) {
+	j.mu.Lock()
+	j.pending &^= b
+	j.mu.Unlock()
+}
+
+// Latch applies the buttons set since the last call.
+func (j *Joypad) Latch() {
+	j.mu.Lock()
+	b := j.pending
+	j.mu.Unlock()
+	j.update(b, j.sel)
+}
+
+// Pending returns the buttons the next Latch will apply.
+func (j *Joypad) Pending() Button {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.pending
+}
+
+// Buttons returns the currently latched button state.
+func (j *Joypad) Buttons() Button {
+	return j.pressed
+}
+
+// lines returns the four input lines, active low.
+func (j *Joypad) lines(pressed Button, sel byte) byte {
+	var low byte
+	if sel&SELECT_DIRECTIONS == 0 {
+		low |= byte(pressed) & 0x0F
+	}
+	if sel&SELECT_BUTTONS == 0 {
+		low |= byte(pressed>>4) & 0x0F
+	}
+	return ^low & 0x0F
+}
+
+func (j *Joypad) update(pressed Button, sel byte) {
+	before := j.lines(j.pressed, j.sel)
+	after := j.lines(pressed, sel)
+	j.pressed = pressed
+	j.sel = sel
+	if before&^after != 0 && j.irq != nil {
+		j.irq.RequestInterrupt(z80.JOYPAD_INT)
+	}
+}
+
+func (j *Joypad) ReadByte(addr uint16) byte {
+	return 0xC0 | j.sel | j.lines(j.pressed, j.sel)
+}
+
+func (j *Joypad) WriteByte(addr uint16, val byte) {
+	j.update(j.pressed, val&(SELECT_DIRECTIONS|SELECT_BUTTONS))
+}
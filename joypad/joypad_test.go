@@ -0,0 +1,183 @@
+package joypad
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zbyrne/golangboy/savestate"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+type mockInterrupter struct {
+	requested byte
+}
+
+func (m *mockInterrupter) RequestInterrupt(i byte) {
+	m.requested |= i
+}
+
+func TestNew(t *testing.T) {
+	j := New(nil)
+	if val := j.ReadByte(0xFF00); val != 0xFF {
+		t.Errorf("P1 = 0x%02X, not 0xFF", val)
+	}
+}
+
+func TestReadDirections(t *testing.T) {
+	j := New(nil)
+	j.SetButtons(LEFT | DOWN | A)
+	j.Latch()
+	j.WriteByte(0xFF00, SELECT_BUTTONS)
+	if val := j.ReadByte(0xFF00); val != 0xE5 {
+		t.Errorf("P1 = 0x%02X, not 0xE5", val)
+	}
+}
+
+func TestReadButtons(t *testing.T) {
+	j := New(nil)
+	j.SetButtons(LEFT | START | A)
+	j.Latch()
+	j.WriteByte(0xFF00, SELECT_DIRECTIONS)
+	if val := j.ReadByte(0xFF00); val != 0xD6 {
+		t.Errorf("P1 = 0x%02X, not 0xD6", val)
+	}
+}
+
+func TestReadBoth(t *testing.T) {
+	j := New(nil)
+	j.SetButtons(RIGHT | B)
+	j.Latch()
+	j.WriteByte(0xFF00, 0)
+	if val := j.ReadByte(0xFF00); val != 0xCC {
+		t.Errorf("P1 = 0x%02X, not 0xCC", val)
+	}
+}
+
+func TestPendingUntilLatch(t *testing.T) {
+	j := New(nil)
+	j.WriteByte(0xFF00, SELECT_BUTTONS)
+	j.Press(UP)
+	if val := j.ReadByte(0xFF00); val != 0xEF {
+		t.Errorf("P1 = 0x%02X before latch, not 0xEF", val)
+	}
+	j.Latch()
+	if val := j.ReadByte(0xFF00); val != 0xEB {
+		t.Errorf("P1 = 0x%02X after latch, not 0xEB", val)
+	}
+	j.Release(UP)
+	j.Latch()
+	if j.Buttons() != 0 {
+		t.Errorf("Buttons = 0x%02X after release", j.Buttons())
+	}
+}
+
+func TestInterruptOnPress(t *testing.T) {
+	var irq mockInterrupter
+	j := New(&irq)
+	j.WriteByte(0xFF00, SELECT_DIRECTIONS)
+	j.Press(START)
+	j.Latch()
+	if irq.requested != z80.JOYPAD_INT {
+		t.Errorf("Requested 0x%02X, not the joypad interrupt", irq.requested)
+	}
+}
+
+func TestNoInterruptWhenUnselected(t *testing.T) {
+	var irq mockInterrupter
+	j := New(&irq)
+	j.WriteByte(0xFF00, SELECT_BUTTONS)
+	j.Press(START)
+	j.Latch()
+	if irq.requested != 0 {
+		t.Error("Interrupt requested for an unselected button")
+	}
+}
+
+func TestNoInterruptOnRelease(t *testing.T) {
+	var irq mockInterrupter
+	j := New(&irq)
+	j.SetButtons(A)
+	j.Latch()
+	j.WriteByte(0xFF00, SELECT_DIRECTIONS)
+	irq.requested = 0
+	j.SetButtons(0)
+	j.Latch()
+	if irq.requested != 0 {
+		t.Error("Interrupt requested on release")
+	}
+}
+
+func TestInterruptOnSelect(t *testing.T) {
+	var irq mockInterrupter
+	j := New(&irq)
+	j.SetButtons(A)
+	j.Latch()
+	if irq.requested != 0 {
+		t.Error("Interrupt requested with nothing selected")
+	}
+	j.WriteByte(0xFF00, SELECT_DIRECTIONS)
+	if irq.requested != z80.JOYPAD_INT {
+		t.Error("Selecting a held button did not request an interrupt")
+	}
+}
+
+func TestWakeFromStop(t *testing.T) {
+	buff := []byte{0x10, 0x00, 0x00}
+	z := z80.New(&flatMemory{buff})
+	j := New(&z)
+	j.WriteByte(0xFF00, SELECT_BUTTONS)
+	z.Dispatch()
+	if !z.Stopped() {
+		t.Fatal("STOP did not stop the CPU")
+	}
+	z.Dispatch()
+	if z.PC != 2 {
+		t.Errorf("Stopped CPU advanced to 0x%04X", z.PC)
+	}
+	j.Press(DOWN)
+	j.Latch()
+	if z.Stopped() {
+		t.Error("Button press did not wake the CPU")
+	}
+	z.Dispatch()
+	if z.PC != 3 {
+		t.Errorf("Program Counter advanced to 0x%04X, not 0x0003", z.PC)
+	}
+}
+
+type flatMemory struct {
+	buff []byte
+}
+
+func (m *flatMemory) ReadByte(addr uint16) byte {
+	return m.buff[addr]
+}
+
+func (m *flatMemory) WriteByte(addr uint16, val byte) {
+	m.buff[addr] = val
+}
+
+func (m *flatMemory) ReadWord(addr uint16) uint16 {
+	return uint16(m.buff[addr]) | uint16(m.buff[addr+1])<<8
+}
+
+func (m *flatMemory) WriteWord(addr uint16, val uint16) {
+	m.buff[addr] = byte(val)
+	m.buff[addr+1] = byte(val >> 8)
+}
+
+func TestState(t *testing.T) {
+	j := New(nil)
+	j.SetButtons(START | UP)
+	j.Latch()
+	j.WriteByte(0xFF00, SELECT_BUTTONS)
+	loaded := New(nil)
+	if err := savestate.Copy(loaded, j, STATE_VERSION); err != nil {
+		t.Fatal(err)
+	}
+	// Buttons not yet latched belong to the frontend.
+	loaded.pending = j.pending
+	if !reflect.DeepEqual(loaded, j) {
+		t.Errorf("Loaded %+v, saved %+v", loaded, j)
+	}
+}
@@ -0,0 +1,18 @@
+package joypad
+
+import "github.com/zbyrne/golangboy/savestate"
+
+// STATE_VERSION is the version of the joypad's save state chunk.
+const STATE_VERSION = 1
+
+// SaveState saves the latched buttons. Buttons set since the last Latch
+// belong to the frontend and are left alone by LoadState.
+func (j *Joypad) SaveState(e *savestate.Encoder) {
+	e.Byte(byte(j.pressed))
+	e.Byte(j.sel)
+}
+
+func (j *Joypad) LoadState(d *savestate.Decoder) {
+	j.pressed = Button(d.Byte())
+	j.sel = d.Byte()
+}
@@ -0,0 +1,114 @@
+package movie
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/joypad"
+)
+
+var ErrBK2 = errors.New("movie: can't import .bk2")
+
+var bk2Buttons = map[string]joypad.Button{
+	"Up":     joypad.UP,
+	"Down":   joypad.DOWN,
+	"Left":   joypad.LEFT,
+	"Right":  joypad.RIGHT,
+	"Start":  joypad.START,
+	"Select": joypad.SELECT,
+	"B":      joypad.B,
+	"A":      joypad.A,
+}
+
+// ImportBK2 reads the input log of a BizHawk movie, a zip holding
+// Header.txt and Input Log.txt. Only Game Boy movies that start from power
+// on can be imported. BizHawk's states mean nothing here, so the movie has
+// no checkpoints and is played from however the GameBoy was started.
+func ImportBK2(r io.ReaderAt, size int64) (*Movie, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	m := &Movie{Model: gameboy.DMG}
+	var hasROM bool
+	err = eachLine(z, "Header.txt", func(line string) error {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "SHA1":
+			sum, err := hex.DecodeString(value)
+			if err != nil || len(sum) != len(m.ROM) {
+				return fmt.Errorf("%w: bad SHA1 %q", ErrBK2, value)
+			}
+			copy(m.ROM[:], sum)
+			hasROM = true
+		case "Platform":
+			if value != "GB" {
+				return fmt.Errorf("%w: platform %s", ErrBK2, value)
+			}
+		case "StartsFromSavestate", "StartsFromSaveRam":
+			if value == "True" {
+				return fmt.Errorf("%w: starts from a BizHawk state", ErrBK2)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !hasROM {
+		return nil, fmt.Errorf("%w: no ROM hash", ErrBK2)
+	}
+
+	var columns []string
+	err = eachLine(z, "Input Log.txt", func(line string) error {
+		switch {
+		case strings.HasPrefix(line, "LogKey:"):
+			columns = nil
+			for _, name := range strings.FieldsFunc(line[len("LogKey:"):], func(r rune) bool { return r == '#' || r == '|' }) {
+				columns = append(columns, strings.TrimPrefix(name, "P1 "))
+			}
+		case strings.HasPrefix(line, "|"):
+			marks := strings.ReplaceAll(line, "|", "")
+			if len(marks) != len(columns) {
+				return fmt.Errorf("%w: input %q doesn't match the log key", ErrBK2, line)
+			}
+			var b joypad.Button
+			for i, name := range columns {
+				if marks[i] == '.' || marks[i] == ' ' {
+					continue
+				}
+				if name == "Power" && len(m.Inputs) > 0 {
+					return fmt.Errorf("%w: power cycled on frame %d", ErrBK2, len(m.Inputs)+1)
+				}
+				b |= bk2Buttons[name]
+			}
+			m.Inputs = append(m.Inputs, b)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func eachLine(z *zip.Reader, name string, f func(string) error) error {
+	file, err := z.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBK2, err)
+	}
+	defer file.Close()
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		if err := f(strings.TrimSpace(s.Text())); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
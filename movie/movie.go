@@ -0,0 +1,160 @@
+// Package movie records the joypad input of a session so it can be played
+// back to get exactly the same frames.
+//
+// A movie file starts with the magic "GBMV" and a little endian uint16
+// format version, followed by:
+//
+//	rom         [20]byte  SHA-1 of the cartridge ROM
+//	model       byte
+//	state       uint32 length, then a save state to start from
+//	inputs      uint32 count, then the buttons latched on each frame
+//	checkpoints uint32 count, then a uint32 frame and uint64 hash each
+//
+// A movie with no state starts from power on. Checkpoints hash the
+// finished frame every so often so a playback that drifts from the
+// recording is caught near where it happened.
+package movie
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/golden"
+	"github.com/zbyrne/golangboy/joypad"
+)
+
+const FORMAT_VERSION = 1
+
+const magic = "GBMV"
+
+var (
+	ErrFormat  = errors.New("movie: not a movie")
+	ErrVersion = errors.New("movie: made by a newer version")
+	ErrShort   = errors.New("movie: file is truncated")
+	ErrROM     = errors.New("movie: recorded with another ROM")
+	ErrModel   = errors.New("movie: recorded on another model")
+)
+
+// Checkpoint is the hash of the frame finished on a given frame of the
+// movie, counting from 1.
+type Checkpoint struct {
+	Frame int
+	Hash  uint64
+}
+
+type Movie struct {
+	ROM         [sha1.Size]byte
+	Model       gameboy.Model
+	State       []byte
+	Inputs      []joypad.Button
+	Checkpoints []Checkpoint
+}
+
+// DesyncError is returned when playback doesn't match a checkpoint.
+type DesyncError struct {
+	Frame     int
+	Want, Got uint64
+}
+
+func (e *DesyncError) Error() string {
+	return fmt.Sprintf("movie: desync at frame %d", e.Frame)
+}
+
+// HashFrame hashes the GameBoy's last frame the way golden files do, so a
+// CGB game's colours count as well as its pixels.
+func HashFrame(g *gameboy.GameBoy) uint64 {
+	return golden.Hash(g)
+}
+
+// Write saves the movie in the format described above.
+func (m *Movie) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(magic)
+	le := binary.LittleEndian
+	var buf [8]byte
+	le.PutUint16(buf[:], FORMAT_VERSION)
+	bw.Write(buf[:2])
+	bw.Write(m.ROM[:])
+	bw.WriteByte(byte(m.Model))
+	putLen := func(n int) {
+		le.PutUint32(buf[:], uint32(n))
+		bw.Write(buf[:4])
+	}
+	putLen(len(m.State))
+	bw.Write(m.State)
+	putLen(len(m.Inputs))
+	for _, b := range m.Inputs {
+		bw.WriteByte(byte(b))
+	}
+	putLen(len(m.Checkpoints))
+	for _, c := range m.Checkpoints {
+		putLen(c.Frame)
+		le.PutUint64(buf[:], c.Hash)
+		bw.Write(buf[:])
+	}
+	return bw.Flush()
+}
+
+// Read loads a movie saved by Write.
+func Read(r io.Reader) (*Movie, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 6 || string(data[:4]) != magic {
+		return nil, ErrFormat
+	}
+	if binary.LittleEndian.Uint16(data[4:]) > FORMAT_VERSION {
+		return nil, ErrVersion
+	}
+	d := decoder{data: data[6:]}
+	m := &Movie{}
+	copy(m.ROM[:], d.take(sha1.Size))
+	m.Model = gameboy.Model(d.take(1)[0])
+	if state := d.take(d.count(1)); len(state) > 0 {
+		m.State = append([]byte(nil), state...)
+	}
+	for _, b := range d.take(d.count(1)) {
+		m.Inputs = append(m.Inputs, joypad.Button(b))
+	}
+	for n := d.count(12); n > 0; n-- {
+		frame := int(binary.LittleEndian.Uint32(d.take(4)))
+		hash := binary.LittleEndian.Uint64(d.take(8))
+		m.Checkpoints = append(m.Checkpoints, Checkpoint{frame, hash})
+	}
+	if d.short {
+		return nil, ErrShort
+	}
+	return m, nil
+}
+
+// decoder reads the fields of a movie, noting if it runs out.
+type decoder struct {
+	data  []byte
+	short bool
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.short || len(d.data) < n {
+		d.short = true
+		return make([]byte, n)
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+// count reads a count of things size bytes each, which must all be there.
+func (d *decoder) count(size int) int {
+	n := int(binary.LittleEndian.Uint32(d.take(4)))
+	if n*size > len(d.data) {
+		d.short = true
+		return 0
+	}
+	return n
+}
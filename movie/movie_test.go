@@ -0,0 +1,187 @@
+package movie
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/internal/testrom"
+	"github.com/zbyrne/golangboy/joypad"
+)
+
+// inputProgram reads the joypad once a frame and shows a running total of
+// the lines on the palette, so the frames depend on every frame's input.
+const inputProgram = `
+SECTION "main", ROM0[$100]
+	ld a, $01
+	ldh [$FF], a
+.loop:
+	halt
+	xor a
+	ldh [$0F], a
+	ld a, $20
+	ldh [$00], a
+	ldh a, [$00]
+	add a, b
+	ld b, a
+	ldh [$47], a
+	jr .loop
+`
+
+// record runs a few frames in, then records n more.
+func record(t *testing.T, g *gameboy.GameBoy, n int) *Movie {
+	for i := 0; i < 10; i++ {
+		g.RunFrame()
+	}
+	r, err := NewRecorder(g, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		g.Joypad.SetButtons(testrom.Press(i, 5))
+		r.RunFrame()
+	}
+	return r.Movie()
+}
+
+func TestRecordPlay(t *testing.T) {
+	rom := testrom.ROM(t, inputProgram, []byte("MOVIE")...)
+	g := testrom.GameBoy(t, rom, gameboy.Options{})
+	m := record(t, g, 100)
+	if len(m.Inputs) != 100 || len(m.Checkpoints) != 7 || m.Checkpoints[6].Frame != 100 {
+		t.Fatalf("Recorded %d frames with checkpoints %v", len(m.Inputs), m.Checkpoints)
+	}
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, m) {
+		t.Error("Movie changed when saved and loaded")
+	}
+
+	played := testrom.GameBoy(t, rom, gameboy.Options{})
+	p, err := NewPlayer(played, loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Play(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Frame() != 100 || !bytes.Equal(played.Frame(), g.Frame()) {
+		t.Errorf("Played %d frames to a different picture", p.Frame())
+	}
+	var want, got bytes.Buffer
+	g.SaveState(&want)
+	played.SaveState(&got)
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Error("Machines differ after playing")
+	}
+}
+
+func TestDesync(t *testing.T) {
+	rom := testrom.ROM(t, inputProgram, []byte("MOVIE")...)
+	m := record(t, testrom.GameBoy(t, rom, gameboy.Options{}), 100)
+	m.Inputs[40] ^= joypad.RIGHT
+	p, err := NewPlayer(testrom.GameBoy(t, rom, gameboy.Options{}), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var desync *DesyncError
+	if err := p.Play(); !errors.As(err, &desync) || desync.Frame != 48 {
+		t.Errorf("Changed input gave %v", err)
+	}
+}
+
+func TestWrongMachine(t *testing.T) {
+	rom := testrom.ROM(t, inputProgram, []byte("MOVIE")...)
+	other := testrom.ROM(t, inputProgram, []byte("OTHER")...)
+	m := record(t, testrom.GameBoy(t, rom, gameboy.Options{}), 5)
+	if _, err := NewPlayer(testrom.GameBoy(t, other, gameboy.Options{}), m); err != ErrROM {
+		t.Errorf("Played on another ROM: %v", err)
+	}
+	m.Model = gameboy.Model(7)
+	if _, err := NewPlayer(testrom.GameBoy(t, rom, gameboy.Options{}), m); err != ErrModel {
+		t.Errorf("Played on another model: %v", err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	var buf bytes.Buffer
+	m := &Movie{Inputs: []joypad.Button{1, 2, 3}, Checkpoints: []Checkpoint{{3, 0x1234}}}
+	m.Write(&buf)
+	good := buf.Bytes()
+
+	if _, err := Read(bytes.NewReader([]byte("GBSS\x01\x00"))); err != ErrFormat {
+		t.Errorf("Bad magic gave %v", err)
+	}
+	newer := append([]byte(nil), good...)
+	newer[4] = FORMAT_VERSION + 1
+	if _, err := Read(bytes.NewReader(newer)); err != ErrVersion {
+		t.Errorf("Newer format gave %v", err)
+	}
+	for _, n := range []int{10, 32, len(good) - 1} {
+		if _, err := Read(bytes.NewReader(good[:n])); err != ErrShort {
+			t.Errorf("Truncated to %d bytes gave %v", n, err)
+		}
+	}
+}
+
+func bk2(t *testing.T, header, log string) []byte {
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	for name, data := range map[string]string{"Header.txt": header, "Input Log.txt": log} {
+		w, err := z.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+	z.Close()
+	return buf.Bytes()
+}
+
+func TestImportBK2(t *testing.T) {
+	rom := testrom.ROM(t, inputProgram, []byte("MOVIE")...)
+	header := fmt.Sprintf("MovieVersion BizHawk v2.0\nPlatform GB\nCore Gambatte\nSHA1 %X\n", sha1.Sum(rom))
+	log := "[Input]\nLogKey:#Up|Down|Left|Right|Start|Select|B|A|Power|\n" +
+		"|........P|\n|U......A.|\n|...RS....|\n[/Input]\n"
+	data := bk2(t, header, log)
+	m, err := ImportBK2(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []joypad.Button{0, joypad.UP | joypad.A, joypad.RIGHT | joypad.START}
+	if !reflect.DeepEqual(m.Inputs, want) || m.State != nil || m.Checkpoints != nil {
+		t.Errorf("Imported %+v", m)
+	}
+	p, err := NewPlayer(testrom.GameBoy(t, rom, gameboy.Options{}), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Play(); err != nil || p.Frame() != 3 {
+		t.Errorf("Played %d frames: %v", p.Frame(), err)
+	}
+
+	for _, bad := range []struct{ header, log string }{
+		{header + "StartsFromSavestate True\n", log},
+		{"Platform GBC\n", log},
+		{"Platform GB\n", log},
+		{header, "LogKey:#Up|Down|\n|U|\n"},
+		{header, "LogKey:#Up|Power|\n|..|\n|.P|\n"},
+	} {
+		data := bk2(t, bad.header, bad.log)
+		if _, err := ImportBK2(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrBK2) {
+			t.Errorf("Imported %q %q: %v", bad.header, bad.log, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package movie
+
+import (
+	"bytes"
+	"crypto/sha1"
+
+	"github.com/zbyrne/golangboy/gameboy"
+)
+
+// DEFAULT_INTERVAL is how often the recorder checkpoints, a second's worth
+// of frames.
+const DEFAULT_INTERVAL = 60
+
+// Recorder runs a GameBoy a frame at a time, writing down the input.
+type Recorder struct {
+	gb       *gameboy.GameBoy
+	interval int
+	movie    Movie
+}
+
+// NewRecorder starts a movie from the GameBoy's current state,
+// checkpointing every interval frames, or DEFAULT_INTERVAL if it's 0.
+func NewRecorder(g *gameboy.GameBoy, interval int) (*Recorder, error) {
+	if interval <= 0 {
+		interval = DEFAULT_INTERVAL
+	}
+	var state bytes.Buffer
+	if err := g.SaveState(&state); err != nil {
+		return nil, err
+	}
+	r := &Recorder{gb: g, interval: interval}
+	r.movie.ROM = sha1.Sum(g.Cart.ROM())
+	r.movie.Model = g.Model()
+	r.movie.State = state.Bytes()
+	return r, nil
+}
+
+// RunFrame runs a frame with whatever buttons the frontend has set.
+func (r *Recorder) RunFrame() {
+	r.gb.RunFrame()
+	r.movie.Inputs = append(r.movie.Inputs, r.gb.Joypad.Buttons())
+	if n := len(r.movie.Inputs); n%r.interval == 0 {
+		r.movie.Checkpoints = append(r.movie.Checkpoints, Checkpoint{n, HashFrame(r.gb)})
+	}
+}
+
+// Movie returns what has been recorded so far, checkpointing the last
+// frame so a desync right at the end is caught too.
+func (r *Recorder) Movie() *Movie {
+	m := r.movie
+	m.Inputs = append(m.Inputs[:0:0], m.Inputs...)
+	m.Checkpoints = append(m.Checkpoints[:0:0], m.Checkpoints...)
+	n := len(m.Inputs)
+	if n > 0 && n%r.interval != 0 {
+		m.Checkpoints = append(m.Checkpoints, Checkpoint{n, HashFrame(r.gb)})
+	}
+	return &m
+}
+
+// Player feeds a movie's input to a GameBoy.
+type Player struct {
+	gb    *gameboy.GameBoy
+	movie *Movie
+	frame int
+	next  int
+}
+
+// NewPlayer checks the GameBoy has the movie's ROM and model and puts it
+// in the movie's starting state. A movie without one is played from
+// however the GameBoy was started.
+func NewPlayer(g *gameboy.GameBoy, m *Movie) (*Player, error) {
+	if sha1.Sum(g.Cart.ROM()) != m.ROM {
+		return nil, ErrROM
+	}
+	if g.Model() != m.Model {
+		return nil, ErrModel
+	}
+	if m.State != nil {
+		if err := g.LoadState(bytes.NewReader(m.State)); err != nil {
+			return nil, err
+		}
+	}
+	return &Player{gb: g, movie: m}, nil
+}
+
+// Frame is the number of frames played.
+func (p *Player) Frame() int {
+	return p.frame
+}
+
+func (p *Player) Done() bool {
+	return p.frame >= len(p.movie.Inputs)
+}
+
+// RunFrame plays the next frame, returning a *DesyncError if it doesn't
+// match the recording.
+func (p *Player) RunFrame() error {
+	if p.Done() {
+		return nil
+	}
+	p.gb.Joypad.SetButtons(p.movie.Inputs[p.frame])
+	p.gb.RunFrame()
+	p.frame++
+	cps := p.movie.Checkpoints
+	for p.next < len(cps) && cps[p.next].Frame < p.frame {
+		p.next++
+	}
+	if p.next < len(cps) && cps[p.next].Frame == p.frame {
+		want := cps[p.next].Hash
+		p.next++
+		if got := HashFrame(p.gb); got != want {
+			return &DesyncError{p.frame, want, got}
+		}
+	}
+	return nil
+}
+
+// Play plays the whole movie, stopping at the first desync.
+func (p *Player) Play() error {
+	for !p.Done() {
+		if err := p.RunFrame(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
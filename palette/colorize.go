@@ -0,0 +1,233 @@
+package palette
+
+import (
+	"image/color"
+
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/joypad"
+)
+
+// CGB is a palette for each layer a pixel can come from, indexed by
+// ppu.LAYER_BG, LAYER_OBJ0 and LAYER_OBJ1. It's how a CGB colours a DMG
+// game.
+type CGB [3]DMG
+
+// Color returns the colour of a shade drawn on a layer.
+func (p CGB) Color(shade, layer byte) color.RGBA {
+	return p[layer%3][shade&3]
+}
+
+// boot is a palette set from the CGB boot ROM, as 24-bit colours for
+// reading's sake. The ROM holds them as 15-bit colours, which is what
+// they're cut back to before correction.
+type boot [3][4]uint32
+
+func (b boot) colors(c Correction) CGB {
+	var p CGB
+	for layer, pal := range b {
+		for shade, v := range pal {
+			p[layer][shade] = c.RGB(uint16(v>>19&0x1F | v>>11&0x1F<<5 | v>>3&0x1F<<10))
+		}
+	}
+	return p
+}
+
+// The four colour palettes the boot ROM builds its sets from.
+var (
+	greenBlue  = [4]uint32{0xFFFFFF, 0x7BFF31, 0x0063C5, 0x000000}
+	red        = [4]uint32{0xFFFFFF, 0xFF8484, 0x943A3A, 0x000000}
+	blue       = [4]uint32{0xFFFFFF, 0x63A5FF, 0x0000FF, 0x000000}
+	green      = [4]uint32{0xFFFFFF, 0x7BFF31, 0x008400, 0x000000}
+	brown      = [4]uint32{0xFFFFFF, 0xFFAD63, 0x843100, 0x000000}
+	darkBlue   = [4]uint32{0xFFFFFF, 0x8C8CDE, 0x52528C, 0x000000}
+	darkBrown  = [4]uint32{0xFFE7C5, 0xCC9C85, 0x846B29, 0x5A3108}
+	grey       = [4]uint32{0xFFFFFF, 0xA5A5A5, 0x525252, 0x000000}
+	pastel     = [4]uint32{0xFFFFA5, 0xFF9494, 0x9494FF, 0x000000}
+	orange     = [4]uint32{0xFFFFFF, 0xFFFF00, 0xFF0000, 0x000000}
+	yellow     = [4]uint32{0xFFFFFF, 0xFFFF00, 0x7B4A00, 0x000000}
+	lime       = [4]uint32{0xFFFFFF, 0x52FF00, 0xFF4200, 0x000000}
+	inverted   = [4]uint32{0x000000, 0x008484, 0xFFDE00, 0xFFFFFF}
+	olive      = [4]uint32{0xFFFFFF, 0xADAD84, 0x42737B, 0x000000}
+	rust       = [4]uint32{0xFFFFFF, 0xFF7300, 0x944200, 0x000000}
+	primary    = [4]uint32{0xFFFFFF, 0x5ABDFF, 0xFF0000, 0x0000FF}
+	amber      = [4]uint32{0xFFFFFF, 0xFF9C00, 0xFF0000, 0x000000}
+	lilac      = [4]uint32{0xA59CFF, 0xFFFF00, 0x006300, 0x000000}
+	scarlet    = [4]uint32{0xFF6352, 0xD60000, 0x630000, 0x000000}
+	sky        = [4]uint32{0x0000FF, 0xFFFFFF, 0xFFFF7B, 0x0084FF}
+	periwinkle = [4]uint32{0xB5B5FF, 0xFFFF94, 0xAD5A42, 0x000000}
+	redOnBlack = [4]uint32{0x000000, 0xFFFFFF, 0xFF8484, 0x943A3A}
+	gold       = [4]uint32{0xFFFFFF, 0xFFCE00, 0x9C6300, 0x000000}
+	flame      = [4]uint32{0xFFC542, 0xFFD600, 0x943A00, 0x4A0000}
+	sea        = [4]uint32{0xFFFF9C, 0x94B5FF, 0x639473, 0x003A3A}
+	pitch      = [4]uint32{0x6BFF00, 0xFFFFFF, 0xFF524A, 0x000000}
+	paleBlue   = [4]uint32{0xFFFFFF, 0xFFFFFF, 0x63A5FF, 0x0000FF}
+	field      = [4]uint32{0x52DE00, 0xFF8400, 0xFFFF00, 0xFFFFFF}
+	cream      = [4]uint32{0xFFFFCE, 0x63EFEF, 0x9C8431, 0x5A5A5A}
+	wave       = [4]uint32{0xFFFFFF, 0xFFFF7B, 0x0084FF, 0xFF0000}
+	forest     = [4]uint32{0xFFFFFF, 0x00FF00, 0x318400, 0x004A00}
+	khaki      = [4]uint32{0xFFFFFF, 0x7BFF00, 0xB57300, 0x000000}
+)
+
+func same(pal [4]uint32) boot {
+	return boot{pal, pal, pal}
+}
+
+// bootDefault is what games without a palette of their own get.
+var bootDefault = boot{greenBlue, red, red}
+
+// combos are the palettes picked by holding buttons while the CGB logo
+// is up.
+var combos = map[joypad.Button]boot{
+	joypad.UP:               same(brown),
+	joypad.UP | joypad.A:    {red, green, blue},
+	joypad.UP | joypad.B:    same(darkBrown),
+	joypad.LEFT:             {blue, red, green},
+	joypad.LEFT | joypad.A:  {darkBlue, red, brown},
+	joypad.LEFT | joypad.B:  same(grey),
+	joypad.DOWN:             same(pastel),
+	joypad.DOWN | joypad.A:  same(orange),
+	joypad.DOWN | joypad.B:  {yellow, blue, green},
+	joypad.RIGHT:            same(lime),
+	joypad.RIGHT | joypad.A: bootDefault,
+	joypad.RIGHT | joypad.B: same(inverted),
+}
+
+type titleKey struct {
+	sum, fourth byte
+}
+
+// titles is the boot ROM's table of palettes for Nintendo's DMG games, by
+// the sum of the 16 title bytes and, for the sums in shared, the title's
+// fourth letter. Any other game whose title sums the same gets the same
+// palettes.
+var titles = map[titleKey]boot{
+	{0x88, 0}:   same(lilac),                          // ALLEY WAY
+	{0x16, 0}:   same(brown),                          // YAKUMAN
+	{0x36, 0}:   {field, paleBlue, red},               // BASEBALL
+	{0xD1, 0}:   {pitch, paleBlue, brown},             // TENNIS
+	{0xDB, 0}:   same(orange),                         // TETRIS
+	{0xF2, 0}:   {orange, orange, primary},            // QIX
+	{0x3C, 0}:   {blue, blue, red},                    // DR.MARIO
+	{0x8C, 0}:   {olive, rust, olive},                 // RADARMISSION
+	{0x92, 0}:   same(brown),                          // F1RACE
+	{0x3D, 0}:   {lime, red, red},                     // YOSSY NO TAMAGO
+	{0x5C, 0}:   {lilac, scarlet, sky},                // HOSHINOKA-BI
+	{0x58, 0}:   same(grey),                           // X
+	{0xC9, 0}:   {cream, rust, blue},                  // MARIOLAND2
+	{0x3E, 0}:   {amber, amber, primary},              // YOSSY NO COOKIE
+	{0x70, 0}:   {forest, red, blue},                  // ZELDA
+	{0x1D, 0}:   {lilac, scarlet, scarlet},            // KIRBY'S PINBALL
+	{0x59, 0}:   {olive, rust, primary},               // SUPERMARIOLAND3
+	{0x69, 0}:   {orange, orange, primary},            // TETRIS FLASH
+	{0x19, 0}:   {amber, red, red},                    // DONKEY KONG
+	{0x35, 0}:   same(brown),                          // MARIO'S PICROSS
+	{0xA8, 0}:   {sea, flame, red},                    // untitled
+	{0x14, 0}:   {red, green, red},                    // POKEMON RED
+	{0xAA, 0}:   {greenBlue, red, greenBlue},          // POKEMON GREEN
+	{0x75, 0}:   same(brown),                          // PICROSS 2
+	{0x95, 0}:   {lime, lime, primary},                // YOSSY NO PANEPON
+	{0x99, 0}:   same(brown),                          // KIRAKIRA KIDS
+	{0x34, 0}:   {khaki, red, red},                    // GAMEBOY GALLERY
+	{0x6F, 0}:   same(gold),                           // POCKETCAMERA
+	{0x15, 0}:   same(orange),                         // POKEMON YELLOW
+	{0xFF, 0}:   same(amber),                          // BALLOON KID
+	{0x97, 0}:   {blue, brown, brown},                 // KINGOFTHEZOO
+	{0x4B, 0}:   {green, red, red},                    // DMG FOOTBALL
+	{0x90, 0}:   {green, red, red},                    // WORLD CUP
+	{0x17, 0}:   {green, red, blue},                   // OTHELLO
+	{0x10, 0}:   {blue, red, green},                   // SUPER RC PRO-AM
+	{0x39, 0}:   {blue, brown, brown},                 // DYNABLASTER
+	{0xF7, 0}:   {brown, green, blue},                 // BOY AND BLOB GB2
+	{0xF6, 0}:   {blue, red, green},                   // MEGAMAN
+	{0xA2, 0}:   {brown, green, blue},                 // STAR WARS-NOA
+	{0x49, 0}:   {lilac, scarlet, sky},                // KIRBY DREAM LAND
+	{0x4E, 0}:   {wave, red, blue},                    // WAVERACE
+	{0x43, 0}:   {blue, brown, brown},                 // THE CHESSMASTER
+	{0x68, 0}:   {blue, red, green},                   // LOLO2
+	{0xE0, 0}:   {amber, amber, primary},              // YOSHI'S COOKIE
+	{0x8B, 0}:   {green, red, blue},                   // MYSTIC QUEST
+	{0xF0, 0}:   {pitch, paleBlue, brown},             // TOPRANKTENNIS
+	{0xCE, 0}:   {pitch, paleBlue, brown},             // TOPRANKINGTENNIS
+	{0x0C, 0}:   same(brown),                          // MANSELL
+	{0x29, 0}:   {blue, red, green},                   // MEGAMAN3
+	{0xE8, 0}:   same(inverted),                       // SPACE INVADERS
+	{0xB7, 0}:   same(brown),                          // GAME&WATCH
+	{0x86, 0}:   {sea, flame, red},                    // DONKEYKONGLAND95
+	{0x9A, 0}:   {green, red, red},                    // ASTEROIDS/MISCMD
+	{0x52, 0}:   {blue, red, green},                   // STREET FIGHTER 2
+	{0x01, 0}:   {blue, red, green},                   // DEFENDER/JOUST
+	{0x9D, 0}:   {darkBlue, red, brown},               // KILLERINSTINCT95
+	{0x71, 0}:   same(amber),                          // TETRIS BLAST
+	{0x9C, 0}:   {gold, gold, flame},                  // PINOCCHIO
+	{0xBD, 0}:   {green, red, red},                    // TOY STORY
+	{0x5D, 0}:   {blue, red, green},                   // BA.TOSHINDEN
+	{0x6D, 0}:   {blue, red, green},                   // NETTOU KOF 95
+	{0x67, 0}:   same(brown),                          // STAR STACKER
+	{0x3F, 0}:   {greenBlue, red, red},                // TETRIS PLUS
+	{0x6B, 0}:   {gold, red, flame},                   // DONKEYKONGLAND 3
+	{0xB3, 'B'}: {lilac, scarlet, sky},                // KIRBY2
+	{0x46, 'E'}: {periwinkle, redOnBlack, redOnBlack}, // SUPER MARIOLAND
+	{0x28, 'F'}: {green, red, red},                    // GOLF
+	{0xA5, 'A'}: same(inverted),                       // SOLARSTRIKER
+	{0xC6, 'A'}: {olive, rust, primary},               // GBWARS
+	{0xD3, 'R'}: {darkBlue, red, darkBlue},            // KAERUNOTAMENI
+	{0x27, 'B'}: {lilac, scarlet, sky},                // KIRBY BLOCKBALL
+	{0x61, 'E'}: {blue, red, blue},                    // POKEMON BLUE
+	{0x18, 'K'}: {gold, red, flame},                   // DONKEYKONGLAND
+	{0x66, 'E'}: {khaki, red, red},                    // GAMEBOY GALLERY2
+	{0x6A, 'K'}: {gold, red, flame},                   // DONKEYKONGLAND 2
+	{0xBF, ' '}: {darkBlue, flame, flame},             // KID ICARUS
+	{0x0D, 'R'}: {orange, orange, primary},            // TETRIS2
+	{0xF4, '-'}: {greenBlue, red, blue},               // PAC-IN-TIME
+	{0xB3, 'U'}: {olive, rust, rust},                  // MOGURANYA
+	{0x46, 'R'}: {yellow, blue, green},                // METROID2
+	{0x28, 'A'}: same(inverted),                       // GALAGA&GALAXIAN
+	{0xA5, 'R'}: {brown, blue, blue},                  // BT2RAGNAROKWORLD
+	{0xC6, ' '}: {greenBlue, red, red},                // KEN GRIFFEY JR
+	{0xD3, 'I'}: {olive, brown, red},                  // WARIOLAND2
+	{0x27, 'N'}: {green, red, blue},                   // MAGNETIC SOCCER
+	{0x61, 'A'}: {green, red, blue},                   // VEGAS STAKES
+	{0x18, 'I'}: {greenBlue, red, red},                // WARIO BLAST
+	{0x66, 'L'}: {greenBlue, red, red},                // MILLI/CENTI/PEDE
+	{0x6A, 'I'}: {lime, red, red},                     // MARIO & YOSHI
+	{0xBF, 'C'}: {pitch, paleBlue, brown},             // SOCCER
+	{0x0D, 'E'}: {gold, flame, flame},                 // POKEBOM
+	{0xF4, ' '}: {khaki, red, red},                    // G&W GALLERY
+	{0xB3, 'R'}: {lime, lime, primary},                // TETRIS ATTACK
+}
+
+// shared are the title sums the boot ROM's table has more than one game
+// for. It tells those apart by the title's fourth letter.
+var shared = map[byte]bool{
+	0xB3: true, 0x46: true, 0x28: true, 0xA5: true, 0xC6: true, 0xD3: true, 0x27: true,
+	0x61: true, 0x18: true, 0x66: true, 0x6A: true, 0xBF: true, 0x0D: true, 0xF4: true,
+}
+
+// Colorize picks the palettes the CGB boot ROM would give a DMG game from
+// its header. Only games licensed by Nintendo are looked up; it returns
+// the default palettes and false for the rest and for games it doesn't
+// know.
+func Colorize(h cartridge.Header, c Correction) (CGB, bool) {
+	if !h.Nintendo() {
+		return bootDefault.colors(c), false
+	}
+	key := titleKey{sum: h.TitleSum()}
+	if shared[key.sum] && len(h.Title) > 3 {
+		key.fourth = h.Title[3]
+	}
+	b, ok := titles[key]
+	if !ok {
+		return bootDefault.colors(c), false
+	}
+	return b.colors(c), true
+}
+
+// Combo returns the palettes picked by holding buttons during the CGB boot
+// animation, if that combination picks any.
+func Combo(buttons joypad.Button, c Correction) (CGB, bool) {
+	b, ok := combos[buttons]
+	if !ok {
+		return CGB{}, false
+	}
+	return b.colors(c), true
+}
@@ -0,0 +1,78 @@
+package palette
+
+import (
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Correction turns the 15-bit colours of a CGB palette into what the
+// screen showed. The CGB's LCD bled the channels into each other and
+// washed out bright colours, so scaling them straight to 8 bits looks
+// harsher than the hardware.
+type Correction int
+
+const (
+	// CORRECT_NONE scales each 5-bit channel to 8 bits.
+	CORRECT_NONE Correction = iota
+	// CORRECT_CGB is the curve byuu and Gambatte use for the CGB's LCD.
+	CORRECT_CGB
+	// CORRECT_AGB is higan's curve for the darker GBA LCD.
+	CORRECT_AGB
+)
+
+var corrections = map[string]Correction{
+	"none": CORRECT_NONE,
+	"cgb":  CORRECT_CGB,
+	"agb":  CORRECT_AGB,
+}
+
+// LookupCorrection finds a correction by name.
+func LookupCorrection(name string) (Correction, error) {
+	c, ok := corrections[name]
+	if !ok {
+		return 0, ErrUnknown
+	}
+	return c, nil
+}
+
+// CorrectionNames lists the corrections.
+func CorrectionNames() []string {
+	var names []string
+	for name := range corrections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RGB converts a colour in the CGB's format: 5 bits each of red, green and
+// blue from the bottom up.
+func (c Correction) RGB(v uint16) color.RGBA {
+	r, g, b := int(v&0x1F), int(v>>5&0x1F), int(v>>10&0x1F)
+	switch c {
+	case CORRECT_CGB:
+		mix := func(v int) byte {
+			if v > 960 {
+				v = 960
+			}
+			return byte(v >> 2)
+		}
+		return color.RGBA{mix(r*26 + g*4 + b*2), mix(g*24 + b*8), mix(r*6 + g*4 + b*22), 0xFF}
+	case CORRECT_AGB:
+		const lcdGamma, outGamma = 4.0, 2.2
+		lr := math.Pow(float64(r)/31, lcdGamma)
+		lg := math.Pow(float64(g)/31, lcdGamma)
+		lb := math.Pow(float64(b)/31, lcdGamma)
+		mix := func(v float64) byte {
+			v = math.Pow(v/255, 1/outGamma) * 255 * 255 / 280
+			return byte(math.Min(255, math.Round(v)))
+		}
+		return color.RGBA{mix(255*lr + 50*lg), mix(10*lr + 230*lg + 30*lb), mix(50*lr + 10*lg + 220*lb), 0xFF}
+	}
+	return color.RGBA{scale5(r), scale5(g), scale5(b), 0xFF}
+}
+
+func scale5(v int) byte {
+	return byte(v<<3 | v>>2)
+}
@@ -0,0 +1,91 @@
+// Package palette maps the four shades of a DMG frame to colours.
+package palette
+
+import (
+	"errors"
+	"image/color"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknown = errors.New("palette: no such palette")
+	ErrFormat  = errors.New("palette: want a preset or four hex colours, lightest first")
+)
+
+// DMG is the colour of each shade, lightest first.
+type DMG [4]color.RGBA
+
+var (
+	// Green is the DMG's own screen.
+	Green = DMG{rgb(0x9BBC0F), rgb(0x8BAC0F), rgb(0x306230), rgb(0x0F380F)}
+	// Pocket is the Game Boy Pocket's grey-green screen.
+	Pocket = DMG{rgb(0xC4CFA1), rgb(0x8B956D), rgb(0x4D533C), rgb(0x1F1F1F)}
+	// BGB is the default of the BGB emulator, a softer green.
+	BGB = DMG{rgb(0xE0F8D0), rgb(0x88C070), rgb(0x346856), rgb(0x081820)}
+	// Grey is plain greyscale.
+	Grey = DMG{rgb(0xFFFFFF), rgb(0xAAAAAA), rgb(0x555555), rgb(0x000000)}
+)
+
+var presets = map[string]DMG{
+	"green":  Green,
+	"pocket": Pocket,
+	"bgb":    BGB,
+	"grey":   Grey,
+}
+
+func rgb(v uint32) color.RGBA {
+	return color.RGBA{byte(v >> 16), byte(v >> 8), byte(v), 0xFF}
+}
+
+// Lookup finds a preset by name.
+func Lookup(name string) (DMG, error) {
+	p, ok := presets[name]
+	if !ok {
+		return DMG{}, ErrUnknown
+	}
+	return p, nil
+}
+
+// Parse takes a preset name or four comma separated RGB colours in hex,
+// lightest first, like "e0f8d0,88c070,#346856,081820".
+func Parse(s string) (DMG, error) {
+	if !strings.Contains(s, ",") {
+		return Lookup(s)
+	}
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 {
+		return DMG{}, ErrFormat
+	}
+	var p DMG
+	for i, f := range fields {
+		f = strings.TrimPrefix(strings.TrimSpace(f), "#")
+		v, err := strconv.ParseUint(f, 16, 24)
+		if err != nil || len(f) != 6 {
+			return DMG{}, ErrFormat
+		}
+		p[i] = rgb(uint32(v))
+	}
+	return p, nil
+}
+
+// Names lists the presets.
+func Names() []string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Color returns the colour of a shade.
+func (p DMG) Color(shade byte) color.RGBA {
+	return p[shade&3]
+}
+
+// CGB uses the palette for every layer.
+func (p DMG) CGB() CGB {
+	return CGB{p, p, p}
+}
@@ -0,0 +1,121 @@
+package palette
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/zbyrne/golangboy/cartridge"
+	"github.com/zbyrne/golangboy/joypad"
+	"github.com/zbyrne/golangboy/ppu"
+)
+
+func TestLookup(t *testing.T) {
+	p, err := Lookup("grey")
+	if err != nil || p.Color(2) != (color.RGBA{0x55, 0x55, 0x55, 0xFF}) {
+		t.Errorf("Grey shade 2 is %v: %v", p.Color(2), err)
+	}
+	if _, err := Lookup("purple"); err != ErrUnknown {
+		t.Errorf("Looked up a missing palette: %v", err)
+	}
+	if names := Names(); len(names) != len(presets) || names[0] != "bgb" {
+		t.Errorf("Names are %v", names)
+	}
+}
+
+func TestParse(t *testing.T) {
+	p, err := Parse("ffffff, #C0C0C0,808080,000001")
+	if err != nil || p[1] != (color.RGBA{0xC0, 0xC0, 0xC0, 0xFF}) || p[3] != (color.RGBA{0, 0, 1, 0xFF}) {
+		t.Errorf("Parsed %v: %v", p, err)
+	}
+	if p, err := Parse("pocket"); err != nil || p != Pocket {
+		t.Errorf("Parsed the pocket preset as %v: %v", p, err)
+	}
+	for _, s := range []string{"ffffff,c0c0c0,808080", "ffffff,c0c0c0,808080,00000g", "fff,ccc,888,000"} {
+		if _, err := Parse(s); err != ErrFormat {
+			t.Errorf("Parsed %q: %v", s, err)
+		}
+	}
+}
+
+func TestCorrection(t *testing.T) {
+	const white, red = 0x7FFF, 0x001F
+	for _, c := range []struct {
+		corr       Correction
+		white, red color.RGBA
+	}{
+		{CORRECT_NONE, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}, color.RGBA{0xFF, 0, 0, 0xFF}},
+		{CORRECT_CGB, color.RGBA{0xF0, 0xF0, 0xF0, 0xFF}, color.RGBA{0xC9, 0, 0x2E, 0xFF}},
+	} {
+		if w, r := c.corr.RGB(white), c.corr.RGB(red); w != c.white || r != c.red {
+			t.Errorf("Correction %d makes white %v and red %v", c.corr, w, r)
+		}
+	}
+	// The GBA's screen is darker, most of all in the mid tones.
+	mid, dark := CORRECT_AGB.RGB(0x3DEF), CORRECT_AGB.RGB(0x0421)
+	if mid.R >= 0x7B || dark.R != 0 || CORRECT_AGB.RGB(0x7FFF).G < 0xE0 {
+		t.Errorf("GBA mid grey is %v, dark grey %v", mid, dark)
+	}
+	if c, err := LookupCorrection("agb"); err != nil || c != CORRECT_AGB {
+		t.Errorf("Looked up %d: %v", c, err)
+	}
+}
+
+func header(title string, licensee byte) cartridge.Header {
+	return cartridge.Header{Title: title, OldLicensee: licensee}
+}
+
+func TestColorize(t *testing.T) {
+	blue, ok := Colorize(header("POKEMON BLUE", 0x01), CORRECT_NONE)
+	if !ok || blue.Color(2, ppu.LAYER_OBJ1) != (color.RGBA{0, 0, 0xFF, 0xFF}) {
+		t.Errorf("Pokemon Blue got %v", blue)
+	}
+	// Same sum, different fourth letter.
+	fake := []byte("POKEMON BLUE")
+	fake[3], fake[4] = fake[4], fake[3]
+	if _, ok := Colorize(header(string(fake), 0x01), CORRECT_NONE); ok {
+		t.Error("Matched on the title sum alone")
+	}
+	for _, c := range []struct {
+		title string
+		layer byte
+		want  color.RGBA
+	}{
+		{"TETRIS", ppu.LAYER_OBJ1, color.RGBA{0xFF, 0xFF, 0x00, 0xFF}},
+		{"DR.MARIO", ppu.LAYER_OBJ1, color.RGBA{0xFF, 0x84, 0x84, 0xFF}},
+		{"ZELDA", ppu.LAYER_BG, color.RGBA{0x00, 0xFF, 0x00, 0xFF}},
+		{"KIRBY DREAM LAND", ppu.LAYER_BG, color.RGBA{0xFF, 0xFF, 0x00, 0xFF}},
+		// Both sum to 0x46, as do GOLF and the space padded title of
+		// GALAGA&GALAXIAN.
+		{"SUPER MARIOLAND", ppu.LAYER_BG, color.RGBA{0xFF, 0xFF, 0x94, 0xFF}},
+		{"METROID2", ppu.LAYER_BG, color.RGBA{0xFF, 0xFF, 0x00, 0xFF}},
+		{"GOLF", ppu.LAYER_BG, color.RGBA{0x7B, 0xFF, 0x31, 0xFF}},
+		{"GALAGA&GALAXIAN ", ppu.LAYER_BG, color.RGBA{0x00, 0x84, 0x84, 0xFF}},
+	} {
+		if got, ok := Colorize(header(c.title, 0x01), CORRECT_NONE); !ok || got.Color(1, c.layer) != c.want {
+			t.Errorf("%s got %v", c.title, got)
+		}
+	}
+	for key := range titles {
+		if key.fourth != 0 && !shared[key.sum] {
+			t.Errorf("%02X%c is never looked up", key.sum, key.fourth)
+		}
+	}
+	h := header("POKEMON RED", 0x33)
+	h.NewLicensee = "01"
+	if red, ok := Colorize(h, CORRECT_NONE); !ok || red[ppu.LAYER_BG][1] != (color.RGBA{0xFF, 0x84, 0x84, 0xFF}) {
+		t.Errorf("Pokemon Red got %v", red)
+	}
+	def, ok := Colorize(header("POKEMON RED", 0x08), CORRECT_NONE)
+	if ok || def.Color(1, ppu.LAYER_BG) != (color.RGBA{0x7B, 0xFF, 0x31, 0xFF}) || def.Color(1, ppu.LAYER_OBJ0) != (color.RGBA{0xFF, 0x84, 0x84, 0xFF}) {
+		t.Errorf("Another licensee got %v", def)
+	}
+	if combo, ok := Combo(joypad.RIGHT|joypad.A, CORRECT_NONE); !ok || combo != def {
+		t.Errorf("Right and A picked %v", combo)
+	}
+	if _, ok := Combo(joypad.START, CORRECT_NONE); ok {
+		t.Error("Start picked a palette")
+	}
+	if Grey.CGB().Color(3, ppu.LAYER_OBJ1) != Grey[3] {
+		t.Error("DMG palette isn't used for sprites")
+	}
+}
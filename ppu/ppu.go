@@ -0,0 +1,552 @@
+package ppu
+
+import (
+	"sort"
+
+	"github.com/zbyrne/golangboy/z80"
+)
+
+const (
+	WIDTH  = 160
+	HEIGHT = 144
+
+	LINE_TICKS  = 456
+	FRAME_LINES = 154
+	FRAME_TICKS = LINE_TICKS * FRAME_LINES
+
+	oamTicks      = 80
+	transferTicks = 172
+)
+
+const (
+	LCDC uint16 = 0xFF40 + iota
+	STAT
+	SCY
+	SCX
+	LY
+	LYC
+	_
+	BGP
+	OBP0
+	OBP1
+	WY
+	WX
+)
+
+// CGB registers, which the MMU only passes on in CGB mode.
+const VBK uint16 = 0xFF4F
+
+const (
+	BCPS uint16 = 0xFF68 + iota
+	BCPD
+	OCPS
+	OCPD
+)
+
+const (
+	LCDC_BG_ENABLE byte = 1 << iota
+	LCDC_OBJ_ENABLE
+	LCDC_OBJ_SIZE
+	LCDC_BG_MAP
+	LCDC_TILE_DATA
+	LCDC_WINDOW_ENABLE
+	LCDC_WINDOW_MAP
+	LCDC_ENABLE
+)
+
+const (
+	MODE_HBLANK byte = iota
+	MODE_VBLANK
+	MODE_OAM
+	MODE_TRANSFER
+)
+
+const (
+	STAT_LYC_EQUAL  byte = 1 << 2
+	STAT_HBLANK_INT byte = 1 << 3
+	STAT_VBLANK_INT byte = 1 << 4
+	STAT_OAM_INT    byte = 1 << 5
+	STAT_LYC_INT    byte = 1 << 6
+)
+
+// Sprite attributes in OAM. In CGB mode background tiles have the same
+// attributes in VRAM1, apart from ATTR_PALETTE.
+const (
+	// ATTR_CGB_PALETTE picks one of the 8 colour palettes in CGB mode.
+	ATTR_CGB_PALETTE byte = 7
+	// ATTR_BANK takes the tile from VRAM1 in CGB mode.
+	ATTR_BANK     byte = 1 << 3
+	ATTR_PALETTE  byte = 1 << 4
+	ATTR_X_FLIP   byte = 1 << 5
+	ATTR_Y_FLIP   byte = 1 << 6
+	ATTR_PRIORITY byte = 1 << 7
+)
+
+// Which palette register a pixel's shade came from.
+const (
+	LAYER_BG byte = iota
+	LAYER_OBJ0
+	LAYER_OBJ1
+)
+
+// PPU draws one scanline at a time, at the end of mode 3. The frame buffer
+// holds one shade (0 white to 3 black) per pixel after the palette
+// registers have been applied. In CGB mode it holds the colour numbers
+// before the colour palettes instead, and the colours go in a buffer of
+// their own.
+type PPU struct {
+	VRAM [0x2000]byte
+	OAM  [0xA0]byte
+	// VRAM1 is the CGB's second bank of VRAM, picked with VBK.
+	VRAM1 [0x2000]byte
+
+	vbk        byte
+	bcps, ocps byte
+	// Colour palette RAM: 8 palettes of 4 colours, 2 bytes each.
+	bgPalettes, objPalettes [64]byte
+
+	lcdc, stat, scy, scx, ly, lyc byte
+	bgp, obp0, obp1, wy, wx       byte
+
+	mode      byte
+	dot       int
+	statLine  bool
+	winLine   int
+	winActive bool
+
+	frame  [WIDTH * HEIGHT]byte
+	layers [WIDTH * HEIGHT]byte
+	colors [WIDTH * HEIGHT]uint16
+	frames uint64
+
+	irq z80.Interrupter
+	dmg bool
+	cgb bool
+}
+
+func New(irq z80.Interrupter) *PPU {
+	return &PPU{irq: irq, mode: MODE_OAM}
+}
+
+// SetDMG turns on the DMG's STAT write bug: for a moment a write to STAT
+// enables the HBlank, VBlank and LYC sources, so it raises the interrupt
+// in HBlank, in VBlank or while LY matches LYC. Later models fixed it.
+func (p *PPU) SetDMG(on bool) {
+	p.dmg = on
+}
+
+// SetCGB draws with the tile attributes in VRAM1 and the colour palettes,
+// for a game running in CGB mode.
+func (p *PPU) SetCGB(on bool) {
+	p.cgb = on
+}
+
+// Frame returns the last completed frame, WIDTH*HEIGHT shades in rows
+// from the top.
+func (p *PPU) Frame() []byte {
+	return p.frame[:]
+}
+
+// Layers says which palette each pixel of the last frame was drawn with,
+// for colouring frames the way a CGB colours DMG games.
+func (p *PPU) Layers() []byte {
+	return p.layers[:]
+}
+
+// Colors returns the last frame's colours in CGB mode, in the CGB's 15 bit
+// format.
+func (p *PPU) Colors() []uint16 {
+	return p.colors[:]
+}
+
+// Frames counts the frames completed since power on.
+func (p *PPU) Frames() uint64 {
+	return p.frames
+}
+
+func (p *PPU) Mode() byte {
+	return p.mode
+}
+
+func (p *PPU) Enabled() bool {
+	return p.lcdc&LCDC_ENABLE != 0
+}
+
+// vram is the VRAM bank the CPU sees.
+func (p *PPU) vram() *[0x2000]byte {
+	if p.vbk&1 != 0 {
+		return &p.VRAM1
+	}
+	return &p.VRAM
+}
+
+// CGBColor returns colour c of background or object palette pal as set
+// through BCPD or OCPD, in the CGB's 15 bit format.
+func (p *PPU) CGBColor(obj bool, pal, c int) uint16 {
+	ram := &p.bgPalettes
+	if obj {
+		ram = &p.objPalettes
+	}
+	i := (pal*4 + c) * 2
+	return uint16(ram[i]) | uint16(ram[i+1])<<8
+}
+
+// writePalette stores a byte of palette RAM at the index in spec, then
+// moves the index on if its auto increment bit is set.
+func writePalette(ram *[64]byte, spec *byte, val byte) {
+	ram[*spec&0x3F] = val
+	if *spec&0x80 != 0 {
+		*spec = 0x80 | (*spec+1)&0x3F
+	}
+}
+
+func (p *PPU) ReadByte(addr uint16) byte {
+	switch {
+	case addr >= 0x8000 && addr < 0xA000:
+		return p.vram()[addr-0x8000]
+	case addr >= 0xFE00 && addr < 0xFEA0:
+		return p.OAM[addr-0xFE00]
+	}
+	switch addr {
+	case LCDC:
+		return p.lcdc
+	case STAT:
+		return 0x80 | p.stat | p.mode
+	case SCY:
+		return p.scy
+	case SCX:
+		return p.scx
+	case LY:
+		return p.ly
+	case LYC:
+		return p.lyc
+	case BGP:
+		return p.bgp
+	case OBP0:
+		return p.obp0
+	case OBP1:
+		return p.obp1
+	case WY:
+		return p.wy
+	case WX:
+		return p.wx
+	case VBK:
+		return 0xFE | p.vbk
+	case BCPS:
+		return p.bcps | 0x40
+	case BCPD:
+		return p.bgPalettes[p.bcps&0x3F]
+	case OCPS:
+		return p.ocps | 0x40
+	case OCPD:
+		return p.objPalettes[p.ocps&0x3F]
+	}
+	return 0xFF
+}
+
+func (p *PPU) WriteByte(addr uint16, val byte) {
+	switch {
+	case addr >= 0x8000 && addr < 0xA000:
+		p.vram()[addr-0x8000] = val
+		return
+	case addr >= 0xFE00 && addr < 0xFEA0:
+		p.OAM[addr-0xFE00] = val
+		return
+	}
+	switch addr {
+	case LCDC:
+		wasEnabled := p.Enabled()
+		p.lcdc = val
+		if wasEnabled && !p.Enabled() {
+			p.ly = 0
+			p.dot = 0
+			p.mode = MODE_HBLANK
+		} else if !wasEnabled && p.Enabled() {
+			p.mode = MODE_OAM
+			p.winLine = 0
+			p.winActive = false
+		}
+	case STAT:
+		if p.dmg {
+			p.stat |= STAT_HBLANK_INT | STAT_VBLANK_INT | STAT_LYC_INT
+			p.updateStat()
+		}
+		p.stat = val & 0x78
+	case SCY:
+		p.scy = val
+	case SCX:
+		p.scx = val
+	case LYC:
+		p.lyc = val
+	case BGP:
+		p.bgp = val
+	case OBP0:
+		p.obp0 = val
+	case OBP1:
+		p.obp1 = val
+	case WY:
+		p.wy = val
+	case WX:
+		p.wx = val
+	case VBK:
+		p.vbk = val & 1
+	case BCPS:
+		p.bcps = val &^ 0x40
+	case BCPD:
+		writePalette(&p.bgPalettes, &p.bcps, val)
+	case OCPS:
+		p.ocps = val &^ 0x40
+	case OCPD:
+		writePalette(&p.objPalettes, &p.ocps, val)
+	}
+	p.updateStat()
+}
+
+// updateStat raises the STAT interrupt on a rising edge of the OR of all
+// enabled sources.
+func (p *PPU) updateStat() {
+	if !p.Enabled() {
+		p.stat &^= STAT_LYC_EQUAL
+		p.statLine = false
+		return
+	}
+	if p.ly == p.lyc {
+		p.stat |= STAT_LYC_EQUAL
+	} else {
+		p.stat &^= STAT_LYC_EQUAL
+	}
+	line := p.stat&STAT_LYC_EQUAL != 0 && p.stat&STAT_LYC_INT != 0
+	switch p.mode {
+	case MODE_HBLANK:
+		line = line || p.stat&STAT_HBLANK_INT != 0
+	case MODE_VBLANK:
+		line = line || p.stat&STAT_VBLANK_INT != 0
+	case MODE_OAM:
+		line = line || p.stat&STAT_OAM_INT != 0
+	}
+	if line && !p.statLine && p.irq != nil {
+		p.irq.RequestInterrupt(z80.LCD_STAT_INT)
+	}
+	p.statLine = line
+}
+
+func (p *PPU) Tick(t z80.ClockTicks) {
+	if !p.Enabled() {
+		return
+	}
+	for ; t > 0; t -= 4 {
+		p.step()
+	}
+}
+
+func (p *PPU) step() {
+	p.dot += 4
+	if p.dot == LINE_TICKS {
+		p.nextLine()
+	} else if p.ly < HEIGHT {
+		switch p.dot {
+		case oamTicks:
+			p.mode = MODE_TRANSFER
+		case oamTicks + transferTicks:
+			p.renderLine()
+			p.mode = MODE_HBLANK
+		default:
+			return
+		}
+	} else {
+		return
+	}
+	p.updateStat()
+}
+
+func (p *PPU) nextLine() {
+	p.dot = 0
+	p.ly++
+	switch {
+	case p.ly == HEIGHT:
+		p.mode = MODE_VBLANK
+		p.frames++
+		if p.irq != nil {
+			p.irq.RequestInterrupt(z80.VBLANK_INT)
+		}
+	case p.ly == FRAME_LINES:
+		p.ly = 0
+		p.winLine = 0
+		p.winActive = false
+		p.mode = MODE_OAM
+	case p.ly < HEIGHT:
+		p.mode = MODE_OAM
+	}
+}
+
+// tileRow returns the two bytes of a row of a tile, from the VRAM bank
+// attr picks.
+func (p *PPU) tileRow(tile byte, row int, unsigned bool, attr byte) (byte, byte) {
+	var base int
+	if unsigned {
+		base = int(tile) * 16
+	} else {
+		base = 0x1000 + int(int8(tile))*16
+	}
+	vram := &p.VRAM
+	if attr&ATTR_BANK != 0 {
+		vram = &p.VRAM1
+	}
+	return vram[base+row*2], vram[base+row*2+1]
+}
+
+// mapPixel returns the colour number at (x, y) of the tile map at base,
+// and the tile's attributes in CGB mode.
+func (p *PPU) mapPixel(base, x, y int, unsigned bool) (idx, attr byte) {
+	i := base + (y/8)*32 + x/8
+	tile := p.VRAM[i]
+	if p.cgb {
+		attr = p.VRAM1[i]
+	}
+	row, col := y%8, x%8
+	if attr&ATTR_Y_FLIP != 0 {
+		row = 7 - row
+	}
+	if attr&ATTR_X_FLIP != 0 {
+		col = 7 - col
+	}
+	lo, hi := p.tileRow(tile, row, unsigned, attr)
+	return pixel(lo, hi, col), attr
+}
+
+func pixel(lo, hi byte, x int) byte {
+	bit := 7 - uint(x)
+	return (lo>>bit)&1 | ((hi>>bit)&1)<<1
+}
+
+func shade(palette, idx byte) byte {
+	return (palette >> (idx * 2)) & 3
+}
+
+type sprite struct {
+	y, x, tile, attr byte
+	index            int
+}
+
+func (p *PPU) renderLine() {
+	// bgAttr holds each background pixel's tile attributes in CGB mode.
+	var bgIdx, bgAttr [WIDTH]byte
+	line := p.frame[int(p.ly)*WIDTH : int(p.ly+1)*WIDTH]
+	layers := p.layers[int(p.ly)*WIDTH : int(p.ly+1)*WIDTH]
+	colors := p.colors[int(p.ly)*WIDTH : int(p.ly+1)*WIDTH]
+	unsigned := p.lcdc&LCDC_TILE_DATA != 0
+
+	if p.ly == p.wy {
+		p.winActive = true
+	}
+	// In CGB mode LCDC bit 0 doesn't hide the background, it only takes
+	// away its priority over sprites.
+	if p.cgb || p.lcdc&LCDC_BG_ENABLE != 0 {
+		mapBase := 0x1800
+		if p.lcdc&LCDC_BG_MAP != 0 {
+			mapBase = 0x1C00
+		}
+		y := int(p.ly+p.scy) & 0xFF
+		for x := 0; x < WIDTH; x++ {
+			bgIdx[x], bgAttr[x] = p.mapPixel(mapBase, (x+int(p.scx))&0xFF, y, unsigned)
+		}
+
+		wx := int(p.wx) - 7
+		if p.lcdc&LCDC_WINDOW_ENABLE != 0 && p.winActive && wx < WIDTH {
+			mapBase = 0x1800
+			if p.lcdc&LCDC_WINDOW_MAP != 0 {
+				mapBase = 0x1C00
+			}
+			for x := wx; x < WIDTH; x++ {
+				if x < 0 {
+					continue
+				}
+				bgIdx[x], bgAttr[x] = p.mapPixel(mapBase, x-wx, p.winLine, unsigned)
+			}
+			p.winLine++
+		}
+	}
+	for x := range line {
+		if p.cgb {
+			line[x] = bgIdx[x]
+			colors[x] = p.CGBColor(false, int(bgAttr[x]&ATTR_CGB_PALETTE), int(bgIdx[x]))
+		} else {
+			line[x] = shade(p.bgp, bgIdx[x])
+		}
+		layers[x] = LAYER_BG
+	}
+
+	if p.lcdc&LCDC_OBJ_ENABLE == 0 {
+		return
+	}
+	height := 8
+	if p.lcdc&LCDC_OBJ_SIZE != 0 {
+		height = 16
+	}
+	var sprites []sprite
+	for i := 0; i < 40 && len(sprites) < 10; i++ {
+		s := sprite{p.OAM[i*4], p.OAM[i*4+1], p.OAM[i*4+2], p.OAM[i*4+3], i}
+		top := int(s.y) - 16
+		if int(p.ly) >= top && int(p.ly) < top+height {
+			sprites = append(sprites, s)
+		}
+	}
+	// Lower X wins, then lower OAM index. A CGB in CGB mode only goes by
+	// OAM index. Draw the winners last.
+	sort.Slice(sprites, func(i, j int) bool {
+		if !p.cgb && sprites[i].x != sprites[j].x {
+			return sprites[i].x > sprites[j].x
+		}
+		return sprites[i].index > sprites[j].index
+	})
+	for _, s := range sprites {
+		row := int(p.ly) - (int(s.y) - 16)
+		if s.attr&ATTR_Y_FLIP != 0 {
+			row = height - 1 - row
+		}
+		tile := s.tile
+		if height == 16 {
+			tile &^= 1
+		}
+		attr := s.attr
+		if !p.cgb {
+			attr &^= ATTR_BANK
+		}
+		lo, hi := p.tileRow(tile, row, true, attr)
+		palette, layer := p.obp0, LAYER_OBJ0
+		if s.attr&ATTR_PALETTE != 0 && !p.cgb {
+			palette, layer = p.obp1, LAYER_OBJ1
+		}
+		for px := 0; px < 8; px++ {
+			x := int(s.x) - 8 + px
+			if x < 0 || x >= WIDTH {
+				continue
+			}
+			tx := px
+			if s.attr&ATTR_X_FLIP != 0 {
+				tx = 7 - px
+			}
+			idx := pixel(lo, hi, tx)
+			if idx == 0 {
+				continue
+			}
+			if p.cgb {
+				// The background's own priority bit beats the sprite's,
+				// and LCDC bit 0 turns both off.
+				behind := s.attr&ATTR_PRIORITY != 0 || bgAttr[x]&ATTR_PRIORITY != 0
+				if behind && bgIdx[x] != 0 && p.lcdc&LCDC_BG_ENABLE != 0 {
+					continue
+				}
+				line[x] = idx
+				colors[x] = p.CGBColor(true, int(s.attr&ATTR_CGB_PALETTE), int(idx))
+				layers[x] = layer
+				continue
+			}
+			if s.attr&ATTR_PRIORITY != 0 && bgIdx[x] != 0 {
+				continue
+			}
+			line[x] = shade(palette, idx)
+			layers[x] = layer
+		}
+	}
+}
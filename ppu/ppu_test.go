@@ -0,0 +1,407 @@
+package ppu
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zbyrne/golangboy/savestate"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+type mockInterrupter struct {
+	requested byte
+}
+
+func (m *mockInterrupter) RequestInterrupt(i byte) {
+	m.requested |= i
+}
+
+func newEnabled(irq z80.Interrupter) *PPU {
+	p := New(irq)
+	p.WriteByte(BGP, 0xE4)
+	p.WriteByte(OBP0, 0xE4)
+	p.WriteByte(OBP1, 0x1B)
+	p.WriteByte(LCDC, LCDC_ENABLE|LCDC_BG_ENABLE|LCDC_TILE_DATA)
+	return p
+}
+
+// solidTile fills tile n (in the 0x8000 area) with colour index idx.
+func solidTile(p *PPU, n int, idx byte) {
+	var lo, hi byte
+	if idx&1 != 0 {
+		lo = 0xFF
+	}
+	if idx&2 != 0 {
+		hi = 0xFF
+	}
+	for row := 0; row < 8; row++ {
+		p.VRAM[n*16+row*2] = lo
+		p.VRAM[n*16+row*2+1] = hi
+	}
+}
+
+func TestModeTiming(t *testing.T) {
+	p := newEnabled(nil)
+	if p.Mode() != MODE_OAM {
+		t.Errorf("Mode = %d at start of line, not 2", p.Mode())
+	}
+	p.Tick(80)
+	if p.Mode() != MODE_TRANSFER {
+		t.Errorf("Mode = %d after 80 ticks, not 3", p.Mode())
+	}
+	p.Tick(172)
+	if p.Mode() != MODE_HBLANK {
+		t.Errorf("Mode = %d after 252 ticks, not 0", p.Mode())
+	}
+	p.Tick(204)
+	if val := p.ReadByte(LY); val != 1 {
+		t.Errorf("LY = %d, not 1", val)
+	}
+	if p.Mode() != MODE_OAM {
+		t.Errorf("Mode = %d at start of line 1, not 2", p.Mode())
+	}
+}
+
+func TestVBlank(t *testing.T) {
+	var irq mockInterrupter
+	p := newEnabled(&irq)
+	p.Tick(LINE_TICKS*HEIGHT - 4)
+	if irq.requested != 0 {
+		t.Error("Interrupt before VBlank")
+	}
+	p.Tick(4)
+	if irq.requested != z80.VBLANK_INT {
+		t.Errorf("Requested 0x%02X, not VBlank", irq.requested)
+	}
+	if p.Frames() != 1 {
+		t.Errorf("Frames = %d, not 1", p.Frames())
+	}
+	if val := p.ReadByte(STAT) & 3; val != MODE_VBLANK {
+		t.Errorf("Mode = %d, not 1", val)
+	}
+	p.Tick(LINE_TICKS * 10)
+	if val := p.ReadByte(LY); val != 0 {
+		t.Errorf("LY = %d after a whole frame, not 0", val)
+	}
+}
+
+func TestLCDOff(t *testing.T) {
+	p := newEnabled(nil)
+	p.Tick(LINE_TICKS * 5)
+	p.WriteByte(LCDC, 0)
+	if val := p.ReadByte(LY); val != 0 {
+		t.Errorf("LY = %d with the LCD off, not 0", val)
+	}
+	p.Tick(LINE_TICKS * 5)
+	if val := p.ReadByte(LY); val != 0 {
+		t.Errorf("LY = %d with the LCD off, not 0", val)
+	}
+}
+
+func TestLYCInterrupt(t *testing.T) {
+	var irq mockInterrupter
+	p := newEnabled(&irq)
+	p.WriteByte(LYC, 3)
+	p.WriteByte(STAT, STAT_LYC_INT)
+	p.Tick(LINE_TICKS * 3)
+	if irq.requested != z80.LCD_STAT_INT {
+		t.Errorf("Requested 0x%02X, not STAT", irq.requested)
+	}
+	if p.ReadByte(STAT)&STAT_LYC_EQUAL == 0 {
+		t.Error("LYC coincidence flag not set")
+	}
+}
+
+func TestHBlankInterrupt(t *testing.T) {
+	var irq mockInterrupter
+	p := newEnabled(&irq)
+	p.WriteByte(STAT, STAT_HBLANK_INT)
+	p.Tick(248)
+	if irq.requested != 0 {
+		t.Error("Interrupt before HBlank")
+	}
+	p.Tick(4)
+	if irq.requested != z80.LCD_STAT_INT {
+		t.Errorf("Requested 0x%02X, not STAT", irq.requested)
+	}
+}
+
+func TestDMGStatWriteBug(t *testing.T) {
+	for _, dmg := range []bool{false, true} {
+		var irq mockInterrupter
+		p := newEnabled(&irq)
+		p.SetDMG(dmg)
+		// Away from LY, which would set it off too.
+		p.WriteByte(LYC, 5)
+		p.WriteByte(STAT, 0)
+		if irq.requested != 0 {
+			t.Errorf("DMG %t: interrupt from a write in OAM search", dmg)
+		}
+		p.Tick(252)
+		p.WriteByte(STAT, 0)
+		if want := map[bool]byte{true: z80.LCD_STAT_INT}[dmg]; irq.requested != want {
+			t.Errorf("DMG %t: write in HBlank requested 0x%02X", dmg, irq.requested)
+		}
+	}
+}
+
+func TestBackground(t *testing.T) {
+	p := newEnabled(nil)
+	solidTile(p, 1, 3)
+	p.VRAM[0x1800+1] = 1
+	p.Tick(FRAME_TICKS)
+	f := p.Frame()
+	if f[0] != 0 {
+		t.Errorf("Pixel (0, 0) = %d, not 0", f[0])
+	}
+	if f[8] != 3 || f[7*WIDTH+15] != 3 {
+		t.Errorf("Tile 1 pixels = %d, %d, not 3", f[8], f[7*WIDTH+15])
+	}
+}
+
+func TestBackgroundScroll(t *testing.T) {
+	p := newEnabled(nil)
+	solidTile(p, 1, 2)
+	p.VRAM[0x1800+32+1] = 1
+	p.WriteByte(SCX, 4)
+	p.WriteByte(SCY, 8)
+	p.Tick(FRAME_TICKS)
+	f := p.Frame()
+	if f[3] != 0 || f[4] != 2 || f[11] != 2 || f[12] != 0 {
+		t.Errorf("Scrolled row = %v", f[:16])
+	}
+}
+
+func TestSignedTileData(t *testing.T) {
+	p := newEnabled(nil)
+	p.WriteByte(LCDC, LCDC_ENABLE|LCDC_BG_ENABLE)
+	// Tile 0xFF in 0x8800 mode lives at 0x8FF0.
+	for row := 0; row < 8; row++ {
+		p.VRAM[0x0FF0+row*2] = 0xFF
+	}
+	p.VRAM[0x1800] = 0xFF
+	p.Tick(FRAME_TICKS)
+	if f := p.Frame(); f[0] != 1 {
+		t.Errorf("Pixel (0, 0) = %d, not 1", f[0])
+	}
+}
+
+func TestWindow(t *testing.T) {
+	p := newEnabled(nil)
+	p.WriteByte(LCDC, LCDC_ENABLE|LCDC_BG_ENABLE|LCDC_TILE_DATA|LCDC_WINDOW_ENABLE|LCDC_WINDOW_MAP)
+	solidTile(p, 2, 1)
+	for i := 0; i < 32*32; i++ {
+		p.VRAM[0x1C00+i] = 2
+	}
+	p.WriteByte(WY, 10)
+	p.WriteByte(WX, 87)
+	p.Tick(FRAME_TICKS)
+	f := p.Frame()
+	if f[10*WIDTH+79] != 0 || f[10*WIDTH+80] != 1 {
+		t.Errorf("Window edge = %d, %d", f[10*WIDTH+79], f[10*WIDTH+80])
+	}
+	if f[9*WIDTH+100] != 0 {
+		t.Error("Window drawn above WY")
+	}
+}
+
+func TestSprite(t *testing.T) {
+	p := newEnabled(nil)
+	p.WriteByte(LCDC, LCDC_ENABLE|LCDC_BG_ENABLE|LCDC_TILE_DATA|LCDC_OBJ_ENABLE)
+	solidTile(p, 4, 1)
+	copy(p.OAM[0:], []byte{16 + 10, 8 + 20, 4, 0})
+	copy(p.OAM[4:], []byte{16 + 10, 8 + 40, 4, ATTR_PALETTE})
+	p.Tick(FRAME_TICKS)
+	f := p.Frame()
+	if f[10*WIDTH+20] != 1 || f[17*WIDTH+27] != 1 {
+		t.Error("Sprite not drawn with OBP0")
+	}
+	if f[10*WIDTH+40] != 2 {
+		t.Errorf("Sprite shade = %d with OBP1, not 2", f[10*WIDTH+40])
+	}
+	if f[10*WIDTH+28] != 0 || f[18*WIDTH+20] != 0 {
+		t.Error("Sprite drawn outside its tile")
+	}
+	l := p.Layers()
+	if l[10*WIDTH+20] != LAYER_OBJ0 || l[10*WIDTH+40] != LAYER_OBJ1 || l[10*WIDTH+28] != LAYER_BG {
+		t.Errorf("Layers are %d, %d and %d", l[10*WIDTH+20], l[10*WIDTH+40], l[10*WIDTH+28])
+	}
+}
+
+func TestSpriteBehindBackground(t *testing.T) {
+	p := newEnabled(nil)
+	p.WriteByte(LCDC, LCDC_ENABLE|LCDC_BG_ENABLE|LCDC_TILE_DATA|LCDC_OBJ_ENABLE)
+	solidTile(p, 1, 2)
+	solidTile(p, 4, 3)
+	p.VRAM[0x1800] = 1
+	copy(p.OAM[0:], []byte{16, 8 + 4, 4, ATTR_PRIORITY})
+	p.Tick(FRAME_TICKS)
+	f := p.Frame()
+	if f[3] != 2 {
+		t.Errorf("Pixel over BG colour 1-3 = %d, not 2", f[3])
+	}
+	if f[9] != 3 {
+		t.Errorf("Pixel over BG colour 0 = %d, not 3", f[9])
+	}
+	if l := p.Layers(); l[3] != LAYER_BG || l[9] != LAYER_OBJ0 {
+		t.Errorf("Layers are %d and %d", l[3], l[9])
+	}
+}
+
+func TestSpritePriority(t *testing.T) {
+	p := newEnabled(nil)
+	p.WriteByte(LCDC, LCDC_ENABLE|LCDC_BG_ENABLE|LCDC_TILE_DATA|LCDC_OBJ_ENABLE)
+	solidTile(p, 4, 1)
+	solidTile(p, 5, 3)
+	copy(p.OAM[0:], []byte{16, 8 + 4, 4, 0})
+	copy(p.OAM[4:], []byte{16, 8, 5, 0})
+	copy(p.OAM[8:], []byte{16, 8 + 8, 4, 0})
+	copy(p.OAM[12:], []byte{16, 8 + 8, 5, 0})
+	p.Tick(FRAME_TICKS)
+	f := p.Frame()
+	if f[5] != 3 {
+		t.Errorf("Lower X sprite lost, pixel = %d", f[5])
+	}
+	if f[13] != 1 {
+		t.Errorf("Lower OAM index lost, pixel = %d", f[13])
+	}
+}
+
+// setColor sets colour c of a CGB palette through BCPS and BCPD or OCPS
+// and OCPD.
+func setColor(p *PPU, obj bool, pal, c int, v uint16) {
+	spec, data := BCPS, BCPD
+	if obj {
+		spec, data = OCPS, OCPD
+	}
+	p.WriteByte(spec, 0x80|byte((pal*4+c)*2))
+	p.WriteByte(data, byte(v))
+	p.WriteByte(data, byte(v>>8))
+}
+
+func TestCGBBackground(t *testing.T) {
+	p := newEnabled(nil)
+	p.SetCGB(true)
+	setColor(p, false, 2, 1, 0x001F)
+	setColor(p, false, 0, 3, 0x03E0)
+	solidTile(p, 1, 3)
+	// The left half of tile 1 in VRAM1 is colour 1.
+	for row := 0; row < 8; row++ {
+		p.VRAM1[16+row*2] = 0xF0
+	}
+	p.VRAM[0x1800], p.VRAM1[0x1800] = 1, ATTR_BANK|ATTR_X_FLIP|2
+	p.VRAM[0x1801] = 1
+	p.Tick(FRAME_TICKS)
+	f, c := p.Frame(), p.Colors()
+	if f[0] != 0 || f[4] != 1 || c[4] != 0x001F {
+		t.Errorf("Flipped VRAM1 tile drew %d, %d in 0x%04X", f[0], f[4], c[4])
+	}
+	if f[8] != 3 || c[8] != 0x03E0 {
+		t.Errorf("VRAM tile drew %d in 0x%04X", f[8], c[8])
+	}
+	// Without priority the background is still drawn.
+	p.WriteByte(LCDC, LCDC_ENABLE|LCDC_TILE_DATA)
+	p.Tick(FRAME_TICKS)
+	if f[8] != 3 {
+		t.Errorf("Background with LCDC bit 0 clear drew %d", f[8])
+	}
+}
+
+func TestCGBSprites(t *testing.T) {
+	p := newEnabled(nil)
+	p.SetCGB(true)
+	p.WriteByte(LCDC, LCDC_ENABLE|LCDC_BG_ENABLE|LCDC_TILE_DATA|LCDC_OBJ_ENABLE)
+	setColor(p, true, 5, 3, 0x7C00)
+	setColor(p, true, 1, 1, 0x001F)
+	solidTile(p, 1, 2)
+	solidTile(p, 4, 1)
+	for i := 0; i < 16; i += 2 {
+		p.VRAM1[4*16+i], p.VRAM1[4*16+i+1] = 0xFF, 0xFF
+	}
+	// Tile 1 is behind every sprite by its own attributes.
+	p.VRAM[0x1804], p.VRAM1[0x1804] = 1, ATTR_PRIORITY
+	copy(p.OAM[0:], []byte{16, 8 + 8, 4, ATTR_BANK | 5})
+	// Only OAM index counts, not X.
+	copy(p.OAM[4:], []byte{16, 8 + 4, 4, 1})
+	copy(p.OAM[8:], []byte{16, 8 + 32, 4, 1})
+	p.Tick(FRAME_TICKS)
+	f, c := p.Frame(), p.Colors()
+	if f[8] != 3 || c[8] != 0x7C00 || p.Layers()[8] != LAYER_OBJ0 {
+		t.Errorf("VRAM1 sprite drew %d in 0x%04X", f[8], c[8])
+	}
+	if f[4] != 1 || c[4] != 0x001F {
+		t.Errorf("Sprite with palette 1 drew %d in 0x%04X", f[4], c[4])
+	}
+	if f[32] != 2 {
+		t.Errorf("Sprite over a priority tile drew %d", f[32])
+	}
+	p.WriteByte(LCDC, LCDC_ENABLE|LCDC_TILE_DATA|LCDC_OBJ_ENABLE)
+	p.Tick(FRAME_TICKS)
+	if f[32] != 1 {
+		t.Errorf("Sprite with LCDC bit 0 clear drew %d", f[32])
+	}
+}
+
+func TestSpriteLimit(t *testing.T) {
+	p := newEnabled(nil)
+	p.WriteByte(LCDC, LCDC_ENABLE|LCDC_BG_ENABLE|LCDC_TILE_DATA|LCDC_OBJ_ENABLE)
+	solidTile(p, 4, 3)
+	for i := 0; i < 11; i++ {
+		copy(p.OAM[i*4:], []byte{16, byte(8 + i*10), 4, 0})
+	}
+	p.Tick(FRAME_TICKS)
+	f := p.Frame()
+	if f[90] != 3 {
+		t.Error("Tenth sprite not drawn")
+	}
+	if f[100] != 0 {
+		t.Error("Eleventh sprite drawn")
+	}
+}
+
+func TestTallSpriteFlip(t *testing.T) {
+	p := newEnabled(nil)
+	p.WriteByte(LCDC, LCDC_ENABLE|LCDC_BG_ENABLE|LCDC_TILE_DATA|LCDC_OBJ_ENABLE|LCDC_OBJ_SIZE)
+	solidTile(p, 6, 1)
+	solidTile(p, 7, 3)
+	copy(p.OAM[0:], []byte{16, 8, 7, ATTR_Y_FLIP})
+	p.Tick(FRAME_TICKS)
+	f := p.Frame()
+	if f[0] != 3 || f[15*WIDTH] != 1 {
+		t.Errorf("Flipped 8x16 sprite = %d top, %d bottom", f[0], f[15*WIDTH])
+	}
+}
+
+func TestState(t *testing.T) {
+	var irq mockInterrupter
+	p := newEnabled(&irq)
+	solidTile(p, 0, 3)
+	p.OAM[0] = 0x10
+	p.WriteByte(SCX, 3)
+	p.Tick(LINE_TICKS*10 + 100)
+	loaded := New(&irq)
+	if err := savestate.Copy(loaded, p, STATE_VERSION); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, p) {
+		t.Error("Loaded state differs from saved")
+	}
+}
+
+func TestStateLayers(t *testing.T) {
+	p := newEnabled(nil)
+	p.WriteByte(LCDC, LCDC_ENABLE|LCDC_BG_ENABLE|LCDC_TILE_DATA|LCDC_OBJ_ENABLE)
+	solidTile(p, 1, 3)
+	copy(p.OAM[0:], []byte{16, 8, 1, ATTR_PALETTE})
+	p.Tick(FRAME_TICKS)
+	if p.Layers()[0] != LAYER_OBJ1 {
+		t.Fatalf("Sprite pixel is layer %d", p.Layers()[0])
+	}
+	loaded := New(nil)
+	if err := savestate.Copy(loaded, p, STATE_VERSION); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Layers(), p.Layers()) {
+		t.Error("Layers differ after loading")
+	}
+}
@@ -0,0 +1,54 @@
+package ppu
+
+import "github.com/zbyrne/golangboy/savestate"
+
+// STATE_VERSION is the version of the PPU's save state chunk.
+const STATE_VERSION = 1
+
+func (p *PPU) SaveState(e *savestate.Encoder) {
+	e.Bytes(p.VRAM[:])
+	e.Bytes(p.OAM[:])
+	for _, r := range []byte{p.lcdc, p.stat, p.scy, p.scx, p.ly, p.lyc, p.bgp, p.obp0, p.obp1, p.wy, p.wx, p.mode} {
+		e.Byte(r)
+	}
+	e.Int(p.dot)
+	e.Bool(p.statLine)
+	e.Int(p.winLine)
+	e.Bool(p.winActive)
+	e.Bytes(p.frame[:])
+	e.Uint64(p.frames)
+	e.Bytes(p.layers[:])
+	e.Bytes(p.VRAM1[:])
+	e.Byte(p.vbk)
+	e.Byte(p.bcps)
+	e.Byte(p.ocps)
+	e.Bytes(p.bgPalettes[:])
+	e.Bytes(p.objPalettes[:])
+	for _, c := range p.colors {
+		e.Uint16(c)
+	}
+}
+
+func (p *PPU) LoadState(d *savestate.Decoder) {
+	d.Bytes(p.VRAM[:])
+	d.Bytes(p.OAM[:])
+	for _, r := range []*byte{&p.lcdc, &p.stat, &p.scy, &p.scx, &p.ly, &p.lyc, &p.bgp, &p.obp0, &p.obp1, &p.wy, &p.wx, &p.mode} {
+		*r = d.Byte()
+	}
+	p.dot = d.Int()
+	p.statLine = d.Bool()
+	p.winLine = d.Int()
+	p.winActive = d.Bool()
+	d.Bytes(p.frame[:])
+	p.frames = d.Uint64()
+	d.Bytes(p.layers[:])
+	d.Bytes(p.VRAM1[:])
+	p.vbk = d.Byte()
+	p.bcps = d.Byte()
+	p.ocps = d.Byte()
+	d.Bytes(p.bgPalettes[:])
+	d.Bytes(p.objPalettes[:])
+	for i := range p.colors {
+		p.colors[i] = d.Uint16()
+	}
+}
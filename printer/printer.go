@@ -0,0 +1,286 @@
+package printer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+const (
+	CMD_INIT   byte = 0x01
+	CMD_PRINT  byte = 0x02
+	CMD_DATA   byte = 0x04
+	CMD_STATUS byte = 0x0F
+)
+
+const (
+	STATUS_CHECKSUM_ERROR byte = 1 << iota
+	STATUS_PRINTING
+	STATUS_IMAGE_FULL
+	STATUS_UNPROCESSED
+	STATUS_PACKET_ERROR
+	STATUS_PAPER_JAM
+	STATUS_OTHER_ERROR
+	STATUS_LOW_BATTERY
+)
+
+const (
+	WIDTH = 160
+	// One data packet carries two rows of 20 tiles.
+	bandHeight = 16
+	bandSize   = 640
+	bufferSize = 0x2280
+	aliveReply = 0x81
+)
+
+type state int
+
+const (
+	stateMagic1 state = iota
+	stateMagic2
+	stateCommand
+	stateCompression
+	stateLenLo
+	stateLenHi
+	stateData
+	stateSumLo
+	stateSumHi
+	stateAlive
+	stateStatus
+)
+
+var Shades = color.Palette{
+	color.Gray{0xFF},
+	color.Gray{0xAA},
+	color.Gray{0x55},
+	color.Gray{0x00},
+}
+
+// Printer is a Game Boy Printer. Plug it into a serial port with
+// Port.Connect. Finished prints are passed to Output; consecutive prints
+// with no feed between them are joined into one image.
+type Printer struct {
+	Output func(image.Image) error
+
+	state       state
+	command     byte
+	compressed  bool
+	length      uint16
+	data        []byte
+	sum, rxSum  uint16
+	status      byte
+	busyPackets int
+
+	buffer []byte
+	page   *image.Paletted
+	err    error
+}
+
+func New(output func(image.Image) error) *Printer {
+	return &Printer{Output: output}
+}
+
+// Err returns the first error returned by Output.
+func (p *Printer) Err() error {
+	return p.err
+}
+
+func (p *Printer) Exchange(in byte) byte {
+	switch p.state {
+	case stateMagic1:
+		if in == 0x88 {
+			p.state = stateMagic2
+		}
+	case stateMagic2:
+		if in == 0x33 {
+			p.state = stateCommand
+		} else {
+			p.state = stateMagic1
+		}
+	case stateCommand:
+		p.command = in
+		p.sum = uint16(in)
+		p.state = stateCompression
+	case stateCompression:
+		p.compressed = in&1 != 0
+		p.sum += uint16(in)
+		p.state = stateLenLo
+	case stateLenLo:
+		p.length = uint16(in)
+		p.sum += uint16(in)
+		p.state = stateLenHi
+	case stateLenHi:
+		p.length |= uint16(in) << 8
+		p.sum += uint16(in)
+		p.data = p.data[:0]
+		if p.length > 0 {
+			p.state = stateData
+		} else {
+			p.state = stateSumLo
+		}
+	case stateData:
+		p.data = append(p.data, in)
+		p.sum += uint16(in)
+		if len(p.data) == int(p.length) {
+			p.state = stateSumLo
+		}
+	case stateSumLo:
+		p.rxSum = uint16(in)
+		p.state = stateSumHi
+	case stateSumHi:
+		p.rxSum |= uint16(in) << 8
+		p.state = stateAlive
+	case stateAlive:
+		p.state = stateStatus
+		return aliveReply
+	case stateStatus:
+		p.state = stateMagic1
+		p.packet()
+		return p.status
+	}
+	return 0x00
+}
+
+func (p *Printer) packet() {
+	if p.rxSum != p.sum {
+		p.status |= STATUS_CHECKSUM_ERROR
+		return
+	}
+	p.status &^= STATUS_CHECKSUM_ERROR
+	if p.busyPackets > 0 {
+		p.busyPackets--
+		if p.busyPackets == 0 {
+			p.status &^= STATUS_PRINTING
+		}
+	}
+	switch p.command {
+	case CMD_INIT:
+		p.buffer = p.buffer[:0]
+		p.status = 0
+		p.busyPackets = 0
+	case CMD_DATA:
+		if p.compressed {
+			p.buffer = decompress(p.buffer, p.data)
+		} else {
+			p.buffer = append(p.buffer, p.data...)
+		}
+		if len(p.buffer) >= bufferSize {
+			p.buffer = p.buffer[:bufferSize]
+			p.status |= STATUS_IMAGE_FULL
+		}
+		if len(p.buffer) > 0 {
+			p.status |= STATUS_UNPROCESSED
+		}
+	case CMD_PRINT:
+		if len(p.data) < 4 {
+			p.status |= STATUS_PACKET_ERROR
+			return
+		}
+		p.print(p.data[1], p.data[2])
+		p.buffer = p.buffer[:0]
+		p.status &^= STATUS_UNPROCESSED | STATUS_IMAGE_FULL
+		p.status |= STATUS_PRINTING
+		// Report busy for a couple of status polls, as the real
+		// thing takes a while to move the paper.
+		p.busyPackets = 2
+	case CMD_STATUS:
+	default:
+		p.status |= STATUS_PACKET_ERROR
+	}
+}
+
+// decompress expands the printer's run length encoding. A control byte
+// with the top bit set repeats the next byte (n&0x7F)+2 times, otherwise
+// n+1 literal bytes follow.
+func decompress(dst, src []byte) []byte {
+	for i := 0; i < len(src); {
+		ctrl := src[i]
+		i++
+		if ctrl&0x80 != 0 {
+			if i >= len(src) {
+				break
+			}
+			for n := int(ctrl&0x7F) + 2; n > 0; n-- {
+				dst = append(dst, src[i])
+			}
+			i++
+		} else {
+			n := int(ctrl) + 1
+			if i+n > len(src) {
+				n = len(src) - i
+			}
+			dst = append(dst, src[i:i+n]...)
+			i += n
+		}
+	}
+	return dst
+}
+
+func (p *Printer) print(margins, palette byte) {
+	if palette == 0 {
+		palette = 0xE4
+	}
+	bands := len(p.buffer) / bandSize
+	if p.page == nil {
+		p.page = image.NewPaletted(image.Rect(0, 0, WIDTH, 0), Shades)
+	}
+	top := p.page.Rect.Dy()
+	page := image.NewPaletted(image.Rect(0, 0, WIDTH, top+bands*bandHeight), Shades)
+	copy(page.Pix, p.page.Pix)
+	for b := 0; b < bands; b++ {
+		band := p.buffer[b*bandSize : (b+1)*bandSize]
+		for tile := 0; tile < 40; tile++ {
+			x0 := (tile % 20) * 8
+			y0 := top + b*bandHeight + (tile/20)*8
+			for row := 0; row < 8; row++ {
+				lo := band[tile*16+row*2]
+				hi := band[tile*16+row*2+1]
+				for col := 0; col < 8; col++ {
+					bit := 7 - uint(col)
+					idx := (lo>>bit)&1 | ((hi>>bit)&1)<<1
+					shade := (palette >> (idx * 2)) & 3
+					page.SetColorIndex(x0+col, y0+row, shade)
+				}
+			}
+		}
+	}
+	p.page = page
+	if margins&0x0F != 0 {
+		p.Flush()
+	}
+}
+
+// Flush outputs anything printed since the last paper feed.
+func (p *Printer) Flush() {
+	if p.page == nil || p.page.Rect.Dy() == 0 {
+		return
+	}
+	page := p.page
+	p.page = nil
+	if p.Output != nil {
+		if err := p.Output(page); err != nil && p.err == nil {
+			p.err = err
+		}
+	}
+}
+
+// PNGDir returns an Output function that saves each print as a numbered
+// PNG file in dir.
+func PNGDir(dir string) func(image.Image) error {
+	n := 0
+	return func(img image.Image) error {
+		n++
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("print-%04d.png", n)))
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(f, img); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
@@ -0,0 +1,247 @@
+package printer
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zbyrne/golangboy/serial"
+)
+
+func packet(cmd byte, compressed bool, data []byte) []byte {
+	var comp byte
+	if compressed {
+		comp = 1
+	}
+	pkt := []byte{0x88, 0x33, cmd, comp, byte(len(data)), byte(len(data) >> 8)}
+	pkt = append(pkt, data...)
+	var sum uint16
+	for _, b := range pkt[2:] {
+		sum += uint16(b)
+	}
+	return append(pkt, byte(sum), byte(sum>>8), 0, 0)
+}
+
+// send returns the printer's last two replies: the alive byte and status.
+func send(p *Printer, pkt []byte) (byte, byte) {
+	var replies []byte
+	for _, b := range pkt {
+		replies = append(replies, p.Exchange(b))
+	}
+	return replies[len(replies)-2], replies[len(replies)-1]
+}
+
+func solidBand(lo, hi byte) []byte {
+	band := make([]byte, bandSize)
+	for i := 0; i < bandSize; i += 2 {
+		band[i] = lo
+		band[i+1] = hi
+	}
+	return band
+}
+
+func TestStatus(t *testing.T) {
+	p := New(nil)
+	alive, status := send(p, packet(CMD_STATUS, false, nil))
+	if alive != 0x81 {
+		t.Errorf("Alive byte = 0x%02X, not 0x81", alive)
+	}
+	if status != 0 {
+		t.Errorf("Status = 0x%02X, not 0x00", status)
+	}
+}
+
+func TestNoiseBeforeMagic(t *testing.T) {
+	p := New(nil)
+	p.Exchange(0x00)
+	p.Exchange(0x88)
+	p.Exchange(0x00)
+	alive, _ := send(p, packet(CMD_INIT, false, nil))
+	if alive != 0x81 {
+		t.Errorf("Alive byte = 0x%02X, not 0x81", alive)
+	}
+}
+
+func TestChecksumError(t *testing.T) {
+	p := New(nil)
+	pkt := packet(CMD_INIT, false, nil)
+	pkt[6]++
+	_, status := send(p, pkt)
+	if status&STATUS_CHECKSUM_ERROR == 0 {
+		t.Errorf("Status = 0x%02X, checksum error not flagged", status)
+	}
+	_, status = send(p, packet(CMD_STATUS, false, nil))
+	if status&STATUS_CHECKSUM_ERROR != 0 {
+		t.Error("Checksum error not cleared by a good packet")
+	}
+}
+
+func TestDataUnprocessed(t *testing.T) {
+	p := New(nil)
+	send(p, packet(CMD_INIT, false, nil))
+	_, status := send(p, packet(CMD_DATA, false, solidBand(0, 0)))
+	if status != STATUS_UNPROCESSED {
+		t.Errorf("Status = 0x%02X, not 0x%02X", status, STATUS_UNPROCESSED)
+	}
+	_, status = send(p, packet(CMD_INIT, false, nil))
+	if status != 0 {
+		t.Errorf("Status = 0x%02X after init, not 0x00", status)
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	src := []byte{0x02, 1, 2, 3, 0x81, 9, 0x00, 4}
+	out := decompress(nil, src)
+	want := []byte{1, 2, 3, 9, 9, 9, 4}
+	if string(out) != string(want) {
+		t.Errorf("Decompressed % X, not % X", out, want)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	var prints []image.Image
+	p := New(func(img image.Image) error {
+		prints = append(prints, img)
+		return nil
+	})
+	send(p, packet(CMD_INIT, false, nil))
+	send(p, packet(CMD_DATA, false, solidBand(0xFF, 0x00)))
+	send(p, packet(CMD_DATA, false, solidBand(0xFF, 0xFF)))
+	send(p, packet(CMD_DATA, false, nil))
+	_, status := send(p, packet(CMD_PRINT, false, []byte{1, 0x13, 0xE4, 0x40}))
+	if status&STATUS_PRINTING == 0 {
+		t.Errorf("Status = 0x%02X, not printing", status)
+	}
+	if len(prints) != 1 {
+		t.Fatalf("%d prints output, not 1", len(prints))
+	}
+	img := prints[0].(*image.Paletted)
+	if img.Rect.Dx() != 160 || img.Rect.Dy() != 32 {
+		t.Fatalf("Print is %v, not 160x32", img.Rect.Size())
+	}
+	if idx := img.ColorIndexAt(3, 5); idx != 1 {
+		t.Errorf("Top band shade = %d, not 1", idx)
+	}
+	if idx := img.ColorIndexAt(159, 31); idx != 3 {
+		t.Errorf("Bottom band shade = %d, not 3", idx)
+	}
+	for i := 0; i < 2; i++ {
+		_, status = send(p, packet(CMD_STATUS, false, nil))
+	}
+	if status&STATUS_PRINTING != 0 {
+		t.Error("Printer still busy")
+	}
+}
+
+func TestPrintPalette(t *testing.T) {
+	var prints []image.Image
+	p := New(func(img image.Image) error {
+		prints = append(prints, img)
+		return nil
+	})
+	send(p, packet(CMD_DATA, false, solidBand(0xFF, 0x00)))
+	send(p, packet(CMD_PRINT, false, []byte{1, 0x01, 0x1B, 0x40}))
+	img := prints[0].(*image.Paletted)
+	if idx := img.ColorIndexAt(0, 0); idx != 2 {
+		t.Errorf("Shade = %d, not 2", idx)
+	}
+}
+
+func TestPrintCompressed(t *testing.T) {
+	var prints []image.Image
+	p := New(func(img image.Image) error {
+		prints = append(prints, img)
+		return nil
+	})
+	// 640 bytes of 0xFF as five runs of 128.
+	var data []byte
+	for i := 0; i < 5; i++ {
+		data = append(data, 0xFE, 0xFF)
+	}
+	send(p, packet(CMD_DATA, true, data))
+	send(p, packet(CMD_PRINT, false, []byte{1, 0x01, 0xE4, 0x40}))
+	if len(prints) != 1 {
+		t.Fatalf("%d prints output, not 1", len(prints))
+	}
+	img := prints[0].(*image.Paletted)
+	if img.Rect.Dy() != 16 {
+		t.Errorf("Print is %d rows, not 16", img.Rect.Dy())
+	}
+	if idx := img.ColorIndexAt(80, 8); idx != 3 {
+		t.Errorf("Shade = %d, not 3", idx)
+	}
+}
+
+func TestPrintJoinsWithoutFeed(t *testing.T) {
+	var prints []image.Image
+	p := New(func(img image.Image) error {
+		prints = append(prints, img)
+		return nil
+	})
+	send(p, packet(CMD_DATA, false, solidBand(0, 0)))
+	send(p, packet(CMD_PRINT, false, []byte{1, 0x10, 0xE4, 0x40}))
+	if len(prints) != 0 {
+		t.Fatal("Print output before the paper was fed")
+	}
+	send(p, packet(CMD_INIT, false, nil))
+	send(p, packet(CMD_DATA, false, solidBand(0, 0)))
+	send(p, packet(CMD_PRINT, false, []byte{1, 0x00, 0xE4, 0x40}))
+	p.Flush()
+	if len(prints) != 1 {
+		t.Fatalf("%d prints output, not 1", len(prints))
+	}
+	if h := prints[0].Bounds().Dy(); h != 32 {
+		t.Errorf("Joined print is %d rows, not 32", h)
+	}
+}
+
+func TestSerialPort(t *testing.T) {
+	var prints []image.Image
+	p := New(func(img image.Image) error {
+		prints = append(prints, img)
+		return nil
+	})
+	port := serial.New(nil)
+	port.Connect(p)
+	xfer := func(b byte) byte {
+		port.WriteByte(serial.SB, b)
+		port.WriteByte(serial.SC, 0x81)
+		port.Tick(4096)
+		return port.ReadByte(serial.SB)
+	}
+	var last []byte
+	for _, pkt := range [][]byte{
+		packet(CMD_INIT, false, nil),
+		packet(CMD_DATA, false, solidBand(0xFF, 0xFF)),
+		packet(CMD_PRINT, false, []byte{1, 0x03, 0xE4, 0x40}),
+	} {
+		last = last[:0]
+		for _, b := range pkt {
+			last = append(last, xfer(b))
+		}
+	}
+	if last[len(last)-2] != 0x81 {
+		t.Errorf("Alive byte = 0x%02X, not 0x81", last[len(last)-2])
+	}
+	if len(prints) != 1 {
+		t.Errorf("%d prints output, not 1", len(prints))
+	}
+}
+
+func TestPNGDir(t *testing.T) {
+	dir := t.TempDir()
+	out := PNGDir(dir)
+	img := image.NewPaletted(image.Rect(0, 0, 160, 16), Shades)
+	if err := out(img); err != nil {
+		t.Fatal(err)
+	}
+	if err := out(img); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"print-0001.png", "print-0002.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Package rewind keeps recent history so a running game can be stepped
+// back frame by frame.
+//
+// A Buffer takes a save state every few frames and records the buttons
+// latched on every frame. Going back restores the closest snapshot at or
+// before the frame wanted and runs forward to it with the same input, so
+// the machine ends up exactly as it was.
+//
+// Snapshots are compressed. Most are stored as the difference from the
+// last keyframe, which is mostly zeros and compresses to very little.
+// When the buffer goes over its memory budget the oldest snapshots are
+// dropped.
+package rewind
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/joypad"
+)
+
+const (
+	DEFAULT_INTERVAL = 10
+	DEFAULT_BUDGET   = 16 << 20
+
+	// keyEvery is how many snapshots share a keyframe.
+	keyEvery = 16
+)
+
+var ErrTooFar = errors.New("rewind: frame is no longer in the buffer")
+
+type Options struct {
+	// Interval is the number of frames between snapshots. Stepping back
+	// re-runs up to this many frames.
+	Interval int
+	// Budget is the most memory in bytes the snapshots may take.
+	Budget int
+}
+
+type snapshot struct {
+	frame uint64
+	key   bool
+	data  []byte
+	// inputs are the buttons latched on each frame after this one, up to
+	// the next snapshot.
+	inputs []joypad.Button
+}
+
+// Buffer runs a GameBoy a frame at a time, remembering how to get back.
+type Buffer struct {
+	gb       *gameboy.GameBoy
+	interval int
+	budget   int
+	frame    uint64
+	snaps    []*snapshot
+	size     int
+	// key is the uncompressed state of the last keyframe, which new
+	// snapshots are diffed against, and deltas how many have been.
+	key    []byte
+	deltas int
+}
+
+// New starts a buffer at the GameBoy's current state, frame 0.
+func New(gb *gameboy.GameBoy, opts Options) *Buffer {
+	if opts.Interval <= 0 {
+		opts.Interval = DEFAULT_INTERVAL
+	}
+	if opts.Budget <= 0 {
+		opts.Budget = DEFAULT_BUDGET
+	}
+	b := &Buffer{gb: gb, interval: opts.Interval, budget: opts.Budget}
+	b.snapshot()
+	return b
+}
+
+// Frame is the number of frames run since New, less any stepped back.
+func (b *Buffer) Frame() uint64 {
+	return b.frame
+}
+
+// Oldest is the earliest frame that can still be reached.
+func (b *Buffer) Oldest() uint64 {
+	return b.snaps[0].frame
+}
+
+// Size is the memory taken by the snapshots in bytes.
+func (b *Buffer) Size() int {
+	return b.size + len(b.key)
+}
+
+// RunFrame runs the GameBoy for a frame, taking a snapshot when one is
+// due.
+func (b *Buffer) RunFrame() {
+	b.gb.RunFrame()
+	b.frame++
+	last := b.snaps[len(b.snaps)-1]
+	last.inputs = append(last.inputs, b.gb.Joypad.Buttons())
+	if b.frame%uint64(b.interval) == 0 {
+		b.snapshot()
+	}
+}
+
+// StepBack goes back one frame.
+func (b *Buffer) StepBack() error {
+	if b.frame == 0 {
+		return ErrTooFar
+	}
+	return b.Seek(b.frame - 1)
+}
+
+// Seek goes back to an earlier frame. Going back forgets everything after
+// it, so running on from there starts a new history.
+func (b *Buffer) Seek(frame uint64) error {
+	if frame < b.Oldest() || frame > b.frame {
+		return ErrTooFar
+	}
+	i := len(b.snaps) - 1
+	for b.snaps[i].frame > frame {
+		i--
+	}
+	s := b.snaps[i]
+	state, err := b.decode(i)
+	if err != nil {
+		return err
+	}
+	if err := b.gb.LoadState(bytes.NewReader(state)); err != nil {
+		return err
+	}
+	for _, dropped := range b.snaps[i+1:] {
+		b.size -= len(dropped.data)
+	}
+	b.snaps = b.snaps[:i+1]
+	s.inputs = s.inputs[:frame-s.frame]
+	k := i
+	for !b.snaps[k].key {
+		k--
+	}
+	b.key, _ = b.decode(k)
+	b.deltas = i - k
+
+	// Replay quietly with the recorded input, then hand the joypad back.
+	sink, pending := b.gb.APU.Sink(), b.gb.Joypad.Pending()
+	b.gb.APU.SetSink(nil)
+	for _, buttons := range s.inputs {
+		b.gb.Joypad.SetButtons(buttons)
+		b.gb.RunFrame()
+	}
+	b.gb.APU.SetSink(sink)
+	b.gb.Joypad.SetButtons(pending)
+	b.frame = frame
+	return nil
+}
+
+func (b *Buffer) snapshot() {
+	var state bytes.Buffer
+	b.gb.SaveState(&state)
+	s := &snapshot{frame: b.frame}
+	if b.key == nil || b.deltas == keyEvery-1 || len(b.key) != state.Len() {
+		s.key = true
+		b.key = state.Bytes()
+		b.deltas = 0
+		s.data = compress(b.key)
+	} else {
+		s.data = compress(xor(state.Bytes(), b.key))
+		b.deltas++
+	}
+	b.snaps = append(b.snaps, s)
+	b.size += len(s.data)
+	b.trim()
+}
+
+// trim drops the oldest snapshots until the buffer fits its budget,
+// keeping at least the newest.
+func (b *Buffer) trim() {
+	for b.Size() > b.budget && len(b.snaps) > 1 {
+		if b.snaps[0].key && !b.snaps[1].key {
+			b.promote(1)
+		}
+		b.size -= len(b.snaps[0].data)
+		b.snaps = b.snaps[1:]
+	}
+}
+
+// promote makes delta i a keyframe, so the one before it can be dropped,
+// and rebases the deltas after it.
+func (b *Buffer) promote(i int) {
+	k := i - 1
+	for !b.snaps[k].key {
+		k--
+	}
+	old, _ := decompress(b.snaps[k].data)
+	key, _ := b.decode(i)
+	b.resize(i, compress(key))
+	b.snaps[i].key = true
+	j := i + 1
+	for ; j < len(b.snaps) && !b.snaps[j].key; j++ {
+		delta, _ := decompress(b.snaps[j].data)
+		b.resize(j, compress(xor(xor(delta, old), key)))
+	}
+	if j == len(b.snaps) {
+		b.key = key
+		b.deltas = j - 1 - i
+	}
+}
+
+func (b *Buffer) resize(i int, data []byte) {
+	b.size += len(data) - len(b.snaps[i].data)
+	b.snaps[i].data = data
+}
+
+// decode returns the full state of snapshot i.
+func (b *Buffer) decode(i int) ([]byte, error) {
+	s := b.snaps[i]
+	data, err := decompress(s.data)
+	if err != nil || s.key {
+		return data, err
+	}
+	k := i
+	for !b.snaps[k].key {
+		k--
+	}
+	key, err := decompress(b.snaps[k].data)
+	if err != nil {
+		return nil, err
+	}
+	return xor(data, key), nil
+}
+
+func xor(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+func compress(data []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func decompress(data []byte) ([]byte, error) {
+	return io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+}
@@ -0,0 +1,138 @@
+package rewind
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/internal/testrom"
+	"github.com/zbyrne/golangboy/joypad"
+)
+
+// inputProgram adds up the joypad lines into WRAM and shows the total on
+// the palette, so where the machine ends up depends on every frame's input.
+const inputProgram = `
+SECTION "main", ROM0[$100]
+	ld hl, $C000
+.loop:
+	ld a, $20
+	ldh [$00], a
+	ldh a, [$00]
+	add a, [hl]
+	ld [hl+], a
+	ldh [$47], a
+	ld a, h
+	cp $D0
+	jr nz, .loop
+	ld hl, $C000
+	jr .loop
+`
+
+func newGameBoy(t *testing.T) *gameboy.GameBoy {
+	return testrom.New(t, inputProgram, gameboy.Options{SampleRate: 48000})
+}
+
+func state(t *testing.T, g *gameboy.GameBoy) []byte {
+	var buf bytes.Buffer
+	if err := g.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// run plays n frames from the buffer's current frame and returns the state
+// after each, indexed by frame.
+func run(t *testing.T, b *Buffer, g *gameboy.GameBoy, n int, states map[uint64][]byte) {
+	for i := 0; i < n; i++ {
+		g.Joypad.SetButtons(testrom.Press(int(b.Frame()), 7))
+		b.RunFrame()
+		states[b.Frame()] = state(t, g)
+	}
+}
+
+func TestStepBack(t *testing.T) {
+	g := newGameBoy(t)
+	states := map[uint64][]byte{0: state(t, g)}
+	b := New(g, Options{Interval: 8})
+	run(t, b, g, 50, states)
+	if bytes.Equal(states[49], states[50]) {
+		t.Fatal("Nothing changes between frames")
+	}
+
+	for want := uint64(49); want > 30; want-- {
+		if err := b.StepBack(); err != nil {
+			t.Fatal(err)
+		}
+		if b.Frame() != want || !bytes.Equal(state(t, g), states[want]) {
+			t.Errorf("Stepped back to frame %d, which differs from frame %d", b.Frame(), want)
+		}
+	}
+	for _, want := range []uint64{24, 3, 0} {
+		if err := b.Seek(want); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(state(t, g), states[want]) {
+			t.Errorf("Seek to frame %d differs", want)
+		}
+	}
+	if err := b.StepBack(); err != ErrTooFar {
+		t.Errorf("Stepped back before the start: %v", err)
+	}
+	if err := b.Seek(1); err != ErrTooFar {
+		t.Errorf("Seek past the present: %v", err)
+	}
+}
+
+func TestBranch(t *testing.T) {
+	g := newGameBoy(t)
+	b := New(g, Options{Interval: 4})
+	run(t, b, g, 20, map[uint64][]byte{})
+	if err := b.Seek(10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Different input from frame 10 on makes a different history, which is
+	// the one rewound through afterwards.
+	states := map[uint64][]byte{10: state(t, g)}
+	for i := 0; i < 10; i++ {
+		g.Joypad.SetButtons(joypad.A | joypad.B)
+		b.RunFrame()
+		states[b.Frame()] = state(t, g)
+	}
+	if err := b.Seek(14); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(state(t, g), states[14]) {
+		t.Error("Rewound into the old history")
+	}
+	if pending := g.Joypad.Pending(); pending != joypad.A|joypad.B {
+		t.Errorf("Pending buttons are 0x%02X after seeking", pending)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	g := newGameBoy(t)
+	b := New(g, Options{Interval: 2})
+	full := b.Size()
+	budget := full + full/2
+	b = New(g, Options{Interval: 2, Budget: budget})
+	states := map[uint64][]byte{}
+	run(t, b, g, 300, states)
+
+	if b.Size() > budget {
+		t.Errorf("Buffer takes %d bytes, over its budget of %d", b.Size(), budget)
+	}
+	oldest := b.Oldest()
+	if oldest == 0 {
+		t.Fatal("Nothing was dropped")
+	}
+	if err := b.Seek(oldest - 1); err != ErrTooFar {
+		t.Errorf("Seek before the oldest frame: %v", err)
+	}
+	if err := b.Seek(oldest + 1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(state(t, g), states[oldest+1]) {
+		t.Errorf("Seek to the oldest frames differs")
+	}
+}
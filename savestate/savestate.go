@@ -0,0 +1,273 @@
+// Package savestate reads and writes the binary format used to snapshot
+// a machine.
+//
+// A file starts with the magic "GBSS" and a little endian uint16 format
+// version, then holds one chunk per component:
+//
+//	tag     [4]byte
+//	version uint16
+//	length  uint32
+//	data    [length]byte
+//
+// Fields are only ever appended to a chunk, and its version bumped when
+// they are. Readers ignore data past the fields they know and components
+// leave fields newer than a chunk's version alone, so states load both
+// ways across versions. Chunks a reader doesn't know are skipped. The
+// format version only changes when that's impossible.
+package savestate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const FORMAT_VERSION = 1
+
+const magic = "GBSS"
+
+var (
+	ErrFormat  = errors.New("savestate: not a save state")
+	ErrVersion = errors.New("savestate: made by a newer version")
+	ErrShort   = errors.New("savestate: chunk is truncated")
+	ErrSize    = errors.New("savestate: data doesn't match the machine")
+)
+
+// Component is a piece of hardware that can be snapshotted.
+type Component interface {
+	SaveState(e *Encoder)
+	LoadState(d *Decoder)
+}
+
+// Encoder builds a chunk.
+type Encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *Encoder) Byte(v byte) {
+	e.buf.WriteByte(v)
+}
+
+func (e *Encoder) Bool(v bool) {
+	if v {
+		e.Byte(1)
+	} else {
+		e.Byte(0)
+	}
+}
+
+func (e *Encoder) Uint16(v uint16) {
+	e.buf.Write([]byte{byte(v), byte(v >> 8)})
+}
+
+func (e *Encoder) Uint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *Encoder) Uint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	e.buf.Write(b[:])
+}
+
+// Int stores an int, or anything converted to one, as 64 bits.
+func (e *Encoder) Int(v int) {
+	e.Uint64(uint64(int64(v)))
+}
+
+func (e *Encoder) Float64(v float64) {
+	e.Uint64(math.Float64bits(v))
+}
+
+// Bytes stores a length and then the data.
+func (e *Encoder) Bytes(v []byte) {
+	e.Uint32(uint32(len(v)))
+	e.buf.Write(v)
+}
+
+// Decoder reads a chunk. The first error sticks, and after it every read
+// returns zero.
+type Decoder struct {
+	data    []byte
+	version int
+	err     error
+}
+
+// Version is the version of the chunk, for components that have appended
+// fields since their first.
+func (d *Decoder) Version() int {
+	return d.version
+}
+
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// Fail records an error found by a component, such as a value that
+// doesn't make sense.
+func (d *Decoder) Fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *Decoder) take(n int) []byte {
+	if d.err != nil {
+		return make([]byte, n)
+	}
+	if len(d.data) < n {
+		d.err = ErrShort
+		return make([]byte, n)
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *Decoder) Byte() byte {
+	return d.take(1)[0]
+}
+
+func (d *Decoder) Bool() bool {
+	return d.Byte() != 0
+}
+
+func (d *Decoder) Uint16() uint16 {
+	b := d.take(2)
+	return uint16(b[0]) | uint16(b[1])<<8
+}
+
+func (d *Decoder) Uint32() uint32 {
+	return binary.LittleEndian.Uint32(d.take(4))
+}
+
+func (d *Decoder) Uint64() uint64 {
+	return binary.LittleEndian.Uint64(d.take(8))
+}
+
+func (d *Decoder) Int() int {
+	return int(int64(d.Uint64()))
+}
+
+func (d *Decoder) Float64() float64 {
+	return math.Float64frombits(d.Uint64())
+}
+
+// Bytes reads data stored with Encoder.Bytes into dst, which must be the
+// same length.
+func (d *Decoder) Bytes(dst []byte) {
+	n := d.Uint32()
+	if d.err == nil && int(n) != len(dst) {
+		d.err = ErrSize
+	}
+	if d.err != nil {
+		return
+	}
+	copy(dst, d.take(len(dst)))
+}
+
+// Writer writes a state file. The first error sticks and is returned by
+// Err.
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	sw := &Writer{w: w}
+	var e Encoder
+	e.buf.WriteString(magic)
+	e.Uint16(FORMAT_VERSION)
+	_, sw.err = w.Write(e.buf.Bytes())
+	return sw
+}
+
+// Chunk saves c under a four character tag.
+func (w *Writer) Chunk(tag string, version int, c Component) {
+	if w.err != nil {
+		return
+	}
+	if len(tag) != 4 {
+		panic("savestate: tags are four characters")
+	}
+	var data Encoder
+	c.SaveState(&data)
+	var e Encoder
+	e.buf.WriteString(tag)
+	e.Uint16(uint16(version))
+	e.Uint32(uint32(data.buf.Len()))
+	e.buf.Write(data.buf.Bytes())
+	_, w.err = w.w.Write(e.buf.Bytes())
+}
+
+func (w *Writer) Err() error {
+	return w.err
+}
+
+// Reader holds the chunks of a state file.
+type Reader struct {
+	chunks map[string]Decoder
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 6 || string(data[:4]) != magic {
+		return nil, ErrFormat
+	}
+	if binary.LittleEndian.Uint16(data[4:]) > FORMAT_VERSION {
+		return nil, ErrVersion
+	}
+	sr := &Reader{chunks: map[string]Decoder{}}
+	for data = data[6:]; len(data) > 0; {
+		if len(data) < 10 {
+			return nil, ErrShort
+		}
+		tag := string(data[:4])
+		version := binary.LittleEndian.Uint16(data[4:])
+		n := binary.LittleEndian.Uint32(data[6:])
+		data = data[10:]
+		if uint32(len(data)) < n {
+			return nil, ErrShort
+		}
+		sr.chunks[tag] = Decoder{data: data[:n], version: int(version)}
+		data = data[n:]
+	}
+	return sr, nil
+}
+
+func (r *Reader) Has(tag string) bool {
+	_, ok := r.chunks[tag]
+	return ok
+}
+
+// Chunk loads c from the chunk with the given tag. A chunk can be loaded
+// more than once.
+func (r *Reader) Chunk(tag string, c Component) error {
+	d, ok := r.chunks[tag]
+	if !ok {
+		return fmt.Errorf("savestate: no %q chunk", tag)
+	}
+	c.LoadState(&d)
+	if d.err != nil {
+		return fmt.Errorf("%w in %q chunk", d.err, tag)
+	}
+	return nil
+}
+
+// Copy saves src and loads the result into dst as a chunk of the given
+// version, without the framing of a file.
+func Copy(dst, src Component, version int) error {
+	var e Encoder
+	src.SaveState(&e)
+	d := &Decoder{data: e.buf.Bytes(), version: version}
+	dst.LoadState(d)
+	return d.err
+}
@@ -0,0 +1,147 @@
+package savestate
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// fields is a component with one of everything. Version 2 added Extra.
+type fields struct {
+	B     byte
+	On    bool
+	U16   uint16
+	U32   uint32
+	U64   uint64
+	I     int
+	F     float64
+	Data  [3]byte
+	Extra byte
+}
+
+func (f *fields) SaveState(e *Encoder) {
+	e.Byte(f.B)
+	e.Bool(f.On)
+	e.Uint16(f.U16)
+	e.Uint32(f.U32)
+	e.Uint64(f.U64)
+	e.Int(f.I)
+	e.Float64(f.F)
+	e.Bytes(f.Data[:])
+	e.Byte(f.Extra)
+}
+
+func (f *fields) LoadState(d *Decoder) {
+	f.B = d.Byte()
+	f.On = d.Bool()
+	f.U16 = d.Uint16()
+	f.U32 = d.Uint32()
+	f.U64 = d.Uint64()
+	f.I = d.Int()
+	f.F = d.Float64()
+	d.Bytes(f.Data[:])
+	if d.Version() >= 2 {
+		f.Extra = d.Byte()
+	}
+}
+
+// first is an older reader that only knew the first field.
+type first struct {
+	B byte
+}
+
+func (f *first) SaveState(e *Encoder) {
+	e.Byte(f.B)
+}
+
+func (f *first) LoadState(d *Decoder) {
+	f.B = d.Byte()
+}
+
+var sample = fields{0x12, true, 0x3456, 0x789ABCDE, 1 << 40, -5, 0.25, [3]byte{1, 2, 3}, 9}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Chunk("TEST", 2, &sample)
+	w.Chunk("SKIP", 1, &sample)
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got fields
+	if err := r.Chunk("TEST", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != sample {
+		t.Errorf("Loaded %+v, not %+v", got, sample)
+	}
+	// Chunks can be loaded again.
+	got = fields{}
+	if err := r.Chunk("TEST", &got); err != nil || got != sample {
+		t.Errorf("Loaded %+v again: %v", got, err)
+	}
+	if !r.Has("SKIP") || r.Has("NONE") {
+		t.Error("Has is wrong")
+	}
+	if err := r.Chunk("NONE", &got); err == nil {
+		t.Error("Loaded a missing chunk")
+	}
+}
+
+func TestVersions(t *testing.T) {
+	// Reading a newer chunk ignores the fields added since.
+	var old first
+	if err := Copy(&old, &sample, 2); err != nil || old.B != sample.B {
+		t.Errorf("Old reader loaded %+v: %v", old, err)
+	}
+	// Reading an older chunk leaves the new fields alone.
+	got := fields{Extra: 7}
+	if err := Copy(&got, &sample, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got.Extra != 7 || got.U32 != sample.U32 {
+		t.Errorf("New reader loaded %+v", got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Chunk("TEST", 1, &sample)
+	good := buf.Bytes()
+
+	if _, err := NewReader(bytes.NewReader([]byte("GBXX\x01\x00"))); err != ErrFormat {
+		t.Errorf("Bad magic gave %v", err)
+	}
+	newer := append([]byte(nil), good...)
+	newer[4] = FORMAT_VERSION + 1
+	if _, err := NewReader(bytes.NewReader(newer)); err != ErrVersion {
+		t.Errorf("Newer format gave %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader(good[:len(good)-1])); err != ErrShort {
+		t.Errorf("Truncated file gave %v", err)
+	}
+
+	// A chunk shorter than its fields.
+	short := append([]byte(nil), good[:6]...)
+	short = append(short, "TEST\x01\x00\x02\x00\x00\x00\x12\x01"...)
+	r, err := NewReader(bytes.NewReader(short))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got fields
+	if err := r.Chunk("TEST", &got); !errors.Is(err, ErrShort) {
+		t.Errorf("Short chunk gave %v", err)
+	}
+
+	var data [3]byte
+	d := &Decoder{data: []byte{2, 0, 0, 0, 1, 2}}
+	d.Bytes(data[:])
+	if d.Err() != ErrSize {
+		t.Errorf("Mismatched length gave %v", d.Err())
+	}
+}
@@ -0,0 +1,222 @@
+// Package screenshot turns frames into PNG and animated GIF images.
+package screenshot
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zbyrne/golangboy/gameboy"
+	"github.com/zbyrne/golangboy/palette"
+	"github.com/zbyrne/golangboy/ppu"
+)
+
+var ErrNoFrames = errors.New("screenshot: no frames to write")
+
+// Options says how frames are drawn.
+type Options struct {
+	// Palette colours each layer. Use DMG.CGB for plain DMG colours.
+	Palette palette.CGB
+	// Correction shows the colours of games running in CGB mode.
+	Correction palette.Correction
+	// Scale makes each pixel a Scale by Scale square. 0 means 1.
+	Scale int
+}
+
+// Image draws a frame of shades and the layer each came from, as returned
+// by GameBoy.Frame and GameBoy.Layers. Without layers every pixel is
+// coloured as background.
+func Image(frame, layers []byte, opts Options) *image.Paletted {
+	scale := opts.Scale
+	if scale < 1 {
+		scale = 1
+	}
+	var colors color.Palette
+	for _, pal := range opts.Palette {
+		for _, c := range pal {
+			colors = append(colors, c)
+		}
+	}
+	img := image.NewPaletted(image.Rect(0, 0, ppu.WIDTH*scale, ppu.HEIGHT*scale), colors)
+	for y := 0; y < ppu.HEIGHT; y++ {
+		row := img.Pix[y*scale*img.Stride : (y*scale+1)*img.Stride]
+		for x, shade := range frame[y*ppu.WIDTH : (y+1)*ppu.WIDTH] {
+			idx := shade & 3
+			if layers != nil {
+				idx += layers[y*ppu.WIDTH+x] % 3 * 4
+			}
+			for i := 0; i < scale; i++ {
+				row[x*scale+i] = idx
+			}
+		}
+		for i := 1; i < scale; i++ {
+			copy(img.Pix[(y*scale+i)*img.Stride:], row)
+		}
+	}
+	return img
+}
+
+// Colors draws a frame of a game running in CGB mode, as returned by
+// GameBoy.Colors. The image's palette holds every colour in the frame if
+// there are few enough, otherwise colours with the low bits of each
+// channel dropped.
+func Colors(colors []uint16, opts Options) *image.Paletted {
+	scale := opts.Scale
+	if scale < 1 {
+		scale = 1
+	}
+	var (
+		pal   color.Palette
+		index map[uint16]uint8
+		mask  uint16
+		ok    bool
+	)
+	// Keep 5, 3 then 2 bits a channel until the colours fit.
+	for _, mask = range []uint16{0x7FFF, 0x739C, 0x6318} {
+		if pal, index, ok = colorIndex(colors, mask, opts.Correction); ok {
+			break
+		}
+	}
+	img := image.NewPaletted(image.Rect(0, 0, ppu.WIDTH*scale, ppu.HEIGHT*scale), pal)
+	for y := 0; y < ppu.HEIGHT; y++ {
+		row := img.Pix[y*scale*img.Stride : (y*scale+1)*img.Stride]
+		for x, c := range colors[y*ppu.WIDTH : (y+1)*ppu.WIDTH] {
+			for i := 0; i < scale; i++ {
+				row[x*scale+i] = index[c&mask]
+			}
+		}
+		for i := 1; i < scale; i++ {
+			copy(img.Pix[(y*scale+i)*img.Stride:], row)
+		}
+	}
+	return img
+}
+
+// colorIndex gives each colour in a frame, with only the bits in mask
+// kept, a place in a palette. It fails if there are more than 256.
+func colorIndex(colors []uint16, mask uint16, corr palette.Correction) (color.Palette, map[uint16]uint8, bool) {
+	var pal color.Palette
+	index := make(map[uint16]uint8)
+	for _, c := range colors {
+		c &= mask
+		if _, ok := index[c]; ok {
+			continue
+		}
+		if len(pal) == 256 {
+			return nil, nil, false
+		}
+		index[c] = uint8(len(pal))
+		pal = append(pal, corr.RGB(c))
+	}
+	return pal, index, true
+}
+
+// Screen draws a GameBoy's last frame: in its own colours if the game runs
+// in CGB mode, otherwise with Image.
+func Screen(g *gameboy.GameBoy, opts Options) *image.Paletted {
+	if colors := g.Colors(); colors != nil {
+		return Colors(colors, opts)
+	}
+	return Image(g.Frame(), g.Layers(), opts)
+}
+
+// Scale enlarges an image so each pixel is a scale by scale square.
+func Scale(img image.Image, scale int) image.Image {
+	if scale <= 1 {
+		return img
+	}
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx()*scale, b.Dy()*scale))
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			out.Set(x, y, img.At(b.Min.X+x/scale, b.Min.Y+y/scale))
+		}
+	}
+	return out
+}
+
+// SavePNG writes an image to a PNG file.
+func SavePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Dumper saves every frame it's given to numbered PNGs in a directory.
+type Dumper struct {
+	Dir string
+	// Pattern names the file for a frame number. The default is
+	// frame%06d.png.
+	Pattern string
+}
+
+// Dump saves frame number n.
+func (d *Dumper) Dump(n uint64, img image.Image) error {
+	pattern := d.Pattern
+	if pattern == "" {
+		pattern = "frame%06d.png"
+	}
+	return SavePNG(filepath.Join(d.Dir, fmt.Sprintf(pattern, n)), img)
+}
+
+// GIF collects frames into an animation that plays at the hardware's
+// speed.
+type GIF struct {
+	anim gif.GIF
+	// shown is how long the last image has been up for and not yet put
+	// in its delay.
+	shown time.Duration
+}
+
+// centisecond is the unit of GIF delays. Browsers slow down anything
+// shorter than minDelay, so frames are dropped to keep every delay at least
+// that long.
+const (
+	centisecond = 10 * time.Millisecond
+	minDelay    = 2
+)
+
+func NewGIF() *GIF {
+	return &GIF{}
+}
+
+// Add appends a frame drawn by Image, Colors or Screen, or drops it if
+// the last one hasn't been shown long enough yet.
+func (g *GIF) Add(img *image.Paletted) {
+	n := len(g.anim.Image)
+	if n > 0 {
+		g.shown += gameboy.FRAME_TIME
+		if g.shown < minDelay*centisecond {
+			return
+		}
+		g.anim.Delay[n-1] = int(g.shown / centisecond)
+		g.shown %= centisecond
+	}
+	g.anim.Image = append(g.anim.Image, img)
+	g.anim.Delay = append(g.anim.Delay, minDelay)
+}
+
+// Len is the number of images in the animation.
+func (g *GIF) Len() int {
+	return len(g.anim.Image)
+}
+
+func (g *GIF) Encode(w io.Writer) error {
+	if len(g.anim.Image) == 0 {
+		return ErrNoFrames
+	}
+	return gif.EncodeAll(w, &g.anim)
+}
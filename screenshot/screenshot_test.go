@@ -0,0 +1,115 @@
+package screenshot
+
+import (
+	"bytes"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zbyrne/golangboy/joypad"
+	"github.com/zbyrne/golangboy/palette"
+	"github.com/zbyrne/golangboy/ppu"
+)
+
+// testFrame has a shade per column, cycling through all four.
+func testFrame() []byte {
+	frame := make([]byte, ppu.WIDTH*ppu.HEIGHT)
+	for i := range frame {
+		frame[i] = byte(i % ppu.WIDTH % 4)
+	}
+	return frame
+}
+
+func TestImage(t *testing.T) {
+	layers := make([]byte, ppu.WIDTH*ppu.HEIGHT)
+	layers[2*ppu.WIDTH+3] = ppu.LAYER_OBJ1
+	colors, _ := palette.Combo(joypad.UP|joypad.A, palette.CORRECT_NONE)
+	img := Image(testFrame(), nil, Options{Palette: palette.Grey.CGB(), Scale: 3})
+	if b := img.Bounds(); b.Dx() != 3*ppu.WIDTH || b.Dy() != 3*ppu.HEIGHT {
+		t.Fatalf("Image is %v", b)
+	}
+	for _, p := range []struct{ x, y, shade int }{{0, 0, 0}, {2, 2, 0}, {3, 0, 1}, {8, 431, 2}, {479, 5, 3}} {
+		if got := img.At(p.x, p.y); got != palette.Grey[p.shade] {
+			t.Errorf("(%d, %d) is %v, not shade %d", p.x, p.y, got, p.shade)
+		}
+	}
+	img = Image(testFrame(), layers, Options{Palette: colors})
+	if got := img.At(3, 2); got != colors[ppu.LAYER_OBJ1][3] {
+		t.Errorf("Sprite pixel is %v", got)
+	}
+	if got := img.At(3, 3); got != colors[ppu.LAYER_BG][3] {
+		t.Errorf("Background pixel is %v", got)
+	}
+}
+
+func TestColors(t *testing.T) {
+	colors := make([]uint16, ppu.WIDTH*ppu.HEIGHT)
+	colors[1] = 0x001F
+	img := Colors(colors, Options{Scale: 2})
+	if got := img.At(2, 1); got != (color.RGBA{0xFF, 0, 0, 0xFF}) {
+		t.Errorf("Red pixel is %v", got)
+	}
+	if got := img.At(0, 0); got != (color.RGBA{0, 0, 0, 0xFF}) {
+		t.Errorf("Black pixel is %v", got)
+	}
+	// Every colour there is can't fit in a palette.
+	for i := range colors {
+		colors[i] = uint16(i)
+	}
+	img = Colors(colors, Options{Correction: palette.CORRECT_CGB})
+	if len(img.Palette) > 256 || img.At(0x5800%ppu.WIDTH, 0x5800/ppu.WIDTH) != palette.CORRECT_CGB.RGB(0x4000) {
+		t.Errorf("%d colours, 0x5800 is %v", len(img.Palette), img.At(0x5800%ppu.WIDTH, 0x5800/ppu.WIDTH))
+	}
+}
+
+func TestPNG(t *testing.T) {
+	dir := t.TempDir()
+	d := &Dumper{Dir: dir}
+	if err := d.Dump(12, Image(testFrame(), nil, Options{Palette: palette.Green.CGB()})); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "frame000012.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, g, b, _ := img.At(1, 100).RGBA()
+	if want := palette.Green[1]; byte(r>>8) != want.R || byte(g>>8) != want.G || byte(b>>8) != want.B {
+		t.Errorf("Pixel is %02X%02X%02X, not %v", r>>8, g>>8, b>>8, want)
+	}
+}
+
+func TestGIF(t *testing.T) {
+	g := NewGIF()
+	var buf bytes.Buffer
+	if err := g.Encode(&buf); err != ErrNoFrames {
+		t.Errorf("Encoded no frames: %v", err)
+	}
+	for i := 0; i < 120; i++ {
+		g.Add(Image(testFrame(), nil, Options{Palette: palette.Grey.CGB()}))
+	}
+	if err := g.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int
+	for _, d := range anim.Delay {
+		if d < minDelay {
+			t.Errorf("Delay of %d", d)
+		}
+		total += d
+	}
+	// Two seconds of frames, give or take the last image.
+	if len(anim.Image) != g.Len() || g.Len() >= 120 || total < 198 || total > 202 {
+		t.Errorf("%d images last %d/100s", len(anim.Image), total)
+	}
+}
@@ -0,0 +1,116 @@
+package serial
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	msgTransfer byte = iota
+	msgReply
+)
+
+// Conn carries the link cable over a stream connection such as TCP or a
+// Unix socket. Each byte clocked by either side is sent as a transfer
+// message and answered with a reply carrying the other side's byte.
+type Conn struct {
+	conn  net.Conn
+	local *Port
+
+	Timeout time.Duration
+
+	wmu     sync.Mutex
+	xmu     sync.Mutex
+	replies chan byte
+}
+
+// NewConn plugs local into conn and starts serving transfers clocked by
+// the far side.
+func NewConn(conn net.Conn, local *Port) *Conn {
+	c := &Conn{
+		conn:    conn,
+		local:   local,
+		Timeout: time.Second,
+		replies: make(chan byte, 1),
+	}
+	local.Connect(c)
+	go c.serve()
+	return c
+}
+
+func Dial(network, address string, local *Port) (*Conn, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, local), nil
+}
+
+// Accept waits for the far side to dial in on l.
+func Accept(l net.Listener, local *Port) (*Conn, error) {
+	conn, err := l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, local), nil
+}
+
+func (c *Conn) send(kind, val byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write([]byte{kind, val})
+	return err
+}
+
+func (c *Conn) serve() {
+	msg := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(c.conn, msg); err != nil {
+			close(c.replies)
+			return
+		}
+		switch msg[0] {
+		case msgTransfer:
+			if c.send(msgReply, c.local.Exchange(msg[1])) != nil {
+				close(c.replies)
+				return
+			}
+		case msgReply:
+			select {
+			case c.replies <- msg[1]:
+			default:
+			}
+		}
+	}
+}
+
+// Exchange sends out and waits for the far side's byte. If the far side
+// is gone or doesn't answer within Timeout the line reads high.
+func (c *Conn) Exchange(out byte) byte {
+	c.xmu.Lock()
+	defer c.xmu.Unlock()
+	// Drop a reply that arrived after an earlier timeout.
+	select {
+	case <-c.replies:
+	default:
+	}
+	if c.send(msgTransfer, out) != nil {
+		return 0xFF
+	}
+	select {
+	case in, ok := <-c.replies:
+		if !ok {
+			return 0xFF
+		}
+		return in
+	case <-time.After(c.Timeout):
+		return 0xFF
+	}
+}
+
+func (c *Conn) Close() error {
+	c.local.Connect(nil)
+	return c.conn.Close()
+}
@@ -0,0 +1,142 @@
+package serial
+
+import (
+	"sync"
+
+	"github.com/zbyrne/golangboy/z80"
+)
+
+const (
+	SB uint16 = 0xFF01
+	SC uint16 = 0xFF02
+
+	TRANSFER_START byte = 1 << 7
+	INTERNAL_CLOCK byte = 1 << 0
+
+	// 8 bits at 8192Hz.
+	transferTicks z80.ClockTicks = 4096
+)
+
+// Transport is whatever is plugged into the other end of the link cable.
+// Exchange is called by the side driving the clock with the byte it shifts
+// out, and returns the byte shifted back in.
+type Transport interface {
+	Exchange(out byte) byte
+}
+
+// Disconnected is an empty link port. The data line floats high.
+type Disconnected struct{}
+
+func (Disconnected) Exchange(out byte) byte {
+	return 0xFF
+}
+
+// Port is the SB/SC register pair. A Port is itself a Transport, so two
+// ports can be cabled together directly.
+//
+// Exchange may be called from another goroutine than the one ticking the
+// Port; the resulting interrupt is raised on the next Tick.
+type Port struct {
+	mu         sync.Mutex
+	sb, sc     byte
+	remaining  z80.ClockTicks
+	irqPending bool
+
+	peer Transport
+	irq  z80.Interrupter
+}
+
+func New(irq z80.Interrupter) *Port {
+	return &Port{peer: Disconnected{}, irq: irq}
+}
+
+func (p *Port) Connect(t Transport) {
+	if t == nil {
+		t = Disconnected{}
+	}
+	p.mu.Lock()
+	p.peer = t
+	p.mu.Unlock()
+}
+
+// Pipe cables two ports together in-process.
+func Pipe(a, b *Port) {
+	a.Connect(b)
+	b.Connect(a)
+}
+
+func (p *Port) ReadByte(addr uint16) byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch addr {
+	case SB:
+		return p.sb
+	case SC:
+		return p.sc | 0x7E
+	}
+	return 0xFF
+}
+
+func (p *Port) WriteByte(addr uint16, val byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch addr {
+	case SB:
+		p.sb = val
+	case SC:
+		p.sc = val & (TRANSFER_START | INTERNAL_CLOCK)
+		if p.sc == TRANSFER_START|INTERNAL_CLOCK {
+			p.remaining = transferTicks
+		}
+	}
+}
+
+func (p *Port) Tick(t z80.ClockTicks) {
+	p.mu.Lock()
+	if p.irqPending {
+		p.irqPending = false
+		p.mu.Unlock()
+		p.raise()
+		p.mu.Lock()
+	}
+	if p.sc != TRANSFER_START|INTERNAL_CLOCK {
+		p.mu.Unlock()
+		return
+	}
+	p.remaining -= t
+	if p.remaining > 0 {
+		p.mu.Unlock()
+		return
+	}
+	out, peer := p.sb, p.peer
+	// Don't hold the lock while the peer runs, it may be cabled straight
+	// back to us.
+	p.mu.Unlock()
+	in := peer.Exchange(out)
+	p.mu.Lock()
+	p.sb = in
+	p.sc &^= TRANSFER_START
+	p.mu.Unlock()
+	p.raise()
+}
+
+func (p *Port) raise() {
+	if p.irq != nil {
+		p.irq.RequestInterrupt(z80.SERIAL_INT)
+	}
+}
+
+// Exchange is clocked by the far side. Nothing is shifted unless a
+// transfer has been started on this side with the external clock.
+func (p *Port) Exchange(in byte) byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sc != TRANSFER_START {
+		return 0xFF
+	}
+	out := p.sb
+	p.sb = in
+	p.sc &^= TRANSFER_START
+	p.irqPending = true
+	return out
+}
@@ -0,0 +1,220 @@
+package serial
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zbyrne/golangboy/savestate"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+type mockInterrupter struct {
+	requested byte
+}
+
+func (m *mockInterrupter) RequestInterrupt(i byte) {
+	m.requested |= i
+}
+
+type echo struct {
+	sent []byte
+}
+
+func (e *echo) Exchange(out byte) byte {
+	e.sent = append(e.sent, out)
+	return ^out
+}
+
+func TestNew(t *testing.T) {
+	p := New(nil)
+	if val := p.ReadByte(SB); val != 0 {
+		t.Errorf("SB = 0x%02X, not 0x00", val)
+	}
+	if val := p.ReadByte(SC); val != 0x7E {
+		t.Errorf("SC = 0x%02X, not 0x7E", val)
+	}
+}
+
+func TestInternalClockDisconnected(t *testing.T) {
+	var irq mockInterrupter
+	p := New(&irq)
+	p.WriteByte(SB, 0x42)
+	p.WriteByte(SC, 0x81)
+	p.Tick(4092)
+	if irq.requested != 0 {
+		t.Error("Transfer finished early")
+	}
+	if val := p.ReadByte(SC); val != 0xFF {
+		t.Errorf("SC = 0x%02X during transfer, not 0xFF", val)
+	}
+	p.Tick(4)
+	if irq.requested != z80.SERIAL_INT {
+		t.Error("Serial interrupt not requested")
+	}
+	if val := p.ReadByte(SB); val != 0xFF {
+		t.Errorf("SB = 0x%02X, not 0xFF", val)
+	}
+	if val := p.ReadByte(SC); val != 0x7F {
+		t.Errorf("SC = 0x%02X after transfer, not 0x7F", val)
+	}
+}
+
+func TestInternalClockTransport(t *testing.T) {
+	var e echo
+	p := New(nil)
+	p.Connect(&e)
+	p.WriteByte(SB, 'P')
+	p.WriteByte(SC, 0x81)
+	p.Tick(4096)
+	if len(e.sent) != 1 || e.sent[0] != 'P' {
+		t.Errorf("Sent %q, not \"P\"", e.sent)
+	}
+	if val := p.ReadByte(SB); val != ^byte('P') {
+		t.Errorf("SB = 0x%02X, not 0x%02X", val, ^byte('P'))
+	}
+	p.Tick(4096)
+	if len(e.sent) != 1 {
+		t.Error("Transfer repeated without being started")
+	}
+}
+
+func TestExternalClockWaits(t *testing.T) {
+	var e echo
+	p := New(nil)
+	p.Connect(&e)
+	p.WriteByte(SC, 0x80)
+	p.Tick(100000)
+	if len(e.sent) != 0 {
+		t.Error("External clock transfer drove the clock")
+	}
+}
+
+func TestExchangeNotArmed(t *testing.T) {
+	p := New(nil)
+	p.WriteByte(SB, 0x12)
+	if val := p.Exchange(0x34); val != 0xFF {
+		t.Errorf("Unarmed port replied 0x%02X, not 0xFF", val)
+	}
+	if val := p.ReadByte(SB); val != 0x12 {
+		t.Errorf("SB = 0x%02X, not 0x12", val)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	var masterIRQ, slaveIRQ mockInterrupter
+	master := New(&masterIRQ)
+	slave := New(&slaveIRQ)
+	Pipe(master, slave)
+	slave.WriteByte(SB, 0xAA)
+	slave.WriteByte(SC, 0x80)
+	master.WriteByte(SB, 0x55)
+	master.WriteByte(SC, 0x81)
+	master.Tick(4096)
+	if val := master.ReadByte(SB); val != 0xAA {
+		t.Errorf("Master SB = 0x%02X, not 0xAA", val)
+	}
+	if val := slave.ReadByte(SB); val != 0x55 {
+		t.Errorf("Slave SB = 0x%02X, not 0x55", val)
+	}
+	if val := slave.ReadByte(SC); val != 0x7E {
+		t.Errorf("Slave SC = 0x%02X, not 0x7E", val)
+	}
+	if masterIRQ.requested != z80.SERIAL_INT {
+		t.Error("Master interrupt not requested")
+	}
+	if slaveIRQ.requested != 0 {
+		t.Error("Slave interrupt raised outside its own Tick")
+	}
+	slave.Tick(4)
+	if slaveIRQ.requested != z80.SERIAL_INT {
+		t.Error("Slave interrupt not requested")
+	}
+}
+
+func testConn(t *testing.T, a, b net.Conn) {
+	master := New(nil)
+	slave := New(nil)
+	mc := NewConn(a, master)
+	sc := NewConn(b, slave)
+	defer mc.Close()
+	defer sc.Close()
+	slave.WriteByte(SB, 0x3C)
+	slave.WriteByte(SC, 0x80)
+	master.WriteByte(SB, 0xC3)
+	master.WriteByte(SC, 0x81)
+	master.Tick(4096)
+	if val := master.ReadByte(SB); val != 0x3C {
+		t.Errorf("Master SB = 0x%02X, not 0x3C", val)
+	}
+	if val := slave.ReadByte(SB); val != 0xC3 {
+		t.Errorf("Slave SB = 0x%02X, not 0xC3", val)
+	}
+}
+
+func TestConnPipe(t *testing.T) {
+	a, b := net.Pipe()
+	testConn(t, a, b)
+}
+
+func TestConnTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	a, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testConn(t, a, <-accepted)
+}
+
+func TestConnTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	p := New(nil)
+	c := NewConn(a, p)
+	defer c.Close()
+	c.Timeout = 10 * time.Millisecond
+	// Swallow the request without ever answering it.
+	go func() {
+		buf := make([]byte, 2)
+		b.Read(buf)
+	}()
+	if val := c.Exchange(0x00); val != 0xFF {
+		t.Errorf("Unanswered exchange returned 0x%02X, not 0xFF", val)
+	}
+}
+
+func TestConnClosedPeer(t *testing.T) {
+	a, b := net.Pipe()
+	p := New(nil)
+	c := NewConn(a, p)
+	b.Close()
+	if val := c.Exchange(0x00); val != 0xFF {
+		t.Errorf("Exchange with a closed peer returned 0x%02X, not 0xFF", val)
+	}
+	c.Close()
+}
+
+func TestState(t *testing.T) {
+	var irq mockInterrupter
+	p := New(&irq)
+	p.WriteByte(SB, 0x42)
+	p.WriteByte(SC, TRANSFER_START|INTERNAL_CLOCK)
+	p.Tick(100)
+	loaded := New(&irq)
+	if err := savestate.Copy(loaded, p, STATE_VERSION); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, p) {
+		t.Errorf("Loaded %+v, saved %+v", loaded, p)
+	}
+}
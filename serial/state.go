@@ -0,0 +1,28 @@
+package serial
+
+import (
+	"github.com/zbyrne/golangboy/savestate"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+// STATE_VERSION is the version of the serial port's save state chunk.
+const STATE_VERSION = 1
+
+// SaveState saves the port but not what it's connected to.
+func (p *Port) SaveState(e *savestate.Encoder) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.Byte(p.sb)
+	e.Byte(p.sc)
+	e.Int(int(p.remaining))
+	e.Bool(p.irqPending)
+}
+
+func (p *Port) LoadState(d *savestate.Decoder) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sb = d.Byte()
+	p.sc = d.Byte()
+	p.remaining = z80.ClockTicks(d.Int())
+	p.irqPending = d.Bool()
+}
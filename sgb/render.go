@@ -0,0 +1,93 @@
+package sgb
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/zbyrne/golangboy/palette"
+	"github.com/zbyrne/golangboy/ppu"
+)
+
+func rgb(v uint16) color.RGBA {
+	return palette.CORRECT_NONE.RGB(v)
+}
+
+// Image draws what the SNES shows: the Game Boy's frame of shades in the
+// SGB's colours with the border around it.
+func (s *SGB) Image(frame []byte) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, WIDTH, HEIGHT))
+	backdrop := rgb(s.palettes[0][0])
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = backdrop.R, backdrop.G, backdrop.B, backdrop.A
+	}
+	s.drawScreen(img, frame)
+	s.drawBorder(img)
+	return img
+}
+
+func (s *SGB) drawScreen(img *image.RGBA, frame []byte) {
+	switch s.mask {
+	case MASK_FREEZE:
+		if s.hasFrozen {
+			frame = s.frozen[:]
+		}
+	case MASK_BLACK:
+		for y := 0; y < ppu.HEIGHT; y++ {
+			for x := 0; x < ppu.WIDTH; x++ {
+				img.SetRGBA(SCREEN_X+x, SCREEN_Y+y, color.RGBA{0, 0, 0, 0xFF})
+			}
+		}
+		return
+	case MASK_COLOR0:
+		return
+	}
+	var colors [4][4]color.RGBA
+	for p := range colors {
+		for c := range colors[p] {
+			colors[p][c] = rgb(s.palettes[p][c])
+		}
+		colors[p][0] = rgb(s.palettes[0][0])
+	}
+	for y := 0; y < ppu.HEIGHT; y++ {
+		for x := 0; x < ppu.WIDTH; x++ {
+			pal := s.attrs[y/8*cellsWide+x/8]
+			img.SetRGBA(SCREEN_X+x, SCREEN_Y+y, colors[pal][frame[y*ppu.WIDTH+x]&3])
+		}
+	}
+}
+
+// drawBorder draws the border's tiles around the screen. Colour 0 of a
+// border tile is clear and shows the backdrop.
+func (s *SGB) drawBorder(img *image.RGBA) {
+	for i, e := range s.borderMap {
+		tile := &s.tiles[e&0xFF]
+		pal := int(e>>10&7) - 4
+		if pal < 0 {
+			pal = 0
+		}
+		for row := 0; row < 8; row++ {
+			y := i/32*8 + row
+			ty := row
+			if e&0x8000 != 0 {
+				ty = 7 - row
+			}
+			for col := 0; col < 8; col++ {
+				x := i%32*8 + col
+				if x >= SCREEN_X && x < SCREEN_X+ppu.WIDTH && y >= SCREEN_Y && y < SCREEN_Y+ppu.HEIGHT {
+					continue
+				}
+				bit := uint(7 - col)
+				if e&0x4000 != 0 {
+					bit = uint(col)
+				}
+				// SNES 4 bit tiles keep planes 0 and 1 in the first 16
+				// bytes and planes 2 and 3 in the next, a row at a time.
+				c := tile[ty*2]>>bit&1 | tile[ty*2+1]>>bit&1<<1 |
+					tile[16+ty*2]>>bit&1<<2 | tile[16+ty*2+1]>>bit&1<<3
+				if c != 0 {
+					img.SetRGBA(x, y, rgb(s.borderPalettes[pal][c]))
+				}
+			}
+		}
+	}
+}
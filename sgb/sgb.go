@@ -0,0 +1,403 @@
+// Package sgb is the Super Game Boy: the SNES side that takes commands
+// from the game through the joypad register, colours the screen and draws
+// a border around it.
+//
+// A command is one to seven 16 byte packets. Each packet starts with a
+// reset pulse (P14 and P15 both low), then 128 bits, least significant
+// first, where P14 low is a 0 and P15 low is a 1, each followed by both
+// going high, and ends with a 0 bit. The first byte of a command is the
+// command number times 8 plus its packet count.
+package sgb
+
+import (
+	"encoding/binary"
+
+	"github.com/zbyrne/golangboy/ppu"
+)
+
+const (
+	WIDTH  = 256
+	HEIGHT = 224
+
+	// The Game Boy's screen sits in the middle of the border.
+	SCREEN_X = (WIDTH - ppu.WIDTH) / 2
+	SCREEN_Y = (HEIGHT - ppu.HEIGHT) / 2
+)
+
+const (
+	PAL01 byte = iota
+	PAL23
+	PAL03
+	PAL12
+	ATTR_BLK
+	ATTR_LIN
+	ATTR_DIV
+	ATTR_CHR
+	SOUND
+	SOU_TRN
+	PAL_SET
+	PAL_TRN
+	ATRC_EN
+	TEST_EN
+	ICON_EN
+	DATA_SND
+	DATA_TRN
+	MLT_REQ
+	JUMP
+	CHR_TRN
+	PCT_TRN
+	ATTR_TRN
+	ATTR_SET
+	MASK_EN
+	OBJ_TRN
+	PAL_PRI
+)
+
+// What MASK_EN does to the Game Boy's screen.
+const (
+	MASK_CANCEL byte = iota
+	MASK_FREEZE
+	MASK_BLACK
+	MASK_COLOR0
+)
+
+const (
+	packetSize = 16
+	// Palettes are set per 8x8 cell of the screen.
+	cellsWide = ppu.WIDTH / 8
+	cellsHigh = ppu.HEIGHT / 8
+	// An attribute file packs a palette for each cell into 2 bits.
+	atfSize  = cellsWide * cellsHigh / 4
+	atfCount = 45
+	// VRAM transfers take the first 4K of tile data on screen.
+	transferSize = 0x1000
+	noTransfer   = 0xFF
+)
+
+// defaultPalette is palette 1-A, which the SGB starts with.
+var defaultPalette = [4]uint16{0x67BF, 0x265B, 0x10B5, 0x2866}
+
+type SGB struct {
+	// Packet transfer.
+	p1      byte
+	bits    int
+	packet  [packetSize]byte
+	command []byte
+
+	palettes [4][4]uint16
+	system   [512][4]uint16
+	attrs    [cellsWide * cellsHigh]byte
+	atfs     [atfCount][atfSize]byte
+
+	mask      byte
+	frozen    [ppu.WIDTH * ppu.HEIGHT]byte
+	hasFrozen bool
+
+	// The border: 256 SNES tiles, a 32x28 map and palettes 4-7.
+	tiles          [256][32]byte
+	borderMap      [32 * 28]uint16
+	borderPalettes [4][16]uint16
+
+	players, player int
+
+	// transfer is a command waiting for its data on the next frame.
+	transfer    byte
+	transferArg byte
+}
+
+func New() *SGB {
+	s := &SGB{bits: -1, players: 1, transfer: noTransfer}
+	for i := range s.palettes {
+		s.palettes[i] = defaultPalette
+	}
+	return s
+}
+
+// WriteP1 watches what the game writes to the joypad register.
+func (s *SGB) WriteP1(val byte) {
+	sel := val & 0x30
+	prev := s.p1
+	s.p1 = sel
+	// Each time P15 goes high the next controller is read.
+	if s.players > 1 && prev&0x20 == 0 && sel&0x20 != 0 {
+		s.player = (s.player + 1) % s.players
+	}
+	switch sel {
+	case 0x00:
+		s.bits = 0
+		s.packet = [packetSize]byte{}
+	case 0x10, 0x20:
+		if prev != 0x30 || s.bits < 0 {
+			return
+		}
+		if s.bits == packetSize*8 {
+			s.bits = -1
+			if sel == 0x20 {
+				s.receive()
+			}
+			return
+		}
+		if sel == 0x10 {
+			s.packet[s.bits/8] |= 1 << uint(s.bits%8)
+		}
+		s.bits++
+	}
+}
+
+// ReadP1 adjusts what the joypad register reads for multiplayer. With
+// both lines deselected it reads the current controller's number, counting
+// down from 0xF, and controllers other than the first have nothing
+// pressed.
+func (s *SGB) ReadP1(val byte) byte {
+	if s.players == 1 {
+		return val
+	}
+	if val&0x30 == 0x30 {
+		return val&0xF0 | byte(0xF-s.player)
+	}
+	if s.player != 0 {
+		return val | 0x0F
+	}
+	return val
+}
+
+// Player is the controller being read, counting from 0.
+func (s *SGB) Player() int {
+	return s.player
+}
+
+func (s *SGB) receive() {
+	s.command = append(s.command, s.packet[:]...)
+	n := int(s.command[0] & 7)
+	if n == 0 {
+		n = 1
+	}
+	if len(s.command) < n*packetSize {
+		return
+	}
+	cmd := s.command
+	s.command = nil
+	s.run(cmd[0]>>3, cmd)
+}
+
+func (s *SGB) run(op byte, d []byte) {
+	switch op {
+	case PAL01:
+		s.setPalettes(0, 1, d)
+	case PAL23:
+		s.setPalettes(2, 3, d)
+	case PAL03:
+		s.setPalettes(0, 3, d)
+	case PAL12:
+		s.setPalettes(1, 2, d)
+	case ATTR_BLK:
+		s.attrBlock(d)
+	case ATTR_LIN:
+		s.attrLine(d)
+	case ATTR_DIV:
+		s.attrDivide(d)
+	case ATTR_CHR:
+		s.attrChar(d)
+	case PAL_SET:
+		for i := range s.palettes {
+			s.palettes[i] = s.system[binary.LittleEndian.Uint16(d[1+2*i:])&0x1FF]
+		}
+		s.attrSet(d[9])
+	case ATTR_SET:
+		// Unlike PAL_SET there's no bit to apply the file: it always is.
+		s.attrSet(d[1] | 0x80)
+	case MLT_REQ:
+		s.players = 1
+		switch d[1] & 3 {
+		case 1:
+			s.players = 2
+		case 3:
+			s.players = 4
+		}
+		s.player = 0
+	case MASK_EN:
+		s.mask = d[1] & 3
+		s.hasFrozen = false
+	case PAL_TRN, CHR_TRN, PCT_TRN, ATTR_TRN:
+		s.transfer, s.transferArg = op, d[1]
+	}
+}
+
+func (s *SGB) setPalettes(a, b int, d []byte) {
+	color := func(i int) uint16 {
+		return binary.LittleEndian.Uint16(d[1+2*i:]) & 0x7FFF
+	}
+	for i := range s.palettes {
+		s.palettes[i][0] = color(0)
+	}
+	for i := 1; i < 4; i++ {
+		s.palettes[a][i] = color(i)
+		s.palettes[b][i] = color(i + 3)
+	}
+}
+
+func (s *SGB) setCell(x, y int, pal byte) {
+	if x < cellsWide && y < cellsHigh {
+		s.attrs[y*cellsWide+x] = pal & 3
+	}
+}
+
+func (s *SGB) attrBlock(d []byte) {
+	for i := 0; i < int(d[1]) && 2+6*i+6 <= len(d); i++ {
+		b := d[2+6*i:]
+		ctrl := b[0] & 7
+		in, on, out := b[1]&3, b[1]>>2&3, b[1]>>4&3
+		x1, y1, x2, y2 := int(b[2]&0x1F), int(b[3]&0x1F), int(b[4]&0x1F), int(b[5]&0x1F)
+		// Setting only the inside or the outside sets the edge too.
+		setOn := ctrl&2 != 0
+		switch ctrl {
+		case 1:
+			setOn, on = true, in
+		case 4:
+			setOn, on = true, out
+		}
+		for y := 0; y < cellsHigh; y++ {
+			for x := 0; x < cellsWide; x++ {
+				switch {
+				case x > x1 && x < x2 && y > y1 && y < y2:
+					if ctrl&1 != 0 {
+						s.setCell(x, y, in)
+					}
+				case x >= x1 && x <= x2 && y >= y1 && y <= y2:
+					if setOn {
+						s.setCell(x, y, on)
+					}
+				default:
+					if ctrl&4 != 0 {
+						s.setCell(x, y, out)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (s *SGB) attrLine(d []byte) {
+	for i := 0; i < int(d[1]) && 2+i < len(d); i++ {
+		b := d[2+i]
+		line, pal := int(b&0x1F), b>>5&3
+		for j := 0; j < cellsWide || j < cellsHigh; j++ {
+			if b&0x80 != 0 {
+				s.setCell(j, line, pal)
+			} else {
+				s.setCell(line, j, pal)
+			}
+		}
+	}
+}
+
+func (s *SGB) attrDivide(d []byte) {
+	after, before, on := d[1]&3, d[1]>>2&3, d[1]>>4&3
+	at := int(d[2] & 0x1F)
+	for y := 0; y < cellsHigh; y++ {
+		for x := 0; x < cellsWide; x++ {
+			v := x
+			if d[1]&0x40 != 0 {
+				v = y
+			}
+			switch {
+			case v < at:
+				s.setCell(x, y, before)
+			case v == at:
+				s.setCell(x, y, on)
+			default:
+				s.setCell(x, y, after)
+			}
+		}
+	}
+}
+
+func (s *SGB) attrChar(d []byte) {
+	x, y := int(d[1]), int(d[2])
+	n := int(binary.LittleEndian.Uint16(d[3:]))
+	down := d[5]&1 != 0
+	for i := 0; i < n && 6+i/4 < len(d) && x < cellsWide && y < cellsHigh; i++ {
+		s.setCell(x, y, d[6+i/4]>>uint(6-2*(i%4)))
+		if down {
+			if y++; y == cellsHigh {
+				y, x = 0, x+1
+			}
+		} else if x++; x == cellsWide {
+			x, y = 0, y+1
+		}
+	}
+}
+
+// attrSet applies an attribute file if bit 7 is set, and cancels the mask
+// if bit 6 is.
+func (s *SGB) attrSet(b byte) {
+	if n := int(b & 0x3F); b&0x80 != 0 && n < atfCount {
+		for i := range s.attrs {
+			s.attrs[i] = s.atfs[n][i/4] >> uint(6-2*(i%4)) & 3
+		}
+	}
+	if b&0x40 != 0 {
+		s.mask = MASK_CANCEL
+	}
+}
+
+// Frame is told about every frame the Game Boy finishes, which is when a
+// VRAM transfer picks up its data.
+func (s *SGB) Frame(frame []byte) {
+	if s.mask == MASK_FREEZE && !s.hasFrozen {
+		copy(s.frozen[:], frame)
+		s.hasFrozen = true
+	}
+	if s.transfer == noTransfer {
+		return
+	}
+	data := screenTiles(frame)
+	switch s.transfer {
+	case PAL_TRN:
+		for i := range s.system {
+			for c := range s.system[i] {
+				s.system[i][c] = binary.LittleEndian.Uint16(data[i*8+c*2:]) & 0x7FFF
+			}
+		}
+	case CHR_TRN:
+		base := int(s.transferArg&1) * 128
+		for i := 0; i < 128; i++ {
+			copy(s.tiles[base+i][:], data[i*32:])
+		}
+	case PCT_TRN:
+		for i := range s.borderMap {
+			s.borderMap[i] = binary.LittleEndian.Uint16(data[i*2:])
+		}
+		for p := range s.borderPalettes {
+			for c := range s.borderPalettes[p] {
+				s.borderPalettes[p][c] = binary.LittleEndian.Uint16(data[0x800+p*32+c*2:]) & 0x7FFF
+			}
+		}
+	case ATTR_TRN:
+		for i := range s.atfs {
+			copy(s.atfs[i][:], data[i*atfSize:])
+		}
+	}
+	s.transfer = noTransfer
+}
+
+// screenTiles turns the picture back into the 2 bits per pixel tile data
+// it was drawn from, tile by tile from the top left, which is how the SGB
+// reads VRAM transfers.
+func screenTiles(frame []byte) []byte {
+	data := make([]byte, transferSize)
+	for t := 0; t < transferSize/16; t++ {
+		tx, ty := t%cellsWide*8, t/cellsWide*8
+		for row := 0; row < 8; row++ {
+			var lo, hi byte
+			for x := 0; x < 8; x++ {
+				shade := frame[(ty+row)*ppu.WIDTH+tx+x]
+				lo |= (shade & 1) << uint(7-x)
+				hi |= (shade >> 1 & 1) << uint(7-x)
+			}
+			data[t*16+row*2] = lo
+			data[t*16+row*2+1] = hi
+		}
+	}
+	return data
+}
@@ -0,0 +1,239 @@
+package sgb
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+
+	"github.com/zbyrne/golangboy/ppu"
+	"github.com/zbyrne/golangboy/savestate"
+)
+
+// send bit bangs a command the way games do, padding it to whole packets.
+func send(s *SGB, data ...byte) {
+	n := int(data[0] & 7)
+	if n == 0 {
+		n = 1
+	}
+	cmd := make([]byte, n*packetSize)
+	copy(cmd, data)
+	for p := 0; p < n; p++ {
+		s.WriteP1(0x00)
+		s.WriteP1(0x30)
+		for _, b := range cmd[p*packetSize : (p+1)*packetSize] {
+			for i := 0; i < 8; i++ {
+				if b>>uint(i)&1 != 0 {
+					s.WriteP1(0x10)
+				} else {
+					s.WriteP1(0x20)
+				}
+				s.WriteP1(0x30)
+			}
+		}
+		s.WriteP1(0x20)
+		s.WriteP1(0x30)
+	}
+}
+
+func header(op byte, packets int) byte {
+	return op<<3 | byte(packets)
+}
+
+// frameOf draws tile data the way a game shows it for a VRAM transfer.
+func frameOf(data []byte) []byte {
+	frame := make([]byte, ppu.WIDTH*ppu.HEIGHT)
+	for t := 0; t*16 < len(data); t++ {
+		tx, ty := t%cellsWide*8, t/cellsWide*8
+		for row := 0; row < 8; row++ {
+			lo, hi := data[t*16+row*2], data[t*16+row*2+1]
+			for x := 0; x < 8; x++ {
+				frame[(ty+row)*ppu.WIDTH+tx+x] = lo>>uint(7-x)&1 | (hi>>uint(7-x)&1)<<1
+			}
+		}
+	}
+	return frame
+}
+
+func TestPalettes(t *testing.T) {
+	s := New()
+	if s.palettes[3] != defaultPalette {
+		t.Errorf("Palette 3 starts as %04X", s.palettes[3])
+	}
+	send(s, header(PAL23, 1), 0x1F, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04, 0x00, 0x05, 0x00, 0x06, 0x80)
+	if want := [4]uint16{0x1F, 1, 2, 3}; s.palettes[2] != want {
+		t.Errorf("Palette 2 is %04X", s.palettes[2])
+	}
+	if want := [4]uint16{0x1F, 4, 5, 6}; s.palettes[3] != want {
+		t.Errorf("Palette 3 is %04X", s.palettes[3])
+	}
+	if s.palettes[0][0] != 0x1F || s.palettes[0][1] != defaultPalette[1] {
+		t.Errorf("Palette 0 is %04X", s.palettes[0])
+	}
+
+	// A packet without its stop bit is dropped.
+	s.WriteP1(0x00)
+	s.WriteP1(0x30)
+	for i := 0; i < 129; i++ {
+		s.WriteP1(0x10)
+		s.WriteP1(0x30)
+	}
+	if s.palettes[0][1] != defaultPalette[1] {
+		t.Error("Packet with a bad stop bit was run")
+	}
+
+	data := make([]byte, transferSize)
+	for i := 0; i < 4; i++ {
+		data[300*8+i*2] = byte(0x10 + i)
+	}
+	send(s, header(PAL_TRN, 1))
+	s.Frame(frameOf(data))
+	send(s, header(PAL_SET, 1), 0, 0, 0, 0, 0x2C, 0x01, 0, 0, 0)
+	if want := [4]uint16{0x10, 0x11, 0x12, 0x13}; s.palettes[2] != want {
+		t.Errorf("PAL_SET palette 2 is %04X", s.palettes[2])
+	}
+}
+
+func cells(s *SGB, row int) []byte {
+	return s.attrs[row*cellsWide : (row+1)*cellsWide]
+}
+
+func TestAttributes(t *testing.T) {
+	s := New()
+	// Inside only, so the edge goes with it.
+	send(s, header(ATTR_BLK, 1), 1, 1, 0x01, 2, 2, 4, 4)
+	if got := cells(s, 2)[:6]; !bytes.Equal(got, []byte{0, 0, 1, 1, 1, 0}) || cells(s, 5)[3] != 0 {
+		t.Errorf("ATTR_BLK row 2 is %v", got)
+	}
+	send(s, header(ATTR_BLK, 1), 1, 6, 0x3E, 2, 2, 4, 4)
+	if got := cells(s, 3)[:6]; !bytes.Equal(got, []byte{3, 3, 3, 1, 3, 3}) || cells(s, 0)[0] != 3 {
+		t.Errorf("ATTR_BLK edge and outside leave row 3 as %v", got)
+	}
+
+	send(s, header(ATTR_DIV, 1), 0x40|2<<4|1<<2|0, 9)
+	if cells(s, 8)[0] != 1 || cells(s, 9)[19] != 2 || cells(s, 10)[5] != 0 {
+		t.Errorf("ATTR_DIV rows are %d %d %d", cells(s, 8)[0], cells(s, 9)[19], cells(s, 10)[5])
+	}
+	send(s, header(ATTR_LIN, 1), 2, 0x80|3<<5|17, 1<<5|0)
+	if cells(s, 17)[10] != 3 || cells(s, 4)[0] != 1 || cells(s, 17)[0] != 1 {
+		t.Errorf("ATTR_LIN cells are %d %d %d", cells(s, 17)[10], cells(s, 4)[0], cells(s, 17)[0])
+	}
+
+	// Five cells from the end of row 0, wrapping onto row 1.
+	send(s, header(ATTR_CHR, 1), 18, 0, 5, 0, 0, 0x1B, 0xC0)
+	if got := s.attrs[18:23]; !bytes.Equal(got, []byte{0, 1, 2, 3, 3}) {
+		t.Errorf("ATTR_CHR set %v", got)
+	}
+	// Down from the bottom of a column, wrapping onto the top of the next.
+	send(s, header(ATTR_CHR, 1), 18, 17, 2, 0, 1, 0xC0)
+	if cells(s, 17)[18] != 3 || cells(s, 0)[19] != 0 {
+		t.Errorf("ATTR_CHR down set %d and %d", cells(s, 17)[18], cells(s, 0)[19])
+	}
+
+	data := make([]byte, transferSize)
+	data[2*atfSize] = 0xE4
+	send(s, header(ATTR_TRN, 1))
+	s.Frame(frameOf(data))
+	send(s, header(MASK_EN, 1), MASK_BLACK)
+	send(s, header(ATTR_SET, 1), 2)
+	if got := cells(s, 0)[:6]; !bytes.Equal(got, []byte{3, 2, 1, 0, 0, 0}) || s.mask != MASK_BLACK {
+		t.Errorf("ATTR_SET row 0 is %v, mask %d", got, s.mask)
+	}
+	send(s, header(ATTR_SET, 1), 0x40|1)
+	if cells(s, 0)[0] != 0 || s.mask != MASK_CANCEL {
+		t.Errorf("ATTR_SET 1 left cell 0 at %d, mask %d", cells(s, 0)[0], s.mask)
+	}
+	send(s, header(PAL_SET, 1), 0, 0, 0, 0, 0, 0, 0, 0, 2)
+	if cells(s, 0)[0] != 0 {
+		t.Error("PAL_SET applied an attribute file without bit 7")
+	}
+}
+
+func TestBorder(t *testing.T) {
+	s := New()
+	tiles := make([]byte, transferSize)
+	// Tile 1 row 0: colour 5 (planes 0 and 2) on the left pixel, colour 0
+	// on the rest.
+	tiles[32+0] = 0x80
+	tiles[32+16] = 0x80
+	send(s, header(CHR_TRN, 1), 0)
+	s.Frame(frameOf(tiles))
+
+	pct := make([]byte, transferSize)
+	pct[0], pct[1] = 1, 5<<2      // tile 1, palette 5
+	pct[2], pct[3] = 1, 5<<2|0x40 // flipped across
+	pct[0x800+32+10] = 0x1F       // palette 5 colour 5 is red
+	send(s, header(PCT_TRN, 1))
+	s.Frame(frameOf(pct))
+
+	send(s, header(PAL01, 1), 0x00, 0x7C, 0xE0, 0x03)
+	frame := make([]byte, ppu.WIDTH*ppu.HEIGHT)
+	frame[0] = 1
+	img := s.Image(frame)
+	if b := img.Bounds(); b.Dx() != WIDTH || b.Dy() != HEIGHT {
+		t.Fatalf("Image is %v", b)
+	}
+	red, blue := color.RGBA{0xFF, 0, 0, 0xFF}, color.RGBA{0, 0, 0xFF, 0xFF}
+	for _, p := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, red},
+		{1, 0, blue},
+		{15, 0, red},
+		{100, 200, blue},
+		{SCREEN_X, SCREEN_Y, color.RGBA{0, 0xFF, 0, 0xFF}},
+		{SCREEN_X + 1, SCREEN_Y, blue},
+	} {
+		if got := img.RGBAAt(p.x, p.y); got != p.want {
+			t.Errorf("(%d, %d) is %v, not %v", p.x, p.y, got, p.want)
+		}
+	}
+
+	send(s, header(MASK_EN, 1), MASK_FREEZE)
+	s.Frame(frame)
+	if got := s.Image(make([]byte, len(frame))).RGBAAt(SCREEN_X, SCREEN_Y); got != (color.RGBA{0, 0xFF, 0, 0xFF}) {
+		t.Errorf("Frozen screen shows %v", got)
+	}
+	send(s, header(MASK_EN, 1), MASK_BLACK)
+	if got := s.Image(frame).RGBAAt(SCREEN_X+1, SCREEN_Y); got != (color.RGBA{0, 0, 0, 0xFF}) {
+		t.Errorf("Blacked out screen shows %v", got)
+	}
+}
+
+func TestMultiplayer(t *testing.T) {
+	s := New()
+	if got := s.ReadP1(0xFF); got != 0xFF {
+		t.Errorf("One player reads 0x%02X", got)
+	}
+	send(s, header(MLT_REQ, 1), 3)
+	var ids []byte
+	for i := 0; i < 5; i++ {
+		ids = append(ids, s.ReadP1(0xFF)&0x0F)
+		s.WriteP1(0x10)
+		if s.Player() != 0 && s.ReadP1(0xD7)&0x0F != 0x0F {
+			t.Errorf("Player %d has buttons down", s.Player())
+		}
+		s.WriteP1(0x30)
+	}
+	if !bytes.Equal(ids, []byte{0xF, 0xE, 0xD, 0xC, 0xF}) {
+		t.Errorf("Controller IDs went %X", ids)
+	}
+	send(s, header(MLT_REQ, 1), 0)
+	if got := s.ReadP1(0xFF); got != 0xFF || s.Player() != 0 {
+		t.Errorf("Back to one player reads 0x%02X", got)
+	}
+}
+
+func TestState(t *testing.T) {
+	s := New()
+	send(s, header(PAL01, 1), 0x00, 0x7C, 0xE0, 0x03)
+	send(s, header(MLT_REQ, 1), 1)
+	send(s, header(CHR_TRN, 1), 1)
+	restored := New()
+	if err := savestate.Copy(restored, s, STATE_VERSION); err != nil {
+		t.Fatal(err)
+	}
+	if restored.palettes != s.palettes || restored.players != 2 || restored.transfer != CHR_TRN || restored.transferArg != 1 {
+		t.Errorf("Restored palettes %04X, %d players, transfer %d", restored.palettes[0], restored.players, restored.transfer)
+	}
+}
@@ -0,0 +1,87 @@
+package sgb
+
+import "github.com/zbyrne/golangboy/savestate"
+
+// STATE_VERSION is the version of the SGB's save state chunk.
+const STATE_VERSION = 1
+
+func (s *SGB) SaveState(e *savestate.Encoder) {
+	e.Byte(s.p1)
+	e.Int(s.bits)
+	e.Bytes(s.packet[:])
+	e.Int(len(s.command))
+	e.Bytes(s.command)
+	words := func(ws []uint16) {
+		for _, w := range ws {
+			e.Uint16(w)
+		}
+	}
+	for i := range s.palettes {
+		words(s.palettes[i][:])
+	}
+	for i := range s.system {
+		words(s.system[i][:])
+	}
+	e.Bytes(s.attrs[:])
+	for i := range s.atfs {
+		e.Bytes(s.atfs[i][:])
+	}
+	e.Byte(s.mask)
+	e.Bytes(s.frozen[:])
+	e.Bool(s.hasFrozen)
+	for i := range s.tiles {
+		e.Bytes(s.tiles[i][:])
+	}
+	words(s.borderMap[:])
+	for i := range s.borderPalettes {
+		words(s.borderPalettes[i][:])
+	}
+	e.Int(s.players)
+	e.Int(s.player)
+	e.Byte(s.transfer)
+	e.Byte(s.transferArg)
+}
+
+func (s *SGB) LoadState(d *savestate.Decoder) {
+	s.p1 = d.Byte()
+	s.bits = d.Int()
+	d.Bytes(s.packet[:])
+	n := d.Int()
+	if n < 0 || n > 7*packetSize {
+		n = 0
+	}
+	s.command = make([]byte, n)
+	d.Bytes(s.command)
+	words := func(ws []uint16) {
+		for i := range ws {
+			ws[i] = d.Uint16()
+		}
+	}
+	for i := range s.palettes {
+		words(s.palettes[i][:])
+	}
+	for i := range s.system {
+		words(s.system[i][:])
+	}
+	d.Bytes(s.attrs[:])
+	for i := range s.atfs {
+		d.Bytes(s.atfs[i][:])
+	}
+	s.mask = d.Byte()
+	d.Bytes(s.frozen[:])
+	s.hasFrozen = d.Bool()
+	for i := range s.tiles {
+		d.Bytes(s.tiles[i][:])
+	}
+	words(s.borderMap[:])
+	for i := range s.borderPalettes {
+		words(s.borderPalettes[i][:])
+	}
+	s.players = d.Int()
+	s.player = d.Int()
+	if s.players < 1 || s.player >= s.players {
+		s.players, s.player = 1, 0
+	}
+	s.transfer = d.Byte()
+	s.transferArg = d.Byte()
+}
@@ -0,0 +1,247 @@
+// Package symbols reads the label files written by RGBDS and no$gmb.
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Symbol struct {
+	Name string
+	Bank int
+	Addr uint16
+}
+
+func (s Symbol) String() string {
+	return fmt.Sprintf("%02X:%04X %s", s.Bank, s.Addr, s.Name)
+}
+
+// Table holds labels by name and by address.
+type Table struct {
+	byName map[string]Symbol
+	sorted []Symbol
+}
+
+func New() *Table {
+	return &Table{byName: map[string]Symbol{}}
+}
+
+func (t *Table) Add(s Symbol) {
+	if old, ok := t.byName[s.Name]; ok {
+		for i, o := range t.sorted {
+			if o == old {
+				t.sorted = append(t.sorted[:i], t.sorted[i+1:]...)
+				break
+			}
+		}
+	}
+	t.byName[s.Name] = s
+	i := sort.Search(len(t.sorted), func(i int) bool {
+		return !less(t.sorted[i], s)
+	})
+	t.sorted = append(t.sorted, Symbol{})
+	copy(t.sorted[i+1:], t.sorted[i:])
+	t.sorted[i] = s
+}
+
+func less(a, b Symbol) bool {
+	if a.Bank != b.Bank {
+		return a.Bank < b.Bank
+	}
+	if a.Addr != b.Addr {
+		return a.Addr < b.Addr
+	}
+	return a.Name < b.Name
+}
+
+func (t *Table) Lookup(name string) (Symbol, bool) {
+	s, ok := t.byName[name]
+	return s, ok
+}
+
+// Symbols returns every symbol ordered by bank and address.
+func (t *Table) Symbols() []Symbol {
+	return append([]Symbol(nil), t.sorted...)
+}
+
+func (t *Table) Len() int {
+	return len(t.sorted)
+}
+
+// Nearest finds the closest label at or before addr in the given bank and
+// how far past it addr is. Labels in bank 0 are only searched for
+// addresses outside the banked ROM area, unless nothing in the bank
+// matches: ROMs linked without banks put everything in bank 0. Where
+// several labels share an address the last by name wins, so a local
+// label is preferred over its parent.
+func (t *Table) Nearest(bank int, addr uint16) (Symbol, uint16, bool) {
+	if s, offset, ok := t.nearest(bank, addr); ok || bank == 0 {
+		return s, offset, ok
+	}
+	return t.nearest(0, addr)
+}
+
+func (t *Table) nearest(bank int, addr uint16) (Symbol, uint16, bool) {
+	i := sort.Search(len(t.sorted), func(i int) bool {
+		s := t.sorted[i]
+		return s.Bank > bank || (s.Bank == bank && s.Addr > addr)
+	})
+	for i--; i >= 0; i-- {
+		s := t.sorted[i]
+		if s.Bank != bank {
+			break
+		}
+		// Don't run back from a banked area into fixed ROM or vice versa.
+		if region(s.Addr) != region(addr) {
+			break
+		}
+		return s, addr - s.Addr, true
+	}
+	return Symbol{}, 0, false
+}
+
+// Label names addr as label or label+offset, with the offset in decimal.
+// It returns "" if no label comes before addr.
+func (t *Table) Label(bank int, addr uint16) string {
+	s, offset, ok := t.Nearest(bank, addr)
+	switch {
+	case !ok:
+		return ""
+	case offset == 0:
+		return s.Name
+	}
+	return fmt.Sprintf("%s+%d", s.Name, offset)
+}
+
+// Resolve looks up a label with an optional decimal offset, such as
+// Main.loop+3, as written by Label.
+func (t *Table) Resolve(expr string) (Symbol, error) {
+	name, offset := expr, 0
+	if i := strings.LastIndexAny(expr, "+-"); i > 0 {
+		n, err := strconv.Atoi(expr[i:])
+		if err != nil {
+			return Symbol{}, fmt.Errorf("bad offset in %q", expr)
+		}
+		name, offset = expr[:i], n
+	}
+	s, ok := t.byName[name]
+	if !ok {
+		return Symbol{}, fmt.Errorf("unknown label %q", name)
+	}
+	s.Addr += uint16(offset)
+	return s, nil
+}
+
+// region groups addresses so a label in one memory area isn't used to
+// name an address in another.
+func region(addr uint16) int {
+	switch {
+	case addr < 0x4000:
+		return 0
+	case addr < 0x8000:
+		return 1
+	case addr < 0xA000:
+		return 2
+	case addr < 0xC000:
+		return 3
+	case addr < 0xD000:
+		return 4
+	case addr < 0xE000:
+		return 5
+	case addr < 0xFF80:
+		return 6
+	}
+	return 7
+}
+
+// parseBankAddr reads bb:aaaa, both in hex.
+func parseBankAddr(s string) (int, uint16, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected bank:address, not %q", s)
+	}
+	bank, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad bank %q", parts[0])
+	}
+	addr, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad address %q", parts[1])
+	}
+	return int(bank), uint16(addr), nil
+}
+
+// ParseSym reads a .sym file of "bank:address name" lines, the format
+// shared by RGBDS and no$gmb. Comments start with a semicolon.
+func ParseSym(r io.Reader) (*Table, error) {
+	t := New()
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, ";"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected bank:address name", n)
+		}
+		bank, addr, err := parseBankAddr(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		t.Add(Symbol{Name: fields[1], Bank: bank, Addr: addr})
+	}
+	return t, scanner.Err()
+}
+
+// ParseMap reads the symbols out of an rgblink .map file. Each
+// "TYPE bank #n:" heading sets the bank for the "$addr = name" lines
+// under it.
+func ParseMap(r io.Reader) (*Table, error) {
+	t := New()
+	bank := 0
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, " bank #"); i >= 0 && strings.HasSuffix(line, ":") {
+			val, err := strconv.Atoi(strings.TrimSuffix(line[i+len(" bank #"):], ":"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad bank heading %q", n, line)
+			}
+			bank = val
+			continue
+		}
+		if !strings.HasPrefix(line, "$") || !strings.Contains(line, " = ") {
+			continue
+		}
+		parts := strings.SplitN(line, " = ", 2)
+		addr, err := strconv.ParseUint(parts[0][1:], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad address %q", n, parts[0])
+		}
+		t.Add(Symbol{Name: strings.TrimSpace(parts[1]), Bank: bank, Addr: uint16(addr)})
+	}
+	return t, scanner.Err()
+}
+
+// Load reads a .map file, or a .sym file for any other extension.
+func Load(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(path), ".map") {
+		return ParseMap(f)
+	}
+	return ParseSym(f)
+}
@@ -0,0 +1,160 @@
+package symbols
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sym = `; File generated by rgblink
+00:0150 Main
+00:0156 Main.loop
+01:4000 BankedRoutine
+02:4000 OtherBank
+00:4800 Unbanked
+00:c000 wCounter
+00:ff80 hFlag
+`
+
+func TestParseSym(t *testing.T) {
+	tab, err := ParseSym(strings.NewReader(sym))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tab.Len() != 7 {
+		t.Errorf("Read %d symbols, not 7", tab.Len())
+	}
+	s, ok := tab.Lookup("OtherBank")
+	if !ok || s.Bank != 2 || s.Addr != 0x4000 {
+		t.Errorf("OtherBank is %v", s)
+	}
+	if s.String() != "02:4000 OtherBank" {
+		t.Errorf("Formatted as %q", s)
+	}
+	if _, err := ParseSym(strings.NewReader("0150 Main\n")); err == nil {
+		t.Error("Parsed a line without a bank")
+	}
+}
+
+func TestNearest(t *testing.T) {
+	tab, _ := ParseSym(strings.NewReader(sym))
+	for _, c := range []struct {
+		bank   int
+		addr   uint16
+		name   string
+		offset uint16
+		ok     bool
+	}{
+		{0, 0x0150, "Main", 0, true},
+		{0, 0x0158, "Main.loop", 2, true},
+		{1, 0x4010, "BankedRoutine", 0x10, true},
+		{2, 0x4001, "OtherBank", 1, true},
+		{3, 0x4001, "", 0, false},
+		{0, 0x0100, "", 0, false},
+		// Fixed ROM labels don't name banked or RAM addresses.
+		{0, 0x4000, "", 0, false},
+		{0, 0xC005, "wCounter", 5, true},
+		{0, 0xFF81, "hFlag", 1, true},
+		// Labels linked without banks are used when the bank has none.
+		{3, 0x4801, "Unbanked", 1, true},
+	} {
+		s, offset, ok := tab.Nearest(c.bank, c.addr)
+		if ok != c.ok || s.Name != c.name || offset != c.offset {
+			t.Errorf("Nearest %02X:%04X is %q+%d, %v", c.bank, c.addr, s.Name, offset, ok)
+		}
+	}
+}
+
+func TestLabel(t *testing.T) {
+	tab, _ := ParseSym(strings.NewReader(sym))
+	for _, c := range []struct {
+		bank int
+		addr uint16
+		text string
+	}{
+		{0, 0x0150, "Main"},
+		{0, 0x0159, "Main.loop+3"},
+		{2, 0x4020, "OtherBank+32"},
+		{0, 0x0000, ""},
+	} {
+		text := tab.Label(c.bank, c.addr)
+		if text != c.text {
+			t.Errorf("Labelled %02X:%04X as %q, not %q", c.bank, c.addr, text, c.text)
+		}
+		if text == "" {
+			continue
+		}
+		s, err := tab.Resolve(text)
+		if err != nil || s.Bank != c.bank || s.Addr != c.addr {
+			t.Errorf("Resolved %q to %v: %v", text, s, err)
+		}
+	}
+	if s, err := tab.Resolve("wCounter-1"); err != nil || s.Addr != 0xBFFF {
+		t.Errorf("Resolved wCounter-1 to %v: %v", s, err)
+	}
+	for _, expr := range []string{"Missing", "Main+x"} {
+		if _, err := tab.Resolve(expr); err == nil {
+			t.Errorf("Resolved %q", expr)
+		}
+	}
+}
+
+func TestAddReplaces(t *testing.T) {
+	tab := New()
+	tab.Add(Symbol{"Foo", 0, 0x100})
+	tab.Add(Symbol{"Foo", 0, 0x200})
+	if tab.Len() != 1 {
+		t.Errorf("%d symbols after redefining Foo", tab.Len())
+	}
+	if s, _, _ := tab.Nearest(0, 0x150); s.Name != "" {
+		t.Errorf("Found stale %v", s)
+	}
+}
+
+const mapFile = `SUMMARY:
+	ROM0: 342 bytes used / 16042 free
+
+ROM0 bank #0:
+	SECTION: $0150-$0160 ($0011 bytes) ["Main"]
+	         $0150 = Main
+	         $0156 = Main.loop
+	EMPTY: $3e9f bytes
+
+ROMX bank #3:
+	SECTION: $4000-$4001 ($0002 bytes) ["Banked"]
+	         $4000 = Banked
+
+WRAM0 bank #0:
+	SECTION: $c000-$c000 ($0001 byte) ["Vars"]
+	         $c000 = wCounter
+`
+
+func TestParseMap(t *testing.T) {
+	tab, err := ParseMap(strings.NewReader(mapFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]Symbol{
+		"Main":      {"Main", 0, 0x150},
+		"Main.loop": {"Main.loop", 0, 0x156},
+		"Banked":    {"Banked", 3, 0x4000},
+		"wCounter":  {"wCounter", 0, 0xC000},
+	} {
+		if s, ok := tab.Lookup(name); !ok || s != want {
+			t.Errorf("%s is %v", name, s)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "game.sym"), []byte(sym), 0644)
+	os.WriteFile(filepath.Join(dir, "game.map"), []byte(mapFile), 0644)
+	if tab, err := Load(filepath.Join(dir, "game.sym")); err != nil || tab.Len() != 7 {
+		t.Errorf("Loaded .sym: %v", err)
+	}
+	if tab, err := Load(filepath.Join(dir, "game.map")); err != nil || tab.Len() != 4 {
+		t.Errorf("Loaded .map: %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+package timer
+
+import "github.com/zbyrne/golangboy/savestate"
+
+// STATE_VERSION is the version of the timer's save state chunk.
+const STATE_VERSION = 1
+
+func (t *Timer) SaveState(e *savestate.Encoder) {
+	e.Uint16(t.counter)
+	e.Byte(t.tima)
+	e.Byte(t.tma)
+	e.Byte(t.tac)
+	e.Bool(t.overflow)
+}
+
+func (t *Timer) LoadState(d *savestate.Decoder) {
+	t.counter = d.Uint16()
+	t.tima = d.Byte()
+	t.tma = d.Byte()
+	t.tac = d.Byte()
+	t.overflow = d.Bool()
+}
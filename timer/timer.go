@@ -0,0 +1,104 @@
+package timer
+
+import (
+	"github.com/zbyrne/golangboy/z80"
+)
+
+const (
+	DIV  uint16 = 0xFF04
+	TIMA uint16 = 0xFF05
+	TMA  uint16 = 0xFF06
+	TAC  uint16 = 0xFF07
+
+	TAC_ENABLE byte = 1 << 2
+)
+
+// TIMA counts falling edges of one bit of the internal 16-bit divider,
+// selected by the low bits of TAC.
+var tacBits = [4]uint16{1 << 9, 1 << 3, 1 << 5, 1 << 7}
+
+type Timer struct {
+	counter        uint16
+	tima, tma, tac byte
+	overflow       bool
+	irq            z80.Interrupter
+}
+
+func New(irq z80.Interrupter) *Timer {
+	return &Timer{irq: irq}
+}
+
+// SetCounter sets the internal divider, for starting without a boot ROM.
+func (t *Timer) SetCounter(c uint16) {
+	t.counter = c
+}
+
+func (t *Timer) Counter() uint16 {
+	return t.counter
+}
+
+func (t *Timer) signal() bool {
+	return t.tac&TAC_ENABLE != 0 && t.counter&tacBits[t.tac&3] != 0
+}
+
+func (t *Timer) increment() {
+	t.tima++
+	if t.tima == 0 {
+		t.overflow = true
+	}
+}
+
+// Tick runs the timer a machine cycle at a time.
+func (t *Timer) Tick(ticks z80.ClockTicks) {
+	for ; ticks > 0; ticks -= 4 {
+		// TIMA reads 0 for a cycle after overflowing before TMA is
+		// loaded and the interrupt raised.
+		if t.overflow {
+			t.overflow = false
+			t.tima = t.tma
+			if t.irq != nil {
+				t.irq.RequestInterrupt(z80.TIMER_INT)
+			}
+		}
+		before := t.signal()
+		t.counter += 4
+		if before && !t.signal() {
+			t.increment()
+		}
+	}
+}
+
+func (t *Timer) ReadByte(addr uint16) byte {
+	switch addr {
+	case DIV:
+		return byte(t.counter >> 8)
+	case TIMA:
+		return t.tima
+	case TMA:
+		return t.tma
+	case TAC:
+		return t.tac | 0xF8
+	}
+	return 0xFF
+}
+
+// WriteByte handles register writes. Resetting DIV or changing TAC can
+// produce a falling edge, which increments TIMA like any other.
+func (t *Timer) WriteByte(addr uint16, val byte) {
+	before := t.signal()
+	switch addr {
+	case DIV:
+		t.counter = 0
+	case TIMA:
+		// Writing during the overflow cycle cancels the reload.
+		t.overflow = false
+		t.tima = val
+	case TMA:
+		t.tma = val
+	case TAC:
+		t.tac = val & 0x07
+	}
+	if before && !t.signal() {
+		t.increment()
+	}
+}
@@ -0,0 +1,139 @@
+package timer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zbyrne/golangboy/savestate"
+	"github.com/zbyrne/golangboy/z80"
+)
+
+type mockInterrupter struct {
+	requested byte
+}
+
+func (m *mockInterrupter) RequestInterrupt(i byte) {
+	m.requested |= i
+}
+
+func TestDIV(t *testing.T) {
+	tm := New(nil)
+	tm.Tick(252)
+	if val := tm.ReadByte(DIV); val != 0 {
+		t.Errorf("DIV = 0x%02X, not 0x00", val)
+	}
+	tm.Tick(4)
+	if val := tm.ReadByte(DIV); val != 1 {
+		t.Errorf("DIV = 0x%02X, not 0x01", val)
+	}
+	tm.WriteByte(DIV, 0x55)
+	if val := tm.ReadByte(DIV); val != 0 {
+		t.Errorf("DIV = 0x%02X after reset, not 0x00", val)
+	}
+}
+
+func TestTACRead(t *testing.T) {
+	tm := New(nil)
+	tm.WriteByte(TAC, 0xFD)
+	if val := tm.ReadByte(TAC); val != 0xFD {
+		t.Errorf("TAC = 0x%02X, not 0xFD", val)
+	}
+}
+
+func TestTIMADisabled(t *testing.T) {
+	tm := New(nil)
+	tm.WriteByte(TAC, 0x01)
+	tm.Tick(1024)
+	if val := tm.ReadByte(TIMA); val != 0 {
+		t.Errorf("TIMA = 0x%02X while disabled", val)
+	}
+}
+
+func TestTIMARates(t *testing.T) {
+	for tac, period := range []z80.ClockTicks{1024, 16, 64, 256} {
+		tm := New(nil)
+		tm.WriteByte(TAC, TAC_ENABLE|byte(tac))
+		tm.Tick(period*10 - 4)
+		if val := tm.ReadByte(TIMA); val != 9 {
+			t.Errorf("TAC %d: TIMA = %d, not 9", tac, val)
+		}
+		tm.Tick(4)
+		if val := tm.ReadByte(TIMA); val != 10 {
+			t.Errorf("TAC %d: TIMA = %d, not 10", tac, val)
+		}
+	}
+}
+
+func TestTIMAOverflow(t *testing.T) {
+	var irq mockInterrupter
+	tm := New(&irq)
+	tm.WriteByte(TMA, 0xAB)
+	tm.WriteByte(TIMA, 0xFF)
+	tm.WriteByte(TAC, 0x05)
+	tm.Tick(16)
+	if val := tm.ReadByte(TIMA); val != 0 {
+		t.Errorf("TIMA = 0x%02X during reload delay, not 0x00", val)
+	}
+	if irq.requested != 0 {
+		t.Error("Interrupt requested before the reload")
+	}
+	tm.Tick(4)
+	if val := tm.ReadByte(TIMA); val != 0xAB {
+		t.Errorf("TIMA = 0x%02X, not 0xAB", val)
+	}
+	if irq.requested != z80.TIMER_INT {
+		t.Error("Timer interrupt not requested")
+	}
+}
+
+func TestTIMAWriteCancelsReload(t *testing.T) {
+	var irq mockInterrupter
+	tm := New(&irq)
+	tm.WriteByte(TMA, 0xAB)
+	tm.WriteByte(TIMA, 0xFF)
+	tm.WriteByte(TAC, 0x05)
+	tm.Tick(16)
+	tm.WriteByte(TIMA, 0x12)
+	tm.Tick(4)
+	if val := tm.ReadByte(TIMA); val != 0x12 {
+		t.Errorf("TIMA = 0x%02X, not 0x12", val)
+	}
+	if irq.requested != 0 {
+		t.Error("Interrupt requested after the reload was cancelled")
+	}
+}
+
+func TestDIVResetFallingEdge(t *testing.T) {
+	tm := New(nil)
+	tm.WriteByte(TAC, 0x05)
+	tm.Tick(8)
+	tm.WriteByte(DIV, 0)
+	if val := tm.ReadByte(TIMA); val != 1 {
+		t.Errorf("TIMA = %d, not 1", val)
+	}
+}
+
+func TestTACDisableFallingEdge(t *testing.T) {
+	tm := New(nil)
+	tm.WriteByte(TAC, 0x05)
+	tm.Tick(8)
+	tm.WriteByte(TAC, 0x01)
+	if val := tm.ReadByte(TIMA); val != 1 {
+		t.Errorf("TIMA = %d, not 1", val)
+	}
+}
+
+func TestState(t *testing.T) {
+	var irq mockInterrupter
+	tm := New(&irq)
+	tm.WriteByte(TMA, 0x80)
+	tm.WriteByte(TAC, 0x05)
+	tm.Tick(1020)
+	loaded := New(&irq)
+	if err := savestate.Copy(loaded, tm, STATE_VERSION); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, tm) {
+		t.Errorf("Loaded %+v, saved %+v", loaded, tm)
+	}
+}
@@ -0,0 +1,64 @@
+package wav
+
+import (
+	"io"
+)
+
+// Recorder captures a stereo mix and, optionally, a mono stem for each
+// sound channel so that a single channel can be compared between builds.
+type Recorder struct {
+	mix   *Writer
+	stems []*Writer
+	frame []int16
+}
+
+// NewRecorder writes the mix to mix and one stem to each of stems. stems
+// may be empty.
+func NewRecorder(mix io.WriteSeeker, stems []io.WriteSeeker, sampleRate int) (*Recorder, error) {
+	var err error
+	r := &Recorder{frame: make([]int16, 2)}
+	r.mix, err = NewWriter(mix, sampleRate, 2)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range stems {
+		w, err := NewWriter(s, sampleRate, 1)
+		if err != nil {
+			return nil, err
+		}
+		r.stems = append(r.stems, w)
+	}
+	return r, nil
+}
+
+// WriteSample records one output frame. channels holds the per-channel
+// samples in stem order; extra values are ignored and missing ones are
+// recorded as silence.
+func (r *Recorder) WriteSample(left, right int16, channels ...int16) error {
+	r.frame[0] = left
+	r.frame[1] = right
+	if err := r.mix.Write(r.frame); err != nil {
+		return err
+	}
+	for i, s := range r.stems {
+		var v int16
+		if i < len(channels) {
+			v = channels[i]
+		}
+		r.frame[0] = v
+		if err := s.Write(r.frame[:1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	err := r.mix.Close()
+	for _, s := range r.stems {
+		if e := s.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
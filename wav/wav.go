@@ -0,0 +1,116 @@
+package wav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	headerSize    = 44
+	bitsPerSample = 16
+)
+
+var ErrShortFrame = errors.New("wav: sample count is not a multiple of the channel count")
+
+// Writer encodes interleaved 16-bit PCM samples as a RIFF WAVE file. The
+// sizes in the header are filled in by Close, so the destination must be
+// seekable. The file starts wherever the destination was when the Writer
+// was made. Samples are buffered, so the destination is only up to date
+// after Close.
+type Writer struct {
+	w        io.WriteSeeker
+	bw       *bufio.Writer
+	start    int64
+	channels int
+	dataLen  uint32
+	buf      []byte
+}
+
+func NewWriter(w io.WriteSeeker, sampleRate, channels int) (*Writer, error) {
+	if channels < 1 {
+		return nil, errors.New("wav: need at least one channel")
+	}
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	wr := &Writer{w: w, bw: bufio.NewWriter(w), start: start, channels: channels}
+	if err := wr.writeHeader(sampleRate); err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+func (w *Writer) writeHeader(sampleRate int) error {
+	blockAlign := w.channels * bitsPerSample / 8
+	hdr := make([]byte, headerSize)
+	copy(hdr[0:], "RIFF")
+	binary.LittleEndian.PutUint32(hdr[4:], 36)
+	copy(hdr[8:], "WAVE")
+	copy(hdr[12:], "fmt ")
+	binary.LittleEndian.PutUint32(hdr[16:], 16)
+	binary.LittleEndian.PutUint16(hdr[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(hdr[22:], uint16(w.channels))
+	binary.LittleEndian.PutUint32(hdr[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(hdr[28:], uint32(sampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(hdr[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(hdr[34:], bitsPerSample)
+	copy(hdr[36:], "data")
+	_, err := w.w.Write(hdr)
+	return err
+}
+
+// Write appends interleaved samples, one per channel per frame. Once
+// writing to the destination fails, Write and Close return that first
+// error.
+func (w *Writer) Write(samples []int16) error {
+	if len(samples)%w.channels != 0 {
+		return ErrShortFrame
+	}
+	if cap(w.buf) < len(samples)*2 {
+		w.buf = make([]byte, len(samples)*2)
+	}
+	b := w.buf[:len(samples)*2]
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(b[i*2:], uint16(s))
+	}
+	n, err := w.bw.Write(b)
+	w.dataLen += uint32(n)
+	return err
+}
+
+// Frames returns the number of sample frames written so far.
+func (w *Writer) Frames() int {
+	return int(w.dataLen) / (w.channels * bitsPerSample / 8)
+}
+
+// Close writes out buffered samples and patches the chunk sizes into the
+// header. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	end, err := w.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	size := make([]byte, 4)
+	binary.LittleEndian.PutUint32(size, 36+w.dataLen)
+	if _, err := w.w.Seek(w.start+4, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(size); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(size, w.dataLen)
+	if _, err := w.w.Seek(w.start+40, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(size); err != nil {
+		return err
+	}
+	_, err = w.w.Seek(end, io.SeekStart)
+	return err
+}
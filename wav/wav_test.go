@@ -0,0 +1,204 @@
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+type seekBuffer struct {
+	buff   []byte
+	pos    int
+	writes int
+	err    error
+}
+
+func (s *seekBuffer) Write(p []byte) (int, error) {
+	s.writes++
+	if s.err != nil {
+		return 0, s.err
+	}
+	if end := s.pos + len(p); end > len(s.buff) {
+		s.buff = append(s.buff, make([]byte, end-len(s.buff))...)
+	}
+	copy(s.buff[s.pos:], p)
+	s.pos += len(p)
+	return len(p), nil
+}
+
+func (s *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		s.pos = int(offset)
+	case io.SeekCurrent:
+		s.pos += int(offset)
+	case io.SeekEnd:
+		s.pos = len(s.buff) + int(offset)
+	}
+	return int64(s.pos), nil
+}
+
+func TestNewWriterHeader(t *testing.T) {
+	var b seekBuffer
+	_, err := NewWriter(&b, 44100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.buff) != 44 {
+		t.Fatalf("Header is %d bytes, not 44", len(b.buff))
+	}
+	if string(b.buff[0:4]) != "RIFF" || string(b.buff[8:12]) != "WAVE" {
+		t.Errorf("Bad magic %q", b.buff[0:12])
+	}
+	if ch := binary.LittleEndian.Uint16(b.buff[22:]); ch != 2 {
+		t.Errorf("Channels = %d, not 2", ch)
+	}
+	if rate := binary.LittleEndian.Uint32(b.buff[24:]); rate != 44100 {
+		t.Errorf("Sample rate = %d, not 44100", rate)
+	}
+	if br := binary.LittleEndian.Uint32(b.buff[28:]); br != 44100*4 {
+		t.Errorf("Byte rate = %d, not %d", br, 44100*4)
+	}
+}
+
+func TestNewWriterNoChannels(t *testing.T) {
+	var b seekBuffer
+	if _, err := NewWriter(&b, 44100, 0); err == nil {
+		t.Error("Created a writer with no channels")
+	}
+}
+
+func TestWriteAndClose(t *testing.T) {
+	var b seekBuffer
+	w, _ := NewWriter(&b, 48000, 2)
+	if err := w.Write([]int16{1, -1, 0x1234, -0x1234}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Frames() != 2 {
+		t.Errorf("Frames = %d, not 2", w.Frames())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.buff) != 52 {
+		t.Fatalf("File is %d bytes, not 52", len(b.buff))
+	}
+	if size := binary.LittleEndian.Uint32(b.buff[4:]); size != 44 {
+		t.Errorf("RIFF size = %d, not 44", size)
+	}
+	if size := binary.LittleEndian.Uint32(b.buff[40:]); size != 8 {
+		t.Errorf("data size = %d, not 8", size)
+	}
+	if s := int16(binary.LittleEndian.Uint16(b.buff[46:])); s != -1 {
+		t.Errorf("Second sample = %d, not -1", s)
+	}
+	if s := int16(binary.LittleEndian.Uint16(b.buff[48:])); s != 0x1234 {
+		t.Errorf("Third sample = 0x%04X, not 0x1234", s)
+	}
+}
+
+func TestWriteAfterOffset(t *testing.T) {
+	b := seekBuffer{buff: []byte("junk"), pos: 4}
+	w, _ := NewWriter(&b, 48000, 1)
+	w.Write([]int16{1, 2})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(b.buff[:8]) != "junkRIFF" {
+		t.Errorf("File starts %q", b.buff[:8])
+	}
+	if size := binary.LittleEndian.Uint32(b.buff[4+4:]); size != 40 {
+		t.Errorf("RIFF size = %d, not 40", size)
+	}
+	if size := binary.LittleEndian.Uint32(b.buff[4+40:]); size != 4 {
+		t.Errorf("data size = %d, not 4", size)
+	}
+}
+
+func TestWriteBuffers(t *testing.T) {
+	var b seekBuffer
+	w, _ := NewWriter(&b, 48000, 2)
+	b.writes = 0
+	for i := 0; i < 1000; i++ {
+		w.Write([]int16{int16(i), -int16(i)})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b.writes > 5 {
+		t.Errorf("Wrote 1000 frames in %d writes", b.writes)
+	}
+	if size := binary.LittleEndian.Uint32(b.buff[40:]); size != 4000 || len(b.buff) != 44+4000 {
+		t.Errorf("data size = %d in a %d byte file", size, len(b.buff))
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	var b seekBuffer
+	w, _ := NewWriter(&b, 48000, 1)
+	fail := errors.New("disk full")
+	b.err = fail
+	var err error
+	for i := 0; i < 5000 && err == nil; i++ {
+		err = w.Write([]int16{1})
+	}
+	if err != fail {
+		t.Errorf("Write returned %v", err)
+	}
+	b.err = errors.New("another error")
+	if err := w.Write([]int16{1}); err != fail {
+		t.Errorf("Write after the error returned %v", err)
+	}
+	if err := w.Close(); err != fail {
+		t.Errorf("Close returned %v", err)
+	}
+}
+
+func TestWriteShortFrame(t *testing.T) {
+	var b seekBuffer
+	w, _ := NewWriter(&b, 48000, 2)
+	if err := w.Write([]int16{1, 2, 3}); err != ErrShortFrame {
+		t.Errorf("Write returned %v, not ErrShortFrame", err)
+	}
+}
+
+func TestCloseKeepsAppending(t *testing.T) {
+	var b seekBuffer
+	w, _ := NewWriter(&b, 48000, 1)
+	w.Write([]int16{1})
+	w.Close()
+	w.Write([]int16{2})
+	w.Close()
+	if size := binary.LittleEndian.Uint32(b.buff[40:]); size != 4 {
+		t.Errorf("data size = %d, not 4", size)
+	}
+}
+
+func TestRecorderStems(t *testing.T) {
+	var mix, s1, s2 seekBuffer
+	r, err := NewRecorder(&mix, []io.WriteSeeker{&s1, &s2}, 32768)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.WriteSample(10, 20, 3, 4, 5)
+	r.WriteSample(30, 40, 6)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if size := binary.LittleEndian.Uint32(mix.buff[40:]); size != 8 {
+		t.Errorf("Mix data size = %d, not 8", size)
+	}
+	if size := binary.LittleEndian.Uint32(s1.buff[40:]); size != 4 {
+		t.Errorf("Stem data size = %d, not 4", size)
+	}
+	if s := binary.LittleEndian.Uint16(s1.buff[46:]); s != 6 {
+		t.Errorf("First stem second sample = %d, not 6", s)
+	}
+	if s := binary.LittleEndian.Uint16(s2.buff[44:]); s != 4 {
+		t.Errorf("Second stem first sample = %d, not 4", s)
+	}
+	if s := binary.LittleEndian.Uint16(s2.buff[46:]); s != 0 {
+		t.Errorf("Missing stem sample = %d, not 0", s)
+	}
+}
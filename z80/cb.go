@@ -0,0 +1,115 @@
+package z80
+
+func (z *Z80) cbRead(op byte) byte {
+	switch op & 0x7 {
+	case 0:
+		return z.B
+	case 1:
+		return z.C
+	case 2:
+		return z.D
+	case 3:
+		return z.E
+	case 4:
+		return z.H
+	case 5:
+		return z.L
+	case 6:
+		return z.mem.ReadByte(z.getHL())
+	}
+	return z.A
+}
+
+func (z *Z80) cbWrite(op byte, val byte) {
+	switch op & 0x7 {
+	case 0:
+		z.B = val
+	case 1:
+		z.C = val
+	case 2:
+		z.D = val
+	case 3:
+		z.E = val
+	case 4:
+		z.H = val
+	case 5:
+		z.L = val
+	case 6:
+		z.mem.WriteByte(z.getHL(), val)
+	case 7:
+		z.A = val
+	}
+}
+
+// shift performs the rotate/shift selected by bits 3-5 of a CB opcode in
+// the 0x00-0x3F block.
+func (z *Z80) shift(op byte, val byte) byte {
+	var res byte
+	var carry bool
+	switch op & 0x38 {
+	case 0x00:
+		// RLC
+		res = val<<1 | val>>7
+		carry = val&0x80 != 0
+	case 0x08:
+		// RRC
+		res = val>>1 | val<<7
+		carry = val&1 != 0
+	case 0x10:
+		// RL
+		res = val << 1
+		if z.getCFlag() {
+			res |= 1
+		}
+		carry = val&0x80 != 0
+	case 0x18:
+		// RR
+		res = val >> 1
+		if z.getCFlag() {
+			res |= 0x80
+		}
+		carry = val&1 != 0
+	case 0x20:
+		// SLA
+		res = val << 1
+		carry = val&0x80 != 0
+	case 0x28:
+		// SRA
+		res = val>>1 | val&0x80
+		carry = val&1 != 0
+	case 0x30:
+		// SWAP
+		res = val<<4 | val>>4
+	case 0x38:
+		// SRL
+		res = val >> 1
+		carry = val&1 != 0
+	}
+	z.F = 0
+	z.setZFlag(res == 0)
+	z.setCFlag(carry)
+	return res
+}
+
+func (z *Z80) dispatchCB() ClockTicks {
+	op := z.mem.ReadByte(z.PC)
+	z.PC++
+	val := z.cbRead(op)
+	bit := byte(1) << ((op >> 3) & 0x7)
+	switch op & 0xC0 {
+	case 0x00:
+		z.cbWrite(op, z.shift(op, val))
+	case 0x40:
+		// BIT n R8
+		z.setZFlag(val&bit == 0)
+		z.setNFlag(false)
+		z.setHFlag(true)
+	case 0x80:
+		// RES n R8
+		z.cbWrite(op, val&^bit)
+	case 0xC0:
+		// SET n R8
+		z.cbWrite(op, val|bit)
+	}
+	return CBOpcodes[op].Cycles
+}
@@ -0,0 +1,73 @@
+package z80
+
+const (
+	VBLANK_INT byte = 1 << iota
+	LCD_STAT_INT
+	TIMER_INT
+	SERIAL_INT
+	JOYPAD_INT
+)
+
+// Interrupter is implemented by whatever holds the IF register. Peripherals
+// use it to raise their interrupt lines.
+type Interrupter interface {
+	RequestInterrupt(byte)
+}
+
+func (z *Z80) RequestInterrupt(i byte) {
+	z.IF |= i & 0x1F
+	// Any joypad line going low brings the CPU out of STOP.
+	if i&JOYPAD_INT != 0 {
+		z.stopped = false
+	}
+}
+
+func (z Z80) Stopped() bool {
+	return z.stopped
+}
+
+// Resume ends a STOP. A CGB switching speed only stops until its clock
+// settles.
+func (z *Z80) Resume() {
+	z.stopped = false
+}
+
+func (z Z80) Halted() bool {
+	return z.halted
+}
+
+// Locked reports whether an illegal opcode has hung the CPU.
+func (z Z80) Locked() bool {
+	return z.locked
+}
+
+func (z Z80) pendingInterrupts() byte {
+	return z.IE & z.IF & 0x1F
+}
+
+// serviceInterrupt jumps to the vector of the highest priority pending
+// interrupt. Any pending interrupt ends a HALT, even with IME clear.
+func (z *Z80) serviceInterrupt() ClockTicks {
+	pending := z.pendingInterrupts()
+	if pending == 0 {
+		return 0
+	}
+	z.halted = false
+	if !z.IME {
+		return 0
+	}
+	z.IME = false
+	for i := uint16(0); i < 5; i++ {
+		bit := byte(1) << i
+		if pending&bit != 0 {
+			z.IF &^= bit
+			z.idle()
+			z.idle()
+			z.push(z.PC)
+			z.PC = 0x40 + i*8
+			z.idle()
+			break
+		}
+	}
+	return 20
+}
@@ -0,0 +1,205 @@
+package z80
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The single step tests run one instruction from a given state and check
+// the registers, memory and bus accesses afterwards. They use the format of
+// the community SM83 vectors, one JSON file per opcode ("00.json" to
+// "ff.json" and "cb 00.json" to "cb ff.json") holding a list of:
+//
+//	{"name": "...", "initial": state, "final": state, "cycles": [...]}
+//
+// where a state is {"pc", "sp", "a", "b", "c", "d", "e", "f", "h", "l",
+// "ime", "ie", "ram": [[addr, value], ...]} with pc at the opcode, and
+// each M-cycle is [addr, value, kind], kind containing "r" for a read or
+// "w" for a write, or null when the bus is idle. The CPU's accesses and
+// Idle calls are compared with them one M-cycle at a time.
+//
+// testdata/sm83 has eight vectors for every opcode but STOP, HALT and EI,
+// written by testdata/gensm83.go from a model of its own rather than
+// copied from the community set; see testdata/sm83/README. Point
+// SM83_TESTS at a directory of the full set to run all of those.
+
+type sm83State struct {
+	PC, SP                 uint16
+	A, B, C, D, E, F, H, L byte
+	IME, IE                byte
+	RAM                    [][2]int
+}
+
+type sm83Test struct {
+	Name    string
+	Initial sm83State
+	Final   sm83State
+	Cycles  [][]interface{}
+}
+
+// access is what happened on the bus in one M-cycle.
+type access struct {
+	idle  bool
+	write bool
+	addr  uint16
+	val   byte
+}
+
+func (a access) String() string {
+	if a.idle {
+		return "idle"
+	}
+	kind := "read"
+	if a.write {
+		kind = "write"
+	}
+	return fmt.Sprintf("%s %02X at %04X", kind, a.val, a.addr)
+}
+
+// busMemory is 64K of RAM that remembers every byte read and written and
+// every M-cycle spent off the bus.
+type busMemory struct {
+	ram [0x10000]byte
+	bus []access
+}
+
+func (m *busMemory) ReadByte(addr uint16) byte {
+	m.bus = append(m.bus, access{addr: addr, val: m.ram[addr]})
+	return m.ram[addr]
+}
+
+func (m *busMemory) WriteByte(addr uint16, val byte) {
+	m.bus = append(m.bus, access{write: true, addr: addr, val: val})
+	m.ram[addr] = val
+}
+
+func (m *busMemory) Idle() {
+	m.bus = append(m.bus, access{idle: true})
+}
+
+func (m *busMemory) ReadWord(addr uint16) uint16 {
+	return uint16(m.ReadByte(addr)) | uint16(m.ReadByte(addr+1))<<8
+}
+
+func (m *busMemory) WriteWord(addr uint16, val uint16) {
+	m.WriteByte(addr, byte(val))
+	m.WriteByte(addr+1, byte(val>>8))
+}
+
+// runSM83 runs one vector and returns what came out differently.
+func runSM83(test sm83Test) []string {
+	var m busMemory
+	in := test.Initial
+	for _, r := range in.RAM {
+		m.ram[r[0]] = byte(r[1])
+	}
+	z := New(&m)
+	z.A, z.F, z.B, z.C, z.D, z.E, z.H, z.L = in.A, in.F, in.B, in.C, in.D, in.E, in.H, in.L
+	z.PC, z.SP = in.PC, in.SP
+	z.IME, z.IE = in.IME != 0, in.IE
+	ticks := z.Dispatch()
+
+	var diffs []string
+	out := test.Final
+	for _, r := range []struct {
+		name      string
+		got, want uint16
+	}{
+		{"A", uint16(z.A), uint16(out.A)}, {"F", uint16(z.F), uint16(out.F)},
+		{"B", uint16(z.B), uint16(out.B)}, {"C", uint16(z.C), uint16(out.C)},
+		{"D", uint16(z.D), uint16(out.D)}, {"E", uint16(z.E), uint16(out.E)},
+		{"H", uint16(z.H), uint16(out.H)}, {"L", uint16(z.L), uint16(out.L)},
+		{"PC", z.PC, out.PC}, {"SP", z.SP, out.SP},
+	} {
+		if r.got != r.want {
+			diffs = append(diffs, fmt.Sprintf("%s = 0x%02X, not 0x%02X", r.name, r.got, r.want))
+		}
+	}
+	if z.IE != out.IE {
+		diffs = append(diffs, fmt.Sprintf("IE = 0x%02X, not 0x%02X", z.IE, out.IE))
+	}
+	// EI takes effect after the next instruction, which the vectors
+	// count as done.
+	if ime := z.IME || z.imeDelay > 0; ime != (out.IME != 0) {
+		diffs = append(diffs, fmt.Sprintf("IME = %v", ime))
+	}
+	for _, r := range out.RAM {
+		if got := m.ram[r[0]]; got != byte(r[1]) {
+			diffs = append(diffs, fmt.Sprintf("[0x%04X] = 0x%02X, not 0x%02X", r[0], got, r[1]))
+		}
+	}
+	if int(ticks) != 4*len(test.Cycles) {
+		diffs = append(diffs, fmt.Sprintf("took %d ticks, not %d", ticks, 4*len(test.Cycles)))
+	}
+
+	// Each M-cycle is a read, a write or idle, so the bus is compared a
+	// cycle at a time.
+	var want []access
+	for _, c := range test.Cycles {
+		a := access{idle: true}
+		if len(c) == 3 {
+			addr, _ := c[0].(float64)
+			val, _ := c[1].(float64)
+			kind, _ := c[2].(string)
+			a.addr, a.val = uint16(addr), byte(val)
+			a.write = strings.Contains(kind, "w")
+			a.idle = !a.write && !strings.Contains(kind, "r")
+		}
+		want = append(want, a)
+	}
+	for i := 0; i < len(want) || i < len(m.bus); i++ {
+		got, exp := "nothing", "nothing"
+		if i < len(m.bus) {
+			got = m.bus[i].String()
+		}
+		if i < len(want) {
+			exp = want[i].String()
+		}
+		if got != exp {
+			diffs = append(diffs, fmt.Sprintf("M-cycle %d is %s, not %s", i+1, got, exp))
+			break
+		}
+	}
+	return diffs
+}
+
+func TestSM83(t *testing.T) {
+	dir := os.Getenv("SM83_TESTS")
+	if dir == "" {
+		dir = filepath.Join("testdata", "sm83")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No vectors in %s: %v", dir, err)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var tests []sm83Test
+			if err := json.Unmarshal(data, &tests); err != nil {
+				t.Fatal(err)
+			}
+			var failed int
+			for _, test := range tests {
+				diffs := runSM83(test)
+				if len(diffs) == 0 {
+					continue
+				}
+				if failed++; failed <= 5 {
+					t.Errorf("%s: %s", test.Name, strings.Join(diffs, "; "))
+				}
+			}
+			if failed > 5 {
+				t.Errorf("%d of %d vectors failed", failed, len(tests))
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package z80
+
+import "github.com/zbyrne/golangboy/savestate"
+
+// STATE_VERSION is the version of the CPU's save state chunk.
+const STATE_VERSION = 1
+
+func (z *Z80) SaveState(e *savestate.Encoder) {
+	for _, r := range []byte{z.A, z.F, z.B, z.C, z.D, z.E, z.H, z.L, z.IE, z.IF} {
+		e.Byte(r)
+	}
+	e.Uint16(z.SP)
+	e.Uint16(z.PC)
+	e.Bool(z.IME)
+	e.Int(z.imeDelay)
+	e.Bool(z.halted)
+	e.Bool(z.haltBug)
+	e.Bool(z.stopped)
+	e.Bool(z.locked)
+}
+
+func (z *Z80) LoadState(d *savestate.Decoder) {
+	for _, r := range []*byte{&z.A, &z.F, &z.B, &z.C, &z.D, &z.E, &z.H, &z.L, &z.IE, &z.IF} {
+		*r = d.Byte()
+	}
+	z.SP = d.Uint16()
+	z.PC = d.Uint16()
+	z.IME = d.Bool()
+	z.imeDelay = d.Int()
+	z.halted = d.Bool()
+	z.haltBug = d.Bool()
+	z.stopped = d.Bool()
+	z.locked = d.Bool()
+}
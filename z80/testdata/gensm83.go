@@ -0,0 +1,604 @@
+//go:build ignore
+
+// gensm83 writes the vectors in testdata/sm83, eight for every opcode the
+// SM83 has and for every CB opcode. Run it from the z80 directory:
+//
+//	go run testdata/gensm83.go
+//
+// The vectors come from the small model of the SM83 below, written from
+// the hardware's documented behaviour and separately from Dispatch, so
+// the two check each other. Each file is seeded from its opcode, so the
+// output is the same every run. The opcodes in unchecked get no vectors.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const perFile = 8
+
+// illegal opcodes hang the CPU and have no vectors.
+var illegal = map[byte]bool{
+	0xD3: true, 0xDB: true, 0xDD: true, 0xE3: true, 0xE4: true, 0xEB: true,
+	0xEC: true, 0xED: true, 0xF4: true, 0xFC: true, 0xFD: true,
+}
+
+// unchecked opcodes do things a single step can't pin down from the
+// documentation alone: STOP's skipped byte, HALT's wait and the delay
+// before EI takes effect. A model of them would only repeat Dispatch, so
+// they are left to the community vectors.
+var unchecked = map[byte]bool{0x10: true, 0x76: true, 0xFB: true}
+
+// interesting values turn up often enough to reach the edge cases of the
+// flags.
+var interesting = []byte{0x00, 0x01, 0x0F, 0x10, 0x7F, 0x80, 0xF0, 0xFE, 0xFF}
+
+type state struct {
+	PC  uint16   `json:"pc"`
+	SP  uint16   `json:"sp"`
+	A   byte     `json:"a"`
+	B   byte     `json:"b"`
+	C   byte     `json:"c"`
+	D   byte     `json:"d"`
+	E   byte     `json:"e"`
+	F   byte     `json:"f"`
+	H   byte     `json:"h"`
+	L   byte     `json:"l"`
+	IME byte     `json:"ime"`
+	IE  byte     `json:"ie"`
+	RAM [][2]int `json:"ram"`
+}
+
+type vector struct {
+	Name    string        `json:"name"`
+	Initial state         `json:"initial"`
+	Final   state         `json:"final"`
+	Cycles  []interface{} `json:"cycles"`
+}
+
+type cpu struct {
+	regs    [8]byte // B, C, D, E, H, L, F, A: r8 order with F where (HL) is
+	pc, sp  uint16
+	ime, ie byte
+	rng     *rand.Rand
+	mem     map[uint16]byte
+	initial map[uint16]byte
+	cycles  []interface{}
+}
+
+const (
+	rB = iota
+	rC
+	rD
+	rE
+	rH
+	rL
+	rF
+	rA
+)
+
+func (c *cpu) byte() byte {
+	if c.rng.Intn(2) == 0 {
+		return interesting[c.rng.Intn(len(interesting))]
+	}
+	return byte(c.rng.Intn(0x100))
+}
+
+// read returns what is at addr, making up what was there at the start if
+// nothing has been read or written there yet.
+func (c *cpu) read(addr uint16) byte {
+	v, ok := c.mem[addr]
+	if !ok {
+		v = c.byte()
+		c.mem[addr] = v
+		c.initial[addr] = v
+	}
+	c.cycles = append(c.cycles, []interface{}{int(addr), int(v), "r-m"})
+	return v
+}
+
+func (c *cpu) write(addr uint16, v byte) {
+	c.mem[addr] = v
+	c.cycles = append(c.cycles, []interface{}{int(addr), int(v), "-wm"})
+}
+
+func (c *cpu) idle() {
+	c.cycles = append(c.cycles, nil)
+}
+
+func (c *cpu) fetch() byte {
+	v := c.read(c.pc)
+	c.pc++
+	return v
+}
+
+func (c *cpu) fetch16() uint16 {
+	lo := c.fetch()
+	return uint16(c.fetch())<<8 | uint16(lo)
+}
+
+func (c *cpu) pair(hi, lo int) uint16 {
+	return uint16(c.regs[hi])<<8 | uint16(c.regs[lo])
+}
+
+func (c *cpu) setPair(hi, lo int, v uint16) {
+	c.regs[hi], c.regs[lo] = byte(v>>8), byte(v)
+}
+
+func (c *cpu) hl() uint16 {
+	return c.pair(rH, rL)
+}
+
+// rp is BC, DE, HL or SP.
+func (c *cpu) rp(i byte) uint16 {
+	if i == 3 {
+		return c.sp
+	}
+	return c.pair(int(i)*2, int(i)*2+1)
+}
+
+func (c *cpu) setRP(i byte, v uint16) {
+	if i == 3 {
+		c.sp = v
+		return
+	}
+	c.setPair(int(i)*2, int(i)*2+1, v)
+}
+
+// r8 is B, C, D, E, H, L, (HL) or A.
+func (c *cpu) r8(i byte) byte {
+	if i == 6 {
+		return c.read(c.hl())
+	}
+	return c.regs[i]
+}
+
+func (c *cpu) setR8(i byte, v byte) {
+	if i == 6 {
+		c.write(c.hl(), v)
+		return
+	}
+	c.regs[i] = v
+}
+
+func (c *cpu) flag(mask byte) bool {
+	return c.regs[rF]&mask != 0
+}
+
+func (c *cpu) setFlags(z, n, h, cy bool) {
+	var f byte
+	for i, set := range []bool{cy, h, n, z} {
+		if set {
+			f |= 0x10 << i
+		}
+	}
+	c.regs[rF] = f
+}
+
+const (
+	fZ byte = 0x80
+	fN byte = 0x40
+	fH byte = 0x20
+	fC byte = 0x10
+)
+
+// cond is NZ, Z, NC or C.
+func (c *cpu) cond(op byte) bool {
+	switch (op >> 3) & 3 {
+	case 0:
+		return !c.flag(fZ)
+	case 1:
+		return c.flag(fZ)
+	case 2:
+		return !c.flag(fC)
+	}
+	return c.flag(fC)
+}
+
+func (c *cpu) push(v uint16) {
+	c.sp--
+	c.write(c.sp, byte(v>>8))
+	c.sp--
+	c.write(c.sp, byte(v))
+}
+
+func (c *cpu) pop() uint16 {
+	lo := c.read(c.sp)
+	c.sp++
+	hi := c.read(c.sp)
+	c.sp++
+	return uint16(hi)<<8 | uint16(lo)
+}
+
+// alu does ADD, ADC, SUB, SBC, AND, XOR, OR or CP of v into A.
+func (c *cpu) alu(kind byte, v byte) {
+	a := c.regs[rA]
+	var carry byte
+	if (kind == 1 || kind == 3) && c.flag(fC) {
+		carry = 1
+	}
+	var r byte
+	switch kind {
+	case 0, 1:
+		sum := int(a) + int(v) + int(carry)
+		r = byte(sum)
+		c.setFlags(r == 0, false, a&0xF+v&0xF+carry > 0xF, sum > 0xFF)
+	case 2, 3, 7:
+		diff := int(a) - int(v) - int(carry)
+		r = byte(diff)
+		c.setFlags(r == 0, true, int(a&0xF)-int(v&0xF)-int(carry) < 0, diff < 0)
+		if kind == 7 {
+			r = a
+		}
+	case 4:
+		r = a & v
+		c.setFlags(r == 0, false, true, false)
+	case 5:
+		r = a ^ v
+		c.setFlags(r == 0, false, false, false)
+	case 6:
+		r = a | v
+		c.setFlags(r == 0, false, false, false)
+	}
+	c.regs[rA] = r
+}
+
+// spOffset is SP plus a signed byte, with the flags ADD SP,e and
+// LD HL,SP+e set.
+func (c *cpu) spOffset(e byte) uint16 {
+	r := c.sp + uint16(int8(e))
+	c.setFlags(false, false, c.sp&0xF+uint16(e)&0xF > 0xF, c.sp&0xFF+uint16(e) > 0xFF)
+	return r
+}
+
+// shift does the rotate or shift of a CB opcode in 0x00-0x3F.
+func (c *cpu) shift(kind byte, v byte) byte {
+	var r byte
+	var out bool
+	switch kind {
+	case 0: // RLC
+		r, out = v<<1|v>>7, v&0x80 != 0
+	case 1: // RRC
+		r, out = v>>1|v<<7, v&1 != 0
+	case 2: // RL
+		r, out = v<<1, v&0x80 != 0
+		if c.flag(fC) {
+			r |= 1
+		}
+	case 3: // RR
+		r, out = v>>1, v&1 != 0
+		if c.flag(fC) {
+			r |= 0x80
+		}
+	case 4: // SLA
+		r, out = v<<1, v&0x80 != 0
+	case 5: // SRA
+		r, out = v>>1|v&0x80, v&1 != 0
+	case 6: // SWAP
+		r = v<<4 | v>>4
+	case 7: // SRL
+		r, out = v>>1, v&1 != 0
+	}
+	c.setFlags(r == 0, false, false, out)
+	return r
+}
+
+func (c *cpu) cb() {
+	op := c.fetch()
+	reg := op & 7
+	v := c.r8(reg)
+	bit := byte(1) << ((op >> 3) & 7)
+	switch op & 0xC0 {
+	case 0x00:
+		c.setR8(reg, c.shift((op>>3)&7, v))
+	case 0x40:
+		c.setFlags(v&bit == 0, false, true, c.flag(fC))
+	case 0x80:
+		c.setR8(reg, v&^bit)
+	case 0xC0:
+		c.setR8(reg, v|bit)
+	}
+}
+
+// step runs the instruction at PC, one bus access or idle cycle at a time.
+func (c *cpu) step() {
+	op := c.fetch()
+	x, y, z := op>>6, (op>>3)&7, op&7
+	switch {
+	case op == 0x00: // NOP
+	case op == 0x08: // LD (nn),SP
+		addr := c.fetch16()
+		c.write(addr, byte(c.sp))
+		c.write(addr+1, byte(c.sp>>8))
+	case op == 0x18: // JR e
+		e := c.fetch()
+		c.idle()
+		c.pc += uint16(int8(e))
+	case x == 0 && z == 0: // JR cc,e
+		e := c.fetch()
+		if c.cond(op) {
+			c.idle()
+			c.pc += uint16(int8(e))
+		}
+	case x == 0 && op&0xF == 0x1: // LD rr,nn
+		c.setRP(y>>1, c.fetch16())
+	case x == 0 && op&0xF == 0x9: // ADD HL,rr
+		hl, v := c.hl(), c.rp(y>>1)
+		c.idle()
+		c.setFlags(c.flag(fZ), false, hl&0xFFF+v&0xFFF > 0xFFF, uint32(hl)+uint32(v) > 0xFFFF)
+		c.setPair(rH, rL, hl+v)
+	case x == 0 && z == 2: // LD (rr),A and LD A,(rr)
+		var addr uint16
+		switch y >> 1 {
+		case 0:
+			addr = c.pair(rB, rC)
+		case 1:
+			addr = c.pair(rD, rE)
+		case 2:
+			addr = c.hl()
+			c.setPair(rH, rL, addr+1)
+		case 3:
+			addr = c.hl()
+			c.setPair(rH, rL, addr-1)
+		}
+		if y&1 == 0 {
+			c.write(addr, c.regs[rA])
+		} else {
+			c.regs[rA] = c.read(addr)
+		}
+	case x == 0 && z == 3: // INC rr and DEC rr
+		v := c.rp(y >> 1)
+		if y&1 == 0 {
+			v++
+		} else {
+			v--
+		}
+		c.idle()
+		c.setRP(y>>1, v)
+	case x == 0 && z == 4: // INC r
+		v := c.r8(y)
+		r := v + 1
+		c.setFlags(r == 0, false, v&0xF == 0xF, c.flag(fC))
+		c.setR8(y, r)
+	case x == 0 && z == 5: // DEC r
+		v := c.r8(y)
+		r := v - 1
+		c.setFlags(r == 0, true, v&0xF == 0, c.flag(fC))
+		c.setR8(y, r)
+	case x == 0 && z == 6: // LD r,n
+		c.setR8(y, c.fetch())
+	case x == 0 && z == 7:
+		a := c.regs[rA]
+		switch y {
+		case 0, 1, 2, 3: // RLCA, RRCA, RLA, RRA
+			c.regs[rA] = c.shift(y, a)
+			c.setFlags(false, false, false, c.flag(fC))
+		case 4: // DAA
+			cy := c.flag(fC)
+			if !c.flag(fN) {
+				if cy || a > 0x99 {
+					a += 0x60
+					cy = true
+				}
+				if c.flag(fH) || a&0xF > 0x9 {
+					a += 0x06
+				}
+			} else {
+				if cy {
+					a -= 0x60
+				}
+				if c.flag(fH) {
+					a -= 0x06
+				}
+			}
+			c.regs[rA] = a
+			c.setFlags(a == 0, c.flag(fN), false, cy)
+		case 5: // CPL
+			c.regs[rA] = ^a
+			c.setFlags(c.flag(fZ), true, true, c.flag(fC))
+		case 6: // SCF
+			c.setFlags(c.flag(fZ), false, false, true)
+		case 7: // CCF
+			c.setFlags(c.flag(fZ), false, false, !c.flag(fC))
+		}
+	case x == 1: // LD r,r
+		c.setR8(y, c.r8(z))
+	case x == 2: // ALU A,r
+		c.alu(y, c.r8(z))
+	case op == 0xC9, op == 0xD9: // RET and RETI
+		c.pc = c.pop()
+		c.idle()
+		if op == 0xD9 {
+			c.ime = 1
+		}
+	case x == 3 && z == 0 && y < 4: // RET cc
+		c.idle()
+		if c.cond(op) {
+			c.pc = c.pop()
+			c.idle()
+		}
+	case op == 0xE0: // LDH (n),A
+		c.write(0xFF00|uint16(c.fetch()), c.regs[rA])
+	case op == 0xF0: // LDH A,(n)
+		c.regs[rA] = c.read(0xFF00 | uint16(c.fetch()))
+	case op == 0xE8: // ADD SP,e
+		e := c.fetch()
+		c.idle()
+		c.idle()
+		c.sp = c.spOffset(e)
+	case op == 0xF8: // LD HL,SP+e
+		e := c.fetch()
+		c.idle()
+		c.setPair(rH, rL, c.spOffset(e))
+	case x == 3 && z == 1 && y&1 == 0: // POP rr
+		v := c.pop()
+		switch y >> 1 {
+		case 3:
+			c.setPair(rA, rF, v&0xFFF0)
+		default:
+			c.setRP(y>>1, v)
+		}
+	case op == 0xE9: // JP HL
+		c.pc = c.hl()
+	case op == 0xF9: // LD SP,HL
+		c.idle()
+		c.sp = c.hl()
+	case op == 0xC3: // JP nn
+		addr := c.fetch16()
+		c.idle()
+		c.pc = addr
+	case x == 3 && z == 2 && y < 4: // JP cc,nn
+		addr := c.fetch16()
+		if c.cond(op) {
+			c.idle()
+			c.pc = addr
+		}
+	case op == 0xE2: // LD (C),A
+		c.write(0xFF00|uint16(c.regs[rC]), c.regs[rA])
+	case op == 0xF2: // LD A,(C)
+		c.regs[rA] = c.read(0xFF00 | uint16(c.regs[rC]))
+	case op == 0xEA: // LD (nn),A
+		c.write(c.fetch16(), c.regs[rA])
+	case op == 0xFA: // LD A,(nn)
+		c.regs[rA] = c.read(c.fetch16())
+	case op == 0xCB:
+		c.cb()
+	case op == 0xF3: // DI
+		c.ime = 0
+	case op == 0xCD: // CALL nn
+		addr := c.fetch16()
+		c.idle()
+		c.push(c.pc)
+		c.pc = addr
+	case x == 3 && z == 4 && y < 4: // CALL cc,nn
+		addr := c.fetch16()
+		if c.cond(op) {
+			c.idle()
+			c.push(c.pc)
+			c.pc = addr
+		}
+	case x == 3 && z == 5 && y&1 == 0: // PUSH rr
+		var v uint16
+		switch y >> 1 {
+		case 3:
+			v = c.pair(rA, rF)
+		default:
+			v = c.rp(y >> 1)
+		}
+		c.idle()
+		c.push(v)
+	case x == 3 && z == 6: // ALU A,n
+		c.alu(y, c.fetch())
+	case x == 3 && z == 7: // RST
+		c.idle()
+		c.push(c.pc)
+		c.pc = uint16(y) * 8
+	default:
+		panic(fmt.Sprintf("no model of opcode %02X", op))
+	}
+}
+
+func (c *cpu) state() state {
+	r := c.regs
+	return state{
+		PC: c.pc, SP: c.sp,
+		A: r[rA], B: r[rB], C: r[rC], D: r[rD], E: r[rE], F: r[rF], H: r[rH], L: r[rL],
+		IME: c.ime, IE: c.ie,
+	}
+}
+
+func ram(m map[uint16]byte) [][2]int {
+	out := [][2]int{}
+	for addr, v := range m {
+		out = append(out, [2]int{int(addr), int(v)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i][0] < out[j][0]
+	})
+	return out
+}
+
+// generate makes one vector for the opcode, after 0xCB if cb is set.
+func generate(rng *rand.Rand, name string, cb bool, op byte) vector {
+	c := &cpu{rng: rng, mem: map[uint16]byte{}, initial: map[uint16]byte{}}
+	for i := range c.regs {
+		c.regs[i] = c.byte()
+	}
+	c.regs[rF] &= 0xF0
+	c.pc = uint16(rng.Intn(0x10000))
+	c.sp = uint16(rng.Intn(0x10000))
+	c.ime = byte(rng.Intn(2))
+	c.ie = byte(rng.Intn(0x20))
+	code := []byte{op}
+	if cb {
+		code = []byte{0xCB, op}
+	}
+	for i, b := range code {
+		c.mem[c.pc+uint16(i)] = b
+		c.initial[c.pc+uint16(i)] = b
+	}
+	v := vector{Name: name}
+	v.Initial = c.state()
+	c.step()
+	v.Final = c.state()
+	v.Initial.RAM = ram(c.initial)
+	v.Final.RAM = ram(c.mem)
+	v.Cycles = c.cycles
+	return v
+}
+
+func writeFile(dir, file string, cb bool, op byte) error {
+	seed := int64(op)
+	if cb {
+		seed += 0x100
+	}
+	rng := rand.New(rand.NewSource(0x8300 + seed))
+	var buf bytes.Buffer
+	buf.WriteString("[\n")
+	for i := 0; i < perFile; i++ {
+		data, err := json.Marshal(generate(rng, fmt.Sprintf("%s %04d", file, i), cb, op))
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		if i < perFile-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("]\n")
+	return os.WriteFile(filepath.Join(dir, file+".json"), buf.Bytes(), 0644)
+}
+
+func main() {
+	dir := filepath.Join("testdata", "sm83")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for i := 0; i < 0x100; i++ {
+		op := byte(i)
+		files := []struct {
+			name string
+			cb   bool
+		}{{fmt.Sprintf("cb %02x", op), true}}
+		if !illegal[op] && !unchecked[op] && op != 0xCB {
+			files = append(files, struct {
+				name string
+				cb   bool
+			}{fmt.Sprintf("%02x", op), false})
+		}
+		for _, f := range files {
+			if err := writeFile(dir, f.name, f.cb, op); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package z80
+
+import "io"
+
+const hexDigits = "0123456789ABCDEF"
+
+// SetTracer logs the CPU state before each instruction to w in the
+// Gameboy Doctor format:
+//
+//	A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02
+//
+// Passing nil turns tracing off. Write errors are ignored.
+func (z *Z80) SetTracer(w io.Writer) {
+	z.tracer = w
+}
+
+// SetTraceLabels names the code being run at the end of each trace line,
+// after a semicolon, whenever label returns something. That changes the
+// line format, so leave it unset when diffing against Gameboy Doctor
+// logs.
+func (z *Z80) SetTraceLabels(label func(pc uint16) string) {
+	z.traceLabel = label
+}
+
+func appendHex(b []byte, val byte) []byte {
+	return append(b, hexDigits[val>>4], hexDigits[val&0xF])
+}
+
+func (z *Z80) trace() {
+	b := z.traceBuf[:0]
+	for i, r := range []byte{z.A, z.F, z.B, z.C, z.D, z.E, z.H, z.L} {
+		b = append(b, "AFBCDEHL"[i], ':')
+		b = appendHex(b, r)
+		b = append(b, ' ')
+	}
+	b = append(b, "SP:"...)
+	b = appendHex(b, byte(z.SP>>8))
+	b = appendHex(b, byte(z.SP))
+	b = append(b, " PC:"...)
+	b = appendHex(b, byte(z.PC>>8))
+	b = appendHex(b, byte(z.PC))
+	b = append(b, " PCMEM:"...)
+	read := z.mem.ReadByte
+	if p, ok := z.mem.(Peeker); ok {
+		read = p.PeekByte
+	}
+	for i := uint16(0); i < 4; i++ {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendHex(b, read(z.PC+i))
+	}
+	if z.traceLabel != nil {
+		if label := z.traceLabel(z.PC); label != "" {
+			b = append(b, " ; "...)
+			b = append(b, label...)
+		}
+	}
+	b = append(b, '\n')
+	z.traceBuf = b
+	z.tracer.Write(b)
+}
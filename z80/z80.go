@@ -2,6 +2,7 @@ package z80
 
 import (
 	"encoding/binary"
+	"io"
 )
 
 const (
@@ -18,11 +19,34 @@ type Memory interface {
 	WriteWord(uint16, uint16)
 }
 
+// Peeker is Memory that can also be read without side effects, such as a
+// tool's wrapper noticing the access. The tracer peeks when it can.
+type Peeker interface {
+	PeekByte(uint16) byte
+}
+
+// Idler is Memory that is told about the M-cycles an instruction spends
+// off the bus, so each of its cycles is either an access or an Idle.
+type Idler interface {
+	Idle()
+}
+
 type Z80 struct {
 	A, F, B, C, D, E, H, L byte
 
 	PC, SP uint16
+
+	IE, IF byte
+	IME bool
+
 	mem Memory
+	idler Idler
+	imeDelay int
+	halted, haltBug bool
+	stopped, locked bool
+	tracer io.Writer
+	traceBuf []byte
+	traceLabel func(pc uint16) string
 }
 
 type ClockTicks int
@@ -37,7 +61,23 @@ func addSignedByteToU16(a uint16, b byte) uint16 {
 }
 
 func New(m Memory) Z80 {
-	return Z80{mem: m}
+	z := Z80{}
+	z.SetMemory(m)
+	return z
+}
+
+// SetMemory swaps the memory the CPU sees. Tools use it to watch the
+// CPU's accesses.
+func (z *Z80) SetMemory(m Memory) {
+	z.mem = m
+	z.idler, _ = m.(Idler)
+}
+
+// idle spends an M-cycle off the bus.
+func (z *Z80) idle() {
+	if z.idler != nil {
+		z.idler.Idle()
+	}
 }
 
 func (z Z80) getBC() uint16 {
@@ -136,12 +176,15 @@ func (z *Z80) setCFlag(f bool) {
 	}
 }
 
+// push writes the high byte first, as the hardware does.
 func (z *Z80) push(word uint16) {
-	z.SP -= 2
-	z.mem.WriteWord(z.SP, word)
+	z.SP--
+	z.mem.WriteByte(z.SP, byte(word >> 8))
+	z.SP--
+	z.mem.WriteByte(z.SP, byte(word))
 }
 
-func (z Z80) pop() uint16 {
+func (z *Z80) pop() uint16 {
 	word := z.mem.ReadWord(z.SP)
 	z.SP += 2
 	return word
@@ -229,57 +272,164 @@ func (z *Z80) ldDestRegDecode(op byte) *byte {
 	return nil
 }
 
+func (z *Z80) inc8(val byte) byte {
+	res := val + 1
+	z.setZFlag(res == 0)
+	z.setNFlag(false)
+	z.setHFlag(((val & 0xF) + 1) >= 0x10)
+	return res
+}
+
+func (z *Z80) dec8(val byte) byte {
+	res := val - 1
+	z.setZFlag(res == 0)
+	z.setNFlag(true)
+	z.setHFlag(val & 0xF == 0)
+	return res
+}
+
+func (z *Z80) add8(val byte, carry bool) {
+	var c byte
+	if carry {
+		c = 1
+	}
+	res := uint16(z.A) + uint16(val) + uint16(c)
+	z.setZFlag(byte(res) == 0)
+	z.setNFlag(false)
+	z.setHFlag((z.A & 0xF) + (val & 0xF) + c > 0xF)
+	z.setCFlag(res > 0xFF)
+	z.A = byte(res)
+}
+
+// sub8 sets the flags for A - val and returns the result without storing
+// it, so CP can share it.
+func (z *Z80) sub8(val byte, carry bool) byte {
+	var c int
+	if carry {
+		c = 1
+	}
+	res := int(z.A) - int(val) - c
+	z.setZFlag(byte(res) == 0)
+	z.setNFlag(true)
+	z.setHFlag(int(z.A & 0xF) - int(val & 0xF) - c < 0)
+	z.setCFlag(res < 0)
+	return byte(res)
+}
+
+func (z *Z80) and8(val byte) {
+	z.A &= val
+	z.F = H_FLAG
+	z.setZFlag(z.A == 0)
+}
+
+func (z *Z80) xor8(val byte) {
+	z.A ^= val
+	z.F = 0
+	z.setZFlag(z.A == 0)
+}
+
+func (z *Z80) or8(val byte) {
+	z.A |= val
+	z.F = 0
+	z.setZFlag(z.A == 0)
+}
+
+// addSPOffset is shared by ADD SP n and LD HL SP+n. The flags come from
+// the unsigned addition of the low byte.
+func (z *Z80) addSPOffset(offset byte) uint16 {
+	z.F = 0
+	z.setHFlag((z.SP & 0xF) + uint16(offset & 0xF) > 0xF)
+	z.setCFlag((z.SP & 0xFF) + uint16(offset) > 0xFF)
+	return addSignedByteToU16(z.SP, offset)
+}
+
+func (z Z80) condition(op byte) bool {
+	switch op & 0x18 {
+	case 0x00:
+		return !z.getZFlag()
+	case 0x08:
+		return z.getZFlag()
+	case 0x10:
+		return !z.getCFlag()
+	}
+	return z.getCFlag()
+}
+
+func (z *Z80) stackR16Decode(op byte) (func() uint16, func(uint16)) {
+	if op & 0x30 == 0x30 {
+		return func() uint16 {return z.getAF()}, func(x uint16) {z.setAF(x & 0xFFF0)}
+	}
+	return z.r16GetSetDecode(op & 0x30)
+}
+
 func (z *Z80) Dispatch() ClockTicks {
 	var op byte
+	var info Opcode
 	var reg *byte
 	var getReg16 func() uint16
 	var setReg16 func(uint16)
+	if z.stopped || z.locked {
+		return 4
+	}
+	if z.imeDelay > 0 {
+		z.imeDelay--
+		if z.imeDelay == 0 {
+			z.IME = true
+		}
+	}
+	if ticks := z.serviceInterrupt(); ticks != 0 {
+		return ticks
+	}
+	if z.halted {
+		return 4
+	}
+	if z.tracer != nil {
+		z.trace()
+	}
 	op = z.mem.ReadByte(z.PC)
-	z.PC++
+	if z.haltBug {
+		z.haltBug = false
+	} else {
+		z.PC++
+	}
+	info = Opcodes[op]
 	switch op {
 	case 0x00:
 		// NOP
-		return 4
+		return info.Cycles
 	case 0x01, 0x11, 0x21, 0x31:
 		// LD R16 nn
 		_, setReg16 = z.r16GetSetDecode(op)
 		setReg16(z.mem.ReadWord(z.PC))
 		z.PC += 2
-		return 12
+		return info.Cycles
 	case 0x02, 0x12:
 		// LD (R16) A
 		getReg16, _ = z.r16GetSetDecode(op)
 		z.mem.WriteByte(getReg16(), z.A)
-		return 8
+		return info.Cycles
 	case 0x03, 0x13, 0x23, 0x33:
 		// INC R16
 		getReg16, setReg16 = z.r16GetSetDecode(op)
 		setReg16(getReg16() + 1)
-		return 8
+		z.idle()
+		return info.Cycles
 	case 0x04, 0x0C, 0x14, 0x1C, 0x24, 0x2C, 0x3C:
 		// INC R8
 		reg = z.regDecode(op)
-		res := *reg + 1
-		z.setZFlag(res == 0)
-		z.setNFlag(false)
-		z.setHFlag(((*reg & 0xF) + 1) >= 0x10)
-		*reg = res
-		return 4
+		*reg = z.inc8(*reg)
+		return info.Cycles
 	case 0x05, 0x0D, 0x15, 0x1D, 0x25, 0x2D, 0x3D:
 		// DEC R8
 		reg = z.regDecode(op)
-		res := *reg - 1
-		z.setZFlag(res == 0)
-		z.setNFlag(true)
-		z.setHFlag(((*reg & 0xF) + 0xF) >= 0x10)
-		*reg = res
-		return 4
+		*reg = z.dec8(*reg)
+		return info.Cycles
 	case 0x06, 0x0E, 0x16, 0x1E, 0x26, 0x2E, 0x3E:
 		// LD R8 n
 		reg = z.regDecode(op)
 		*reg = z.mem.ReadByte(z.PC)
 		z.PC++
-		return 8
+		return info.Cycles
 	case 0x07:
 		// RLC A
 		val := z.A << 1
@@ -287,14 +437,14 @@ func (z *Z80) Dispatch() ClockTicks {
 		z.setCFlag(z.A & 0x80 != 0)
 		z.setNFlag(false)
 		z.setHFlag(false)
-		z.setZFlag(val == 0)
+		z.setZFlag(false)
 		z.A = val
-		return 4
+		return info.Cycles
 	case 0x08:
 		// LD (nn) SP
 		z.mem.WriteWord(z.mem.ReadWord(z.PC), z.SP)
 		z.PC += 2
-		return 20
+		return info.Cycles
 	case 0x09, 0x19, 0x29, 0x39:
 		// ADD HL R16
 		getReg16, _ = z.r16GetSetDecode(op)
@@ -304,17 +454,19 @@ func (z *Z80) Dispatch() ClockTicks {
 		z.setNFlag(false)
 		z.setHFlag(hl&0xFFF + r16&0xFFF >= 0x1000)
 		z.setHL(hl + r16)
-		return 8
+		z.idle()
+		return info.Cycles
 	case 0x0A, 0x1A:
 		// LD A (R16)
 		getReg16, _ = z.r16GetSetDecode(op)
 		z.A = z.mem.ReadByte(getReg16())
-		return 8
+		return info.Cycles
 	case 0x0B, 0x1B, 0x2B, 0x3B:
 		// DEC R16
 		getReg16, setReg16 = z.r16GetSetDecode(op)
 		setReg16(getReg16() - 1)
-		return 8
+		z.idle()
+		return info.Cycles
 	case 0x0F:
 		// RRC A
 		val := z.A >> 1
@@ -322,14 +474,15 @@ func (z *Z80) Dispatch() ClockTicks {
 		z.setCFlag(z.A & 1 != 0)
 		z.setNFlag(false)
 		z.setHFlag(false)
-		z.setZFlag(val == 0)
+		z.setZFlag(false)
 		z.A = val
-		return 4
+		return info.Cycles
 	case 0x10:
 		// STOP
-		// TODO: fill this in once there's more system around.
+		// Sleeps until a joypad line goes low.
 		z.PC++
-		return 4
+		z.stopped = true
+		return info.Cycles
 	case 0x17:
 		// RL A
 		var carry uint8 = 0
@@ -341,66 +494,111 @@ func (z *Z80) Dispatch() ClockTicks {
 		z.setCFlag(z.A & 0x80 != 0)
 		z.setNFlag(false)
 		z.setHFlag(false)
-		z.setZFlag(val == 0)
+		z.setZFlag(false)
 		z.A = val
-		return 4
+		return info.Cycles
 	case 0x18:
 		// JR n
 		offset := z.mem.ReadByte(z.PC)
 		z.PC++
 		z.PC = addSignedByteToU16(z.PC, offset)
-		return 12
+		z.idle()
+		return info.Cycles
 	case 0x1F:
-		// RRA
-	case 0x20:
-		// JR NZ n
+		// RR A
+		var carry uint8 = 0
+		val := z.A >> 1
+		if z.getCFlag() {
+			carry = 0x80
+		}
+		val |= carry
+		z.setCFlag(z.A & 1 != 0)
+		z.setNFlag(false)
+		z.setHFlag(false)
+		z.setZFlag(false)
+		z.A = val
+		return info.Cycles
+	case 0x20, 0x28, 0x30, 0x38:
+		// JR cc n
+		offset := z.mem.ReadByte(z.PC)
+		z.PC++
+		if z.condition(op) {
+			z.PC = addSignedByteToU16(z.PC, offset)
+			z.idle()
+			return info.Branch
+		}
+		return info.Cycles
 	case 0x22:
 		// LD (HL+) A
 		z.mem.WriteByte(z.getHL(), z.A)
 		z.setHL(z.getHL() + 1)
-		return 8
+		return info.Cycles
 	case 0x27:
 		// DAA
-	case 0x28:
-		// JR Z n
+		var adjust byte
+		carry := z.getCFlag()
+		if z.getHFlag() || (!z.getNFlag() && z.A & 0xF > 0x9) {
+			adjust |= 0x06
+		}
+		if carry || (!z.getNFlag() && z.A > 0x99) {
+			adjust |= 0x60
+			carry = true
+		}
+		if z.getNFlag() {
+			z.A -= adjust
+		} else {
+			z.A += adjust
+		}
+		z.setZFlag(z.A == 0)
+		z.setHFlag(false)
+		z.setCFlag(carry)
+		return info.Cycles
 	case 0x2A:
 		// LD A (HL+)
 		z.A = z.mem.ReadByte(z.getHL())
 		z.setHL(z.getHL() + 1)
-		return 8
+		return info.Cycles
 	case 0x2F:
 		// CPL
-	case 0x30:
-		// JR NC n
+		z.A = ^z.A
+		z.setNFlag(true)
+		z.setHFlag(true)
+		return info.Cycles
 	case 0x32:
 		// LD (HL-) A
 		z.mem.WriteByte(z.getHL(), z.A)
 		z.setHL(z.getHL() - 1)
-		return 8
+		return info.Cycles
 	case 0x34:
 		// INC (HL)
+		z.mem.WriteByte(z.getHL(), z.inc8(z.mem.ReadByte(z.getHL())))
+		return info.Cycles
 	case 0x35:
 		// DEC (HL)
+		z.mem.WriteByte(z.getHL(), z.dec8(z.mem.ReadByte(z.getHL())))
+		return info.Cycles
 	case 0x36:
 		// LD (HL) n
+		z.mem.WriteByte(z.getHL(), z.mem.ReadByte(z.PC))
+		z.PC++
+		return info.Cycles
 	case 0x37:
 		// SCF
 		z.setCFlag(true)
 		z.setNFlag(false)
 		z.setHFlag(false)
-		return 4
-	case 0x38:
-		// JR C n
+		return info.Cycles
 	case 0x3A:
 		// LD A (HL-)
 		z.A = z.mem.ReadByte(z.getHL())
 		z.setHL(z.getHL() - 1)
+		return info.Cycles
 	case 0x3F:
 		// CCF
-		z.setCFlag(false)
+		z.setCFlag(!z.getCFlag())
 		z.setNFlag(false)
 		z.setHFlag(false)
-		return 4
+		return info.Cycles
 	case 0x40, 0x41, 0x42, 0x43, 0x44, 0x45, 0x47,
 		0x48, 0x49, 0x4A, 0x4B, 0x4C, 0x4D, 0x4F,
 		0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x57,
@@ -410,148 +608,270 @@ func (z *Z80) Dispatch() ClockTicks {
 		0x78, 0x79, 0x7A, 0x7B, 0x7C, 0x7D, 0x7F:
 		// LD R8 R8
 		*z.ldDestRegDecode(op) = *z.regDecode(op)
-		return 4
+		return info.Cycles
 	case 0x46, 0x4E, 0x56, 0x5E, 0x66, 0x6E, 0x7E:
 		// LD R8 (HL)
 		*z.ldDestRegDecode(op) = z.mem.ReadByte(z.getHL())
-		return 8
+		return info.Cycles
 	case 0x70, 0x71, 0x72, 0x73, 0x74, 0x75, 0x77:
 		// LD (HL) R8
 		z.mem.WriteByte(z.getHL(), *z.regDecode(op))
-		return 8
+		return info.Cycles
 	case 0x76:
 		// HALT
+		// With interrupts disabled and one already pending the CPU
+		// doesn't halt, and fails to advance PC past the next opcode.
+		if !z.IME && z.pendingInterrupts() != 0 {
+			z.haltBug = true
+		} else {
+			z.halted = true
+		}
+		return info.Cycles
 	case 0x80, 0x81, 0x82, 0x83, 0x84, 0x85, 0x87:
 		// ADD A R8
-		reg = z.regDecode(op)
-		z.setZFlag(z.A + *reg == 0)
-		z.setNFlag(false)
-		z.setHFlag((z.A & 0xF) > 0xF - (*reg) & 0xF)
-		z.setCFlag(z.A > 0xFF - *reg)
-		z.A += *reg
-		return 4
+		z.add8(*z.regDecode(op), false)
+		return info.Cycles
 	case 0x86:
 		// ADD A (HL)
-	case 0x88:
-	case 0x89:
-	case 0x8A:
-	case 0x8B:
-	case 0x8C:
-	case 0x8D:
+		z.add8(z.mem.ReadByte(z.getHL()), false)
+		return info.Cycles
+	case 0x88, 0x89, 0x8A, 0x8B, 0x8C, 0x8D, 0x8F:
+		// ADC A R8
+		z.add8(*z.regDecode(op), z.getCFlag())
+		return info.Cycles
 	case 0x8E:
-	case 0x8F:
-	case 0x90:
-	case 0x91:
-	case 0x92:
-	case 0x93:
-	case 0x94:
-	case 0x95:
+		// ADC A (HL)
+		z.add8(z.mem.ReadByte(z.getHL()), z.getCFlag())
+		return info.Cycles
+	case 0x90, 0x91, 0x92, 0x93, 0x94, 0x95, 0x97:
+		// SUB A R8
+		z.A = z.sub8(*z.regDecode(op), false)
+		return info.Cycles
 	case 0x96:
-	case 0x97:
-	case 0x98:
-	case 0x99:
-	case 0x9A:
-	case 0x9B:
-	case 0x9C:
-	case 0x9D:
+		// SUB A (HL)
+		z.A = z.sub8(z.mem.ReadByte(z.getHL()), false)
+		return info.Cycles
+	case 0x98, 0x99, 0x9A, 0x9B, 0x9C, 0x9D, 0x9F:
+		// SBC A R8
+		z.A = z.sub8(*z.regDecode(op), z.getCFlag())
+		return info.Cycles
 	case 0x9E:
-	case 0x9F:
-	case 0xA0:
-	case 0xA1:
-	case 0xA2:
-	case 0xA3:
-	case 0xA4:
-	case 0xA5:
+		// SBC A (HL)
+		z.A = z.sub8(z.mem.ReadByte(z.getHL()), z.getCFlag())
+		return info.Cycles
+	case 0xA0, 0xA1, 0xA2, 0xA3, 0xA4, 0xA5, 0xA7:
+		// AND A R8
+		z.and8(*z.regDecode(op))
+		return info.Cycles
 	case 0xA6:
-	case 0xA7:
-	case 0xA8:
-	case 0xA9:
-	case 0xAA:
-	case 0xAB:
-	case 0xAC:
-	case 0xAD:
+		// AND A (HL)
+		z.and8(z.mem.ReadByte(z.getHL()))
+		return info.Cycles
+	case 0xA8, 0xA9, 0xAA, 0xAB, 0xAC, 0xAD, 0xAF:
+		// XOR A R8
+		z.xor8(*z.regDecode(op))
+		return info.Cycles
 	case 0xAE:
-	case 0xAF:
-	case 0xB0:
-	case 0xB1:
-	case 0xB2:
-	case 0xB3:
-	case 0xB4:
-	case 0xB5:
+		// XOR A (HL)
+		z.xor8(z.mem.ReadByte(z.getHL()))
+		return info.Cycles
+	case 0xB0, 0xB1, 0xB2, 0xB3, 0xB4, 0xB5, 0xB7:
+		// OR A R8
+		z.or8(*z.regDecode(op))
+		return info.Cycles
 	case 0xB6:
-	case 0xB7:
-	case 0xB8:
-	case 0xB9:
-	case 0xBA:
-	case 0xBB:
-	case 0xBC:
-	case 0xBD:
+		// OR A (HL)
+		z.or8(z.mem.ReadByte(z.getHL()))
+		return info.Cycles
+	case 0xB8, 0xB9, 0xBA, 0xBB, 0xBC, 0xBD, 0xBF:
+		// CP A R8
+		z.sub8(*z.regDecode(op), false)
+		return info.Cycles
 	case 0xBE:
-	case 0xBF:
-	case 0xC0:
-	case 0xC1:
-	case 0xC2:
+		// CP A (HL)
+		z.sub8(z.mem.ReadByte(z.getHL()), false)
+		return info.Cycles
+	case 0xC0, 0xC8, 0xD0, 0xD8:
+		// RET cc
+		z.idle()
+		if z.condition(op) {
+			z.PC = z.pop()
+			z.idle()
+			return info.Branch
+		}
+		return info.Cycles
+	case 0xC1, 0xD1, 0xE1, 0xF1:
+		// POP R16
+		_, setReg16 = z.stackR16Decode(op)
+		setReg16(z.pop())
+		return info.Cycles
+	case 0xC2, 0xCA, 0xD2, 0xDA:
+		// JP cc nn
+		addr := z.mem.ReadWord(z.PC)
+		z.PC += 2
+		if z.condition(op) {
+			z.PC = addr
+			z.idle()
+			return info.Branch
+		}
+		return info.Cycles
 	case 0xC3:
-	case 0xC4:
-	case 0xC5:
+		// JP nn
+		z.PC = z.mem.ReadWord(z.PC)
+		z.idle()
+		return info.Cycles
+	case 0xC4, 0xCC, 0xD4, 0xDC:
+		// CALL cc nn
+		addr := z.mem.ReadWord(z.PC)
+		z.PC += 2
+		if z.condition(op) {
+			z.idle()
+			z.push(z.PC)
+			z.PC = addr
+			return info.Branch
+		}
+		return info.Cycles
+	case 0xC5, 0xD5, 0xE5, 0xF5:
+		// PUSH R16
+		getReg16, _ = z.stackR16Decode(op)
+		z.idle()
+		z.push(getReg16())
+		return info.Cycles
 	case 0xC6:
-	case 0xC7:
-	case 0xC8:
+		// ADD A n
+		z.add8(z.mem.ReadByte(z.PC), false)
+		z.PC++
+		return info.Cycles
+	case 0xC7, 0xCF, 0xD7, 0xDF, 0xE7, 0xEF, 0xF7, 0xFF:
+		// RST n
+		z.idle()
+		z.push(z.PC)
+		z.PC = uint16(op & 0x38)
+		return info.Cycles
 	case 0xC9:
-	case 0xCA:
+		// RET
+		z.PC = z.pop()
+		z.idle()
+		return info.Cycles
 	case 0xCB:
-	case 0xCC:
+		// CB prefixed bit operations
+		return z.dispatchCB()
 	case 0xCD:
+		// CALL nn
+		addr := z.mem.ReadWord(z.PC)
+		z.PC += 2
+		z.idle()
+		z.push(z.PC)
+		z.PC = addr
+		return info.Cycles
 	case 0xCE:
-	case 0xCF:
-	case 0xD0:
-	case 0xD1:
-	case 0xD2:
-	case 0xD3:
-	case 0xD4:
-	case 0xD5:
+		// ADC A n
+		z.add8(z.mem.ReadByte(z.PC), z.getCFlag())
+		z.PC++
+		return info.Cycles
 	case 0xD6:
-	case 0xD7:
-	case 0xD8:
+		// SUB A n
+		z.A = z.sub8(z.mem.ReadByte(z.PC), false)
+		z.PC++
+		return info.Cycles
 	case 0xD9:
-	case 0xDA:
-	case 0xDB:
-	case 0xDC:
-	case 0xDD:
+		// RETI
+		z.PC = z.pop()
+		z.idle()
+		z.IME = true
+		return info.Cycles
 	case 0xDE:
-	case 0xDF:
+		// SBC A n
+		z.A = z.sub8(z.mem.ReadByte(z.PC), z.getCFlag())
+		z.PC++
+		return info.Cycles
 	case 0xE0:
-	case 0xE1:
+		// LDH (n) A
+		z.mem.WriteByte(0xFF00 | uint16(z.mem.ReadByte(z.PC)), z.A)
+		z.PC++
+		return info.Cycles
 	case 0xE2:
-	case 0xE3:
-	case 0xE4:
-	case 0xE5:
+		// LD (C) A
+		z.mem.WriteByte(0xFF00 | uint16(z.C), z.A)
+		return info.Cycles
 	case 0xE6:
-	case 0xE7:
+		// AND A n
+		z.and8(z.mem.ReadByte(z.PC))
+		z.PC++
+		return info.Cycles
 	case 0xE8:
+		// ADD SP n
+		z.SP = z.addSPOffset(z.mem.ReadByte(z.PC))
+		z.PC++
+		z.idle()
+		z.idle()
+		return info.Cycles
 	case 0xE9:
+		// JP HL
+		z.PC = z.getHL()
+		return info.Cycles
 	case 0xEA:
-	case 0xEB:
-	case 0xEC:
-	case 0xED:
+		// LD (nn) A
+		z.mem.WriteByte(z.mem.ReadWord(z.PC), z.A)
+		z.PC += 2
+		return info.Cycles
 	case 0xEE:
-	case 0xEF:
+		// XOR A n
+		z.xor8(z.mem.ReadByte(z.PC))
+		z.PC++
+		return info.Cycles
 	case 0xF0:
-	case 0xF1:
+		// LDH A (n)
+		z.A = z.mem.ReadByte(0xFF00 | uint16(z.mem.ReadByte(z.PC)))
+		z.PC++
+		return info.Cycles
 	case 0xF2:
+		// LD A (C)
+		z.A = z.mem.ReadByte(0xFF00 | uint16(z.C))
+		return info.Cycles
 	case 0xF3:
-	case 0xF4:
-	case 0xF5:
+		// DI
+		z.IME = false
+		z.imeDelay = 0
+		return info.Cycles
 	case 0xF6:
-	case 0xF7:
+		// OR A n
+		z.or8(z.mem.ReadByte(z.PC))
+		z.PC++
+		return info.Cycles
 	case 0xF8:
+		// LD HL SP+n
+		z.setHL(z.addSPOffset(z.mem.ReadByte(z.PC)))
+		z.PC++
+		z.idle()
+		return info.Cycles
 	case 0xF9:
+		// LD SP HL
+		z.SP = z.getHL()
+		z.idle()
+		return info.Cycles
 	case 0xFA:
+		// LD A (nn)
+		z.A = z.mem.ReadByte(z.mem.ReadWord(z.PC))
+		z.PC += 2
+		return info.Cycles
 	case 0xFB:
-	case 0xFC:
-	case 0xFD:
+		// EI
+		// Interrupts are enabled after the following instruction.
+		if !z.IME && z.imeDelay == 0 {
+			z.imeDelay = 2
+		}
+		return info.Cycles
 	case 0xFE:
-	case 0xFF:
+		// CP A n
+		z.sub8(z.mem.ReadByte(z.PC), false)
+		z.PC++
+		return info.Cycles
+	case 0xD3, 0xDB, 0xDD, 0xE3, 0xE4, 0xEB, 0xEC, 0xED, 0xF4, 0xFC, 0xFD:
+		// Illegal opcodes lock the CPU up.
+		z.locked = true
+		z.PC--
+		return info.Cycles
 	}
 	return 0
 }
@@ -1,8 +1,13 @@
 package z80
 
 import (
+	"bytes"
 	"encoding/binary"
+	"reflect"
 	"testing"
+
+	"github.com/zbyrne/golangboy/asm"
+	"github.com/zbyrne/golangboy/savestate"
 )
 
 type mockMemory struct {
@@ -31,6 +36,24 @@ func (m *mockMemory) WriteWord(addr uint16, val uint16) {
 	binary.LittleEndian.PutUint16(m.buff[addr:addr+2], val)
 }
 
+// assemble loads a program into a fresh memory of the given size.
+func assemble(t *testing.T, size uint16, src string) Memory {
+	m, _ := assembleProgram(t, size, src)
+	return m
+}
+
+// assembleProgram is assemble that also returns the program, for its
+// symbols.
+func assembleProgram(t *testing.T, size uint16, src string) (Memory, *asm.Program) {
+	p, err := asm.Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMockMemory(size)
+	p.Load(m)
+	return m, p
+}
+
 func TestNew(t *testing.T) {
 	z := New(nil)
 	if z.A != 0 {
@@ -391,13 +414,18 @@ func TestDispatchDEC_BOverflow(t *testing.T) {
 func TestDispatchDEC_BHalfCarry(t *testing.T) {
 	z := New(newMockMemory(1))
 	z.mem.(*mockMemory).buff[0] = 0x5
-	z.B = 0x0F
+	z.B = 0x10
 	z.Dispatch()
-	if z.B != 0xE {
-		t.Errorf("B set to 0x%02X, not 0xE", z.B)
+	if z.B != 0x0F {
+		t.Errorf("B set to 0x%02X, not 0x0F", z.B)
 	}
 	if !z.getHFlag() {
-		t.Error("H Flag not set after half-carry.")
+		t.Error("H Flag not set after borrowing from bit 4.")
+	}
+	z.PC = 0
+	z.Dispatch()
+	if z.getHFlag() {
+		t.Error("H Flag set without a borrow.")
 	}
 }
 
@@ -858,3 +886,940 @@ func TestDispatchJR_n_negativeOffset(t *testing.T) {
 		t.Errorf("Program Counter advanced to 0x%04X, not 0x0000", z.PC)
 	}
 }
+
+func TestDispatchSTOP(t *testing.T) {
+	z := New(newMockMemory(3))
+	z.mem.(*mockMemory).buff[0] = 0x10
+	tick := z.Dispatch()
+	if tick != 4 {
+		t.Errorf("Calling STOP used %d cycles, not 4", tick)
+	}
+	if z.PC != 2 {
+		t.Errorf("Program Counter advanced to 0x%04X, not 0x0002", z.PC)
+	}
+	if !z.Stopped() {
+		t.Error("CPU not stopped after STOP")
+	}
+	z.Dispatch()
+	if z.PC != 2 {
+		t.Errorf("Stopped CPU advanced to 0x%04X", z.PC)
+	}
+}
+
+func TestRequestInterrupt(t *testing.T) {
+	z := New(nil)
+	z.RequestInterrupt(TIMER_INT)
+	z.RequestInterrupt(VBLANK_INT)
+	if z.IF != 0x05 {
+		t.Errorf("IF = 0x%02X, not 0x05", z.IF)
+	}
+}
+
+func TestJoypadInterruptWakesStop(t *testing.T) {
+	z := New(newMockMemory(3))
+	z.mem.(*mockMemory).buff[0] = 0x10
+	z.Dispatch()
+	z.RequestInterrupt(TIMER_INT)
+	if !z.Stopped() {
+		t.Error("Timer interrupt woke the CPU from STOP")
+	}
+	z.RequestInterrupt(JOYPAD_INT)
+	if z.Stopped() {
+		t.Error("Joypad interrupt did not wake the CPU from STOP")
+	}
+}
+
+func TestDispatchRR_A(t *testing.T) {
+	z := New(newMockMemory(1))
+	z.mem.(*mockMemory).buff[0] = 0x1F
+	z.A = 0x01
+	z.setCFlag(true)
+	tick := z.Dispatch()
+	if tick != 4 {
+		t.Errorf("Calling RR A used %d cycles, not 4", tick)
+	}
+	if z.A != 0x80 {
+		t.Errorf("A set to 0x%02X, not 0x80", z.A)
+	}
+	if !z.getCFlag() {
+		t.Error("C Flag not set after rotating out a 1")
+	}
+}
+
+func TestDispatchJR_NZ_n(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0x20
+	z.mem.(*mockMemory).buff[1] = 0x10
+	tick := z.Dispatch()
+	if tick != 12 {
+		t.Errorf("Calling JR NZ n used %d cycles, not 12", tick)
+	}
+	if z.PC != 0x12 {
+		t.Errorf("Program Counter advanced to 0x%04X, not 0x0012", z.PC)
+	}
+}
+
+func TestDispatchJR_NZ_nNotTaken(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0x20
+	z.mem.(*mockMemory).buff[1] = 0x10
+	z.setZFlag(true)
+	tick := z.Dispatch()
+	if tick != 8 {
+		t.Errorf("Calling JR NZ n used %d cycles, not 8", tick)
+	}
+	if z.PC != 0x02 {
+		t.Errorf("Program Counter advanced to 0x%04X, not 0x0002", z.PC)
+	}
+}
+
+func TestDispatchJR_C_n(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0x38
+	z.mem.(*mockMemory).buff[1] = 0xFE
+	z.setCFlag(true)
+	z.Dispatch()
+	if z.PC != 0 {
+		t.Errorf("Program Counter advanced to 0x%04X, not 0x0000", z.PC)
+	}
+}
+
+func TestDispatchDAA(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0x80
+	z.mem.(*mockMemory).buff[1] = 0x27
+	z.A = 0x19
+	z.B = 0x28
+	z.Dispatch()
+	tick := z.Dispatch()
+	if tick != 4 {
+		t.Errorf("Calling DAA used %d cycles, not 4", tick)
+	}
+	if z.A != 0x47 {
+		t.Errorf("A set to 0x%02X, not 0x47", z.A)
+	}
+}
+
+func TestDispatchDAACarry(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0x80
+	z.mem.(*mockMemory).buff[1] = 0x27
+	z.A = 0x99
+	z.B = 0x01
+	z.Dispatch()
+	z.Dispatch()
+	if z.A != 0x00 {
+		t.Errorf("A set to 0x%02X, not 0x00", z.A)
+	}
+	if !z.getCFlag() || !z.getZFlag() {
+		t.Errorf("Flags are 0x%02X, not Z and C", z.F)
+	}
+}
+
+func TestDispatchDAASubtract(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0x90
+	z.mem.(*mockMemory).buff[1] = 0x27
+	z.A = 0x42
+	z.B = 0x15
+	z.Dispatch()
+	z.Dispatch()
+	if z.A != 0x27 {
+		t.Errorf("A set to 0x%02X, not 0x27", z.A)
+	}
+}
+
+func TestDispatchCPL(t *testing.T) {
+	z := New(newMockMemory(1))
+	z.mem.(*mockMemory).buff[0] = 0x2F
+	z.A = 0xA5
+	tick := z.Dispatch()
+	if tick != 4 {
+		t.Errorf("Calling CPL used %d cycles, not 4", tick)
+	}
+	if z.A != 0x5A {
+		t.Errorf("A set to 0x%02X, not 0x5A", z.A)
+	}
+	if !z.getNFlag() || !z.getHFlag() {
+		t.Error("N and H Flags not set")
+	}
+}
+
+func TestDispatchINC_ind_HL(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0x34
+	z.mem.(*mockMemory).buff[1] = 0xFF
+	z.setHL(1)
+	tick := z.Dispatch()
+	if tick != 12 {
+		t.Errorf("Calling INC (HL) used %d cycles, not 12", tick)
+	}
+	if z.mem.ReadByte(1) != 0 {
+		t.Errorf("(HL) set to 0x%02X, not 0x00", z.mem.ReadByte(1))
+	}
+	if !z.getZFlag() {
+		t.Error("Z Flag not set after overflow.")
+	}
+}
+
+func TestDispatchDEC_ind_HL(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0x35
+	z.mem.(*mockMemory).buff[1] = 0x01
+	z.setHL(1)
+	tick := z.Dispatch()
+	if tick != 12 {
+		t.Errorf("Calling DEC (HL) used %d cycles, not 12", tick)
+	}
+	if z.mem.ReadByte(1) != 0 {
+		t.Errorf("(HL) set to 0x%02X, not 0x00", z.mem.ReadByte(1))
+	}
+	if !z.getZFlag() || !z.getNFlag() {
+		t.Error("Z and N Flags not set.")
+	}
+}
+
+func TestDispatchLD_ind_HL_n(t *testing.T) {
+	z := New(newMockMemory(3))
+	z.mem.(*mockMemory).buff[0] = 0x36
+	z.mem.(*mockMemory).buff[1] = 0x5A
+	z.setHL(2)
+	tick := z.Dispatch()
+	if tick != 12 {
+		t.Errorf("Calling LD (HL) n used %d cycles, not 12", tick)
+	}
+	if z.PC != 2 {
+		t.Errorf("Program Counter advanced to 0x%04X, not 0x0002", z.PC)
+	}
+	if z.mem.ReadByte(2) != 0x5A {
+		t.Errorf("Loaded 0x%02X, not 0x5A", z.mem.ReadByte(2))
+	}
+}
+
+func TestDispatchLD_A_ind_HL_dec(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0x3A
+	z.mem.(*mockMemory).buff[1] = 0xA5
+	z.setHL(1)
+	tick := z.Dispatch()
+	if tick != 8 {
+		t.Errorf("Calling LD A (HL-) used %d cycles, not 8", tick)
+	}
+	if z.A != 0xA5 {
+		t.Errorf("Loaded 0x%02X, not 0xA5", z.A)
+	}
+	if z.getHL() != 0 {
+		t.Errorf("HL is 0x%04X, not 0x0000", z.getHL())
+	}
+}
+
+func TestDispatchADC_A_B(t *testing.T) {
+	z := New(newMockMemory(1))
+	z.mem.(*mockMemory).buff[0] = 0x88
+	z.A = 0x0E
+	z.B = 0x01
+	z.setCFlag(true)
+	tick := z.Dispatch()
+	if tick != 4 {
+		t.Errorf("Calling ADC A B used %d cycles, not 4", tick)
+	}
+	if z.A != 0x10 {
+		t.Errorf("A is 0x%02X, not 0x10", z.A)
+	}
+	if !z.getHFlag() {
+		t.Error("H Flag not set after half carry")
+	}
+	if z.getCFlag() {
+		t.Error("C Flag set without overflow")
+	}
+}
+
+func TestDispatchADD_A_ind_HL(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0x86
+	z.mem.(*mockMemory).buff[1] = 0x20
+	z.setHL(1)
+	z.A = 0x01
+	tick := z.Dispatch()
+	if tick != 8 {
+		t.Errorf("Calling ADD A (HL) used %d cycles, not 8", tick)
+	}
+	if z.A != 0x21 {
+		t.Errorf("A is 0x%02X, not 0x21", z.A)
+	}
+}
+
+func TestDispatchSUB_A_B(t *testing.T) {
+	z := New(newMockMemory(1))
+	z.mem.(*mockMemory).buff[0] = 0x90
+	z.A = 0x10
+	z.B = 0x01
+	tick := z.Dispatch()
+	if tick != 4 {
+		t.Errorf("Calling SUB A B used %d cycles, not 4", tick)
+	}
+	if z.A != 0x0F {
+		t.Errorf("A is 0x%02X, not 0x0F", z.A)
+	}
+	if !z.getNFlag() || !z.getHFlag() || z.getCFlag() {
+		t.Errorf("Flags are 0x%02X, not N and H", z.F)
+	}
+}
+
+func TestDispatchSUB_A_BBorrow(t *testing.T) {
+	z := New(newMockMemory(1))
+	z.mem.(*mockMemory).buff[0] = 0x90
+	z.A = 0x00
+	z.B = 0x01
+	z.Dispatch()
+	if z.A != 0xFF {
+		t.Errorf("A is 0x%02X, not 0xFF", z.A)
+	}
+	if !z.getCFlag() {
+		t.Error("C Flag not set after borrow")
+	}
+}
+
+func TestDispatchSBC_A_B(t *testing.T) {
+	z := New(newMockMemory(1))
+	z.mem.(*mockMemory).buff[0] = 0x98
+	z.A = 0x10
+	z.B = 0x0F
+	z.setCFlag(true)
+	z.Dispatch()
+	if z.A != 0x00 {
+		t.Errorf("A is 0x%02X, not 0x00", z.A)
+	}
+	if !z.getZFlag() || !z.getHFlag() || z.getCFlag() {
+		t.Errorf("Flags are 0x%02X, not Z, N and H", z.F)
+	}
+}
+
+func TestDispatchAND_A_B(t *testing.T) {
+	z := New(newMockMemory(1))
+	z.mem.(*mockMemory).buff[0] = 0xA0
+	z.A = 0xF0
+	z.B = 0x0F
+	z.setCFlag(true)
+	tick := z.Dispatch()
+	if tick != 4 {
+		t.Errorf("Calling AND A B used %d cycles, not 4", tick)
+	}
+	if z.A != 0 {
+		t.Errorf("A is 0x%02X, not 0x00", z.A)
+	}
+	if z.F != Z_FLAG|H_FLAG {
+		t.Errorf("Flags are 0x%02X, not Z and H", z.F)
+	}
+}
+
+func TestDispatchXOR_A_A(t *testing.T) {
+	z := New(newMockMemory(1))
+	z.mem.(*mockMemory).buff[0] = 0xAF
+	z.A = 0x5A
+	z.F = 0xF0
+	z.Dispatch()
+	if z.A != 0 {
+		t.Errorf("A is 0x%02X, not 0x00", z.A)
+	}
+	if z.F != Z_FLAG {
+		t.Errorf("Flags are 0x%02X, not Z", z.F)
+	}
+}
+
+func TestDispatchOR_A_ind_HL(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0xB6
+	z.mem.(*mockMemory).buff[1] = 0x0F
+	z.setHL(1)
+	z.A = 0xF0
+	tick := z.Dispatch()
+	if tick != 8 {
+		t.Errorf("Calling OR A (HL) used %d cycles, not 8", tick)
+	}
+	if z.A != 0xFF {
+		t.Errorf("A is 0x%02X, not 0xFF", z.A)
+	}
+}
+
+func TestDispatchCP_n(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0xFE
+	z.mem.(*mockMemory).buff[1] = 0x42
+	z.A = 0x42
+	tick := z.Dispatch()
+	if tick != 8 {
+		t.Errorf("Calling CP n used %d cycles, not 8", tick)
+	}
+	if z.A != 0x42 {
+		t.Errorf("A changed to 0x%02X", z.A)
+	}
+	if !z.getZFlag() || !z.getNFlag() {
+		t.Errorf("Flags are 0x%02X, not Z and N", z.F)
+	}
+}
+
+func TestDispatchPUSH_POP(t *testing.T) {
+	z := New(newMockMemory(0x10))
+	z.mem.(*mockMemory).buff[0] = 0xC5
+	z.mem.(*mockMemory).buff[1] = 0xD1
+	z.SP = 0x10
+	z.setBC(0xA55A)
+	tick := z.Dispatch()
+	if tick != 16 {
+		t.Errorf("Calling PUSH BC used %d cycles, not 16", tick)
+	}
+	if z.SP != 0x0E {
+		t.Errorf("SP is 0x%04X, not 0x000E", z.SP)
+	}
+	tick = z.Dispatch()
+	if tick != 12 {
+		t.Errorf("Calling POP DE used %d cycles, not 12", tick)
+	}
+	if z.getDE() != 0xA55A {
+		t.Errorf("DE is 0x%04X, not 0xA55A", z.getDE())
+	}
+	if z.SP != 0x10 {
+		t.Errorf("SP is 0x%04X, not 0x0010", z.SP)
+	}
+}
+
+func TestDispatchPOP_AF(t *testing.T) {
+	z := New(newMockMemory(0x10))
+	z.mem.(*mockMemory).buff[0] = 0xF1
+	z.mem.WriteWord(0x8, 0x12FF)
+	z.SP = 0x8
+	z.Dispatch()
+	if z.getAF() != 0x12F0 {
+		t.Errorf("AF is 0x%04X, not 0x12F0", z.getAF())
+	}
+}
+
+func TestDispatchJP_nn(t *testing.T) {
+	z := New(newMockMemory(3))
+	z.mem.(*mockMemory).buff[0] = 0xC3
+	z.mem.WriteWord(1, 0x1234)
+	tick := z.Dispatch()
+	if tick != 16 {
+		t.Errorf("Calling JP nn used %d cycles, not 16", tick)
+	}
+	if z.PC != 0x1234 {
+		t.Errorf("Program Counter is 0x%04X, not 0x1234", z.PC)
+	}
+}
+
+func TestDispatchJP_Z_nnNotTaken(t *testing.T) {
+	z := New(newMockMemory(3))
+	z.mem.(*mockMemory).buff[0] = 0xCA
+	z.mem.WriteWord(1, 0x1234)
+	tick := z.Dispatch()
+	if tick != 12 {
+		t.Errorf("Calling JP Z nn used %d cycles, not 12", tick)
+	}
+	if z.PC != 3 {
+		t.Errorf("Program Counter is 0x%04X, not 0x0003", z.PC)
+	}
+}
+
+func TestDispatchCALL_RET(t *testing.T) {
+	z := New(assemble(t, 0x20, `
+	call Sub
+	ds $10 - @
+Sub:
+	ret
+`))
+	z.SP = 0x20
+	tick := z.Dispatch()
+	if tick != 24 {
+		t.Errorf("Calling CALL nn used %d cycles, not 24", tick)
+	}
+	if z.PC != 0x10 {
+		t.Errorf("Program Counter is 0x%04X, not 0x0010", z.PC)
+	}
+	if z.mem.ReadWord(0x1E) != 0x0003 {
+		t.Errorf("Pushed 0x%04X, not 0x0003", z.mem.ReadWord(0x1E))
+	}
+	tick = z.Dispatch()
+	if tick != 16 {
+		t.Errorf("Calling RET used %d cycles, not 16", tick)
+	}
+	if z.PC != 0x3 {
+		t.Errorf("Program Counter is 0x%04X, not 0x0003", z.PC)
+	}
+	if z.SP != 0x20 {
+		t.Errorf("SP is 0x%04X, not 0x0020", z.SP)
+	}
+}
+
+func TestDispatchCALL_NC_nnNotTaken(t *testing.T) {
+	z := New(newMockMemory(3))
+	z.mem.(*mockMemory).buff[0] = 0xD4
+	z.setCFlag(true)
+	tick := z.Dispatch()
+	if tick != 12 {
+		t.Errorf("Calling CALL NC nn used %d cycles, not 12", tick)
+	}
+	if z.PC != 3 {
+		t.Errorf("Program Counter is 0x%04X, not 0x0003", z.PC)
+	}
+}
+
+func TestDispatchRET_Z(t *testing.T) {
+	z := New(newMockMemory(0x10))
+	z.mem.(*mockMemory).buff[0] = 0xC8
+	z.mem.WriteWord(0x8, 0x1234)
+	z.SP = 0x8
+	tick := z.Dispatch()
+	if tick != 8 {
+		t.Errorf("Calling RET Z not taken used %d cycles, not 8", tick)
+	}
+	z.PC = 0
+	z.setZFlag(true)
+	tick = z.Dispatch()
+	if tick != 20 {
+		t.Errorf("Calling RET Z taken used %d cycles, not 20", tick)
+	}
+	if z.PC != 0x1234 {
+		t.Errorf("Program Counter is 0x%04X, not 0x1234", z.PC)
+	}
+}
+
+func TestDispatchRST(t *testing.T) {
+	z := New(newMockMemory(0x10))
+	z.mem.(*mockMemory).buff[0] = 0xEF
+	z.SP = 0x10
+	tick := z.Dispatch()
+	if tick != 16 {
+		t.Errorf("Calling RST 28 used %d cycles, not 16", tick)
+	}
+	if z.PC != 0x28 {
+		t.Errorf("Program Counter is 0x%04X, not 0x0028", z.PC)
+	}
+	if z.mem.ReadWord(0xE) != 0x0001 {
+		t.Errorf("Pushed 0x%04X, not 0x0001", z.mem.ReadWord(0xE))
+	}
+}
+
+func TestDispatchLDH(t *testing.T) {
+	z := New(newMockMemory(0xFFFF))
+	z.mem.(*mockMemory).buff[0] = 0xE0
+	z.mem.(*mockMemory).buff[1] = 0x80
+	z.mem.(*mockMemory).buff[2] = 0xF0
+	z.mem.(*mockMemory).buff[3] = 0x81
+	z.mem.(*mockMemory).buff[0xFF81] = 0x5A
+	z.A = 0xA5
+	tick := z.Dispatch()
+	if tick != 12 {
+		t.Errorf("Calling LDH (n) A used %d cycles, not 12", tick)
+	}
+	if z.mem.ReadByte(0xFF80) != 0xA5 {
+		t.Errorf("Stored 0x%02X, not 0xA5", z.mem.ReadByte(0xFF80))
+	}
+	z.Dispatch()
+	if z.A != 0x5A {
+		t.Errorf("Loaded 0x%02X, not 0x5A", z.A)
+	}
+}
+
+func TestDispatchLD_ind_C_A(t *testing.T) {
+	z := New(newMockMemory(0xFFFF))
+	z.mem.(*mockMemory).buff[0] = 0xE2
+	z.C = 0x90
+	z.A = 0x12
+	tick := z.Dispatch()
+	if tick != 8 {
+		t.Errorf("Calling LD (C) A used %d cycles, not 8", tick)
+	}
+	if z.mem.ReadByte(0xFF90) != 0x12 {
+		t.Errorf("Stored 0x%02X, not 0x12", z.mem.ReadByte(0xFF90))
+	}
+}
+
+func TestDispatchADD_SP_n(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0xE8
+	z.mem.(*mockMemory).buff[1] = 0xFF
+	z.SP = 0x0001
+	tick := z.Dispatch()
+	if tick != 16 {
+		t.Errorf("Calling ADD SP n used %d cycles, not 16", tick)
+	}
+	if z.SP != 0 {
+		t.Errorf("SP is 0x%04X, not 0x0000", z.SP)
+	}
+	if z.F != H_FLAG|C_FLAG {
+		t.Errorf("Flags are 0x%02X, not H and C", z.F)
+	}
+}
+
+func TestDispatchLD_HL_SP_n(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0xF8
+	z.mem.(*mockMemory).buff[1] = 0x02
+	z.SP = 0xFFF0
+	tick := z.Dispatch()
+	if tick != 12 {
+		t.Errorf("Calling LD HL SP+n used %d cycles, not 12", tick)
+	}
+	if z.getHL() != 0xFFF2 {
+		t.Errorf("HL is 0x%04X, not 0xFFF2", z.getHL())
+	}
+	if z.SP != 0xFFF0 {
+		t.Errorf("SP changed to 0x%04X", z.SP)
+	}
+}
+
+func TestDispatchJP_HL(t *testing.T) {
+	z := New(newMockMemory(1))
+	z.mem.(*mockMemory).buff[0] = 0xE9
+	z.setHL(0x4000)
+	tick := z.Dispatch()
+	if tick != 4 {
+		t.Errorf("Calling JP HL used %d cycles, not 4", tick)
+	}
+	if z.PC != 0x4000 {
+		t.Errorf("Program Counter is 0x%04X, not 0x4000", z.PC)
+	}
+}
+
+func TestDispatchLD_ind_nn_A(t *testing.T) {
+	z := New(newMockMemory(4))
+	z.mem.(*mockMemory).buff[0] = 0xEA
+	z.mem.WriteWord(1, 0x0003)
+	z.A = 0x77
+	tick := z.Dispatch()
+	if tick != 16 {
+		t.Errorf("Calling LD (nn) A used %d cycles, not 16", tick)
+	}
+	if z.mem.ReadByte(3) != 0x77 {
+		t.Errorf("Stored 0x%02X, not 0x77", z.mem.ReadByte(3))
+	}
+}
+
+func TestDispatchEIDelay(t *testing.T) {
+	z := New(newMockMemory(0x100))
+	z.mem.(*mockMemory).buff[0] = 0xFB
+	z.SP = 0x100
+	z.IE = VBLANK_INT
+	z.IF = VBLANK_INT
+	z.Dispatch()
+	if z.IME {
+		t.Error("IME set straight after EI")
+	}
+	z.Dispatch()
+	if z.PC != 2 {
+		t.Errorf("Interrupt taken before the instruction after EI, PC is 0x%04X", z.PC)
+	}
+	tick := z.Dispatch()
+	if tick != 20 {
+		t.Errorf("Servicing an interrupt used %d cycles, not 20", tick)
+	}
+	if z.PC != 0x40 {
+		t.Errorf("Program Counter is 0x%04X, not 0x0040", z.PC)
+	}
+	if z.IF != 0 {
+		t.Errorf("IF is 0x%02X, not 0x00", z.IF)
+	}
+	if z.IME {
+		t.Error("IME still set inside the handler")
+	}
+	if z.mem.ReadWord(0xFE) != 2 {
+		t.Errorf("Pushed 0x%04X, not 0x0002", z.mem.ReadWord(0xFE))
+	}
+}
+
+func TestDispatchDICancelsEI(t *testing.T) {
+	z := New(newMockMemory(3))
+	z.mem.(*mockMemory).buff[0] = 0xFB
+	z.mem.(*mockMemory).buff[1] = 0xF3
+	z.Dispatch()
+	z.Dispatch()
+	z.Dispatch()
+	if z.IME {
+		t.Error("IME set after EI DI")
+	}
+}
+
+func TestInterruptPriority(t *testing.T) {
+	z := New(newMockMemory(0x100))
+	z.SP = 0x100
+	z.IME = true
+	z.IE = 0x1F
+	z.IF = TIMER_INT | SERIAL_INT
+	z.Dispatch()
+	if z.PC != 0x50 {
+		t.Errorf("Program Counter is 0x%04X, not 0x0050", z.PC)
+	}
+	if z.IF != SERIAL_INT {
+		t.Errorf("IF is 0x%02X, not 0x%02X", z.IF, SERIAL_INT)
+	}
+}
+
+func TestDispatchRETI(t *testing.T) {
+	z := New(newMockMemory(0x10))
+	z.mem.(*mockMemory).buff[0] = 0xD9
+	z.mem.WriteWord(0x8, 0x0100)
+	z.SP = 0x8
+	tick := z.Dispatch()
+	if tick != 16 {
+		t.Errorf("Calling RETI used %d cycles, not 16", tick)
+	}
+	if !z.IME {
+		t.Error("IME not set after RETI")
+	}
+	if z.PC != 0x0100 {
+		t.Errorf("Program Counter is 0x%04X, not 0x0100", z.PC)
+	}
+}
+
+func TestDispatchHALT(t *testing.T) {
+	z := New(newMockMemory(0x100))
+	z.mem.(*mockMemory).buff[0] = 0x76
+	z.SP = 0x100
+	z.IE = TIMER_INT
+	z.Dispatch()
+	if !z.Halted() {
+		t.Fatal("CPU not halted")
+	}
+	z.Dispatch()
+	if z.PC != 1 {
+		t.Errorf("Halted CPU advanced to 0x%04X", z.PC)
+	}
+	z.RequestInterrupt(TIMER_INT)
+	z.Dispatch()
+	if z.Halted() {
+		t.Error("Pending interrupt did not end HALT")
+	}
+	if z.PC != 2 {
+		t.Errorf("Program Counter is 0x%04X, not 0x0002", z.PC)
+	}
+}
+
+func TestDispatchHALTBug(t *testing.T) {
+	z := New(newMockMemory(3))
+	z.mem.(*mockMemory).buff[0] = 0x76
+	z.mem.(*mockMemory).buff[1] = 0x04
+	z.IE = TIMER_INT
+	z.IF = TIMER_INT
+	z.Dispatch()
+	if z.Halted() {
+		t.Fatal("CPU halted with an interrupt pending")
+	}
+	z.Dispatch()
+	z.Dispatch()
+	if z.B != 2 {
+		t.Errorf("B is %d, not 2", z.B)
+	}
+	if z.PC != 2 {
+		t.Errorf("Program Counter is 0x%04X, not 0x0002", z.PC)
+	}
+}
+
+func TestDispatchIllegal(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0xD3
+	z.Dispatch()
+	z.Dispatch()
+	if z.PC != 0 {
+		t.Errorf("Program Counter is 0x%04X, not 0x0000", z.PC)
+	}
+}
+
+func TestDispatchCB_RLC_B(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0xCB
+	z.mem.(*mockMemory).buff[1] = 0x00
+	z.B = 0x80
+	tick := z.Dispatch()
+	if tick != 8 {
+		t.Errorf("Calling RLC B used %d cycles, not 8", tick)
+	}
+	if z.PC != 2 {
+		t.Errorf("Program Counter is 0x%04X, not 0x0002", z.PC)
+	}
+	if z.B != 0x01 {
+		t.Errorf("B is 0x%02X, not 0x01", z.B)
+	}
+	if z.F != C_FLAG {
+		t.Errorf("Flags are 0x%02X, not C", z.F)
+	}
+}
+
+func TestDispatchCB_SRA_A(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0xCB
+	z.mem.(*mockMemory).buff[1] = 0x2F
+	z.A = 0x81
+	z.Dispatch()
+	if z.A != 0xC0 {
+		t.Errorf("A is 0x%02X, not 0xC0", z.A)
+	}
+	if !z.getCFlag() {
+		t.Error("C Flag not set")
+	}
+}
+
+func TestDispatchCB_SWAP_ind_HL(t *testing.T) {
+	z := New(newMockMemory(3))
+	z.mem.(*mockMemory).buff[0] = 0xCB
+	z.mem.(*mockMemory).buff[1] = 0x36
+	z.mem.(*mockMemory).buff[2] = 0xA5
+	z.setHL(2)
+	tick := z.Dispatch()
+	if tick != 16 {
+		t.Errorf("Calling SWAP (HL) used %d cycles, not 16", tick)
+	}
+	if z.mem.ReadByte(2) != 0x5A {
+		t.Errorf("(HL) is 0x%02X, not 0x5A", z.mem.ReadByte(2))
+	}
+}
+
+func TestDispatchCB_BIT_7_H(t *testing.T) {
+	z := New(newMockMemory(2))
+	z.mem.(*mockMemory).buff[0] = 0xCB
+	z.mem.(*mockMemory).buff[1] = 0x7C
+	z.H = 0x7F
+	z.setCFlag(true)
+	tick := z.Dispatch()
+	if tick != 8 {
+		t.Errorf("Calling BIT 7 H used %d cycles, not 8", tick)
+	}
+	if z.F != Z_FLAG|H_FLAG|C_FLAG {
+		t.Errorf("Flags are 0x%02X, not Z, H and C", z.F)
+	}
+}
+
+func TestDispatchCB_BIT_0_ind_HL(t *testing.T) {
+	z := New(newMockMemory(3))
+	z.mem.(*mockMemory).buff[0] = 0xCB
+	z.mem.(*mockMemory).buff[1] = 0x46
+	z.mem.(*mockMemory).buff[2] = 0x01
+	z.setHL(2)
+	tick := z.Dispatch()
+	if tick != 12 {
+		t.Errorf("Calling BIT 0 (HL) used %d cycles, not 12", tick)
+	}
+	if z.getZFlag() {
+		t.Error("Z Flag set for a set bit")
+	}
+}
+
+func TestDispatchCB_RES_SET(t *testing.T) {
+	z := New(newMockMemory(4))
+	z.mem.(*mockMemory).buff[0] = 0xCB
+	z.mem.(*mockMemory).buff[1] = 0x99
+	z.mem.(*mockMemory).buff[2] = 0xCB
+	z.mem.(*mockMemory).buff[3] = 0xF9
+	z.C = 0x08
+	z.Dispatch()
+	if z.C != 0 {
+		t.Errorf("C is 0x%02X after RES 3 C, not 0x00", z.C)
+	}
+	z.Dispatch()
+	if z.C != 0x80 {
+		t.Errorf("C is 0x%02X after SET 7 C, not 0x80", z.C)
+	}
+}
+
+func TestOpcodeLengths(t *testing.T) {
+	// Control flow, the CB prefix and illegal opcodes are covered elsewhere.
+	skip := map[byte]bool{0x18: true, 0x20: true, 0x28: true, 0x30: true, 0x38: true,
+		0xC0: true, 0xC2: true, 0xC3: true, 0xC4: true, 0xC7: true, 0xC8: true,
+		0xC9: true, 0xCA: true, 0xCB: true, 0xCC: true, 0xCD: true, 0xCF: true, 0xD0: true,
+		0xD2: true, 0xD4: true, 0xD7: true, 0xD8: true, 0xD9: true, 0xDA: true,
+		0xDC: true, 0xDF: true, 0xE7: true, 0xE9: true, 0xEF: true, 0xF7: true,
+		0xFF: true}
+	for i := 0; i < 256; i++ {
+		op := byte(i)
+		if skip[op] || Opcodes[op].Mnemonic == "" {
+			continue
+		}
+		z := New(newMockMemory(0xFFFF))
+		z.SP = 0xFFF0
+		z.setHL(0x8000)
+		z.mem.WriteByte(0x100, op)
+		z.PC = 0x100
+		z.Dispatch()
+		if n := int(z.PC - 0x100); n != Opcodes[op].Length {
+			t.Errorf("%s advanced PC by %d, not %d", Opcodes[op].Mnemonic, n, Opcodes[op].Length)
+		}
+	}
+	for i := 0; i < 256; i++ {
+		op := byte(i)
+		z := New(newMockMemory(0xFFFF))
+		z.setHL(0x8000)
+		z.mem.WriteByte(0x100, 0xCB)
+		z.mem.WriteByte(0x101, op)
+		z.PC = 0x100
+		ticks := z.Dispatch()
+		if n := int(z.PC - 0x100); n != CBOpcodes[op].Length {
+			t.Errorf("%s advanced PC by %d, not %d", CBOpcodes[op].Mnemonic, n, CBOpcodes[op].Length)
+		}
+		if ticks != CBOpcodes[op].Cycles {
+			t.Errorf("%s took %d ticks, not %d", CBOpcodes[op].Mnemonic, ticks, CBOpcodes[op].Cycles)
+		}
+	}
+}
+
+func TestProgramMultiply(t *testing.T) {
+	m, p := assembleProgram(t, 0x100, `
+	ld b, 7
+	ld c, 6
+	xor a
+.loop:
+	add a, c
+	dec b
+	jr nz, .loop
+	ld [Result], a
+	halt
+Result:
+	db 0
+`)
+	z := New(m)
+	for !z.Halted() {
+		z.Dispatch()
+	}
+	result, ok := p.Symbols["Result"]
+	if !ok {
+		t.Fatal("No Result symbol")
+	}
+	if got := z.mem.ReadByte(uint16(result)); z.A != 42 || got != 42 {
+		t.Errorf("7 * 6 = %d, stored %d", z.A, got)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	z := New(assemble(t, 0x10, `
+	ld a, $12
+	ld hl, $ABCD
+	nop
+`))
+	z.SP = 0xFFFE
+	var buf bytes.Buffer
+	z.SetTracer(&buf)
+	z.Dispatch()
+	z.Dispatch()
+	z.SetTracer(nil)
+	z.Dispatch()
+	want := "A:00 F:00 B:00 C:00 D:00 E:00 H:00 L:00 SP:FFFE PC:0000 PCMEM:3E,12,21,CD\n" +
+		"A:12 F:00 B:00 C:00 D:00 E:00 H:00 L:00 SP:FFFE PC:0002 PCMEM:21,CD,AB,00\n"
+	if buf.String() != want {
+		t.Errorf("Traced\n%s\nnot\n%s", buf.String(), want)
+	}
+}
+
+func TestState(t *testing.T) {
+	z := New(newMockMemory(0x10))
+	z.A, z.F, z.B, z.C, z.D, z.E, z.H, z.L = 1, 0xB0, 2, 3, 4, 5, 6, 7
+	z.SP, z.PC = 0xFFFE, 0x1234
+	z.IE, z.IF = 0x1F, 0x04
+	z.imeDelay = 1
+	z.halted, z.haltBug = true, true
+	loaded := New(z.mem)
+	if err := savestate.Copy(&loaded, &z, STATE_VERSION); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, z) {
+		t.Errorf("Loaded %+v, saved %+v", loaded, z)
+	}
+}